@@ -4,13 +4,13 @@ go 1.18
 
 require (
 	github.com/google/go-cmp v0.5.8
+	github.com/linkedin/goavro/v2 v2.11.1
 	github.com/riferrei/srclient v0.5.4
+	github.com/santhosh-tekuri/jsonschema/v5 v5.0.0
 	google.golang.org/protobuf v1.28.0
 )
 
 require (
 	github.com/golang/snappy v0.0.4 // indirect
-	github.com/linkedin/goavro/v2 v2.11.1 // indirect
-	github.com/santhosh-tekuri/jsonschema/v5 v5.0.0 // indirect
 	golang.org/x/sync v0.0.0-20220513210516-0976fa681c29 // indirect
 )
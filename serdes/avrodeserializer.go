@@ -0,0 +1,174 @@
+package serdes
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/riferrei/srclient"
+)
+
+// AvroDeserializerConfigValue config values for avro deserialization
+type AvroDeserializerConfigValue interface{}
+
+// AvroDeserializerConfig map of string to AvroDeserializerConfigValue
+type AvroDeserializerConfig map[string]AvroDeserializerConfigValue
+
+// AvroValue a deserialized value along with where its writer schema is registered
+type AvroValue struct {
+	Value      interface{} // in the native Go form goavro decodes to, such as map[string]interface{} for a record
+	SchemaID   int
+	RecordName string // full name of the named type the writer schema defines, or the type name for other schemas
+	Subject    string // the subject the SubjectNameStrategy names for the topic and RecordName
+}
+
+// AvroDeserializer using the schema registry client
+type AvroDeserializer struct {
+	client              srclient.ISchemaRegistryClient
+	schemas             map[int]avroRegisteredSchema // map from schema ID to associated writer schema
+	schemasLock         sync.RWMutex
+	subjectNameStrategy SubjectNameStrategy
+}
+
+// avroRegisteredSchema a writer schema fetched from Schema Registry
+type avroRegisteredSchema struct {
+	codec      *goavro.Codec
+	recordName string
+}
+
+// NewAvroDeserializer returns a new AvroDeserializer that fetches writer schemas from Schema Registry
+func NewAvroDeserializer(schemaRegistryClient srclient.ISchemaRegistryClient, config AvroDeserializerConfig) (*AvroDeserializer, error) {
+	if schemaRegistryClient == nil {
+		return nil, fmt.Errorf("schemaRegistryClient must not be nil")
+	}
+
+	ad := &AvroDeserializer{
+		client:  schemaRegistryClient,
+		schemas: make(map[int]avroRegisteredSchema),
+	}
+
+	// set all the defaults
+	configToUse := AvroDeserializerConfig{
+		SubjectNameStrategyImpl: TopicSubjectNameStrategy{}, // TopicSubjectNameStrategy is the default
+	}
+
+	// handle configuration
+	// update the defaults in configToUse with the values from the passed in config
+	if config != nil {
+		for key, value := range config {
+			configToUse[key] = value
+		}
+	}
+
+	err := ad.SetSubjectNameStrategy(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	// check for left over unrecognized properties
+	if len(configToUse) > 0 {
+		var keys []string
+		for key := range configToUse {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return nil, fmt.Errorf("unrecognized properties: %s", strings.Join(keys, ", "))
+	}
+
+	return ad, nil
+}
+
+// SetSubjectNameStrategy using the supplied AvroDeserializerConfig
+func (ad *AvroDeserializer) SetSubjectNameStrategy(config AvroDeserializerConfig) error {
+	subjectNameStrategyConf, ok := config[SubjectNameStrategyImpl]
+	if ok {
+		subjectNameStrategy, okTypeCast := subjectNameStrategyConf.(SubjectNameStrategy)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a SubjectNameStrategy", SubjectNameStrategyImpl)
+		}
+		ad.subjectNameStrategy = subjectNameStrategy
+		delete(config, SubjectNameStrategyImpl)
+	}
+	return nil
+}
+
+// Deserialize using the Confluent Schema Registry wire format, the writer schema is fetched from Schema Registry
+func (ad *AvroDeserializer) Deserialize(bytes []byte, ctx SerializationContext) (*AvroValue, error) {
+	return ad.DeserializeContext(context.Background(), bytes, ctx)
+}
+
+// DeserializeContext using the Confluent Schema Registry wire format, ctx is used for cancellation and deadlines of any Schema Registry calls
+func (ad *AvroDeserializer) DeserializeContext(ctx context.Context, bytes []byte, serCtx SerializationContext) (*AvroValue, error) {
+	schemaID, payload, err := parseWireFormat(bytes)
+	if err != nil {
+		return nil, newSerdeError(serCtx, "", schemaIdentifier{}, err)
+	}
+
+	writerSchema, err := ad.getWriterSchema(ctx, schemaID)
+	if err != nil {
+		return nil, newSerdeError(serCtx, "", schemaIdentifier{id: schemaID}, err)
+	}
+	subject := ad.subjectNameStrategy.Subject(serCtx, writerSchema.recordName)
+	payloadOffset := len(bytes) - len(payload)
+
+	value, remaining, err := writerSchema.codec.NativeFromBinary(payload)
+	if err != nil {
+		err = newKindError(ErrInvalidPayload, payloadOffset, fmt.Errorf("unable to decode %s with schema ID %d: %w", writerSchema.recordName, schemaID, err))
+		return nil, newSerdeError(serCtx, subject, schemaIdentifier{id: schemaID}, err)
+	}
+	if len(remaining) > 0 {
+		err = newKindError(ErrInvalidPayload, payloadOffset, fmt.Errorf("unable to decode %s with schema ID %d: %d bytes left over", writerSchema.recordName, schemaID, len(remaining)))
+		return nil, newSerdeError(serCtx, subject, schemaIdentifier{id: schemaID}, err)
+	}
+
+	return &AvroValue{
+		Value:      value,
+		SchemaID:   schemaID,
+		RecordName: writerSchema.recordName,
+		Subject:    subject,
+	}, nil
+}
+
+// DeserializeValue for AvroDeserializer, returns the value in the native Go form goavro decodes to
+func (ad *AvroDeserializer) DeserializeValue(ctx context.Context, bytes []byte, serCtx SerializationContext) (interface{}, error) {
+	av, err := ad.DeserializeContext(ctx, bytes, serCtx)
+	if err != nil {
+		return nil, err
+	}
+	return av.Value, nil
+}
+
+// getWriterSchema returns the writer schema registered with schemaID, fetching it from Schema Registry on first use
+func (ad *AvroDeserializer) getWriterSchema(ctx context.Context, schemaID int) (avroRegisteredSchema, error) {
+	ad.schemasLock.RLock()
+	writerSchema, ok := ad.schemas[schemaID]
+	ad.schemasLock.RUnlock()
+	if ok {
+		return writerSchema, nil
+	}
+
+	theSchema, err := callRegistry(ctx, func() (*srclient.Schema, error) {
+		return ad.client.GetSchema(schemaID)
+	})
+	if err != nil {
+		return avroRegisteredSchema{}, fmt.Errorf("unable to find schema ID %d: %w", schemaID, err)
+	}
+	codec, err := goavro.NewCodec(theSchema.Schema())
+	if err != nil {
+		return avroRegisteredSchema{}, fmt.Errorf("invalid Avro schema for schema ID %d: %w", schemaID, err)
+	}
+	recordName, err := avroRecordName(theSchema.Schema())
+	if err != nil {
+		return avroRegisteredSchema{}, err
+	}
+	writerSchema = avroRegisteredSchema{codec: codec, recordName: recordName}
+
+	ad.schemasLock.Lock()
+	ad.schemas[schemaID] = writerSchema
+	ad.schemasLock.Unlock()
+
+	return writerSchema, nil
+}
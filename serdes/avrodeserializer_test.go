@@ -0,0 +1,200 @@
+package serdes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/riferrei/srclient"
+	"reflect"
+	"testing"
+)
+
+func TestAvroDeserializer_Deserialize(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	serCtx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+
+	// another schema registered first so that the schema ID is not the default of 1
+	_, err := msrc.CreateSchema("other-value", testAvroSchemaV2, srclient.Avro)
+	if err != nil {
+		t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+	}
+	as, err := NewAvroSerializer(testAvroSchema, msrc, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewAvroSerializer: %s", err.Error())
+	}
+	data, err := as.Serialize(map[string]interface{}{"name": "Ann", "age": 40}, serCtx)
+	if err != nil {
+		t.Fatalf("unexpected error on Serialize: %s", err.Error())
+	}
+
+	cases := []struct {
+		name   string
+		config AvroDeserializerConfig
+		want   *AvroValue
+	}{
+		{
+			"topic subject",
+			nil,
+			&AvroValue{Value: map[string]interface{}{"name": "Ann", "age": int32(40)}, SchemaID: 2, RecordName: "com.example.User", Subject: "test-value"},
+		},
+		{
+			"record subject",
+			AvroDeserializerConfig{SubjectNameStrategyImpl: RecordSubjectNameStrategy{}},
+			&AvroValue{Value: map[string]interface{}{"name": "Ann", "age": int32(40)}, SchemaID: 2, RecordName: "com.example.User", Subject: "com.example.User"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ad, err := NewAvroDeserializer(msrc, c.config)
+			if err != nil {
+				t.Fatalf("unexpected error on NewAvroDeserializer: %s", err.Error())
+			}
+			got, err := ad.Deserialize(data, serCtx)
+			if err != nil {
+				t.Fatalf("unexpected error on Deserialize: %s", err.Error())
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("ad.Deserialize(%v) == %+v, want %+v", data, got, c.want)
+			}
+		})
+	}
+}
+
+func TestAvroDeserializer_DeserializeCachesSchemas(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	serCtx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+
+	as, err := NewAvroSerializer(testAvroSchema, msrc, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewAvroSerializer: %s", err.Error())
+	}
+	data, err := as.Serialize(map[string]interface{}{"name": "Ann", "age": 40}, serCtx)
+	if err != nil {
+		t.Fatalf("unexpected error on Serialize: %s", err.Error())
+	}
+
+	ad, err := NewAvroDeserializer(msrc, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewAvroDeserializer: %s", err.Error())
+	}
+	for i := 0; i < 3; i++ {
+		_, err = ad.Deserialize(data, serCtx)
+		if err != nil {
+			t.Fatalf("unexpected error on Deserialize: %s", err.Error())
+		}
+	}
+	if calls := msrc.callCount("GetSchema"); calls != 1 {
+		t.Fatalf("GetSchema called %d times, want 1", calls)
+	}
+}
+
+func TestAvroDeserializer_DeserializeErrors(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	_, err := msrc.CreateSchema("test-value", testAvroSchema, srclient.Avro)
+	if err != nil {
+		t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+	}
+	_, err = msrc.CreateSchema("proto-value", `syntax = "proto3";`, srclient.Protobuf)
+	if err != nil {
+		t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+	}
+
+	cases := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{
+			"message too small",
+			[]byte{0, 0, 0, 0},
+			"message too small. This message was not produced with a Confluent Schema Registry serializer",
+		},
+		{
+			"magic byte missing",
+			[]byte{1, 0, 0, 0, 1, 0},
+			"unknown magic byte. This message was not produced with a Confluent Schema Registry serializer",
+		},
+		{
+			"unknown schema ID",
+			[]byte{0, 0, 0, 0, 9, 0},
+			"unable to find schema ID 9: schema 9 not found",
+		},
+		{
+			"schema ID of a protobuf schema",
+			[]byte{0, 0, 0, 0, 2, 0},
+			"invalid Avro schema for schema ID 2: cannot unmarshal schema JSON: invalid character 's' looking for beginning of value",
+		},
+		{
+			"payload cut short",
+			[]byte{0, 0, 0, 0, 1, 6, 'A', 'n'},
+			"unable to decode com.example.User with schema ID 1: cannot decode binary record \"com.example.User\" field \"name\": cannot decode binary string: cannot decode binary bytes: short buffer",
+		},
+		{
+			"bytes left over",
+			[]byte{0, 0, 0, 0, 1, 6, 'A', 'n', 'n', 80, 0},
+			"unable to decode com.example.User with schema ID 1: 1 bytes left over",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ad, err := NewAvroDeserializer(msrc, nil)
+			if err != nil {
+				t.Fatalf("unexpected error on NewAvroDeserializer: %s", err.Error())
+			}
+			_, err = ad.Deserialize(c.data, SerializationContext{Topic: "test", Field: MessageFieldValue})
+			if err == nil || err.Error() != c.want {
+				t.Fatalf("ad.Deserialize(%v) == %v, want %v", c.data, err, c.want)
+			}
+		})
+	}
+}
+
+func TestAvroDeserializer_DeserializeContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ad, err := NewAvroDeserializer(newRegistryMockSchemaRegistryClient(), nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewAvroDeserializer: %s", err.Error())
+	}
+	_, err = ad.DeserializeContext(ctx, []byte{0, 0, 0, 0, 1, 0}, SerializationContext{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("ad.DeserializeContext() == %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestAvroDeserializer_NewAvroDeserializerErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		client srclient.ISchemaRegistryClient
+		config AvroDeserializerConfig
+		want   error
+	}{
+		{
+			"nil client",
+			nil,
+			nil,
+			errors.New("schemaRegistryClient must not be nil"),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", SubjectNameStrategyImpl),
+			&mockSchemaRegistryClient{},
+			AvroDeserializerConfig{SubjectNameStrategyImpl: "topic"},
+			fmt.Errorf("%s must be a SubjectNameStrategy", SubjectNameStrategyImpl),
+		},
+		{
+			"serializer only property",
+			&mockSchemaRegistryClient{},
+			AvroDeserializerConfig{AutoRegisterSchemas: true},
+			fmt.Errorf("unrecognized properties: %s", AutoRegisterSchemas),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := NewAvroDeserializer(c.client, c.config)
+			if err == nil || err.Error() != c.want.Error() {
+				t.Fatalf("NewAvroDeserializer(%v) == %v, want %v", c.config, err, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package serdes
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/riferrei/srclient"
+)
+
+// AvroSerializerConfigValue config values for avro serialization
+type AvroSerializerConfigValue interface{}
+
+// AvroSerializerConfig map of string to AvroSerializerConfigValue
+type AvroSerializerConfig map[string]AvroSerializerConfigValue
+
+// AvroSerializer using the schema registry client
+type AvroSerializer struct {
+	client              srclient.ISchemaRegistryClient
+	codec               *goavro.Codec
+	recordName          string
+	autoRegisterSchemas bool
+	useLatestVersion    bool
+	knownSubjects       map[string]avroWriterSchema // map from subject name to the schema data is written with
+	knownSubjectsLock   sync.RWMutex
+	subjectNameStrategy SubjectNameStrategy
+}
+
+// avroWriterSchema a registered schema and the codec to write data with it
+type avroWriterSchema struct {
+	id    int
+	codec *goavro.Codec
+}
+
+// NewAvroSerializer returns a new AvroSerializer for data written with the Avro schema
+func NewAvroSerializer(schema string, schemaRegistryClient srclient.ISchemaRegistryClient, config AvroSerializerConfig) (*AvroSerializer, error) {
+	codec, err := goavro.NewCodec(schema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Avro schema: %w", err)
+	}
+	recordName, err := avroRecordName(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	as := &AvroSerializer{
+		client:        schemaRegistryClient,
+		codec:         codec,
+		recordName:    recordName,
+		knownSubjects: make(map[string]avroWriterSchema),
+	}
+
+	// set all the defaults
+	configToUse := AvroSerializerConfig{
+		AutoRegisterSchemas:     true,
+		UseLatestVersion:        false,
+		SubjectNameStrategyImpl: TopicSubjectNameStrategy{}, // TopicSubjectNameStrategy is the default
+	}
+
+	// handle configuration
+	// update the defaults in configToUse with the values from the passed in config
+	if config != nil {
+		for key, value := range config {
+			configToUse[key] = value
+		}
+	}
+
+	err = as.SetAutoRegisterSchemas(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	err = as.SetUseLatestVersion(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	err = as.SetSubjectNameStrategy(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	// check for left over unrecognized properties
+	if len(configToUse) > 0 {
+		var keys []string
+		for key := range configToUse {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return nil, fmt.Errorf("unrecognized properties: %s", strings.Join(keys, ", "))
+	}
+
+	return as, nil
+}
+
+// SetAutoRegisterSchemas using the supplied AvroSerializerConfig
+func (as *AvroSerializer) SetAutoRegisterSchemas(config AvroSerializerConfig) error {
+	autoRegisterSchemasConf, ok := config[AutoRegisterSchemas]
+	if ok {
+		autoRegisterSchemas, okTypeCast := autoRegisterSchemasConf.(bool)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a boolean value", AutoRegisterSchemas)
+		}
+		as.autoRegisterSchemas = autoRegisterSchemas
+		delete(config, AutoRegisterSchemas)
+	}
+	return as.isUseLatestVersionAndAutoRegisterSchemas()
+}
+
+// SetUseLatestVersion using the supplied AvroSerializerConfig
+func (as *AvroSerializer) SetUseLatestVersion(config AvroSerializerConfig) error {
+	useLatestVersionConf, ok := config[UseLatestVersion]
+	if ok {
+		useLatestVersion, okTypeCast := useLatestVersionConf.(bool)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a boolean value", UseLatestVersion)
+		}
+		as.useLatestVersion = useLatestVersion
+		delete(config, UseLatestVersion)
+	}
+	return as.isUseLatestVersionAndAutoRegisterSchemas()
+}
+
+func (as *AvroSerializer) isUseLatestVersionAndAutoRegisterSchemas() error {
+	if as.useLatestVersion && as.autoRegisterSchemas {
+		return fmt.Errorf("cannot enable both %s and %s", UseLatestVersion, AutoRegisterSchemas)
+	}
+	return nil
+}
+
+// SetSubjectNameStrategy using the supplied AvroSerializerConfig
+func (as *AvroSerializer) SetSubjectNameStrategy(config AvroSerializerConfig) error {
+	subjectNameStrategyConf, ok := config[SubjectNameStrategyImpl]
+	if ok {
+		subjectNameStrategy, okTypeCast := subjectNameStrategyConf.(SubjectNameStrategy)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a SubjectNameStrategy", SubjectNameStrategyImpl)
+		}
+		as.subjectNameStrategy = subjectNameStrategy
+		delete(config, SubjectNameStrategyImpl)
+	}
+	return nil
+}
+
+// Serialize using the Confluent Schema Registry wire format, datum is in the native Go form goavro accepts
+func (as *AvroSerializer) Serialize(datum interface{}, ctx SerializationContext) ([]byte, error) {
+	return as.SerializeContext(context.Background(), datum, ctx)
+}
+
+// SerializeContext using the Confluent Schema Registry wire format, ctx is used for cancellation and deadlines of any Schema Registry calls
+func (as *AvroSerializer) SerializeContext(ctx context.Context, datum interface{}, serCtx SerializationContext) ([]byte, error) {
+	subject := as.subjectNameStrategy.Subject(serCtx, as.recordName)
+
+	writerSchema, err := as.getWriterSchema(ctx, subject)
+	if err != nil {
+		return nil, newSerdeError(serCtx, subject, schemaIdentifier{}, err)
+	}
+
+	schemaIDBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(schemaIDBytes, uint32(writerSchema.id))
+
+	var msgBytes []byte
+	// schema serialization protocol version number
+	msgBytes = append(msgBytes, byte(0))
+	// schema id
+	msgBytes = append(msgBytes, schemaIDBytes...)
+
+	msgBytes, err = writerSchema.codec.BinaryFromNative(msgBytes, datum)
+	if err != nil {
+		return nil, newSerdeError(serCtx, subject, schemaIdentifier{id: writerSchema.id}, fmt.Errorf("unable to encode %s: %w", as.recordName, err))
+	}
+	return msgBytes, nil
+}
+
+// SerializeValue for AvroSerializer, value is in the native Go form goavro accepts
+func (as *AvroSerializer) SerializeValue(ctx context.Context, value interface{}, serCtx SerializationContext) ([]byte, error) {
+	return as.SerializeContext(ctx, value, serCtx)
+}
+
+// getWriterSchema returns the schema to write data for subject with, the cache is only updated once every Schema Registry call has succeeded
+func (as *AvroSerializer) getWriterSchema(ctx context.Context, subject string) (avroWriterSchema, error) {
+	as.knownSubjectsLock.RLock()
+	writerSchema, ok := as.knownSubjects[subject]
+	as.knownSubjectsLock.RUnlock()
+	if ok {
+		return writerSchema, nil
+	}
+
+	if as.useLatestVersion {
+		theSchema, err := callRegistry(ctx, func() (*srclient.Schema, error) {
+			return as.client.GetLatestSchema(subject)
+		})
+		if err != nil {
+			return avroWriterSchema{}, err
+		}
+		// data must be written with the latest schema for its ID to describe it, a codec is built here rather than
+		// with theSchema.Codec() as that is not safe to call from several goroutines on a cached schema
+		codec, err := goavro.NewCodec(theSchema.Schema())
+		if err != nil {
+			return avroWriterSchema{}, fmt.Errorf("invalid Avro schema for latest version %d of subject %s: %w", theSchema.Version(), subject, err)
+		}
+		writerSchema = avroWriterSchema{id: theSchema.ID(), codec: codec}
+	} else if as.autoRegisterSchemas {
+		theSchema, err := callRegistry(ctx, func() (*srclient.Schema, error) {
+			return as.client.CreateSchema(subject, as.codec.Schema(), srclient.Avro)
+		})
+		if err != nil {
+			return avroWriterSchema{}, err
+		}
+		writerSchema = avroWriterSchema{id: theSchema.ID(), codec: as.codec}
+	} else {
+		theSchema, err := callRegistry(ctx, func() (*srclient.Schema, error) {
+			return as.client.LookupSchema(subject, as.codec.Schema(), srclient.Avro)
+		})
+		if err != nil {
+			return avroWriterSchema{}, err
+		}
+		writerSchema = avroWriterSchema{id: theSchema.ID(), codec: as.codec}
+	}
+
+	as.knownSubjectsLock.Lock()
+	as.knownSubjects[subject] = writerSchema
+	as.knownSubjectsLock.Unlock()
+
+	return writerSchema, nil
+}
+
+// avroRecordName returns the full name of the named type schema defines, for other schemas it returns the type name,
+// as Confluent's Java serializer does
+func avroRecordName(schema string) (string, error) {
+	var parsed interface{}
+	err := json.Unmarshal([]byte(schema), &parsed)
+	if err != nil {
+		return "", fmt.Errorf("invalid Avro schema: %w", err)
+	}
+	return avroTypeName(parsed)
+}
+
+// avroTypeName returns the name of the type described by the parsed JSON schema
+func avroTypeName(schema interface{}) (string, error) {
+	switch s := schema.(type) {
+	case string:
+		return s, nil
+	case []interface{}:
+		return "union", nil
+	case map[string]interface{}:
+		typeName, ok := s["type"].(string)
+		if !ok {
+			// make recursive call
+			return avroTypeName(s["type"])
+		}
+		switch typeName {
+		case "record", "error", "enum", "fixed":
+			name, _ := s["name"].(string)
+			namespace, _ := s["namespace"].(string)
+			if strings.Contains(name, ".") || namespace == "" {
+				return name, nil
+			}
+			return namespace + "." + name, nil
+		default:
+			return typeName, nil
+		}
+	default:
+		return "", fmt.Errorf("invalid Avro schema: unexpected type %v", schema)
+	}
+}
@@ -0,0 +1,238 @@
+package serdes
+
+import (
+	"errors"
+	"fmt"
+	"github.com/linkedin/goavro/v2"
+	"github.com/riferrei/srclient"
+	"reflect"
+	"testing"
+)
+
+const testAvroSchema = `{"type":"record","name":"User","namespace":"com.example","fields":[{"name":"name","type":"string"},{"name":"age","type":"int"}]}`
+
+// testAvroSchemaV2 adds a field with a default to testAvroSchema
+const testAvroSchemaV2 = `{"type":"record","name":"User","namespace":"com.example","fields":[{"name":"name","type":"string"},{"name":"age","type":"int"},{"name":"email","type":"string","default":""}]}`
+
+// testAvroBytes returns the wire format bytes of datum written with schema under schemaID
+func testAvroBytes(t *testing.T, schema string, schemaID byte, datum interface{}) []byte {
+	codec, err := goavro.NewCodec(schema)
+	if err != nil {
+		t.Fatalf("unexpected error on NewCodec: %s", err.Error())
+	}
+	data, err := codec.BinaryFromNative([]byte{0, 0, 0, 0, schemaID}, datum)
+	if err != nil {
+		t.Fatalf("unexpected error on BinaryFromNative: %s", err.Error())
+	}
+	return data
+}
+
+func TestAvroSerializer_Serialize(t *testing.T) {
+	user := map[string]interface{}{"name": "Ann", "age": 40}
+	// data for the latest schema has every field it needs
+	userV2 := map[string]interface{}{"name": "Ann", "age": 40, "email": ""}
+
+	cases := []struct {
+		name        string
+		config      AvroSerializerConfig
+		registered  map[string]string // map from subject to schema registered before serializing
+		ctx         SerializationContext
+		datum       map[string]interface{}
+		wantSubject string
+		want        []byte
+	}{
+		{
+			"auto register under the topic subject",
+			nil,
+			nil,
+			SerializationContext{Topic: "test", Field: MessageFieldValue},
+			user,
+			"test-value",
+			testAvroBytes(t, testAvroSchema, 1, user),
+		},
+		{
+			"auto register under the record subject",
+			AvroSerializerConfig{SubjectNameStrategyImpl: RecordSubjectNameStrategy{}},
+			nil,
+			SerializationContext{Topic: "test", Field: MessageFieldValue},
+			user,
+			"com.example.User",
+			testAvroBytes(t, testAvroSchema, 1, user),
+		},
+		{
+			"auto register under the topic and record subject for a key",
+			AvroSerializerConfig{SubjectNameStrategyImpl: TopicRecordSubjectNameStrategy{}},
+			nil,
+			SerializationContext{Topic: "test", Field: MessageFieldKey},
+			user,
+			"test-com.example.User",
+			testAvroBytes(t, testAvroSchema, 1, user),
+		},
+		{
+			"look up the registered schema",
+			AvroSerializerConfig{AutoRegisterSchemas: false},
+			map[string]string{"other-value": testAvroSchemaV2, "test-value": testAvroSchema},
+			SerializationContext{Topic: "test", Field: MessageFieldValue},
+			user,
+			"test-value",
+			testAvroBytes(t, testAvroSchema, 2, user),
+		},
+		{
+			"write with the latest schema",
+			AvroSerializerConfig{AutoRegisterSchemas: false, UseLatestVersion: true},
+			map[string]string{"test-value": testAvroSchemaV2},
+			SerializationContext{Topic: "test", Field: MessageFieldValue},
+			userV2,
+			"test-value",
+			testAvroBytes(t, testAvroSchemaV2, 1, userV2),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			msrc := newRegistryMockSchemaRegistryClient()
+			for _, subject := range []string{"other-value", "test-value"} {
+				if schema, ok := c.registered[subject]; ok {
+					_, err := msrc.CreateSchema(subject, schema, srclient.Avro)
+					if err != nil {
+						t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+					}
+				}
+			}
+			as, err := NewAvroSerializer(testAvroSchema, msrc, c.config)
+			if err != nil {
+				t.Fatalf("unexpected error on NewAvroSerializer: %s", err.Error())
+			}
+			// the second call uses the cached schema
+			for i := 0; i < 2; i++ {
+				got, err := as.Serialize(c.datum, c.ctx)
+				if err != nil {
+					t.Fatalf("unexpected error on Serialize: %s", err.Error())
+				}
+				if !reflect.DeepEqual(got, c.want) {
+					t.Fatalf("as.Serialize(%v) == %v, want %v", c.datum, got, c.want)
+				}
+			}
+			if _, ok := msrc.subjects[c.wantSubject]; !ok {
+				t.Fatalf("subjects == %v, want %s", msrc.subjects, c.wantSubject)
+			}
+			calls := msrc.callCount("CreateSchema") + msrc.callCount("LookupSchema") + msrc.callCount("GetLatestSchema")
+			if calls != 1+len(c.registered) {
+				t.Fatalf("Schema Registry called %d times, want %d", calls, 1+len(c.registered))
+			}
+		})
+	}
+}
+
+func TestAvroSerializer_SerializeErrors(t *testing.T) {
+	ctx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+
+	as, err := NewAvroSerializer(testAvroSchema, newRegistryMockSchemaRegistryClient(), AvroSerializerConfig{AutoRegisterSchemas: false})
+	if err != nil {
+		t.Fatalf("unexpected error on NewAvroSerializer: %s", err.Error())
+	}
+	_, err = as.Serialize(map[string]interface{}{"name": "Ann", "age": 40}, ctx)
+	if err == nil || err.Error() != "schema not found for subject test-value" {
+		t.Fatalf("as.Serialize() == %v, want schema not found for subject test-value", err)
+	}
+
+	as, err = NewAvroSerializer(testAvroSchema, newRegistryMockSchemaRegistryClient(), nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewAvroSerializer: %s", err.Error())
+	}
+	_, err = as.Serialize(map[string]interface{}{"name": "Ann"}, ctx)
+	if err == nil {
+		t.Fatalf("as.Serialize() with a missing field == nil, want an error")
+	}
+}
+
+func TestAvroSerializer_NewAvroSerializer(t *testing.T) {
+	msrc := &mockSchemaRegistryClient{}
+	as, err := NewAvroSerializer(testAvroSchema, msrc, AvroSerializerConfig{AutoRegisterSchemas: false, UseLatestVersion: true})
+	if err != nil {
+		t.Fatalf("unexpected error on NewAvroSerializer: %s", err.Error())
+	}
+	if as.autoRegisterSchemas || !as.useLatestVersion || as.recordName != "com.example.User" || as.subjectNameStrategy != (TopicSubjectNameStrategy{}) {
+		t.Fatalf("NewAvroSerializer() == %+v, want use.latest.version with TopicSubjectNameStrategy for com.example.User", as)
+	}
+}
+
+func TestAvroSerializer_NewAvroSerializerErrors(t *testing.T) {
+	msrc := &mockSchemaRegistryClient{}
+
+	cases := []struct {
+		name   string
+		schema string
+		config AvroSerializerConfig
+		want   error
+	}{
+		{
+			"invalid schema",
+			`{"type":"record","name":"User"}`,
+			nil,
+			errors.New(`invalid Avro schema: Record "User" ought to have fields key`),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", AutoRegisterSchemas),
+			testAvroSchema,
+			AvroSerializerConfig{AutoRegisterSchemas: "true"},
+			fmt.Errorf("%s must be a boolean value", AutoRegisterSchemas),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", UseLatestVersion),
+			testAvroSchema,
+			AvroSerializerConfig{AutoRegisterSchemas: false, UseLatestVersion: 1},
+			fmt.Errorf("%s must be a boolean value", UseLatestVersion),
+		},
+		{
+			fmt.Sprintf("both %s and %s", UseLatestVersion, AutoRegisterSchemas),
+			testAvroSchema,
+			AvroSerializerConfig{UseLatestVersion: true},
+			fmt.Errorf("cannot enable both %s and %s", UseLatestVersion, AutoRegisterSchemas),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", SubjectNameStrategyImpl),
+			testAvroSchema,
+			AvroSerializerConfig{SubjectNameStrategyImpl: "topic"},
+			fmt.Errorf("%s must be a SubjectNameStrategy", SubjectNameStrategyImpl),
+		},
+		{
+			"protobuf only property",
+			testAvroSchema,
+			AvroSerializerConfig{SkipKnownTypes: true},
+			fmt.Errorf("unrecognized properties: %s", SkipKnownTypes),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := NewAvroSerializer(c.schema, msrc, c.config)
+			if err == nil || err.Error() != c.want.Error() {
+				t.Fatalf("NewAvroSerializer(%v) == %v, want %v", c.config, err, c.want)
+			}
+		})
+	}
+}
+
+func TestAvroSerializer_avroRecordName(t *testing.T) {
+	cases := []struct {
+		schema string
+		want   string
+	}{
+		{testAvroSchema, "com.example.User"},
+		{`{"type":"record","name":"com.other.User","namespace":"com.example","fields":[]}`, "com.other.User"},
+		{`{"type":"enum","name":"Suit","symbols":["SPADES"]}`, "Suit"},
+		{`{"type":"fixed","name":"Hash","namespace":"com.example","size":16}`, "com.example.Hash"},
+		{`"string"`, "string"},
+		{`{"type":"long","logicalType":"timestamp-millis"}`, "long"},
+		{`{"type":"array","items":"int"}`, "array"},
+		{`["null","string"]`, "union"},
+	}
+	for _, c := range cases {
+		got, err := avroRecordName(c.schema)
+		if err != nil {
+			t.Fatalf("unexpected error on avroRecordName: %s", err.Error())
+		}
+		if got != c.want {
+			t.Fatalf("avroRecordName(%s) == %s, want %s", c.schema, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package serdes
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/riferrei/srclient"
+)
+
+// Errors to match with errors.Is, every error returned when serializing or deserializing is a *SerdeError wrapping
+// one of these or the error of whatever failed
+var (
+	// ErrMessageTooSmall the record is shorter than the wire format needs
+	ErrMessageTooSmall = errors.New("message too small. This message was not produced with a Confluent Schema Registry serializer")
+	// ErrUnknownMagicByte the record does not start with a magic byte of the wire format
+	ErrUnknownMagicByte = errors.New("unknown magic byte. This message was not produced with a Confluent Schema Registry serializer")
+	// ErrInvalidMessageIndex the Protobuf message index array cannot be decoded
+	ErrInvalidMessageIndex = errors.New("unable to decode message index array")
+	// ErrInvalidPayload the payload after the wire format cannot be decoded with the writer schema
+	ErrInvalidPayload = errors.New("invalid payload")
+	// ErrMessageTypeMismatch the writer schema has another message type than the one being deserialized into
+	ErrMessageTypeMismatch = errors.New("message type mismatch")
+	// ErrSchemaNotFound Schema Registry does not have the schema, subject or version asked for
+	ErrSchemaNotFound = errors.New("schema not found")
+	// ErrRegistryUnavailable Schema Registry could not be reached or failed to answer, the call may succeed if retried
+	ErrRegistryUnavailable = errors.New("schema registry unavailable")
+)
+
+// SerdeError a failure to serialize or deserialize a record, along with what was known of the record when it failed.
+// Error returns the message of Err unchanged, use errors.As to get at the fields.
+type SerdeError struct {
+	Topic      string
+	Field      string // either key or value
+	Subject    string // empty when not known, deserializers only know it for Avro and when migrating Protobuf
+	SchemaID   int    // 0 when not known
+	SchemaGUID string // empty unless the record was written with the GUID framing
+	// Offset the position in the record the error was found at, in the schema ID header when the error is in it, and
+	// the start of the payload for ErrInvalidPayload. -1 when the error is not about a position in the record
+	Offset int
+	Err    error
+}
+
+// Error for SerdeError
+func (e *SerdeError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap for SerdeError
+func (e *SerdeError) Unwrap() error {
+	return e.Err
+}
+
+// Retriable see IsRetriable
+func (e *SerdeError) Retriable() bool {
+	return IsRetriable(e.Err)
+}
+
+// IsRetriable reports whether err is a transient failure, such as Schema Registry being unreachable or the deadline
+// of the context passing, so the same record may succeed if tried again. Any other error, such as a malformed record
+// or a schema missing from Schema Registry, fails every time and the record is best sent to a dead letter queue.
+func IsRetriable(err error) bool {
+	return errors.Is(err, ErrRegistryUnavailable) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// newSerdeError wraps err in a *SerdeError, or fills in the blanks of the one err already has
+func newSerdeError(serCtx SerializationContext, subject string, schema schemaIdentifier, err error) error {
+	var serdeErr *SerdeError
+	if errors.As(err, &serdeErr) {
+		if serdeErr.Topic == "" {
+			serdeErr.Topic, serdeErr.Field = serCtx.Topic, serCtx.Field
+		}
+		if serdeErr.Subject == "" {
+			serdeErr.Subject = subject
+		}
+		if serdeErr.SchemaID == 0 && serdeErr.SchemaGUID == "" {
+			serdeErr.SchemaID, serdeErr.SchemaGUID = schema.id, schema.guid
+		}
+		return err
+	}
+
+	offset := -1
+	var kindErr *kindError
+	if errors.As(err, &kindErr) {
+		offset = kindErr.offset
+	}
+	return &SerdeError{
+		Topic:      serCtx.Topic,
+		Field:      serCtx.Field,
+		Subject:    subject,
+		SchemaID:   schema.id,
+		SchemaGUID: schema.guid,
+		Offset:     offset,
+		Err:        err,
+	}
+}
+
+// kindError an error matching one of the sentinel errors that keeps its own message
+type kindError struct {
+	kind   error
+	msg    string
+	offset int   // -1 when the error is not about a position in the record
+	cause  error // nil when there is none
+}
+
+// newKindError returns an error matching kind with the message of cause
+func newKindError(kind error, offset int, cause error) *kindError {
+	return &kindError{kind: kind, msg: cause.Error(), offset: offset, cause: cause}
+}
+
+// Error for kindError
+func (e *kindError) Error() string {
+	return e.msg
+}
+
+// Is for kindError
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+// Unwrap for kindError
+func (e *kindError) Unwrap() error {
+	return e.cause
+}
+
+// registryError an error returned by the Schema Registry client, classified as ErrSchemaNotFound,
+// ErrRegistryUnavailable or neither
+type registryError struct {
+	err error
+}
+
+// Error for registryError
+func (e *registryError) Error() string {
+	return e.err.Error()
+}
+
+// Is for registryError
+func (e *registryError) Is(target error) bool {
+	switch target {
+	case ErrSchemaNotFound:
+		return registryStatusCode(e.err) == 404
+	case ErrRegistryUnavailable:
+		return isRegistryUnavailable(e.err)
+	}
+	return false
+}
+
+// Unwrap for registryError
+func (e *registryError) Unwrap() error {
+	return e.err
+}
+
+// registryStatusCode returns the HTTP status code of a Schema Registry error response, or 0 when err is not one.
+// Schema Registry error codes extend the status code with two more digits, such as 40401 for subject not found, and
+// srclient reports responses without a JSON body by their status line alone.
+func registryStatusCode(err error) int {
+	var srErr srclient.Error
+	if errors.As(err, &srErr) {
+		if srErr.Code >= 10000 {
+			return srErr.Code / 100
+		}
+		return srErr.Code
+	}
+	status := err.Error()
+	if len(status) > 3 && status[3] == ' ' {
+		if code, convErr := strconv.Atoi(status[:3]); convErr == nil && code >= 100 && code <= 599 {
+			return code
+		}
+	}
+	return 0
+}
+
+// isRegistryUnavailable reports whether err is a transient failure to reach or get an answer from Schema Registry
+func isRegistryUnavailable(err error) bool {
+	switch code := registryStatusCode(err); {
+	case code == 408 || code == 429 || code >= 500:
+		return true
+	case code != 0:
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	// connection refused or reset, and unresolvable hosts
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	if errors.As(err, &opErr) || errors.As(err, &dnsErr) {
+		return true
+	}
+	// the connection closed before the whole response was read
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,235 @@
+package serdes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/finncolman/kafka-go-serdes/internal/message"
+	"github.com/finncolman/kafka-go-serdes/internal/messagerefs"
+	"github.com/riferrei/srclient"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// failingMockSchemaRegistryClient fails every call with err
+type failingMockSchemaRegistryClient struct {
+	mockSchemaRegistryClient
+	err error
+}
+
+func (m *failingMockSchemaRegistryClient) GetSchema(int) (*srclient.Schema, error) {
+	return nil, m.err
+}
+
+func (m *failingMockSchemaRegistryClient) LookupSchema(string, string, srclient.SchemaType, ...srclient.Reference) (*srclient.Schema, error) {
+	return nil, m.err
+}
+
+// testTimeoutError a net.Error that timed out
+type testTimeoutError struct{}
+
+func (testTimeoutError) Error() string   { return "i/o timeout" }
+func (testTimeoutError) Timeout() bool   { return true }
+func (testTimeoutError) Temporary() bool { return true }
+
+func TestErrors_registryError(t *testing.T) {
+	connRefused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+
+	cases := []struct {
+		name            string
+		err             error
+		wantNotFound    bool
+		wantUnavailable bool
+	}{
+		{"subject not found", srclient.Error{Code: 40401, Message: "Subject not found"}, true, false},
+		{"schema not found", srclient.Error{Code: 40403, Message: "Schema not found"}, true, false},
+		{"invalid schema", srclient.Error{Code: 42201, Message: "Invalid schema"}, false, false},
+		{"backend store error", srclient.Error{Code: 50001, Message: "Error in the backend data store"}, false, true},
+		{"too many requests", srclient.Error{Code: 429, Message: "Too many requests"}, false, true},
+		{"status line only", errors.New("503 Service Unavailable"), false, true},
+		{"not found status line only", errors.New("404 Not Found"), true, false},
+		{"connection refused", &url.Error{Op: "Get", URL: "http://registry", Err: connRefused}, false, true},
+		{"timeout", &url.Error{Op: "Get", URL: "http://registry", Err: testTimeoutError{}}, false, true},
+		{"connection closed", &url.Error{Op: "Get", URL: "http://registry", Err: fmt.Errorf("reading body: %w", io.ErrUnexpectedEOF)}, false, true},
+		{"other error", errors.New("Schema ID is not registered"), false, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := fmt.Errorf("unable to find schema ID 1: %w", &registryError{err: c.err})
+			if got := errors.Is(err, ErrSchemaNotFound); got != c.wantNotFound {
+				t.Fatalf("errors.Is(%v, ErrSchemaNotFound) == %v, want %v", c.err, got, c.wantNotFound)
+			}
+			if got := errors.Is(err, ErrRegistryUnavailable); got != c.wantUnavailable {
+				t.Fatalf("errors.Is(%v, ErrRegistryUnavailable) == %v, want %v", c.err, got, c.wantUnavailable)
+			}
+			if got := IsRetriable(err); got != c.wantUnavailable {
+				t.Fatalf("IsRetriable(%v) == %v, want %v", c.err, got, c.wantUnavailable)
+			}
+			if !errors.Is(err, c.err) {
+				t.Fatalf("errors.Is(%v, %v) == false, want true", err, c.err)
+			}
+		})
+	}
+}
+
+func TestErrors_IsRetriable(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{context.DeadlineExceeded, true},
+		{fmt.Errorf("unable to find schema ID 1: %w", context.DeadlineExceeded), true},
+		{context.Canceled, false},
+		{ErrRegistryUnavailable, true},
+		{ErrMessageTooSmall, false},
+		{&SerdeError{Offset: -1, Err: &registryError{err: errors.New("502 Bad Gateway")}}, true},
+		{nil, false},
+	}
+	for _, c := range cases {
+		if got := IsRetriable(c.err); got != c.want {
+			t.Fatalf("IsRetriable(%v) == %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestErrors_ProtobufDeserialize(t *testing.T) {
+	serCtx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+
+	cases := []struct {
+		name         string
+		data         []byte
+		wantErr      error
+		wantSchemaID int
+		wantGUID     string
+		wantOffset   int
+	}{
+		{"message too small", []byte{0, 0, 0, 0, 1}, ErrMessageTooSmall, 0, "", 5},
+		{"unknown magic byte", []byte{2, 0, 0, 0, 1, 0}, ErrUnknownMagicByte, 0, "", 0},
+		{"invalid message index array length", []byte{0, 0, 0, 0, 1, 3, 0}, ErrInvalidMessageIndex, 0, "", 5},
+		{"message index array missing byte", []byte{0, 0, 0, 0, 1, 4, 0}, ErrInvalidMessageIndex, 0, "", 7},
+		{"invalid payload", []byte{0, 0, 0, 0, 7, 0, 0xff}, ErrInvalidPayload, 7, "", 6},
+		{
+			"invalid payload with schema GUID",
+			[]byte{1, 0x3d, 0x67, 0x4b, 0x6a, 0x53, 0x9c, 0x4b, 0x8a, 0x9e, 0x7f, 0x1c, 0x2d, 0x3e, 0x4f, 0x50, 0x61, 0, 0xff},
+			ErrInvalidPayload,
+			0,
+			"3d674b6a-539c-4b8a-9e7f-1c2d3e4f5061",
+			18,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pd := NewProtobufDeserializer()
+			err := pd.DeserializeWithHeaders(nil, c.data, serCtx, &message.MessageData{})
+			if !errors.Is(err, c.wantErr) {
+				t.Fatalf("pd.DeserializeWithHeaders(%v) == %v, want %v", c.data, err, c.wantErr)
+			}
+			if IsRetriable(err) {
+				t.Fatalf("IsRetriable(%v) == true, want false", err)
+			}
+			var serdeErr *SerdeError
+			if !errors.As(err, &serdeErr) {
+				t.Fatalf("pd.DeserializeWithHeaders(%v) == %T, want a *SerdeError", c.data, err)
+			}
+			want := &SerdeError{
+				Topic:      serCtx.Topic,
+				Field:      serCtx.Field,
+				SchemaID:   c.wantSchemaID,
+				SchemaGUID: c.wantGUID,
+				Offset:     c.wantOffset,
+				Err:        serdeErr.Err,
+			}
+			if !reflect.DeepEqual(serdeErr, want) {
+				t.Fatalf("pd.DeserializeWithHeaders(%v) == %+v, want %+v", c.data, serdeErr, want)
+			}
+		})
+	}
+}
+
+func TestErrors_ProtobufDeserializeRegistry(t *testing.T) {
+	connRefused := &url.Error{Op: "Get", URL: "http://registry/schemas/ids/42", Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}
+	serCtx := SerializationContext{Topic: "test", Field: MessageFieldKey}
+	data := []byte{0, 0, 0, 0, 42, 0}
+
+	pd, err := NewProtobufDeserializerWithClient(&failingMockSchemaRegistryClient{err: connRefused}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufDeserializerWithClient: %s", err.Error())
+	}
+	_, err = pd.DeserializeDynamicWithHeaders(nil, data, serCtx)
+	var serdeErr *SerdeError
+	if !errors.As(err, &serdeErr) {
+		t.Fatalf("pd.DeserializeDynamicWithHeaders(%v) == %v, want a *SerdeError", data, err)
+	}
+	if serdeErr.SchemaID != 42 || serdeErr.Topic != "test" || serdeErr.Field != MessageFieldKey || serdeErr.Offset != -1 {
+		t.Fatalf("pd.DeserializeDynamicWithHeaders(%v) == %+v, want schema ID 42 of the key of topic test", data, serdeErr)
+	}
+	if !serdeErr.Retriable() || !errors.Is(err, ErrRegistryUnavailable) {
+		t.Fatalf("pd.DeserializeDynamicWithHeaders(%v) == %v, want a retriable error", data, err)
+	}
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		t.Fatalf("pd.DeserializeDynamicWithHeaders(%v) == %v, want it to wrap the *url.Error", data, err)
+	}
+}
+
+func TestErrors_ProtobufSerialize(t *testing.T) {
+	serCtx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+	msgData := &messagerefs.MessageData{}
+
+	cases := []struct {
+		name          string
+		err           error
+		wantNotFound  bool
+		wantRetriable bool
+	}{
+		{"schema not found", srclient.Error{Code: 40403, Message: "Schema not found"}, true, false},
+		{"registry unavailable", errors.New("503 Service Unavailable"), false, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ps, err := NewProtobufSerializer(msgData.ProtoReflect().Descriptor(), &failingMockSchemaRegistryClient{err: c.err}, ProtobufSerializerConfig{
+				AutoRegisterSchemas: false,
+				SkipKnownTypes:      true,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+			}
+			_, err = ps.Serialize(msgData, serCtx)
+			var serdeErr *SerdeError
+			if !errors.As(err, &serdeErr) {
+				t.Fatalf("ps.Serialize(%v) == %v, want a *SerdeError", msgData, err)
+			}
+			if serdeErr.Subject != "test-value" || serdeErr.SchemaID != 0 || serdeErr.Offset != -1 {
+				t.Fatalf("ps.Serialize(%v) == %+v, want subject test-value without a schema ID", msgData, serdeErr)
+			}
+			if got := errors.Is(err, ErrSchemaNotFound); got != c.wantNotFound {
+				t.Fatalf("errors.Is(%v, ErrSchemaNotFound) == %v, want %v", err, got, c.wantNotFound)
+			}
+			if got := IsRetriable(err); got != c.wantRetriable {
+				t.Fatalf("IsRetriable(%v) == %v, want %v", err, got, c.wantRetriable)
+			}
+		})
+	}
+}
+
+func TestErrors_JSONSchemaDeserialize(t *testing.T) {
+	data := []byte{0, 0, 0, 0, 3, '{'}
+
+	jd, err := NewJSONSchemaDeserializer(newRegistryMockSchemaRegistryClient(), nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewJSONSchemaDeserializer: %s", err.Error())
+	}
+	err = jd.Deserialize(data, &testJSONUser{})
+	var serdeErr *SerdeError
+	if !errors.As(err, &serdeErr) || !errors.Is(err, ErrInvalidPayload) {
+		t.Fatalf("jd.Deserialize(%v) == %v, want a *SerdeError for ErrInvalidPayload", data, err)
+	}
+	if serdeErr.SchemaID != 3 || serdeErr.Offset != 5 {
+		t.Fatalf("jd.Deserialize(%v) == %+v, want schema ID 3 at offset 5", data, serdeErr)
+	}
+}
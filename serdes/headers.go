@@ -0,0 +1,34 @@
+package serdes
+
+const (
+	// KeySchemaIDHeader the record header carrying the schema ID of the key when it is not in the payload
+	KeySchemaIDHeader = "__key_schema_id"
+	// ValueSchemaIDHeader the record header carrying the schema ID of the value when it is not in the payload
+	ValueSchemaIDHeader = "__value_schema_id"
+)
+
+// Header a Kafka record header, it converts directly to the header types of confluent-kafka-go, sarama and franz-go
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// schemaIDHeaderKey returns the header the schema ID is carried in for field, either key or value
+func schemaIDHeaderKey(field string) string {
+	if field == MessageFieldKey {
+		return KeySchemaIDHeader
+	}
+	return ValueSchemaIDHeader
+}
+
+// findSchemaIDHeader returns the value of the schema ID header for field, the last one wins if it is repeated as
+// Kafka headers are an ordered list that may be appended to
+func findSchemaIDHeader(headers []Header, field string) ([]byte, bool) {
+	key := schemaIDHeaderKey(field)
+	for i := len(headers) - 1; i >= 0; i-- {
+		if headers[i].Key == key {
+			return headers[i].Value, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,167 @@
+package serdes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/riferrei/srclient"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+const (
+	// FailInvalidSchema validate payloads against their writer schema and fail those that do not match
+	FailInvalidSchema = "json.fail.invalid.schema"
+)
+
+// JSONSchemaDeserializerConfigValue config values for JSON Schema deserialization
+type JSONSchemaDeserializerConfigValue interface{}
+
+// JSONSchemaDeserializerConfig map of string to JSONSchemaDeserializerConfigValue
+type JSONSchemaDeserializerConfig map[string]JSONSchemaDeserializerConfigValue
+
+// JSONSchemaDeserializer using the schema registry client
+type JSONSchemaDeserializer struct {
+	client            srclient.ISchemaRegistryClient
+	schemas           map[int]*jsonschema.Schema // map from schema ID to associated compiled writer schema
+	schemasLock       sync.RWMutex
+	failInvalidSchema bool
+}
+
+// NewJSONSchemaDeserializer returns a new JSONSchemaDeserializer that fetches writer schemas from Schema Registry when validating
+func NewJSONSchemaDeserializer(schemaRegistryClient srclient.ISchemaRegistryClient, config JSONSchemaDeserializerConfig) (*JSONSchemaDeserializer, error) {
+	if schemaRegistryClient == nil {
+		return nil, fmt.Errorf("schemaRegistryClient must not be nil")
+	}
+
+	jd := &JSONSchemaDeserializer{
+		client:  schemaRegistryClient,
+		schemas: make(map[int]*jsonschema.Schema),
+	}
+
+	// set all the defaults
+	configToUse := JSONSchemaDeserializerConfig{
+		FailInvalidSchema: false,
+	}
+
+	// handle configuration
+	// update the defaults in configToUse with the values from the passed in config
+	if config != nil {
+		for key, value := range config {
+			configToUse[key] = value
+		}
+	}
+
+	err := jd.SetFailInvalidSchema(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	// check for left over unrecognized properties
+	if len(configToUse) > 0 {
+		var keys []string
+		for key := range configToUse {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return nil, fmt.Errorf("unrecognized properties: %s", strings.Join(keys, ", "))
+	}
+
+	return jd, nil
+}
+
+// SetFailInvalidSchema using the supplied JSONSchemaDeserializerConfig
+func (jd *JSONSchemaDeserializer) SetFailInvalidSchema(config JSONSchemaDeserializerConfig) error {
+	failInvalidSchemaConf, ok := config[FailInvalidSchema]
+	if ok {
+		failInvalidSchema, okTypeCast := failInvalidSchemaConf.(bool)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a boolean value", FailInvalidSchema)
+		}
+		jd.failInvalidSchema = failInvalidSchema
+		delete(config, FailInvalidSchema)
+	}
+	return nil
+}
+
+// Deserialize using the Confluent Schema Registry wire format, v is a pointer to a struct, map[string]interface{} or
+// anything else encoding/json can unmarshal into. When FailInvalidSchema is enabled the payload must be valid against
+// the writer schema, otherwise the error wraps a *JSONSchemaValidationError
+func (jd *JSONSchemaDeserializer) Deserialize(bytes []byte, v interface{}) error {
+	return jd.DeserializeContext(context.Background(), bytes, v)
+}
+
+// DeserializeContext using the Confluent Schema Registry wire format, ctx is used for cancellation and deadlines of any Schema Registry calls
+func (jd *JSONSchemaDeserializer) DeserializeContext(ctx context.Context, bytes []byte, v interface{}) error {
+	return jd.deserialize(ctx, bytes, SerializationContext{}, v)
+}
+
+// deserialize bytes into v, serCtx is only used to describe errors
+func (jd *JSONSchemaDeserializer) deserialize(ctx context.Context, bytes []byte, serCtx SerializationContext, v interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return newSerdeError(serCtx, "", schemaIdentifier{}, err)
+	}
+
+	schemaID, payload, err := parseWireFormat(bytes)
+	if err != nil {
+		return newSerdeError(serCtx, "", schemaIdentifier{}, err)
+	}
+
+	if jd.failInvalidSchema {
+		schema, err := jd.getWriterSchema(ctx, schemaID)
+		if err != nil {
+			return newSerdeError(serCtx, "", schemaIdentifier{id: schemaID}, err)
+		}
+		err = validateJSON(schema, payload)
+		if err != nil {
+			return newSerdeError(serCtx, "", schemaIdentifier{id: schemaID}, err)
+		}
+	}
+
+	err = json.Unmarshal(payload, v)
+	if err != nil {
+		err = newKindError(ErrInvalidPayload, len(bytes)-len(payload), err)
+		return newSerdeError(serCtx, "", schemaIdentifier{id: schemaID}, err)
+	}
+	return nil
+}
+
+// DeserializeValue for JSONSchemaDeserializer, returns the value as encoding/json unmarshals it into an interface{}
+func (jd *JSONSchemaDeserializer) DeserializeValue(ctx context.Context, bytes []byte, serCtx SerializationContext) (interface{}, error) {
+	var value interface{}
+	err := jd.deserialize(ctx, bytes, serCtx, &value)
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// getWriterSchema returns the compiled writer schema registered with schemaID, fetching it from Schema Registry on first use
+func (jd *JSONSchemaDeserializer) getWriterSchema(ctx context.Context, schemaID int) (*jsonschema.Schema, error) {
+	jd.schemasLock.RLock()
+	schema, ok := jd.schemas[schemaID]
+	jd.schemasLock.RUnlock()
+	if ok {
+		return schema, nil
+	}
+
+	theSchema, err := callRegistry(ctx, func() (*srclient.Schema, error) {
+		return jd.client.GetSchema(schemaID)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to find schema ID %d: %w", schemaID, err)
+	}
+	schema, err = compileJSONSchema(theSchema.Schema())
+	if err != nil {
+		return nil, fmt.Errorf("schema ID %d: %w", schemaID, err)
+	}
+
+	jd.schemasLock.Lock()
+	jd.schemas[schemaID] = schema
+	jd.schemasLock.Unlock()
+
+	return schema, nil
+}
@@ -0,0 +1,240 @@
+package serdes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/riferrei/srclient"
+	"reflect"
+	"testing"
+)
+
+func TestJSONSchemaDeserializer_Deserialize(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	js, err := NewJSONSchemaSerializer(testJSONSchema, msrc, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewJSONSchemaSerializer: %s", err.Error())
+	}
+	data, err := js.Serialize(testJSONUser{Name: "Ann", Age: 40, Tags: []string{"a"}}, SerializationContext{Topic: "test", Field: MessageFieldValue})
+	if err != nil {
+		t.Fatalf("unexpected error on Serialize: %s", err.Error())
+	}
+
+	cases := []struct {
+		name   string
+		config JSONSchemaDeserializerConfig
+		got    interface{}
+		want   interface{}
+	}{
+		{
+			"struct",
+			nil,
+			&testJSONUser{},
+			&testJSONUser{Name: "Ann", Age: 40, Tags: []string{"a"}},
+		},
+		{
+			"map",
+			nil,
+			&map[string]interface{}{},
+			&map[string]interface{}{"name": "Ann", "age": float64(40), "tags": []interface{}{"a"}},
+		},
+		{
+			"struct with validation",
+			JSONSchemaDeserializerConfig{FailInvalidSchema: true},
+			&testJSONUser{},
+			&testJSONUser{Name: "Ann", Age: 40, Tags: []string{"a"}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			jd, err := NewJSONSchemaDeserializer(msrc, c.config)
+			if err != nil {
+				t.Fatalf("unexpected error on NewJSONSchemaDeserializer: %s", err.Error())
+			}
+			err = jd.Deserialize(data, c.got)
+			if err != nil {
+				t.Fatalf("unexpected error on Deserialize: %s", err.Error())
+			}
+			if !reflect.DeepEqual(c.got, c.want) {
+				t.Fatalf("jd.Deserialize(%s) == %v, want %v", data, c.got, c.want)
+			}
+		})
+	}
+}
+
+func TestJSONSchemaDeserializer_DeserializeFailInvalidSchema(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	_, err := msrc.CreateSchema("test-value", testJSONSchema, srclient.Json)
+	if err != nil {
+		t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+	}
+	// written without validation, as a producer not using this library might
+	data := append([]byte{0, 0, 0, 0, 1}, `{"name":"Ann","age":-1}`...)
+
+	cases := []struct {
+		name              string
+		failInvalidSchema bool
+		wantCalls         int
+		want              error
+	}{
+		{
+			"validation disabled",
+			false,
+			0,
+			nil,
+		},
+		{
+			"validation enabled",
+			true,
+			1,
+			&JSONSchemaValidationError{Failures: []JSONSchemaValidationFailure{{InstanceLocation: "/age", KeywordLocation: "/properties/age/minimum", Message: "must be >= 0 but found -1"}}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			calls := msrc.callCount("GetSchema")
+			jd, err := NewJSONSchemaDeserializer(msrc, JSONSchemaDeserializerConfig{FailInvalidSchema: c.failInvalidSchema})
+			if err != nil {
+				t.Fatalf("unexpected error on NewJSONSchemaDeserializer: %s", err.Error())
+			}
+			// the writer schema is fetched at most once
+			for i := 0; i < 2; i++ {
+				err = jd.Deserialize(data, &testJSONUser{})
+				var serdeErr *SerdeError
+				if errors.As(err, &serdeErr) {
+					err = serdeErr.Err
+				}
+				if !reflect.DeepEqual(err, c.want) {
+					t.Fatalf("jd.Deserialize(%s) == %v, want %v", data, err, c.want)
+				}
+			}
+			if got := msrc.callCount("GetSchema") - calls; got != c.wantCalls {
+				t.Fatalf("GetSchema called %d times, want %d", got, c.wantCalls)
+			}
+		})
+	}
+}
+
+func TestJSONSchemaDeserializer_DeserializeErrors(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	_, err := msrc.CreateSchema("test-value", testJSONSchema, srclient.Json)
+	if err != nil {
+		t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+	}
+	_, err = msrc.CreateSchema("proto-value", `syntax = "proto3";`, srclient.Protobuf)
+	if err != nil {
+		t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+	}
+
+	cases := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{
+			"message too small",
+			[]byte{0, 0, 0},
+			"message too small. This message was not produced with a Confluent Schema Registry serializer",
+		},
+		{
+			"magic byte missing",
+			append([]byte{1, 0, 0, 0, 1}, `{}`...),
+			"unknown magic byte. This message was not produced with a Confluent Schema Registry serializer",
+		},
+		{
+			"unknown schema ID",
+			append([]byte{0, 0, 0, 0, 9}, `{}`...),
+			"unable to find schema ID 9: schema 9 not found",
+		},
+		{
+			"schema ID of a schema that is not JSON",
+			append([]byte{0, 0, 0, 0, 2}, `{}`...),
+			"schema ID 2: invalid JSON Schema: jsonschema: invalid json mem://schema.json: invalid character 's' looking for beginning of value",
+		},
+		{
+			"payload is not JSON",
+			append([]byte{0, 0, 0, 0, 1}, `{"name":`...),
+			"unexpected EOF",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			jd, err := NewJSONSchemaDeserializer(msrc, JSONSchemaDeserializerConfig{FailInvalidSchema: true})
+			if err != nil {
+				t.Fatalf("unexpected error on NewJSONSchemaDeserializer: %s", err.Error())
+			}
+			err = jd.Deserialize(c.data, &map[string]interface{}{})
+			if err == nil || err.Error() != c.want {
+				t.Fatalf("jd.Deserialize(%v) == %v, want %v", c.data, err, c.want)
+			}
+
+			// DeserializeValue describes the error with its SerializationContext
+			serCtx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+			_, err = jd.DeserializeValue(context.Background(), c.data, serCtx)
+			var serdeErr *SerdeError
+			if !errors.As(err, &serdeErr) || err.Error() != c.want {
+				t.Fatalf("jd.DeserializeValue(%v) == %v, want %v", c.data, err, c.want)
+			}
+			if serdeErr.Topic != serCtx.Topic || serdeErr.Field != serCtx.Field {
+				t.Fatalf("jd.DeserializeValue(%v) error Topic, Field == %s, %s, want %s, %s", c.data, serdeErr.Topic, serdeErr.Field, serCtx.Topic, serCtx.Field)
+			}
+		})
+	}
+}
+
+func TestJSONSchemaDeserializer_DeserializeContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	jd, err := NewJSONSchemaDeserializer(newRegistryMockSchemaRegistryClient(), nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewJSONSchemaDeserializer: %s", err.Error())
+	}
+	err = jd.DeserializeContext(ctx, append([]byte{0, 0, 0, 0, 1}, `{}`...), &map[string]interface{}{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("jd.DeserializeContext() == %v, want %v", err, context.Canceled)
+	}
+
+	serCtx := SerializationContext{Topic: "test", Field: MessageFieldKey}
+	_, err = jd.DeserializeValue(ctx, append([]byte{0, 0, 0, 0, 1}, `{}`...), serCtx)
+	var serdeErr *SerdeError
+	if !errors.Is(err, context.Canceled) || !errors.As(err, &serdeErr) || serdeErr.Topic != serCtx.Topic || serdeErr.Field != serCtx.Field {
+		t.Fatalf("jd.DeserializeValue() == %#v, want %v for topic %s and field %s", err, context.Canceled, serCtx.Topic, serCtx.Field)
+	}
+}
+
+func TestJSONSchemaDeserializer_NewJSONSchemaDeserializerErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		client srclient.ISchemaRegistryClient
+		config JSONSchemaDeserializerConfig
+		want   error
+	}{
+		{
+			"nil client",
+			nil,
+			nil,
+			errors.New("schemaRegistryClient must not be nil"),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", FailInvalidSchema),
+			&mockSchemaRegistryClient{},
+			JSONSchemaDeserializerConfig{FailInvalidSchema: "true"},
+			fmt.Errorf("%s must be a boolean value", FailInvalidSchema),
+		},
+		{
+			"serializer only property",
+			&mockSchemaRegistryClient{},
+			JSONSchemaDeserializerConfig{SubjectNameStrategyImpl: TopicSubjectNameStrategy{}},
+			fmt.Errorf("unrecognized properties: %s", SubjectNameStrategyImpl),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := NewJSONSchemaDeserializer(c.client, c.config)
+			if err == nil || err.Error() != c.want.Error() {
+				t.Fatalf("NewJSONSchemaDeserializer(%v) == %v, want %v", c.config, err, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package serdes
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/riferrei/srclient"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// defaultJSONSchemaRecordName the record name for subject name strategies when the schema has no title
+const defaultJSONSchemaRecordName = "record"
+
+// jsonSchemaURL the URL schemas are compiled under, which is never fetched
+const jsonSchemaURL = "mem://schema.json"
+
+// JSONSchemaSerializerConfigValue config values for JSON Schema serialization
+type JSONSchemaSerializerConfigValue interface{}
+
+// JSONSchemaSerializerConfig map of string to JSONSchemaSerializerConfigValue
+type JSONSchemaSerializerConfig map[string]JSONSchemaSerializerConfigValue
+
+// JSONSchemaValidationError a value that does not match its JSON Schema
+type JSONSchemaValidationError struct {
+	Failures []JSONSchemaValidationFailure
+}
+
+// JSONSchemaValidationFailure one of the reasons a value does not match its JSON Schema
+type JSONSchemaValidationFailure struct {
+	InstanceLocation string // JSON pointer to the failing part of the value, empty for the whole value
+	KeywordLocation  string // JSON pointer to the keyword in the schema that failed
+	Message          string
+}
+
+// Error lists the JSON pointer and reason of every failure
+func (e *JSONSchemaValidationError) Error() string {
+	var failures []string
+	for _, failure := range e.Failures {
+		failures = append(failures, fmt.Sprintf("%q: %s", failure.InstanceLocation, failure.Message))
+	}
+	return fmt.Sprintf("JSON Schema validation failed: %s", strings.Join(failures, "; "))
+}
+
+// JSONSchemaSerializer using the schema registry client
+type JSONSchemaSerializer struct {
+	client              srclient.ISchemaRegistryClient
+	schemaString        string
+	schema              *jsonschema.Schema
+	recordName          string
+	autoRegisterSchemas bool
+	useLatestVersion    bool
+	knownSubjects       map[string]jsonWriterSchema // map from subject name to the schema data is validated against
+	knownSubjectsLock   sync.RWMutex
+	subjectNameStrategy SubjectNameStrategy
+}
+
+// jsonWriterSchema a registered schema and the compiled schema to validate data with
+type jsonWriterSchema struct {
+	id     int
+	schema *jsonschema.Schema
+}
+
+// NewJSONSchemaSerializer returns a new JSONSchemaSerializer for data described by the JSON Schema, the title of the
+// schema is used as the record name for subject name strategies
+func NewJSONSchemaSerializer(schema string, schemaRegistryClient srclient.ISchemaRegistryClient, config JSONSchemaSerializerConfig) (*JSONSchemaSerializer, error) {
+	compiled, err := compileJSONSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	recordName := compiled.Title
+	if recordName == "" {
+		recordName = defaultJSONSchemaRecordName
+	}
+
+	js := &JSONSchemaSerializer{
+		client:        schemaRegistryClient,
+		schemaString:  schema,
+		schema:        compiled,
+		recordName:    recordName,
+		knownSubjects: make(map[string]jsonWriterSchema),
+	}
+
+	// set all the defaults
+	configToUse := JSONSchemaSerializerConfig{
+		AutoRegisterSchemas:     true,
+		UseLatestVersion:        false,
+		SubjectNameStrategyImpl: TopicSubjectNameStrategy{}, // TopicSubjectNameStrategy is the default
+	}
+
+	// handle configuration
+	// update the defaults in configToUse with the values from the passed in config
+	if config != nil {
+		for key, value := range config {
+			configToUse[key] = value
+		}
+	}
+
+	err = js.SetAutoRegisterSchemas(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	err = js.SetUseLatestVersion(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	err = js.SetSubjectNameStrategy(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	// check for left over unrecognized properties
+	if len(configToUse) > 0 {
+		var keys []string
+		for key := range configToUse {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return nil, fmt.Errorf("unrecognized properties: %s", strings.Join(keys, ", "))
+	}
+
+	return js, nil
+}
+
+// SetAutoRegisterSchemas using the supplied JSONSchemaSerializerConfig
+func (js *JSONSchemaSerializer) SetAutoRegisterSchemas(config JSONSchemaSerializerConfig) error {
+	autoRegisterSchemasConf, ok := config[AutoRegisterSchemas]
+	if ok {
+		autoRegisterSchemas, okTypeCast := autoRegisterSchemasConf.(bool)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a boolean value", AutoRegisterSchemas)
+		}
+		js.autoRegisterSchemas = autoRegisterSchemas
+		delete(config, AutoRegisterSchemas)
+	}
+	return js.isUseLatestVersionAndAutoRegisterSchemas()
+}
+
+// SetUseLatestVersion using the supplied JSONSchemaSerializerConfig
+func (js *JSONSchemaSerializer) SetUseLatestVersion(config JSONSchemaSerializerConfig) error {
+	useLatestVersionConf, ok := config[UseLatestVersion]
+	if ok {
+		useLatestVersion, okTypeCast := useLatestVersionConf.(bool)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a boolean value", UseLatestVersion)
+		}
+		js.useLatestVersion = useLatestVersion
+		delete(config, UseLatestVersion)
+	}
+	return js.isUseLatestVersionAndAutoRegisterSchemas()
+}
+
+func (js *JSONSchemaSerializer) isUseLatestVersionAndAutoRegisterSchemas() error {
+	if js.useLatestVersion && js.autoRegisterSchemas {
+		return fmt.Errorf("cannot enable both %s and %s", UseLatestVersion, AutoRegisterSchemas)
+	}
+	return nil
+}
+
+// SetSubjectNameStrategy using the supplied JSONSchemaSerializerConfig
+func (js *JSONSchemaSerializer) SetSubjectNameStrategy(config JSONSchemaSerializerConfig) error {
+	subjectNameStrategyConf, ok := config[SubjectNameStrategyImpl]
+	if ok {
+		subjectNameStrategy, okTypeCast := subjectNameStrategyConf.(SubjectNameStrategy)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a SubjectNameStrategy", SubjectNameStrategyImpl)
+		}
+		js.subjectNameStrategy = subjectNameStrategy
+		delete(config, SubjectNameStrategyImpl)
+	}
+	return nil
+}
+
+// Serialize using the Confluent Schema Registry wire format, v is marshalled with encoding/json and must be valid
+// against the schema, otherwise the error wraps a *JSONSchemaValidationError
+func (js *JSONSchemaSerializer) Serialize(v interface{}, ctx SerializationContext) ([]byte, error) {
+	return js.SerializeContext(context.Background(), v, ctx)
+}
+
+// SerializeContext using the Confluent Schema Registry wire format, ctx is used for cancellation and deadlines of any Schema Registry calls
+func (js *JSONSchemaSerializer) SerializeContext(ctx context.Context, v interface{}, serCtx SerializationContext) ([]byte, error) {
+	subject := js.subjectNameStrategy.Subject(serCtx, js.recordName)
+
+	writerSchema, err := js.getWriterSchema(ctx, subject)
+	if err != nil {
+		return nil, newSerdeError(serCtx, subject, schemaIdentifier{}, err)
+	}
+
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, newSerdeError(serCtx, subject, schemaIdentifier{id: writerSchema.id}, err)
+	}
+	err = validateJSON(writerSchema.schema, payload)
+	if err != nil {
+		return nil, newSerdeError(serCtx, subject, schemaIdentifier{id: writerSchema.id}, err)
+	}
+
+	schemaIDBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(schemaIDBytes, uint32(writerSchema.id))
+
+	var msgBytes []byte
+	// schema serialization protocol version number
+	msgBytes = append(msgBytes, byte(0))
+	// schema id
+	msgBytes = append(msgBytes, schemaIDBytes...)
+
+	msgBytes = append(msgBytes, payload...)
+
+	return msgBytes, nil
+}
+
+// SerializeValue for JSONSchemaSerializer, value is marshalled with encoding/json
+func (js *JSONSchemaSerializer) SerializeValue(ctx context.Context, value interface{}, serCtx SerializationContext) ([]byte, error) {
+	return js.SerializeContext(ctx, value, serCtx)
+}
+
+// getWriterSchema returns the schema to validate data for subject with, the cache is only updated once every Schema Registry call has succeeded
+func (js *JSONSchemaSerializer) getWriterSchema(ctx context.Context, subject string) (jsonWriterSchema, error) {
+	js.knownSubjectsLock.RLock()
+	writerSchema, ok := js.knownSubjects[subject]
+	js.knownSubjectsLock.RUnlock()
+	if ok {
+		return writerSchema, nil
+	}
+
+	if js.useLatestVersion {
+		theSchema, err := callRegistry(ctx, func() (*srclient.Schema, error) {
+			return js.client.GetLatestSchema(subject)
+		})
+		if err != nil {
+			return jsonWriterSchema{}, err
+		}
+		// the data must be valid against the schema its ID refers to, compiled here rather than with
+		// theSchema.JsonSchema() as that is not safe to call from several goroutines on a cached schema
+		compiled, err := compileJSONSchema(theSchema.Schema())
+		if err != nil {
+			return jsonWriterSchema{}, fmt.Errorf("latest version %d of subject %s: %w", theSchema.Version(), subject, err)
+		}
+		writerSchema = jsonWriterSchema{id: theSchema.ID(), schema: compiled}
+	} else if js.autoRegisterSchemas {
+		theSchema, err := callRegistry(ctx, func() (*srclient.Schema, error) {
+			return js.client.CreateSchema(subject, js.schemaString, srclient.Json)
+		})
+		if err != nil {
+			return jsonWriterSchema{}, err
+		}
+		writerSchema = jsonWriterSchema{id: theSchema.ID(), schema: js.schema}
+	} else {
+		theSchema, err := callRegistry(ctx, func() (*srclient.Schema, error) {
+			return js.client.LookupSchema(subject, js.schemaString, srclient.Json)
+		})
+		if err != nil {
+			return jsonWriterSchema{}, err
+		}
+		writerSchema = jsonWriterSchema{id: theSchema.ID(), schema: js.schema}
+	}
+
+	js.knownSubjectsLock.Lock()
+	js.knownSubjects[subject] = writerSchema
+	js.knownSubjectsLock.Unlock()
+
+	return writerSchema, nil
+}
+
+// compileJSONSchema compiles schema for validation
+func compileJSONSchema(schema string) (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	// annotations include the title, which names the record
+	compiler.ExtractAnnotations = true
+	err := compiler.AddResource(jsonSchemaURL, strings.NewReader(schema))
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON Schema: %w", err)
+	}
+	compiled, err := compiler.Compile(jsonSchemaURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON Schema: %w", err)
+	}
+	return compiled, nil
+}
+
+// validateJSON validates the JSON document in data against schema, returning a *JSONSchemaValidationError listing
+// the failures when it is not valid
+func validateJSON(schema *jsonschema.Schema, data []byte) error {
+	// numbers are decoded as json.Number as the validator requires
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var doc interface{}
+	err := decoder.Decode(&doc)
+	if err != nil {
+		return err
+	}
+
+	err = schema.Validate(doc)
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		return err
+	}
+
+	// only the innermost errors say what is wrong, the others just say which keywords they were found under
+	var failures []JSONSchemaValidationFailure
+	var collect func(*jsonschema.ValidationError)
+	collect = func(ve *jsonschema.ValidationError) {
+		if len(ve.Causes) == 0 {
+			failures = append(failures, JSONSchemaValidationFailure{
+				InstanceLocation: ve.InstanceLocation,
+				KeywordLocation:  ve.KeywordLocation,
+				Message:          ve.Message,
+			})
+		}
+		for _, cause := range ve.Causes {
+			// make recursive call
+			collect(cause)
+		}
+	}
+	collect(validationErr)
+	sort.SliceStable(failures, func(i, j int) bool {
+		if failures[i].InstanceLocation != failures[j].InstanceLocation {
+			return failures[i].InstanceLocation < failures[j].InstanceLocation
+		}
+		return failures[i].KeywordLocation < failures[j].KeywordLocation
+	})
+	return &JSONSchemaValidationError{Failures: failures}
+}
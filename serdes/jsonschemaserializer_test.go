@@ -0,0 +1,245 @@
+package serdes
+
+import (
+	"errors"
+	"fmt"
+	"github.com/riferrei/srclient"
+	"reflect"
+	"testing"
+)
+
+const testJSONSchema = `{
+  "title": "com.example.User",
+  "type": "object",
+  "properties": {
+    "name": {"type": "string"},
+    "age": {"type": "integer", "minimum": 0},
+    "tags": {"type": "array", "items": {"type": "string"}}
+  },
+  "required": ["name", "age"]
+}`
+
+// testJSONSchemaV2 makes age optional and rejects additional properties
+const testJSONSchemaV2 = `{
+  "title": "com.example.User",
+  "type": "object",
+  "properties": {
+    "name": {"type": "string"},
+    "age": {"type": "integer", "minimum": 0}
+  },
+  "required": ["name"],
+  "additionalProperties": false
+}`
+
+// testJSONUser a Go struct matching testJSONSchema
+type testJSONUser struct {
+	Name string   `json:"name"`
+	Age  int      `json:"age"`
+	Tags []string `json:"tags,omitempty"`
+}
+
+func TestJSONSchemaSerializer_Serialize(t *testing.T) {
+	user := testJSONUser{Name: "Ann", Age: 40}
+	payload := []byte(`{"name":"Ann","age":40}`)
+
+	cases := []struct {
+		name        string
+		config      JSONSchemaSerializerConfig
+		registered  map[string]string // map from subject to schema registered before serializing
+		ctx         SerializationContext
+		wantSubject string
+		want        []byte
+	}{
+		{
+			"auto register under the topic subject",
+			nil,
+			nil,
+			SerializationContext{Topic: "test", Field: MessageFieldValue},
+			"test-value",
+			append([]byte{0, 0, 0, 0, 1}, payload...),
+		},
+		{
+			"auto register under the title as the record subject",
+			JSONSchemaSerializerConfig{SubjectNameStrategyImpl: RecordSubjectNameStrategy{}},
+			nil,
+			SerializationContext{Topic: "test", Field: MessageFieldValue},
+			"com.example.User",
+			append([]byte{0, 0, 0, 0, 1}, payload...),
+		},
+		{
+			"look up the registered schema",
+			JSONSchemaSerializerConfig{AutoRegisterSchemas: false},
+			map[string]string{"other-value": testJSONSchemaV2, "test-value": testJSONSchema},
+			SerializationContext{Topic: "test", Field: MessageFieldValue},
+			"test-value",
+			append([]byte{0, 0, 0, 0, 2}, payload...),
+		},
+		{
+			"validate against the latest schema",
+			JSONSchemaSerializerConfig{AutoRegisterSchemas: false, UseLatestVersion: true},
+			map[string]string{"test-value": testJSONSchemaV2},
+			SerializationContext{Topic: "test", Field: MessageFieldValue},
+			"test-value",
+			append([]byte{0, 0, 0, 0, 1}, payload...),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			msrc := newRegistryMockSchemaRegistryClient()
+			for _, subject := range []string{"other-value", "test-value"} {
+				if schema, ok := c.registered[subject]; ok {
+					_, err := msrc.CreateSchema(subject, schema, srclient.Json)
+					if err != nil {
+						t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+					}
+				}
+			}
+			js, err := NewJSONSchemaSerializer(testJSONSchema, msrc, c.config)
+			if err != nil {
+				t.Fatalf("unexpected error on NewJSONSchemaSerializer: %s", err.Error())
+			}
+			// the second call uses the cached schema
+			for i := 0; i < 2; i++ {
+				got, err := js.Serialize(user, c.ctx)
+				if err != nil {
+					t.Fatalf("unexpected error on Serialize: %s", err.Error())
+				}
+				if !reflect.DeepEqual(got, c.want) {
+					t.Fatalf("js.Serialize(%v) == %s, want %s", user, got, c.want)
+				}
+			}
+			if _, ok := msrc.subjects[c.wantSubject]; !ok {
+				t.Fatalf("subjects == %v, want %s", msrc.subjects, c.wantSubject)
+			}
+		})
+	}
+}
+
+func TestJSONSchemaSerializer_SerializeValidation(t *testing.T) {
+	ctx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+
+	cases := []struct {
+		name      string
+		schema    string
+		value     interface{}
+		wantError string
+		want      []JSONSchemaValidationFailure
+	}{
+		{
+			"wrong types",
+			testJSONSchema,
+			map[string]interface{}{"name": 5, "age": -1, "tags": []interface{}{"a", 2}},
+			`JSON Schema validation failed: "/age": must be >= 0 but found -1; "/name": expected string, but got number; "/tags/1": expected string, but got number`,
+			[]JSONSchemaValidationFailure{
+				{InstanceLocation: "/age", KeywordLocation: "/properties/age/minimum", Message: "must be >= 0 but found -1"},
+				{InstanceLocation: "/name", KeywordLocation: "/properties/name/type", Message: "expected string, but got number"},
+				{InstanceLocation: "/tags/1", KeywordLocation: "/properties/tags/items/type", Message: "expected string, but got number"},
+			},
+		},
+		{
+			"missing and additional properties",
+			testJSONSchemaV2,
+			map[string]interface{}{"email": "ann@example.com"},
+			`JSON Schema validation failed: "": additionalProperties 'email' not allowed; "": missing properties: 'name'`,
+			[]JSONSchemaValidationFailure{
+				{InstanceLocation: "", KeywordLocation: "/additionalProperties", Message: "additionalProperties 'email' not allowed"},
+				{InstanceLocation: "", KeywordLocation: "/required", Message: "missing properties: 'name'"},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			msrc := newRegistryMockSchemaRegistryClient()
+			js, err := NewJSONSchemaSerializer(c.schema, msrc, nil)
+			if err != nil {
+				t.Fatalf("unexpected error on NewJSONSchemaSerializer: %s", err.Error())
+			}
+			_, err = js.Serialize(c.value, ctx)
+			var validationErr *JSONSchemaValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("js.Serialize(%v) == %v, want a JSONSchemaValidationError", c.value, err)
+			}
+			if err.Error() != c.wantError {
+				t.Fatalf("js.Serialize(%v) == %s, want %s", c.value, err.Error(), c.wantError)
+			}
+			if !reflect.DeepEqual(validationErr.Failures, c.want) {
+				t.Fatalf("failures == %+v, want %+v", validationErr.Failures, c.want)
+			}
+		})
+	}
+}
+
+func TestJSONSchemaSerializer_NewJSONSchemaSerializer(t *testing.T) {
+	cases := []struct {
+		schema string
+		want   string
+	}{
+		{testJSONSchema, "com.example.User"},
+		{`{"type": "string"}`, defaultJSONSchemaRecordName},
+	}
+	for _, c := range cases {
+		js, err := NewJSONSchemaSerializer(c.schema, &mockSchemaRegistryClient{}, nil)
+		if err != nil {
+			t.Fatalf("unexpected error on NewJSONSchemaSerializer: %s", err.Error())
+		}
+		if js.recordName != c.want || !js.autoRegisterSchemas || js.useLatestVersion || js.subjectNameStrategy != (TopicSubjectNameStrategy{}) {
+			t.Fatalf("NewJSONSchemaSerializer(%s) == %+v, want auto.register.schemas with TopicSubjectNameStrategy for %s", c.schema, js, c.want)
+		}
+	}
+}
+
+func TestJSONSchemaSerializer_NewJSONSchemaSerializerErrors(t *testing.T) {
+	msrc := &mockSchemaRegistryClient{}
+
+	cases := []struct {
+		name   string
+		schema string
+		config JSONSchemaSerializerConfig
+		want   error
+	}{
+		{
+			"invalid schema",
+			`{"type": 5}`,
+			nil,
+			errors.New("invalid JSON Schema: jsonschema mem://schema.json compilation failed: '/type' does not validate with https://json-schema.org/draft/2020-12/schema#/allOf/3/$ref/properties/type/anyOf: anyOf failed"),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", AutoRegisterSchemas),
+			testJSONSchema,
+			JSONSchemaSerializerConfig{AutoRegisterSchemas: "true"},
+			fmt.Errorf("%s must be a boolean value", AutoRegisterSchemas),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", UseLatestVersion),
+			testJSONSchema,
+			JSONSchemaSerializerConfig{AutoRegisterSchemas: false, UseLatestVersion: 1},
+			fmt.Errorf("%s must be a boolean value", UseLatestVersion),
+		},
+		{
+			fmt.Sprintf("both %s and %s", UseLatestVersion, AutoRegisterSchemas),
+			testJSONSchema,
+			JSONSchemaSerializerConfig{UseLatestVersion: true},
+			fmt.Errorf("cannot enable both %s and %s", UseLatestVersion, AutoRegisterSchemas),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", SubjectNameStrategyImpl),
+			testJSONSchema,
+			JSONSchemaSerializerConfig{SubjectNameStrategyImpl: "topic"},
+			fmt.Errorf("%s must be a SubjectNameStrategy", SubjectNameStrategyImpl),
+		},
+		{
+			"unrecognized property",
+			testJSONSchema,
+			JSONSchemaSerializerConfig{"unknown": true},
+			errors.New("unrecognized properties: unknown"),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := NewJSONSchemaSerializer(c.schema, msrc, c.config)
+			if err == nil || err.Error() != c.want.Error() {
+				t.Fatalf("NewJSONSchemaSerializer(%v) == %v, want %v", c.config, err, c.want)
+			}
+		})
+	}
+}
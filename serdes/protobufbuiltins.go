@@ -0,0 +1,116 @@
+package serdes
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sync"
+
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	_ "google.golang.org/protobuf/types/descriptorpb" // registers google/protobuf/descriptor.proto
+	_ "google.golang.org/protobuf/types/known/anypb"
+	_ "google.golang.org/protobuf/types/known/apipb"
+	_ "google.golang.org/protobuf/types/known/durationpb"
+	_ "google.golang.org/protobuf/types/known/emptypb"
+	_ "google.golang.org/protobuf/types/known/fieldmaskpb"
+	_ "google.golang.org/protobuf/types/known/sourcecontextpb"
+	_ "google.golang.org/protobuf/types/known/structpb"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+	_ "google.golang.org/protobuf/types/known/typepb"
+	_ "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// builtinProtoFiles .proto source for the files Confluent Schema Registry provides that have no generated Go code here
+//
+//go:embed builtin
+var builtinProtoFiles embed.FS
+
+// builtinProtoPaths the imports Confluent Schema Registry treats as built in, these are never registered as references,
+// the google/protobuf files come from the generated code linked into this binary, the others are parsed from builtin
+var builtinProtoPaths = map[string]bool{
+	"google/protobuf/any.proto":            true,
+	"google/protobuf/api.proto":            true,
+	"google/protobuf/descriptor.proto":     true,
+	"google/protobuf/duration.proto":       true,
+	"google/protobuf/empty.proto":          true,
+	"google/protobuf/field_mask.proto":     true,
+	"google/protobuf/source_context.proto": true,
+	"google/protobuf/struct.proto":         true,
+	"google/protobuf/timestamp.proto":      true,
+	"google/protobuf/type.proto":           true,
+	"google/protobuf/wrappers.proto":       true,
+	"confluent/meta.proto":                 true,
+	"confluent/type/decimal.proto":         true,
+}
+
+var (
+	builtinFiles     *protoregistry.Files
+	builtinFilesErr  error
+	builtinFilesOnce sync.Once
+)
+
+// isBuiltinProto reports whether Schema Registry already knows the import at filePath
+func isBuiltinProto(filePath string) bool {
+	return builtinProtoPaths[filePath]
+}
+
+// builtinFileDescriptor returns the file descriptor of the built in import at filePath
+func builtinFileDescriptor(filePath string) (protoreflect.FileDescriptor, error) {
+	if !isBuiltinProto(filePath) {
+		return nil, fmt.Errorf("%s is not a built in import", filePath)
+	}
+	builtinFilesOnce.Do(func() {
+		builtinFiles, builtinFilesErr = buildBuiltinFiles()
+	})
+	if builtinFilesErr != nil {
+		return nil, builtinFilesErr
+	}
+	return builtinFiles.FindFileByPath(filePath)
+}
+
+// buildBuiltinFiles builds every built in import, preferring generated code linked into this binary
+func buildBuiltinFiles() (*protoregistry.Files, error) {
+	files := new(protoregistry.Files)
+	var build func(filePath string) error
+	build = func(filePath string) error {
+		if _, err := files.FindFileByPath(filePath); err == nil {
+			return nil
+		}
+		if fd, err := protoregistry.GlobalFiles.FindFileByPath(filePath); err == nil {
+			return registerFileWithImports(fd, files)
+		}
+
+		text, err := builtinProtoFiles.ReadFile(path.Join("builtin", filePath))
+		if err != nil {
+			return fmt.Errorf("no source for built in import %s: %w", filePath, err)
+		}
+		fdp, err := parseProtoText(filePath, string(text))
+		if err != nil {
+			return err
+		}
+		for _, dep := range fdp.GetDependency() {
+			// make recursive call
+			if err := build(dep); err != nil {
+				return err
+			}
+		}
+		err = linkFileDescriptorProto(fdp, files)
+		if err != nil {
+			return err
+		}
+		fd, err := protodesc.NewFile(fdp, files)
+		if err != nil {
+			return err
+		}
+		return files.RegisterFile(fd)
+	}
+
+	for filePath := range builtinProtoPaths {
+		if err := build(filePath); err != nil {
+			return nil, fmt.Errorf("unable to build built in import %s: %w", filePath, err)
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,67 @@
+package serdes
+
+import (
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"testing"
+)
+
+func TestProtobufBuiltins_isBuiltinProto(t *testing.T) {
+	cases := []struct {
+		filePath string
+		want     bool
+	}{
+		{"google/protobuf/timestamp.proto", true},
+		{"google/protobuf/descriptor.proto", true},
+		{"confluent/meta.proto", true},
+		{"confluent/type/decimal.proto", true},
+		{"google/protobuf/compiler/plugin.proto", false},
+		{"google/type/date.proto", false},
+		{"confluent/other.proto", false},
+		{"message.proto", false},
+	}
+	for _, c := range cases {
+		got := isBuiltinProto(c.filePath)
+		if got != c.want {
+			t.Fatalf("isBuiltinProto(%s) == %v, want %v", c.filePath, got, c.want)
+		}
+	}
+}
+
+func TestProtobufBuiltins_builtinFileDescriptor(t *testing.T) {
+	for filePath := range builtinProtoPaths {
+		fd, err := builtinFileDescriptor(filePath)
+		if err != nil {
+			t.Fatalf("unexpected error on builtinFileDescriptor(%s): %s", filePath, err.Error())
+		}
+		if fd.Path() != filePath {
+			t.Fatalf("builtinFileDescriptor(%s).Path() == %s, want %s", filePath, fd.Path(), filePath)
+		}
+	}
+
+	meta, _ := builtinFileDescriptor("confluent/meta.proto")
+	fieldMeta := meta.Extensions().ByName("field_meta")
+	if fieldMeta == nil || fieldMeta.Number() != 1088 || fieldMeta.ContainingMessage().FullName() != "google.protobuf.FieldOptions" {
+		t.Fatalf("confluent.field_meta == %v, want extension 1088 of google.protobuf.FieldOptions", fieldMeta)
+	}
+	if params := meta.Messages().ByName("Meta").Fields().ByName("params"); params == nil || !params.IsMap() {
+		t.Fatalf("confluent.Meta.params == %v, want map<string, string>", params)
+	}
+
+	decimal, _ := builtinFileDescriptor("confluent/type/decimal.proto")
+	decimalFields := decimal.Messages().ByName("Decimal").Fields()
+	wantKinds := map[protoreflect.Name]protoreflect.Kind{
+		"value":     protoreflect.BytesKind,
+		"precision": protoreflect.Uint32Kind,
+		"scale":     protoreflect.Int32Kind,
+	}
+	for name, kind := range wantKinds {
+		if field := decimalFields.ByName(name); field == nil || field.Kind() != kind {
+			t.Fatalf("confluent.type.Decimal.%s == %v, want %v", name, field, kind)
+		}
+	}
+
+	_, err := builtinFileDescriptor("message.proto")
+	if err == nil || err.Error() != "message.proto is not a built in import" {
+		t.Fatalf("builtinFileDescriptor(message.proto) == %v, want message.proto is not a built in import", err)
+	}
+}
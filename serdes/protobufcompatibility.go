@@ -0,0 +1,105 @@
+package serdes
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// kindGroups field kinds in the same group share a wire encoding, so changing between them does not break readers
+var kindGroups = map[protoreflect.Kind]int{
+	protoreflect.Int32Kind:    0,
+	protoreflect.Int64Kind:    0,
+	protoreflect.Uint32Kind:   0,
+	protoreflect.Uint64Kind:   0,
+	protoreflect.BoolKind:     0,
+	protoreflect.EnumKind:     0,
+	protoreflect.Sint32Kind:   1,
+	protoreflect.Sint64Kind:   1,
+	protoreflect.Fixed32Kind:  2,
+	protoreflect.Sfixed32Kind: 2,
+	protoreflect.Fixed64Kind:  3,
+	protoreflect.Sfixed64Kind: 3,
+	protoreflect.StringKind:   4,
+	protoreflect.BytesKind:    4,
+	protoreflect.FloatKind:    5,
+	protoreflect.DoubleKind:   6,
+	protoreflect.MessageKind:  7,
+	protoreflect.GroupKind:    8,
+}
+
+// findMessageDescriptor finds the message called name anywhere in fd, including nested messages
+func findMessageDescriptor(fd protoreflect.FileDescriptor, name protoreflect.FullName) protoreflect.MessageDescriptor {
+	var find func(messages protoreflect.MessageDescriptors) protoreflect.MessageDescriptor
+	find = func(messages protoreflect.MessageDescriptors) protoreflect.MessageDescriptor {
+		for i := 0; i < messages.Len(); i++ {
+			md := messages.Get(i)
+			if md.FullName() == name {
+				return md
+			}
+			// make recursive call
+			if nested := find(md.Messages()); nested != nil {
+				return nested
+			}
+		}
+		return nil
+	}
+	return find(fd.Messages())
+}
+
+// checkMessageCompatibility returns an error describing the first field that stops data written with one of the
+// message descriptors from being read with the other, added and removed fields are always compatible
+func checkMessageCompatibility(local protoreflect.MessageDescriptor, registered protoreflect.MessageDescriptor) error {
+	return checkMessageFieldsCompatibility(local, registered, make(map[[2]protoreflect.FullName]bool))
+}
+
+func checkMessageFieldsCompatibility(local protoreflect.MessageDescriptor, registered protoreflect.MessageDescriptor, checked map[[2]protoreflect.FullName]bool) error {
+	// recursive messages would otherwise be checked forever
+	pair := [2]protoreflect.FullName{local.FullName(), registered.FullName()}
+	if checked[pair] {
+		return nil
+	}
+	checked[pair] = true
+
+	localFields := local.Fields()
+	registeredFields := registered.Fields()
+	for i := 0; i < localFields.Len(); i++ {
+		localField := localFields.Get(i)
+		registeredField := registeredFields.ByNumber(localField.Number())
+		if registeredField == nil {
+			continue
+		}
+		if localField.IsMap() != registeredField.IsMap() || localField.IsList() != registeredField.IsList() {
+			return fmt.Errorf("field %d of %s changed cardinality from %s to %s", localField.Number(), local.FullName(), describeCardinality(registeredField), describeCardinality(localField))
+		}
+		if localField.IsMap() {
+			err := checkMessageFieldsCompatibility(localField.Message(), registeredField.Message(), checked)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if kindGroups[localField.Kind()] != kindGroups[registeredField.Kind()] {
+			return fmt.Errorf("field %d of %s changed type from %s to %s", localField.Number(), local.FullName(), registeredField.Kind(), localField.Kind())
+		}
+		if localField.Message() != nil && registeredField.Message() != nil {
+			// make recursive call
+			err := checkMessageFieldsCompatibility(localField.Message(), registeredField.Message(), checked)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func describeCardinality(fd protoreflect.FieldDescriptor) string {
+	switch {
+	case fd.IsMap():
+		return "map"
+	case fd.IsList():
+		return "repeated"
+	default:
+		return "singular"
+	}
+}
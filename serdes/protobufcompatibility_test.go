@@ -0,0 +1,138 @@
+package serdes
+
+import (
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"testing"
+)
+
+func testField(name string, number int32, label descriptorpb.FieldDescriptorProto_Label, fieldType descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+	field := &descriptorpb.FieldDescriptorProto{
+		Name:   proto.String(name),
+		Number: proto.Int32(number),
+		Label:  label.Enum(),
+		Type:   fieldType.Enum(),
+	}
+	if typeName != "" {
+		field.TypeName = proto.String(typeName)
+	}
+	return field
+}
+
+// buildTestMessageDescriptor builds compat.Outer with the supplied fields, compat.Inner is available for message fields
+func buildTestMessageDescriptor(t *testing.T, outerFields []*descriptorpb.FieldDescriptorProto, innerFields []*descriptorpb.FieldDescriptorProto) protoreflect.MessageDescriptor {
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("compat.proto"),
+		Package: proto.String("compat"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Outer"), Field: outerFields},
+			{Name: proto.String("Inner"), Field: innerFields},
+		},
+	}
+	fd, err := protodesc.NewFile(fdp, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on protodesc.NewFile: %s", err.Error())
+	}
+	return fd.Messages().ByName("Outer")
+}
+
+func TestProtobufCompatibility_checkMessageCompatibility(t *testing.T) {
+	const (
+		optional = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		repeated = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	)
+	base := []*descriptorpb.FieldDescriptorProto{
+		testField("id", 1, optional, descriptorpb.FieldDescriptorProto_TYPE_INT32, ""),
+		testField("name", 2, optional, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+		testField("inner", 3, optional, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".compat.Inner"),
+	}
+	baseInner := []*descriptorpb.FieldDescriptorProto{
+		testField("x", 1, optional, descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+	}
+
+	cases := []struct {
+		name        string
+		localFields []*descriptorpb.FieldDescriptorProto
+		localInner  []*descriptorpb.FieldDescriptorProto
+		want        string // empty when compatible
+	}{
+		{
+			"identical",
+			base,
+			baseInner,
+			"",
+		},
+		{
+			"field added",
+			append(append([]*descriptorpb.FieldDescriptorProto{}, base...), testField("extra", 4, optional, descriptorpb.FieldDescriptorProto_TYPE_BOOL, "")),
+			baseInner,
+			"",
+		},
+		{
+			"field removed",
+			base[:2],
+			baseInner,
+			"",
+		},
+		{
+			"field renamed and widened",
+			[]*descriptorpb.FieldDescriptorProto{
+				testField("identifier", 1, optional, descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+				base[1],
+				base[2],
+			},
+			baseInner,
+			"",
+		},
+		{
+			"field type changed",
+			[]*descriptorpb.FieldDescriptorProto{
+				testField("id", 1, optional, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				base[1],
+				base[2],
+			},
+			baseInner,
+			"field 1 of compat.Outer changed type from int32 to string",
+		},
+		{
+			"field made repeated",
+			[]*descriptorpb.FieldDescriptorProto{
+				base[0],
+				testField("name", 2, repeated, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				base[2],
+			},
+			baseInner,
+			"field 2 of compat.Outer changed cardinality from singular to repeated",
+		},
+		{
+			"nested message field type changed",
+			base,
+			[]*descriptorpb.FieldDescriptorProto{
+				testField("x", 1, optional, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, ""),
+			},
+			"field 1 of compat.Inner changed type from int64 to double",
+		},
+	}
+	registered := buildTestMessageDescriptor(t, base, baseInner)
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			local := buildTestMessageDescriptor(t, c.localFields, c.localInner)
+			err := checkMessageCompatibility(local, registered)
+			if c.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error on checkMessageCompatibility: %s", err.Error())
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error on checkMessageCompatibility, got none")
+			}
+			if err.Error() != c.want {
+				t.Fatalf("checkMessageCompatibility(%v, %v) == %v, want %v", local.FullName(), registered.FullName(), err, c.want)
+			}
+		})
+	}
+}
@@ -1,13 +1,56 @@
 package serdes
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/riferrei/srclient"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	// MessageTypeResolverImpl the implementation to use for finding generated message types by name
+	MessageTypeResolverImpl = "message.type.resolver"
+	// StrictTypeChecking rejects payloads whose writer message type does not match the message being deserialized into
+	StrictTypeChecking = "strict.type.checking"
 )
 
+// ProtobufDeserializerConfigValue config values for protobuf deserialization
+type ProtobufDeserializerConfigValue interface{}
+
+// ProtobufDeserializerConfig map of string to ProtobufDeserializerConfigValue
+type ProtobufDeserializerConfig map[string]ProtobufDeserializerConfigValue
+
+// MessageTypeResolver finds the generated message type for a fully qualified message name, *protoregistry.Types implements this
+type MessageTypeResolver interface {
+	FindMessageByName(message protoreflect.FullName) (protoreflect.MessageType, error)
+}
+
 // ProtobufDeserializer using the schema registry client
 type ProtobufDeserializer struct {
+	resolver            *protobufSchemaResolver
+	messageTypeResolver MessageTypeResolver
+	strictTypeChecking  bool
+	encryptionKMS       KMS
+	encryptionTags      []string
+	encryptionFields    []string
+	encryptor           *fieldEncryptor // nil when no fields are decrypted
+	rules               []Rule
+	useRegistryRules    bool
+	ruleDLQHandler      RuleDLQHandler
+	ruleLogger          RuleLogger
+	ruleExecutor        *ruleExecutor // nil when there are no rules to check
+	migrations          []Migration
+	migrationTarget     int
+	subjectNameStrategy SubjectNameStrategy
+	migrator            *protobufMigrator // nil when payloads are not migrated
 }
 
 // NewProtobufDeserializer returns a new ProtobufDeserializer
@@ -15,48 +58,682 @@ func NewProtobufDeserializer() *ProtobufDeserializer {
 	return &ProtobufDeserializer{}
 }
 
+// NewProtobufDeserializerWithClient returns a new ProtobufDeserializer that can fetch writer schemas from Schema Registry
+func NewProtobufDeserializerWithClient(schemaRegistryClient srclient.ISchemaRegistryClient, config ProtobufDeserializerConfig) (*ProtobufDeserializer, error) {
+	if schemaRegistryClient == nil {
+		return nil, fmt.Errorf("schemaRegistryClient must not be nil")
+	}
+
+	pd := &ProtobufDeserializer{
+		resolver: newProtobufSchemaResolver(schemaRegistryClient),
+	}
+
+	// set all the defaults
+	configToUse := ProtobufDeserializerConfig{
+		MessageTypeResolverImpl: protoregistry.GlobalTypes, // types linked into this binary are the default
+		StrictTypeChecking:      false,
+		UseRegistryRules:        false,
+		MigrationTargetVersion:  0,
+		SubjectNameStrategyImpl: TopicSubjectNameStrategy{}, // TopicSubjectNameStrategy is the default
+	}
+
+	// handle configuration
+	// update the defaults in configToUse with the values from the passed in config
+	if config != nil {
+		for key, value := range config {
+			configToUse[key] = value
+		}
+	}
+
+	err := pd.SetMessageTypeResolver(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pd.SetStrictTypeChecking(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pd.SetEncryptionKMS(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pd.SetEncryptionTags(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pd.SetEncryptionFields(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	// without a KMS there is no access to the keys, so encrypted fields are left as they are
+	if pd.encryptionKMS != nil && (len(pd.encryptionTags) > 0 || len(pd.encryptionFields) > 0) {
+		pd.encryptor = newFieldEncryptor(pd.encryptionKMS, "", pd.encryptionTags, pd.encryptionFields)
+	}
+
+	err = pd.SetRules(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pd.SetUseRegistryRules(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pd.SetRuleDLQHandler(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pd.SetRuleLogger(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	var ruleClient RuleSchemaRegistryClient
+	if pd.useRegistryRules {
+		ruleClient = schemaRegistryClient.(RuleSchemaRegistryClient)
+	}
+	pd.ruleExecutor, err = newRuleExecutor(pd.rules, ruleClient, pd.ruleDLQHandler, pd.ruleLogger)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pd.SetMigrations(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pd.SetMigrationTargetVersion(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pd.SetSubjectNameStrategy(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pd.migrations) > 0 && pd.migrationTarget == 0 {
+		return nil, fmt.Errorf("%s must be set to apply %s", MigrationTargetVersion, Migrations)
+	}
+	if pd.migrationTarget != 0 {
+		pd.migrator, err = newProtobufMigrator(pd.resolver, pd.migrationTarget, pd.migrations)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// check for left over unrecognized properties
+	if len(configToUse) > 0 {
+		var keys []string
+		for key := range configToUse {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return nil, fmt.Errorf("unrecognized properties: %s", strings.Join(keys, ", "))
+	}
+
+	return pd, nil
+}
+
+// SetMessageTypeResolver using the supplied ProtobufDeserializerConfig
+func (ps *ProtobufDeserializer) SetMessageTypeResolver(config ProtobufDeserializerConfig) error {
+	messageTypeResolverConf, ok := config[MessageTypeResolverImpl]
+	if ok {
+		messageTypeResolver, okTypeCast := messageTypeResolverConf.(MessageTypeResolver)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a MessageTypeResolver", MessageTypeResolverImpl)
+		}
+		ps.messageTypeResolver = messageTypeResolver
+		delete(config, MessageTypeResolverImpl)
+	}
+	return nil
+}
+
+// SetStrictTypeChecking using the supplied ProtobufDeserializerConfig
+func (ps *ProtobufDeserializer) SetStrictTypeChecking(config ProtobufDeserializerConfig) error {
+	strictTypeCheckingConf, ok := config[StrictTypeChecking]
+	if ok {
+		strictTypeChecking, okTypeCast := strictTypeCheckingConf.(bool)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a boolean value", StrictTypeChecking)
+		}
+		ps.strictTypeChecking = strictTypeChecking
+		delete(config, StrictTypeChecking)
+	}
+	return nil
+}
+
+// SetEncryptionKMS using the supplied ProtobufDeserializerConfig
+func (ps *ProtobufDeserializer) SetEncryptionKMS(config ProtobufDeserializerConfig) error {
+	encryptionKMSConf, ok := config[EncryptionKMS]
+	if ok {
+		encryptionKMS, okTypeCast := encryptionKMSConf.(KMS)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a KMS", EncryptionKMS)
+		}
+		ps.encryptionKMS = encryptionKMS
+		delete(config, EncryptionKMS)
+	}
+	return nil
+}
+
+// SetEncryptionTags using the supplied ProtobufDeserializerConfig
+func (ps *ProtobufDeserializer) SetEncryptionTags(config ProtobufDeserializerConfig) error {
+	encryptionTagsConf, ok := config[EncryptionTags]
+	if ok {
+		encryptionTags, okTypeCast := encryptionTagsConf.([]string)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a []string", EncryptionTags)
+		}
+		ps.encryptionTags = encryptionTags
+		delete(config, EncryptionTags)
+	}
+	return nil
+}
+
+// SetEncryptionFields using the supplied ProtobufDeserializerConfig
+func (ps *ProtobufDeserializer) SetEncryptionFields(config ProtobufDeserializerConfig) error {
+	encryptionFieldsConf, ok := config[EncryptionFields]
+	if ok {
+		encryptionFields, okTypeCast := encryptionFieldsConf.([]string)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a []string", EncryptionFields)
+		}
+		ps.encryptionFields = encryptionFields
+		delete(config, EncryptionFields)
+	}
+	return nil
+}
+
+// SetRules using the supplied ProtobufDeserializerConfig
+func (ps *ProtobufDeserializer) SetRules(config ProtobufDeserializerConfig) error {
+	rulesConf, ok := config[Rules]
+	if ok {
+		rules, okTypeCast := rulesConf.([]Rule)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a []Rule", Rules)
+		}
+		ps.rules = rules
+		delete(config, Rules)
+	}
+	return nil
+}
+
+// SetUseRegistryRules using the supplied ProtobufDeserializerConfig
+func (ps *ProtobufDeserializer) SetUseRegistryRules(config ProtobufDeserializerConfig) error {
+	useRegistryRulesConf, ok := config[UseRegistryRules]
+	if ok {
+		useRegistryRules, okTypeCast := useRegistryRulesConf.(bool)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a boolean value", UseRegistryRules)
+		}
+		if _, okClient := ps.resolver.client.(RuleSchemaRegistryClient); useRegistryRules && !okClient {
+			return fmt.Errorf("%s requires a Schema Registry client that implements RuleSchemaRegistryClient", UseRegistryRules)
+		}
+		ps.useRegistryRules = useRegistryRules
+		delete(config, UseRegistryRules)
+	}
+	return nil
+}
+
+// SetRuleDLQHandler using the supplied ProtobufDeserializerConfig
+func (ps *ProtobufDeserializer) SetRuleDLQHandler(config ProtobufDeserializerConfig) error {
+	ruleDLQHandlerConf, ok := config[RuleDLQHandlerImpl]
+	if ok {
+		ruleDLQHandler, okTypeCast := ruleDLQHandlerConf.(RuleDLQHandler)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a RuleDLQHandler", RuleDLQHandlerImpl)
+		}
+		ps.ruleDLQHandler = ruleDLQHandler
+		delete(config, RuleDLQHandlerImpl)
+	}
+	return nil
+}
+
+// SetRuleLogger using the supplied ProtobufDeserializerConfig
+func (ps *ProtobufDeserializer) SetRuleLogger(config ProtobufDeserializerConfig) error {
+	ruleLoggerConf, ok := config[RuleLoggerImpl]
+	if ok {
+		ruleLogger, okTypeCast := ruleLoggerConf.(RuleLogger)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a RuleLogger", RuleLoggerImpl)
+		}
+		ps.ruleLogger = ruleLogger
+		delete(config, RuleLoggerImpl)
+	}
+	return nil
+}
+
+// SetMigrations using the supplied ProtobufDeserializerConfig
+func (ps *ProtobufDeserializer) SetMigrations(config ProtobufDeserializerConfig) error {
+	migrationsConf, ok := config[Migrations]
+	if ok {
+		migrations, okTypeCast := migrationsConf.([]Migration)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a []Migration", Migrations)
+		}
+		ps.migrations = migrations
+		delete(config, Migrations)
+	}
+	return nil
+}
+
+// SetMigrationTargetVersion using the supplied ProtobufDeserializerConfig
+func (ps *ProtobufDeserializer) SetMigrationTargetVersion(config ProtobufDeserializerConfig) error {
+	migrationTargetVersionConf, ok := config[MigrationTargetVersion]
+	if ok {
+		migrationTargetVersion, okTypeCast := migrationTargetVersionConf.(int)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be an integer value", MigrationTargetVersion)
+		}
+		if migrationTargetVersion < LatestMigrationVersion {
+			return fmt.Errorf("%s must be a version, or LatestMigrationVersion", MigrationTargetVersion)
+		}
+		ps.migrationTarget = migrationTargetVersion
+		delete(config, MigrationTargetVersion)
+	}
+	return nil
+}
+
+// SetSubjectNameStrategy using the supplied ProtobufDeserializerConfig, the subject is only needed to migrate payloads
+func (ps *ProtobufDeserializer) SetSubjectNameStrategy(config ProtobufDeserializerConfig) error {
+	subjectNameStrategyConf, ok := config[SubjectNameStrategyImpl]
+	if ok {
+		subjectNameStrategy, okTypeCast := subjectNameStrategyConf.(SubjectNameStrategy)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a SubjectNameStrategy", SubjectNameStrategyImpl)
+		}
+		ps.subjectNameStrategy = subjectNameStrategy
+		delete(config, SubjectNameStrategyImpl)
+	}
+	return nil
+}
+
 // Deserialize using the Confluent Schema Registry wire format
 func (ps *ProtobufDeserializer) Deserialize(bytes []byte, pb proto.Message) error {
+	return ps.DeserializeContext(context.Background(), bytes, pb)
+}
+
+// DeserializeContext using the Confluent Schema Registry wire format, ctx is used for cancellation and deadlines
+func (ps *ProtobufDeserializer) DeserializeContext(ctx context.Context, bytes []byte, pb proto.Message) error {
+	return ps.deserialize(ctx, nil, SerializationContext{}, bytes, pb)
+}
+
+// DeserializeWithHeaders reads the schema ID from the KeySchemaIDHeader or ValueSchemaIDHeader record header for the
+// field in ctx, falling back to the Confluent Schema Registry wire format when the header is not there
+func (ps *ProtobufDeserializer) DeserializeWithHeaders(headers []Header, bytes []byte, ctx SerializationContext, pb proto.Message) error {
+	return ps.DeserializeWithHeadersContext(context.Background(), headers, bytes, ctx, pb)
+}
+
+// DeserializeWithHeadersContext reads the schema ID from the record headers when it is there, ctx is used for
+// cancellation and deadlines
+func (ps *ProtobufDeserializer) DeserializeWithHeadersContext(ctx context.Context, headers []Header, bytes []byte, serCtx SerializationContext, pb proto.Message) error {
+	return ps.deserialize(ctx, headers, serCtx, bytes, pb)
+}
+
+// DeserializeValue for ProtobufDeserializer, returns the generated message type for the writer schema, see DeserializeMessage
+func (ps *ProtobufDeserializer) DeserializeValue(ctx context.Context, bytes []byte, serCtx SerializationContext) (interface{}, error) {
+	return ps.deserializeMessage(ctx, nil, serCtx, bytes)
+}
+
+// DeserializeDynamic using the Confluent Schema Registry wire format, the writer schema is fetched from Schema Registry
+// so no generated protobuf code is needed
+func (ps *ProtobufDeserializer) DeserializeDynamic(bytes []byte) (*dynamicpb.Message, error) {
+	return ps.DeserializeDynamicContext(context.Background(), bytes)
+}
+
+// DeserializeDynamicContext using the Confluent Schema Registry wire format, ctx is used for cancellation and deadlines of any Schema Registry calls
+func (ps *ProtobufDeserializer) DeserializeDynamicContext(ctx context.Context, bytes []byte) (*dynamicpb.Message, error) {
+	return ps.deserializeDynamic(ctx, nil, SerializationContext{}, bytes)
+}
+
+// DeserializeDynamicWithHeaders reads the schema ID from the record headers when it is there, see DeserializeWithHeaders
+func (ps *ProtobufDeserializer) DeserializeDynamicWithHeaders(headers []Header, bytes []byte, ctx SerializationContext) (*dynamicpb.Message, error) {
+	return ps.DeserializeDynamicWithHeadersContext(context.Background(), headers, bytes, ctx)
+}
+
+// DeserializeDynamicWithHeadersContext reads the schema ID from the record headers when it is there, ctx is used for
+// cancellation and deadlines of any Schema Registry calls
+func (ps *ProtobufDeserializer) DeserializeDynamicWithHeadersContext(ctx context.Context, headers []Header, bytes []byte, serCtx SerializationContext) (*dynamicpb.Message, error) {
+	return ps.deserializeDynamic(ctx, headers, serCtx, bytes)
+}
+
+// DeserializeMessage using the Confluent Schema Registry wire format, the schema ID and message index select the
+// message name which is then used to create the matching generated message type
+func (ps *ProtobufDeserializer) DeserializeMessage(bytes []byte) (proto.Message, error) {
+	return ps.DeserializeMessageContext(context.Background(), bytes)
+}
+
+// DeserializeMessageContext using the Confluent Schema Registry wire format, ctx is used for cancellation and deadlines of any Schema Registry calls
+func (ps *ProtobufDeserializer) DeserializeMessageContext(ctx context.Context, bytes []byte) (proto.Message, error) {
+	return ps.deserializeMessage(ctx, nil, SerializationContext{}, bytes)
+}
+
+// DeserializeMessageWithHeaders reads the schema ID from the record headers when it is there, see DeserializeWithHeaders
+func (ps *ProtobufDeserializer) DeserializeMessageWithHeaders(headers []Header, bytes []byte, ctx SerializationContext) (proto.Message, error) {
+	return ps.DeserializeMessageWithHeadersContext(context.Background(), headers, bytes, ctx)
+}
+
+// DeserializeMessageWithHeadersContext reads the schema ID from the record headers when it is there, ctx is used for
+// cancellation and deadlines of any Schema Registry calls
+func (ps *ProtobufDeserializer) DeserializeMessageWithHeadersContext(ctx context.Context, headers []Header, bytes []byte, serCtx SerializationContext) (proto.Message, error) {
+	return ps.deserializeMessage(ctx, headers, serCtx, bytes)
+}
+
+// deserialize into pb, the schema ID is taken from headers for the field in serCtx when it is there and from bytes otherwise
+func (ps *ProtobufDeserializer) deserialize(ctx context.Context, headers []Header, serCtx SerializationContext, bytes []byte, pb proto.Message) error {
+	err := ps.readInto(ctx, headers, serCtx, bytes, pb)
+	if err != nil {
+		return deserializeError(headers, serCtx, bytes, err)
+	}
+	return nil
+}
+
+// deserializeDynamic into a dynamicpb.Message for the writer schema
+func (ps *ProtobufDeserializer) deserializeDynamic(ctx context.Context, headers []Header, serCtx SerializationContext, bytes []byte) (*dynamicpb.Message, error) {
+	msg, err := ps.readDynamic(ctx, headers, serCtx, bytes)
+	if err != nil {
+		return nil, deserializeError(headers, serCtx, bytes, err)
+	}
+	return msg, nil
+}
+
+// deserializeMessage into the generated message type for the writer schema
+func (ps *ProtobufDeserializer) deserializeMessage(ctx context.Context, headers []Header, serCtx SerializationContext, bytes []byte) (proto.Message, error) {
+	msg, err := ps.readMessage(ctx, headers, serCtx, bytes)
+	if err != nil {
+		return nil, deserializeError(headers, serCtx, bytes, err)
+	}
+	return msg, nil
+}
+
+// deserializeError wraps err in a *SerdeError. The framing is parsed again to find the writer schema and where the
+// payload starts, as this is only done once deserializing has failed.
+func deserializeError(headers []Header, serCtx SerializationContext, bytes []byte, err error) error {
+	schema, _, payload, parseErr := parseProtobufFraming(headers, serCtx.Field, bytes)
+	err = newSerdeError(serCtx, "", schema, err)
+	var serdeErr *SerdeError
+	if parseErr == nil && errors.Is(err, ErrInvalidPayload) && errors.As(err, &serdeErr) {
+		serdeErr.Offset = len(bytes) - len(payload)
+	}
+	return err
+}
+
+// readInto reads bytes into pb, see deserialize
+func (ps *ProtobufDeserializer) readInto(ctx context.Context, headers []Header, serCtx SerializationContext, bytes []byte, pb proto.Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	schema, msgIndex, payload, err := parseProtobufFraming(headers, serCtx.Field, bytes)
+	if err != nil {
+		return err
+	}
+	migrated, readerSchema, err := ps.migrate(ctx, schema, msgIndex, payload, serCtx)
+	if err != nil {
+		return err
+	}
+	if ps.strictTypeChecking {
+		md, err := ps.messageDescriptor(ctx, schema, msgIndex, migrated)
+		if err != nil {
+			return err
+		}
+		wantName := pb.ProtoReflect().Descriptor().FullName()
+		if md.FullName() != wantName {
+			return newKindError(ErrMessageTypeMismatch, -1, fmt.Errorf("message type mismatch. The writer schema has message type %s but %s was expected", md.FullName(), wantName))
+		}
+	}
+	// Protobuf Messages are self-describing; no need to query schema
+	err = ps.unmarshal(ctx, payload, migrated, pb)
+	if err != nil {
+		return err
+	}
+	return ps.executeRules(ctx, pb, readerSchema, bytes, serCtx)
+}
+
+// readDynamic reads bytes into a dynamicpb.Message, see deserializeDynamic
+func (ps *ProtobufDeserializer) readDynamic(ctx context.Context, headers []Header, serCtx SerializationContext, bytes []byte) (*dynamicpb.Message, error) {
+	schema, msgIndex, payload, err := parseProtobufFraming(headers, serCtx.Field, bytes)
+	if err != nil {
+		return nil, err
+	}
+	migrated, readerSchema, err := ps.migrate(ctx, schema, msgIndex, payload, serCtx)
+	if err != nil {
+		return nil, err
+	}
+	md, err := ps.messageDescriptor(ctx, schema, msgIndex, migrated)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := dynamicpb.NewMessage(md)
+	err = ps.unmarshal(ctx, payload, migrated, msg)
+	if err != nil {
+		return nil, err
+	}
+	err = ps.executeRules(ctx, msg, readerSchema, bytes, serCtx)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// readMessage reads bytes into the generated message type, see deserializeMessage
+func (ps *ProtobufDeserializer) readMessage(ctx context.Context, headers []Header, serCtx SerializationContext, bytes []byte) (proto.Message, error) {
+	schema, msgIndex, payload, err := parseProtobufFraming(headers, serCtx.Field, bytes)
+	if err != nil {
+		return nil, err
+	}
+	migrated, readerSchema, err := ps.migrate(ctx, schema, msgIndex, payload, serCtx)
+	if err != nil {
+		return nil, err
+	}
+	md, err := ps.messageDescriptor(ctx, schema, msgIndex, migrated)
+	if err != nil {
+		return nil, err
+	}
+
+	messageType, err := ps.messageTypeResolver.FindMessageByName(md.FullName())
+	if err != nil {
+		return nil, fmt.Errorf("unable to find message type %s: %w", md.FullName(), err)
+	}
+	msg := messageType.New().Interface()
+	err = ps.unmarshal(ctx, payload, migrated, msg)
+	if err != nil {
+		return nil, err
+	}
+	err = ps.executeRules(ctx, msg, readerSchema, bytes, serCtx)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// migrate returns the payload migrated to MigrationTargetVersion, or nil when payloads are not migrated, along with the
+// schema the payload is read with, whose rules apply to it. The payload is decrypted before it is migrated, as encrypted
+// fields are bound to their name in the writer schema. The subject comes from serCtx, so the methods without one fail
+// rather than look up a subject named after an empty topic.
+func (ps *ProtobufDeserializer) migrate(ctx context.Context, schema schemaIdentifier, msgIndex []int, payload []byte, serCtx SerializationContext) (*dynamicpb.Message, schemaIdentifier, error) {
+	if ps.migrator == nil {
+		return nil, schema, nil
+	}
+	if serCtx == (SerializationContext{}) {
+		return nil, schemaIdentifier{}, fmt.Errorf("payloads can only be migrated with a SerializationContext to find their subject, use the WithHeaders methods or DeserializeValue")
+	}
+
+	schemaID, err := ps.resolver.schemaID(ctx, schema)
+	if err != nil {
+		return nil, schemaIdentifier{}, err
+	}
+	md, err := ps.writerMessageDescriptor(ctx, schema, msgIndex)
+	if err != nil {
+		return nil, schemaIdentifier{}, err
+	}
+	msg := dynamicpb.NewMessage(md)
+	err = proto.Unmarshal(payload, msg)
+	if err != nil {
+		return nil, schemaIdentifier{}, newKindError(ErrInvalidPayload, -1, err)
+	}
+	err = ps.decrypt(ctx, msg)
+	if err != nil {
+		return nil, schemaIdentifier{}, err
+	}
+
+	subject := ps.subjectNameStrategy.Subject(serCtx, string(md.FullName()))
+	migrated, targetID, err := ps.migrator.migrate(ctx, subject, schemaID, msg)
+	if err != nil {
+		return nil, schemaIdentifier{}, newSerdeError(serCtx, subject, schemaIdentifier{id: schemaID, guid: schema.guid}, err)
+	}
+	return migrated, schemaIdentifier{id: targetID}, nil
+}
+
+// messageDescriptor returns the descriptor of the message being read, which is the migrated one when there is one
+func (ps *ProtobufDeserializer) messageDescriptor(ctx context.Context, schema schemaIdentifier, msgIndex []int, migrated *dynamicpb.Message) (protoreflect.MessageDescriptor, error) {
+	if migrated != nil {
+		return migrated.Descriptor(), nil
+	}
+	return ps.writerMessageDescriptor(ctx, schema, msgIndex)
+}
+
+// unmarshal payload into pb and decrypt it, or when the payload has been migrated copy migrated, which is already
+// decrypted, into pb
+func (ps *ProtobufDeserializer) unmarshal(ctx context.Context, payload []byte, migrated *dynamicpb.Message, pb proto.Message) error {
+	if migrated == nil {
+		err := proto.Unmarshal(payload, pb)
+		if err != nil {
+			return newKindError(ErrInvalidPayload, -1, err)
+		}
+		return ps.decrypt(ctx, pb)
+	}
+
+	if pb.ProtoReflect().Descriptor() == migrated.Descriptor() {
+		proto.Merge(pb, migrated)
+		return nil
+	}
+	migratedBytes, err := proto.Marshal(migrated)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(migratedBytes, pb)
+}
+
+// decrypt the encrypted fields of pb in place when a KMS is configured
+func (ps *ProtobufDeserializer) decrypt(ctx context.Context, pb proto.Message) error {
+	if ps.encryptor == nil {
+		return nil
+	}
+	return ps.encryptor.decrypt(ctx, pb)
+}
+
+// executeRules checks pb against the rules when any are configured, bytes is what a RuleDLQHandler receives
+func (ps *ProtobufDeserializer) executeRules(ctx context.Context, pb proto.Message, schema schemaIdentifier, bytes []byte, serCtx SerializationContext) error {
+	if ps.ruleExecutor == nil {
+		return nil
+	}
+	schemaID, err := ps.resolver.schemaID(ctx, schema)
+	if err != nil {
+		return err
+	}
+	return ps.ruleExecutor.execute(ctx, pb, schemaID, bytes, serCtx)
+}
+
+// writerMessageDescriptor returns the descriptor of the message the writer used
+func (ps *ProtobufDeserializer) writerMessageDescriptor(ctx context.Context, schema schemaIdentifier, msgIndex []int) (protoreflect.MessageDescriptor, error) {
+	if ps.resolver == nil {
+		return nil, fmt.Errorf("a Schema Registry client is required to resolve writer schemas, use NewProtobufDeserializerWithClient")
+	}
+
+	var fd protoreflect.FileDescriptor
+	var err error
+	if schema.guid != "" {
+		fd, err = ps.resolver.fileDescriptorByGUID(ctx, schema.guid)
+	} else {
+		fd, err = ps.resolver.fileDescriptorByID(ctx, schema.id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return messageDescriptorForIndex(fd, msgIndex)
+}
+
+// parseProtobufFraming returns the writer schema, the message index and the protobuf payload, from the schema ID header
+// for field when it is in headers, otherwise from the Confluent Schema Registry wire format in bytes
+func parseProtobufFraming(headers []Header, field string, bytes []byte) (schemaIdentifier, []int, []byte, error) {
+	headerValue, ok := findSchemaIDHeader(headers, field)
+	if !ok {
+		return parseProtobufWireFormat(bytes)
+	}
+
+	schema, msgIndex, remaining, err := parseProtobufWireFormat(headerValue)
+	if err != nil {
+		return schemaIdentifier{}, nil, nil, fmt.Errorf("invalid %s header: %w", schemaIDHeaderKey(field), err)
+	}
+	if len(remaining) > 0 {
+		return schemaIdentifier{}, nil, nil, fmt.Errorf("invalid %s header: %d bytes left over", schemaIDHeaderKey(field), len(remaining))
+	}
+	return schema, msgIndex, bytes, nil
+}
+
+// parseProtobufWireFormat splits bytes into the writer schema, the message index and the protobuf payload, the
+// writer schema follows magic byte 0 as a 4 byte schema ID or magic byte 1 as a 16 byte schema GUID
+func parseProtobufWireFormat(bytes []byte) (schemaIdentifier, []int, []byte, error) {
 	const (
-		wireFormatLen = 5 // magic byte + schema ID
-		minBytesLen   = 6 // SR wire protocol + msg_index length
-		magicByte     = byte(0)
+		wireFormatLen     = 5  // magic byte + schema ID
+		guidWireFormatLen = 17 // magic byte + schema GUID
+		minBytesLen       = 6  // SR wire protocol + msg_index length
+		magicByte         = byte(0)
+		guidMagicByte     = byte(1)
 	)
+	tooSmall := &kindError{kind: ErrMessageTooSmall, msg: ErrMessageTooSmall.Error(), offset: len(bytes)}
 
 	if len(bytes) < minBytesLen {
-		return fmt.Errorf("message too small. This message was not produced with a Confluent Schema Registry serializer")
+		return schemaIdentifier{}, nil, nil, tooSmall
 	}
 
-	if bytes[0] != magicByte {
-		return fmt.Errorf("unknown magic byte. This message was not produced with a Confluent Schema Registry serializer")
+	var schema schemaIdentifier
+	var headerLen int
+	switch bytes[0] {
+	case magicByte:
+		schema.id = int(binary.BigEndian.Uint32(bytes[1:wireFormatLen]))
+		headerLen = wireFormatLen
+	case guidMagicByte:
+		if len(bytes) < guidWireFormatLen+1 {
+			return schemaIdentifier{}, nil, nil, tooSmall
+		}
+		schema.guid = bytesToGUID(bytes[1:guidWireFormatLen])
+		headerLen = guidWireFormatLen
+	default:
+		return schemaIdentifier{}, nil, nil, &kindError{kind: ErrUnknownMagicByte, msg: ErrUnknownMagicByte.Error(), offset: 0}
 	}
 
 	// decode the number of elements in the array of message indexes
-	arrayLen, bytesRead := binary.Varint(bytes[wireFormatLen:])
-	const msgIndexErrMsg = "unable to decode message index array"
-	if arrayLen < 0 {
-		return fmt.Errorf(msgIndexErrMsg)
-	}
-	if bytesRead <= 0 {
-		return fmt.Errorf(msgIndexErrMsg)
+	arrayLen, bytesRead := binary.Varint(bytes[headerLen:])
+	if arrayLen < 0 || bytesRead <= 0 {
+		return schemaIdentifier{}, nil, nil, &kindError{kind: ErrInvalidMessageIndex, msg: ErrInvalidMessageIndex.Error(), offset: headerLen}
 	}
 	totalBytesRead := bytesRead
-	msgIndexArray := make([]int64, arrayLen)
+	// not preallocated as arrayLen is untrusted, decoding fails as soon as the bytes run out
+	var msgIndexArray []int
 	// iterate arrayLen times, decoding another varint
 	for i := int64(0); i < arrayLen; i++ {
-		idx, bytesRead := binary.Varint(bytes[wireFormatLen+totalBytesRead:])
+		idx, bytesRead := binary.Varint(bytes[headerLen+totalBytesRead:])
 		if bytesRead <= 0 {
-			err := fmt.Errorf("unable to decode value in message index array")
-			return err
+			return schemaIdentifier{}, nil, nil, &kindError{
+				kind:   ErrInvalidMessageIndex,
+				msg:    "unable to decode value in message index array",
+				offset: headerLen + totalBytesRead,
+			}
 		}
 		totalBytesRead += bytesRead
-		msgIndexArray[i] = idx
+		msgIndexArray = append(msgIndexArray, int(idx))
 	}
-	// Protobuf Messages are self-describing; no need to query schema
 	// Move the reader cursor past the index
-	err := proto.Unmarshal(bytes[wireFormatLen+totalBytesRead:], pb)
-	if err != nil {
-		return err
-	}
-	return nil
+	return schema, msgIndexArray, bytes[headerLen+totalBytesRead:], nil
 }
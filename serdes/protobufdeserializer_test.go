@@ -1,9 +1,16 @@
 package serdes
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/finncolman/kafka-go-serdes/internal/message"
+	"github.com/finncolman/kafka-go-serdes/internal/messagerefs"
 	"github.com/google/go-cmp/cmp"
+	"github.com/riferrei/srclient"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"reflect"
 	"testing"
 )
 
@@ -95,9 +102,14 @@ func TestProtobufDeserializer_DeserializeErrors(t *testing.T) {
 		},
 		{
 			"magic byte missing",
-			[]byte{1, 0, 0, 0, 0, 0},
+			[]byte{2, 0, 0, 0, 0, 0},
 			"unknown magic byte. This message was not produced with a Confluent Schema Registry serializer",
 		},
+		{
+			"GUID message too small",
+			[]byte{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+			"message too small. This message was not produced with a Confluent Schema Registry serializer",
+		},
 		{
 			"message index array length only",
 			[]byte{0, 0, 0, 0, 0, 2},
@@ -134,3 +146,651 @@ func TestProtobufDeserializer_DeserializeErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestProtobufDeserializer_DeserializeContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	pd := NewProtobufDeserializer()
+	result := &message.MessageData{}
+	err := pd.DeserializeContext(ctx, []byte{0, 0, 0, 0, 0, 0}, result)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("pd.DeserializeContext(%v) == %v, want %v", result, err, context.Canceled)
+	}
+}
+
+func TestProtobufDeserializer_DeserializeWithHeaders(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	valueCtx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+	keyCtx := SerializationContext{Topic: "test", Field: MessageFieldKey}
+
+	msgData := &message.MessageData{Nest1: &message.Nested1{MessageId: 232}}
+	ps, err := NewProtobufSerializer(msgData.ProtoReflect().Descriptor(), msrc, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+	headers, payload, err := ps.SerializeWithHeaders(msgData, valueCtx)
+	if err != nil {
+		t.Fatalf("unexpected error on SerializeWithHeaders: %s", err.Error())
+	}
+	framed, err := ps.Serialize(msgData, valueCtx)
+	if err != nil {
+		t.Fatalf("unexpected error on Serialize: %s", err.Error())
+	}
+	keyHeaders, keyPayload, err := ps.SerializeWithHeaders(&message.Nested1{MessageId: 1}, keyCtx)
+	if err != nil {
+		t.Fatalf("unexpected error on SerializeWithHeaders: %s", err.Error())
+	}
+
+	cases := []struct {
+		name    string
+		headers []Header
+		data    []byte
+		ctx     SerializationContext
+		want    proto.Message
+	}{
+		{
+			"schema ID in the value header",
+			headers,
+			payload,
+			valueCtx,
+			msgData,
+		},
+		{
+			"schema ID in the payload",
+			nil,
+			framed,
+			valueCtx,
+			msgData,
+		},
+		{
+			"key header is not used for the value",
+			append([]Header{{Key: "trace", Value: []byte("abc")}}, keyHeaders...),
+			framed,
+			valueCtx,
+			msgData,
+		},
+		{
+			"schema ID in the key header alongside the value header",
+			append(append([]Header{}, headers...), keyHeaders...),
+			keyPayload,
+			keyCtx,
+			&message.Nested1{MessageId: 1},
+		},
+		{
+			"last repeated header wins",
+			append([]Header{{Key: ValueSchemaIDHeader, Value: []byte{0, 0, 0, 0, 9, 0}}}, headers...),
+			payload,
+			valueCtx,
+			msgData,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pd, err := NewProtobufDeserializerWithClient(msrc, ProtobufDeserializerConfig{StrictTypeChecking: true})
+			if err != nil {
+				t.Fatalf("unexpected error on NewProtobufDeserializerWithClient: %s", err.Error())
+			}
+
+			got := c.want.ProtoReflect().New().Interface()
+			err = pd.DeserializeWithHeaders(c.headers, c.data, c.ctx, got)
+			if err != nil {
+				t.Fatalf("unexpected error on DeserializeWithHeaders: %s", err.Error())
+			}
+			if !proto.Equal(got, c.want) {
+				t.Fatalf("pd.DeserializeWithHeaders(%v, %v) == %v, want %v", c.headers, c.data, got, c.want)
+			}
+
+			gotMessage, err := pd.DeserializeMessageWithHeaders(c.headers, c.data, c.ctx)
+			if err != nil {
+				t.Fatalf("unexpected error on DeserializeMessageWithHeaders: %s", err.Error())
+			}
+			if !proto.Equal(gotMessage, c.want) {
+				t.Fatalf("pd.DeserializeMessageWithHeaders(%v, %v) == %v, want %v", c.headers, c.data, gotMessage, c.want)
+			}
+
+			gotDynamic, err := pd.DeserializeDynamicWithHeaders(c.headers, c.data, c.ctx)
+			if err != nil {
+				t.Fatalf("unexpected error on DeserializeDynamicWithHeaders: %s", err.Error())
+			}
+			wantDynamic, err := proto.Marshal(c.want)
+			if err != nil {
+				t.Fatalf("unexpected error on proto.Marshal: %s", err.Error())
+			}
+			gotBytes, err := proto.Marshal(gotDynamic)
+			if err != nil {
+				t.Fatalf("unexpected error on proto.Marshal: %s", err.Error())
+			}
+			if gotDynamic.Descriptor().FullName() != c.want.ProtoReflect().Descriptor().FullName() || !reflect.DeepEqual(gotBytes, wantDynamic) {
+				t.Fatalf("pd.DeserializeDynamicWithHeaders(%v, %v) == %v, want %v", c.headers, c.data, gotDynamic, c.want)
+			}
+		})
+	}
+}
+
+func TestProtobufDeserializer_DeserializeWithHeadersErrors(t *testing.T) {
+	ctx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+
+	cases := []struct {
+		name    string
+		headers []Header
+		data    []byte
+		want    string
+	}{
+		{
+			"header too small",
+			[]Header{{Key: ValueSchemaIDHeader, Value: []byte{0, 0, 0, 1}}},
+			nil,
+			"invalid __value_schema_id header: message too small. This message was not produced with a Confluent Schema Registry serializer",
+		},
+		{
+			"header magic byte missing",
+			[]Header{{Key: ValueSchemaIDHeader, Value: []byte{2, 0, 0, 0, 1, 0}}},
+			nil,
+			"invalid __value_schema_id header: unknown magic byte. This message was not produced with a Confluent Schema Registry serializer",
+		},
+		{
+			"bytes left over in header",
+			[]Header{{Key: ValueSchemaIDHeader, Value: []byte{0, 0, 0, 0, 1, 0, 8, 1}}},
+			nil,
+			"invalid __value_schema_id header: 2 bytes left over",
+		},
+		{
+			"no header and no payload framing",
+			[]Header{{Key: KeySchemaIDHeader, Value: []byte{0, 0, 0, 0, 1, 0}}},
+			[]byte{8, 1},
+			"message too small. This message was not produced with a Confluent Schema Registry serializer",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pd := NewProtobufDeserializer()
+			err := pd.DeserializeWithHeaders(c.headers, c.data, ctx, &message.MessageData{})
+			if err == nil || err.Error() != c.want {
+				t.Fatalf("pd.DeserializeWithHeaders(%v, %v) == %v, want %v", c.headers, c.data, err, c.want)
+			}
+		})
+	}
+}
+
+func TestProtobufDeserializer_DeserializeSchemaGUID(t *testing.T) {
+	ctx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+	msgData := &message.MessageData{Nest1: &message.Nested1{MessageId: 232}}
+
+	producerSrc := newGUIDMockSchemaRegistryClient()
+	ps, err := NewProtobufSerializer(msgData.ProtoReflect().Descriptor(), producerSrc, ProtobufSerializerConfig{UseSchemaGUID: true})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+	data, err := ps.Serialize(msgData, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error on Serialize: %s", err.Error())
+	}
+
+	// the topic was migrated to a registry where the schema has another ID
+	consumerSrc := newGUIDMockSchemaRegistryClient()
+	_, err = consumerSrc.CreateSchema("other-value", "other", srclient.Protobuf)
+	if err != nil {
+		t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+	}
+	schemaString, err := fileDescriptorToString(msgData.ProtoReflect().Descriptor().ParentFile())
+	if err != nil {
+		t.Fatalf("unexpected error on fileDescriptorToString: %s", err.Error())
+	}
+	migrated, err := consumerSrc.CreateSchema("test-value", schemaString, srclient.Protobuf)
+	if err != nil {
+		t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+	}
+	if migrated.ID() == 1 {
+		t.Fatalf("migrated schema ID == %d, want an ID other than the producer's", migrated.ID())
+	}
+
+	pd, err := NewProtobufDeserializerWithClient(consumerSrc, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufDeserializerWithClient: %s", err.Error())
+	}
+	// the second call uses the cached schema
+	for i := 0; i < 2; i++ {
+		got, err := pd.DeserializeMessage(data)
+		if err != nil {
+			t.Fatalf("unexpected error on DeserializeMessage: %s", err.Error())
+		}
+		if !proto.Equal(got, msgData) {
+			t.Fatalf("pd.DeserializeMessage(%v) == %v, want %v", data, got, msgData)
+		}
+	}
+	if got := consumerSrc.callCount("GetSchemaByGUID"); got != 1 {
+		t.Fatalf("GetSchemaByGUID called %d times, want 1", got)
+	}
+
+	// generated types do not need the writer schema
+	got := &message.MessageData{}
+	err = NewProtobufDeserializer().Deserialize(data, got)
+	if err != nil {
+		t.Fatalf("unexpected error on Deserialize: %s", err.Error())
+	}
+	if !proto.Equal(got, msgData) {
+		t.Fatalf("pd.Deserialize(%v) == %v, want %v", data, got, msgData)
+	}
+}
+
+func TestProtobufDeserializer_DeserializeSchemaGUIDErrors(t *testing.T) {
+	guid := []byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0}
+	data := append(append([]byte{1}, guid...), 0)
+
+	cases := []struct {
+		name   string
+		client srclient.ISchemaRegistryClient
+		want   string
+	}{
+		{
+			"client without GUID support",
+			newRegistryMockSchemaRegistryClient(),
+			"schema GUID 12345678-9abc-def0-1234-56789abcdef0 cannot be resolved, the Schema Registry client does not implement GUIDSchemaRegistryClient",
+		},
+		{
+			"unknown GUID",
+			newGUIDMockSchemaRegistryClient(),
+			"schema 12345678-9abc-def0-1234-56789abcdef0 not found",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pd, err := NewProtobufDeserializerWithClient(c.client, nil)
+			if err != nil {
+				t.Fatalf("unexpected error on NewProtobufDeserializerWithClient: %s", err.Error())
+			}
+			_, err = pd.DeserializeDynamic(data)
+			if err == nil || err.Error() != c.want {
+				t.Fatalf("pd.DeserializeDynamic(%v) == %v, want %v", data, err, c.want)
+			}
+		})
+	}
+}
+
+func TestProtobufDeserializer_DeserializeDynamic(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	ctx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+
+	msgData := &message.MessageData{Nest1: &message.Nested1{MessageId: 232}, Nest2: &message.Nested2{Id: "sdcvcsdsd", AdditionalData: map[string]string{"a": "b"}}}
+	msgRefsData := &messagerefs.MessageData{Nest1: &messagerefs.Nested1{MessageId: 7}, Nest2: &messagerefs.Nested2{Id: "refs"}}
+	nested2 := &message.Nested2{Id: "second", AnotherPart: "part"}
+
+	ps, err := NewProtobufSerializer(msgData.ProtoReflect().Descriptor(), msrc, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+	pd, err := NewProtobufDeserializerWithClient(msrc, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufDeserializerWithClient: %s", err.Error())
+	}
+
+	cases := []struct {
+		name string
+		msg  proto.Message
+	}{
+		{
+			"third element at top level",
+			msgData,
+		},
+		{
+			"second element at top level",
+			nested2,
+		},
+		{
+			"schema with references",
+			msgRefsData,
+		},
+		{
+			"cached schema",
+			msgData,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data, err := ps.Serialize(c.msg, ctx)
+			if err != nil {
+				t.Fatalf("unexpected error on Serialize: %s", err.Error())
+			}
+			result, err := pd.DeserializeDynamic(data)
+			if err != nil {
+				t.Fatalf("unexpected error on DeserializeDynamic: %s", err.Error())
+			}
+			if result.Descriptor().FullName() != c.msg.ProtoReflect().Descriptor().FullName() {
+				t.Fatalf("pd.DeserializeDynamic(%v) message name == %v, want %v", data, result.Descriptor().FullName(), c.msg.ProtoReflect().Descriptor().FullName())
+			}
+			// the dynamic message must hold the same data as the generated message
+			resultBytes, err := proto.Marshal(result)
+			if err != nil {
+				t.Fatalf("unexpected error on proto.Marshal: %s", err.Error())
+			}
+			got := c.msg.ProtoReflect().New().Interface()
+			err = proto.Unmarshal(resultBytes, got)
+			if err != nil {
+				t.Fatalf("unexpected error on proto.Unmarshal: %s", err.Error())
+			}
+			if !proto.Equal(got, c.msg) {
+				t.Fatalf("pd.DeserializeDynamic(%v) == %v, want %v", data, got, c.msg)
+			}
+		})
+	}
+
+	// one lookup per schema ID, the rest come from the cache
+	if got := msrc.callCount("GetSchema"); got != 2 {
+		t.Fatalf("GetSchema called %d times, want %d", got, 2)
+	}
+}
+
+func TestProtobufDeserializer_DeserializeDynamicErrors(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	msgData := &message.MessageData{}
+	ps, err := NewProtobufSerializer(msgData.ProtoReflect().Descriptor(), msrc, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+	_, err = ps.Serialize(msgData, SerializationContext{Topic: "test", Field: MessageFieldValue})
+	if err != nil {
+		t.Fatalf("unexpected error on Serialize: %s", err.Error())
+	}
+	pdWithClient, err := NewProtobufDeserializerWithClient(msrc, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufDeserializerWithClient: %s", err.Error())
+	}
+
+	cases := []struct {
+		name string
+		pd   *ProtobufDeserializer
+		data []byte
+		want string
+	}{
+		{
+			"no Schema Registry client",
+			NewProtobufDeserializer(),
+			[]byte{0, 0, 0, 0, 1, 0},
+			"a Schema Registry client is required to resolve writer schemas, use NewProtobufDeserializerWithClient",
+		},
+		{
+			"unknown schema ID",
+			pdWithClient,
+			[]byte{0, 0, 0, 0, 9, 0},
+			"schema 9 not found",
+		},
+		{
+			"message index not in schema",
+			pdWithClient,
+			[]byte{0, 0, 0, 0, 1, 2, 6},
+			"message index [3] not found in schema message.proto",
+		},
+		{
+			"magic byte missing",
+			pdWithClient,
+			[]byte{2, 0, 0, 0, 1, 0},
+			"unknown magic byte. This message was not produced with a Confluent Schema Registry serializer",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := c.pd.DeserializeDynamic(c.data)
+			if err == nil {
+				t.Fatalf("expected error on DeserializeDynamic, got none")
+			}
+			if err.Error() != c.want {
+				t.Fatalf("pd.DeserializeDynamic(%v) == %v, want %v", c.data, err, c.want)
+			}
+		})
+	}
+}
+
+func TestProtobufDeserializer_DeserializeMessage(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	ctx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+
+	msgData := &message.MessageData{Nest1: &message.Nested1{MessageId: 232}}
+	ps, err := NewProtobufSerializer(msgData.ProtoReflect().Descriptor(), msrc, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+
+	customTypes := new(protoregistry.Types)
+	err = customTypes.RegisterMessage((&message.Nested2{}).ProtoReflect().Type())
+	if err != nil {
+		t.Fatalf("unexpected error on RegisterMessage: %s", err.Error())
+	}
+
+	cases := []struct {
+		name   string
+		config ProtobufDeserializerConfig
+		msg    proto.Message
+	}{
+		{
+			"global types third element at top level",
+			nil,
+			msgData,
+		},
+		{
+			"global types first element at top level",
+			nil,
+			&message.Nested1{MessageId: 1, Test: "blah"},
+		},
+		{
+			"global types schema with references",
+			nil,
+			&messagerefs.MessageData{Nest2: &messagerefs.Nested2{Id: "refs"}},
+		},
+		{
+			"custom MessageTypeResolver",
+			ProtobufDeserializerConfig{MessageTypeResolverImpl: customTypes},
+			&message.Nested2{Id: "second"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pd, err := NewProtobufDeserializerWithClient(msrc, c.config)
+			if err != nil {
+				t.Fatalf("unexpected error on NewProtobufDeserializerWithClient: %s", err.Error())
+			}
+			data, err := ps.Serialize(c.msg, ctx)
+			if err != nil {
+				t.Fatalf("unexpected error on Serialize: %s", err.Error())
+			}
+			got, err := pd.DeserializeMessage(data)
+			if err != nil {
+				t.Fatalf("unexpected error on DeserializeMessage: %s", err.Error())
+			}
+			if reflect.TypeOf(got) != reflect.TypeOf(c.msg) {
+				t.Fatalf("pd.DeserializeMessage(%v) type == %T, want %T", data, got, c.msg)
+			}
+			if !proto.Equal(got, c.msg) {
+				t.Fatalf("pd.DeserializeMessage(%v) == %v, want %v", data, got, c.msg)
+			}
+		})
+	}
+}
+
+func TestProtobufDeserializer_NewProtobufDeserializerWithClientErrors(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+
+	cases := []struct {
+		name   string
+		config ProtobufDeserializerConfig
+		want   error
+	}{
+		{
+			fmt.Sprintf("wrong type for %s", MessageTypeResolverImpl),
+			ProtobufDeserializerConfig{
+				MessageTypeResolverImpl: true,
+			},
+			fmt.Errorf("%s must be a MessageTypeResolver", MessageTypeResolverImpl),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", StrictTypeChecking),
+			ProtobufDeserializerConfig{
+				StrictTypeChecking: "true",
+			},
+			fmt.Errorf("%s must be a boolean value", StrictTypeChecking),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", EncryptionKMS),
+			ProtobufDeserializerConfig{
+				EncryptionKMS: "kms",
+			},
+			fmt.Errorf("%s must be a KMS", EncryptionKMS),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", EncryptionTags),
+			ProtobufDeserializerConfig{
+				EncryptionTags: "PII",
+			},
+			fmt.Errorf("%s must be a []string", EncryptionTags),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", EncryptionFields),
+			ProtobufDeserializerConfig{
+				EncryptionFields: "message.Nested2.id",
+			},
+			fmt.Errorf("%s must be a []string", EncryptionFields),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", Rules),
+			ProtobufDeserializerConfig{
+				Rules: Rule{Name: "positive", Expr: "amount > 0"},
+			},
+			fmt.Errorf("%s must be a []Rule", Rules),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", UseRegistryRules),
+			ProtobufDeserializerConfig{
+				UseRegistryRules: "true",
+			},
+			fmt.Errorf("%s must be a boolean value", UseRegistryRules),
+		},
+		{
+			fmt.Sprintf("%s without a RuleSchemaRegistryClient", UseRegistryRules),
+			ProtobufDeserializerConfig{
+				UseRegistryRules: true,
+			},
+			fmt.Errorf("%s requires a Schema Registry client that implements RuleSchemaRegistryClient", UseRegistryRules),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", RuleDLQHandlerImpl),
+			ProtobufDeserializerConfig{
+				RuleDLQHandlerImpl: "dlq",
+			},
+			fmt.Errorf("%s must be a RuleDLQHandler", RuleDLQHandlerImpl),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", RuleLoggerImpl),
+			ProtobufDeserializerConfig{
+				RuleLoggerImpl: "log",
+			},
+			fmt.Errorf("%s must be a RuleLogger", RuleLoggerImpl),
+		},
+		{
+			fmt.Sprintf("%s rule without %s", RuleActionDLQ, RuleDLQHandlerImpl),
+			ProtobufDeserializerConfig{
+				Rules: []Rule{{Name: "positive", Expr: "amount > 0", Action: RuleActionDLQ}},
+			},
+			fmt.Errorf("rule positive has action %s but %s is not set", RuleActionDLQ, RuleDLQHandlerImpl),
+		},
+		{
+			"rule with unknown action",
+			ProtobufDeserializerConfig{
+				Rules: []Rule{{Name: "positive", Expr: "amount > 0", Action: "IGNORE"}},
+			},
+			fmt.Errorf("rule positive has unknown action IGNORE"),
+		},
+		{
+			fmt.Sprintf("serializer only property %s", EncryptionKeyID),
+			ProtobufDeserializerConfig{
+				EncryptionKeyID: "k1",
+			},
+			fmt.Errorf("unrecognized properties: %s", EncryptionKeyID),
+		},
+		{
+			"unrecognized properties",
+			ProtobufDeserializerConfig{
+				"made.this.up": true,
+			},
+			fmt.Errorf("unrecognized properties: made.this.up"),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := NewProtobufDeserializerWithClient(msrc, c.config)
+			if err == nil {
+				t.Fatalf("expected error on NewProtobufDeserializerWithClient but got none")
+			}
+			if !reflect.DeepEqual(err, c.want) {
+				t.Fatalf("NewProtobufDeserializerWithClient(%v, %v) == %v, want %v", msrc, c.config, err, c.want)
+			}
+		})
+	}
+}
+
+func TestProtobufDeserializer_DeserializeStrictTypeChecking(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	ctx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+
+	msgData := &message.MessageData{Nest1: &message.Nested1{MessageId: 232}}
+	ps, err := NewProtobufSerializer(msgData.ProtoReflect().Descriptor(), msrc, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+	data, err := ps.Serialize(msgData, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error on Serialize: %s", err.Error())
+	}
+
+	cases := []struct {
+		name   string
+		strict bool
+		result proto.Message
+		want   string // empty when no error is expected
+	}{
+		{
+			"strict with matching type",
+			true,
+			&message.MessageData{},
+			"",
+		},
+		{
+			"strict with mismatched type",
+			true,
+			&message.Nested2{},
+			"message type mismatch. The writer schema has message type message.MessageData but message.Nested2 was expected",
+		},
+		{
+			"strict with same named type from another file",
+			true,
+			&messagerefs.MessageData{},
+			"message type mismatch. The writer schema has message type message.MessageData but messagerefs.MessageData was expected",
+		},
+		{
+			"not strict with mismatched type",
+			false,
+			&message.Nested1{},
+			"",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pd, err := NewProtobufDeserializerWithClient(msrc, ProtobufDeserializerConfig{StrictTypeChecking: c.strict})
+			if err != nil {
+				t.Fatalf("unexpected error on NewProtobufDeserializerWithClient: %s", err.Error())
+			}
+			err = pd.Deserialize(data, c.result)
+			if c.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error on Deserialize: %s", err.Error())
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error on Deserialize, got none")
+			}
+			if err.Error() != c.want {
+				t.Fatalf("pd.Deserialize(%v, %v) == %v, want %v", data, c.result, err, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,491 @@
+package serdes
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	// EncryptionKMS the KMS that wraps and unwraps the data keys fields are encrypted with
+	EncryptionKMS = "encryption.kms"
+	// EncryptionKeyID the key the KMS wraps data keys with when serializing
+	EncryptionKeyID = "encryption.key.id"
+	// EncryptionTags encrypt the fields with any of these tags in their (confluent.field_meta).tags option
+	EncryptionTags = "encryption.tags"
+	// EncryptionFields encrypt the fields with these fully qualified names, such as mypackage.MyMessage.my_field
+	EncryptionFields = "encryption.fields"
+)
+
+const (
+	encryptedFieldVersion = byte(0)
+	dataKeyLen            = 32 // AES-256
+	// dataKeyMaxUses the number of values encrypted with a data key before it is replaced, well below the 2^32
+	// encryptions NIST allows an AES-GCM key with random nonces
+	dataKeyMaxUses = 1 << 30
+	// dataKeyMaxAge how long a data key is used for before it is replaced
+	dataKeyMaxAge = 24 * time.Hour
+)
+
+// KMS wraps and unwraps the data keys used to encrypt fields with the key encryption key named by keyID
+type KMS interface {
+	WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, keyID string, wrappedDataKey []byte) ([]byte, error)
+}
+
+// LocalKMS a KMS holding its key encryption keys in memory, for tests and for deployments that distribute keys themselves
+type LocalKMS struct {
+	keys map[string]cipher.AEAD // map from key ID to associated AES-GCM cipher
+}
+
+// NewLocalKMS returns a new LocalKMS with keys, a map from key ID to a 16, 24 or 32 byte AES key
+func NewLocalKMS(keys map[string][]byte) (*LocalKMS, error) {
+	kms := &LocalKMS{keys: make(map[string]cipher.AEAD)}
+	for keyID, key := range keys {
+		aead, err := newAESGCM(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %w", keyID, err)
+		}
+		kms.keys[keyID] = aead
+	}
+	return kms, nil
+}
+
+// WrapKey for LocalKMS, the wrapped key is the nonce followed by the data key encrypted with AES-GCM
+func (k *LocalKMS) WrapKey(_ context.Context, keyID string, dataKey []byte) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %s", keyID)
+	}
+	return sealAESGCM(aead, dataKey, []byte(keyID))
+}
+
+// UnwrapKey for LocalKMS
+func (k *LocalKMS) UnwrapKey(_ context.Context, keyID string, wrappedDataKey []byte) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %s", keyID)
+	}
+	dataKey, err := openAESGCM(aead, wrappedDataKey, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("unable to unwrap data key with key %s: %w", keyID, err)
+	}
+	return dataKey, nil
+}
+
+// fieldEncryptor encrypts and decrypts the fields of protobuf messages selected by tag or name
+type fieldEncryptor struct {
+	kms          KMS
+	keyID        string
+	tags         map[string]bool
+	fields       map[protoreflect.FullName]bool
+	selected     map[protoreflect.FieldDescriptor]bool // map from field to whether it is encrypted
+	selectedLock sync.RWMutex
+	dataKey      *encryptionDataKey // the data key fields are encrypted with, created on first use and replaced by getDataKey
+	dataKeyLock  sync.Mutex
+	maxUses      uint64                 // replace the data key after encrypting this many values with it
+	maxAge       time.Duration          // replace the data key once it is this old
+	dataKeys     map[string]cipher.AEAD // map from key ID and wrapped data key to associated cipher
+	dataKeysLock sync.RWMutex
+}
+
+// encryptionDataKey a data key along with its wrapped form, which is written with every encrypted field
+type encryptionDataKey struct {
+	aead    cipher.AEAD
+	wrapped []byte
+	uses    uint64 // the number of values encrypted with the data key
+	created time.Time
+}
+
+func newFieldEncryptor(kms KMS, keyID string, tags []string, fields []string) *fieldEncryptor {
+	e := &fieldEncryptor{
+		kms:      kms,
+		keyID:    keyID,
+		tags:     make(map[string]bool),
+		fields:   make(map[protoreflect.FullName]bool),
+		selected: make(map[protoreflect.FieldDescriptor]bool),
+		dataKeys: make(map[string]cipher.AEAD),
+		maxUses:  dataKeyMaxUses,
+		maxAge:   dataKeyMaxAge,
+	}
+	for _, tag := range tags {
+		e.tags[tag] = true
+	}
+	for _, field := range fields {
+		e.fields[protoreflect.FullName(field)] = true
+	}
+	return e
+}
+
+// encrypt returns a copy of pb with the selected fields encrypted, pb itself is left as it is
+func (e *fieldEncryptor) encrypt(ctx context.Context, pb proto.Message) (proto.Message, error) {
+	encrypted := proto.Clone(pb)
+	err := e.transformMessage(encrypted.ProtoReflect(), func(fd protoreflect.FieldDescriptor, value []byte) ([]byte, error) {
+		return e.encryptValue(ctx, fd, value)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return encrypted, nil
+}
+
+// decrypt the selected fields of pb in place
+func (e *fieldEncryptor) decrypt(ctx context.Context, pb proto.Message) error {
+	return e.transformMessage(pb.ProtoReflect(), func(fd protoreflect.FieldDescriptor, value []byte) ([]byte, error) {
+		return e.decryptValue(ctx, fd, value)
+	})
+}
+
+// transformMessage replaces the value of every selected field in m and the messages it contains with transform of it,
+// string values are base64 encoded when encrypted as they must stay valid UTF-8
+func (e *fieldEncryptor) transformMessage(m protoreflect.Message, transform func(protoreflect.FieldDescriptor, []byte) ([]byte, error)) error {
+	// collected first as fields must not be set while ranging over them
+	var fds []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		fds = append(fds, fd)
+		return true
+	})
+
+	for _, fd := range fds {
+		valueDesc := fd
+		if fd.IsMap() {
+			valueDesc = fd.MapValue()
+		}
+
+		if e.isSelected(fd) {
+			if valueDesc.Kind() != protoreflect.StringKind && valueDesc.Kind() != protoreflect.BytesKind {
+				return fmt.Errorf("field %s is %s, only string and bytes fields can be encrypted", fd.FullName(), valueDesc.Kind())
+			}
+			err := transformField(m, fd, func(value protoreflect.Value) (protoreflect.Value, error) {
+				return transformScalar(fd, valueDesc.Kind(), value, transform)
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if valueDesc.Message() != nil {
+			err := transformField(m, fd, func(value protoreflect.Value) (protoreflect.Value, error) {
+				return value, e.transformMessage(value.Message(), transform)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// transformField replaces every value of fd in m, the elements of lists and the values of maps included
+func transformField(m protoreflect.Message, fd protoreflect.FieldDescriptor, transform func(protoreflect.Value) (protoreflect.Value, error)) error {
+	switch {
+	case fd.IsList():
+		list := m.Mutable(fd).List()
+		for i := 0; i < list.Len(); i++ {
+			value, err := transform(list.Get(i))
+			if err != nil {
+				return err
+			}
+			list.Set(i, value)
+		}
+	case fd.IsMap():
+		mapValue := m.Mutable(fd).Map()
+		var keys []protoreflect.MapKey
+		mapValue.Range(func(key protoreflect.MapKey, _ protoreflect.Value) bool {
+			keys = append(keys, key)
+			return true
+		})
+		for _, key := range keys {
+			value, err := transform(mapValue.Get(key))
+			if err != nil {
+				return err
+			}
+			mapValue.Set(key, value)
+		}
+	default:
+		value, err := transform(m.Get(fd))
+		if err != nil {
+			return err
+		}
+		m.Set(fd, value)
+	}
+	return nil
+}
+
+// transformScalar applies transform to a string or bytes value of fd
+func transformScalar(fd protoreflect.FieldDescriptor, kind protoreflect.Kind, value protoreflect.Value, transform func(protoreflect.FieldDescriptor, []byte) ([]byte, error)) (protoreflect.Value, error) {
+	if kind == protoreflect.BytesKind {
+		transformed, err := transform(fd, value.Bytes())
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfBytes(transformed), nil
+	}
+	transformed, err := transform(fd, []byte(value.String()))
+	if err != nil {
+		return protoreflect.Value{}, err
+	}
+	return protoreflect.ValueOfString(string(transformed)), nil
+}
+
+// isSelected reports whether fd is to be encrypted, because of its name or the tags in its options
+func (e *fieldEncryptor) isSelected(fd protoreflect.FieldDescriptor) bool {
+	e.selectedLock.RLock()
+	selected, ok := e.selected[fd]
+	e.selectedLock.RUnlock()
+	if ok {
+		return selected
+	}
+
+	selected = e.fields[fd.FullName()]
+	for _, tag := range fieldTags(fd) {
+		selected = selected || e.tags[tag]
+	}
+
+	e.selectedLock.Lock()
+	e.selected[fd] = selected
+	e.selectedLock.Unlock()
+
+	return selected
+}
+
+// encryptValue encrypts value with the data key, the result holds everything needed to decrypt it given access to
+// the key encryption key: the version, the key ID, the wrapped data key, the nonce and the ciphertext
+func (e *fieldEncryptor) encryptValue(ctx context.Context, fd protoreflect.FieldDescriptor, value []byte) ([]byte, error) {
+	dataKey, err := e.getDataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := sealAESGCM(dataKey.aead, value, []byte(fd.FullName()))
+	if err != nil {
+		return nil, fmt.Errorf("unable to encrypt field %s: %w", fd.FullName(), err)
+	}
+
+	var encrypted []byte
+	encrypted = append(encrypted, encryptedFieldVersion)
+	encrypted = appendLengthPrefixed(encrypted, []byte(e.keyID))
+	encrypted = appendLengthPrefixed(encrypted, dataKey.wrapped)
+	encrypted = append(encrypted, sealed...)
+
+	if fd.Kind() == protoreflect.StringKind || (fd.IsMap() && fd.MapValue().Kind() == protoreflect.StringKind) {
+		return []byte(base64.StdEncoding.EncodeToString(encrypted)), nil
+	}
+	return encrypted, nil
+}
+
+// decryptValue decrypts a value written by encryptValue
+func (e *fieldEncryptor) decryptValue(ctx context.Context, fd protoreflect.FieldDescriptor, value []byte) ([]byte, error) {
+	invalid := fmt.Errorf("unable to decrypt field %s: the value was not encrypted by this serializer", fd.FullName())
+	encrypted := value
+	if fd.Kind() == protoreflect.StringKind || (fd.IsMap() && fd.MapValue().Kind() == protoreflect.StringKind) {
+		decoded, err := base64.StdEncoding.DecodeString(string(value))
+		if err != nil {
+			return nil, invalid
+		}
+		encrypted = decoded
+	}
+
+	if len(encrypted) == 0 || encrypted[0] != encryptedFieldVersion {
+		return nil, invalid
+	}
+	keyID, rest, ok := readLengthPrefixed(encrypted[1:])
+	if !ok {
+		return nil, invalid
+	}
+	wrapped, sealed, ok := readLengthPrefixed(rest)
+	if !ok {
+		return nil, invalid
+	}
+
+	aead, err := e.unwrapDataKey(ctx, string(keyID), wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt field %s: %w", fd.FullName(), err)
+	}
+	plaintext, err := openAESGCM(aead, sealed, []byte(fd.FullName()))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt field %s: %w", fd.FullName(), err)
+	}
+	return plaintext, nil
+}
+
+// getDataKey returns the data key to encrypt one value with, creating it and having the KMS wrap it on first use and
+// whenever the current one has been used maxUses times or is maxAge old. Every value carries its wrapped data key, so
+// values encrypted with earlier data keys can still be decrypted.
+func (e *fieldEncryptor) getDataKey(ctx context.Context) (*encryptionDataKey, error) {
+	e.dataKeyLock.Lock()
+	defer e.dataKeyLock.Unlock()
+	if e.dataKey != nil && e.dataKey.uses < e.maxUses && time.Since(e.dataKey.created) < e.maxAge {
+		e.dataKey.uses++
+		return e.dataKey, nil
+	}
+
+	key := make([]byte, dataKeyLen)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create data key: %w", err)
+	}
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := e.kms.WrapKey(ctx, e.keyID, key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to wrap data key with key %s: %w", e.keyID, err)
+	}
+
+	e.dataKey = &encryptionDataKey{aead: aead, wrapped: wrapped, uses: 1, created: time.Now()}
+	return e.dataKey, nil
+}
+
+// unwrapDataKey returns the cipher for a wrapped data key, having the KMS unwrap it on first use
+func (e *fieldEncryptor) unwrapDataKey(ctx context.Context, keyID string, wrapped []byte) (cipher.AEAD, error) {
+	cacheKey := keyID + "/" + string(wrapped)
+	e.dataKeysLock.RLock()
+	aead, ok := e.dataKeys[cacheKey]
+	e.dataKeysLock.RUnlock()
+	if ok {
+		return aead, nil
+	}
+
+	key, err := e.kms.UnwrapKey(ctx, keyID, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	aead, err = newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	e.dataKeysLock.Lock()
+	e.dataKeys[cacheKey] = aead
+	e.dataKeysLock.Unlock()
+
+	return aead, nil
+}
+
+var (
+	fieldMetaType     protoreflect.ExtensionType
+	fieldMetaTypes    *protoregistry.Types
+	fieldMetaTypeOnce sync.Once
+)
+
+// fieldTags returns the tags in the (confluent.field_meta).tags option of fd
+func fieldTags(fd protoreflect.FieldDescriptor) []string {
+	options, ok := fd.Options().(*descriptorpb.FieldOptions)
+	if !ok || options == nil {
+		return nil
+	}
+
+	fieldMetaTypeOnce.Do(func() {
+		metaFd, err := builtinFileDescriptor("confluent/meta.proto")
+		if err != nil {
+			return
+		}
+		fieldMetaType = dynamicpb.NewExtensionType(metaFd.Extensions().ByName("field_meta"))
+		fieldMetaTypes = new(protoregistry.Types)
+		_ = fieldMetaTypes.RegisterExtension(fieldMetaType)
+	})
+	if fieldMetaType == nil {
+		return nil
+	}
+
+	// the option is an unknown field for generated code and a dynamic extension for schemas from Schema Registry,
+	// so it is read back through a resolver that knows the extension either way
+	optionBytes, err := proto.Marshal(options)
+	if err != nil {
+		return nil
+	}
+	resolved := &descriptorpb.FieldOptions{}
+	err = proto.UnmarshalOptions{Resolver: fieldMetaTypes}.Unmarshal(optionBytes, resolved)
+	if err != nil {
+		return nil
+	}
+	m := resolved.ProtoReflect()
+	if !m.Has(fieldMetaType.TypeDescriptor()) {
+		return nil
+	}
+	meta := m.Get(fieldMetaType.TypeDescriptor()).Message()
+	tagsList := meta.Get(meta.Descriptor().Fields().ByName("tags")).List()
+	var tags []string
+	for i := 0; i < tagsList.Len(); i++ {
+		tags = append(tags, tagsList.Get(i).String())
+	}
+	return tags
+}
+
+// checkEncryptionConfig returns an error when fields are selected for encryption without a KMS or key ID to do it
+func checkEncryptionConfig(kms KMS, keyID string, tags []string, fields []string) error {
+	if len(tags) == 0 && len(fields) == 0 {
+		return nil
+	}
+	var missing []string
+	if kms == nil {
+		missing = append(missing, EncryptionKMS)
+	}
+	if keyID == "" {
+		missing = append(missing, EncryptionKeyID)
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("%s must be set to encrypt fields", strings.Join(missing, " and "))
+	}
+	return nil
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealAESGCM encrypts plaintext with a random nonce, which is prepended to the ciphertext
+func sealAESGCM(aead cipher.AEAD, plaintext []byte, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// openAESGCM decrypts ciphertext written by sealAESGCM
+func openAESGCM(aead cipher.AEAD, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	return aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], additionalData)
+}
+
+func appendLengthPrefixed(b []byte, value []byte) []byte {
+	lenBytes := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(lenBytes, uint64(len(value)))
+	b = append(b, lenBytes[:n]...)
+	return append(b, value...)
+}
+
+// readLengthPrefixed returns the value written by appendLengthPrefixed at the start of b, along with the rest of b
+func readLengthPrefixed(b []byte) ([]byte, []byte, bool) {
+	valueLen, n := binary.Uvarint(b)
+	if n <= 0 || valueLen > uint64(len(b)-n) {
+		return nil, nil, false
+	}
+	end := n + int(valueLen)
+	return b[n:end], b[end:], true
+}
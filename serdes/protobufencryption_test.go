@@ -0,0 +1,463 @@
+package serdes
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"github.com/finncolman/kafka-go-serdes/internal/message"
+	"github.com/riferrei/srclient"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testTaggedSchema = `syntax = "proto3";
+package test.encryption;
+
+import "confluent/meta.proto";
+
+message Customer {
+  string name = 1 [(confluent.field_meta) = { tags: ["PII"] }];
+  bytes passport = 2 [(confluent.field_meta).tags = "PII", (confluent.field_meta).tags = "SECRET"];
+  repeated string emails = 3 [(confluent.field_meta) = { tags: ["PII"] }];
+  string country = 4 [(confluent.field_meta) = { tags: ["PUBLIC"] }];
+  int64 id = 5;
+}
+`
+
+// countingKMS counts the calls made to the wrapped KMS
+type countingKMS struct {
+	KMS
+	lock   sync.Mutex
+	wraps  int
+	unwrap int
+}
+
+func (k *countingKMS) WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error) {
+	k.lock.Lock()
+	k.wraps++
+	k.lock.Unlock()
+	return k.KMS.WrapKey(ctx, keyID, dataKey)
+}
+
+func (k *countingKMS) UnwrapKey(ctx context.Context, keyID string, wrappedDataKey []byte) ([]byte, error) {
+	k.lock.Lock()
+	k.unwrap++
+	k.lock.Unlock()
+	return k.KMS.UnwrapKey(ctx, keyID, wrappedDataKey)
+}
+
+func newTestLocalKMS(t *testing.T) *LocalKMS {
+	kms, err := NewLocalKMS(map[string][]byte{
+		"k1": []byte("0123456789abcdef0123456789abcdef"),
+		"k2": []byte("fedcba9876543210"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on NewLocalKMS: %s", err.Error())
+	}
+	return kms
+}
+
+// taggedCustomerDescriptor returns the Customer message of testTaggedSchema as built from Schema Registry
+func taggedCustomerDescriptor(t *testing.T, msrc *registryMockSchemaRegistryClient) protoreflect.MessageDescriptor {
+	theSchema, err := msrc.CreateSchema("customer", testTaggedSchema, srclient.Protobuf)
+	if err != nil {
+		t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+	}
+	fd, err := newProtobufSchemaResolver(msrc).fileDescriptorByID(context.Background(), theSchema.ID())
+	if err != nil {
+		t.Fatalf("unexpected error on fileDescriptorByID: %s", err.Error())
+	}
+	return fd.Messages().ByName("Customer")
+}
+
+func TestProtobufEncryption_LocalKMS(t *testing.T) {
+	kms := newTestLocalKMS(t)
+	ctx := context.Background()
+	dataKey := []byte("a data key")
+
+	for _, keyID := range []string{"k1", "k2"} {
+		wrapped, err := kms.WrapKey(ctx, keyID, dataKey)
+		if err != nil {
+			t.Fatalf("unexpected error on WrapKey: %s", err.Error())
+		}
+		if reflect.DeepEqual(wrapped, dataKey) {
+			t.Fatalf("kms.WrapKey(%s, %s) == the data key", keyID, dataKey)
+		}
+		got, err := kms.UnwrapKey(ctx, keyID, wrapped)
+		if err != nil {
+			t.Fatalf("unexpected error on UnwrapKey: %s", err.Error())
+		}
+		if !reflect.DeepEqual(got, dataKey) {
+			t.Fatalf("kms.UnwrapKey(%s, %v) == %s, want %s", keyID, wrapped, got, dataKey)
+		}
+	}
+}
+
+func TestProtobufEncryption_LocalKMSErrors(t *testing.T) {
+	kms := newTestLocalKMS(t)
+	ctx := context.Background()
+	wrapped, err := kms.WrapKey(ctx, "k1", []byte("a data key"))
+	if err != nil {
+		t.Fatalf("unexpected error on WrapKey: %s", err.Error())
+	}
+
+	_, err = NewLocalKMS(map[string][]byte{"short": []byte("12345")})
+	want := "invalid key short: crypto/aes: invalid key size 5"
+	if err == nil || err.Error() != want {
+		t.Fatalf("NewLocalKMS() == %v, want %s", err, want)
+	}
+
+	_, err = kms.WrapKey(ctx, "k3", []byte("a data key"))
+	want = "unknown key k3"
+	if err == nil || err.Error() != want {
+		t.Fatalf("kms.WrapKey(k3) == %v, want %s", err, want)
+	}
+
+	// the key ID is authenticated, so a data key cannot be unwrapped with another key
+	_, err = kms.UnwrapKey(ctx, "k2", wrapped)
+	want = "unable to unwrap data key with key k2: cipher: message authentication failed"
+	if err == nil || err.Error() != want {
+		t.Fatalf("kms.UnwrapKey(k2) == %v, want %s", err, want)
+	}
+
+	_, err = kms.UnwrapKey(ctx, "k1", wrapped[:4])
+	want = "unable to unwrap data key with key k1: ciphertext too short"
+	if err == nil || err.Error() != want {
+		t.Fatalf("kms.UnwrapKey(k1) == %v, want %s", err, want)
+	}
+}
+
+func TestProtobufEncryption_fieldTags(t *testing.T) {
+	md := taggedCustomerDescriptor(t, newRegistryMockSchemaRegistryClient())
+
+	// generated code keeps the option as an unknown field when the extension is not linked into the binary
+	fdp := protodesc.ToFileDescriptorProto(md.ParentFile())
+	fdpBytes, err := proto.Marshal(fdp)
+	if err != nil {
+		t.Fatalf("unexpected error on proto.Marshal: %s", err.Error())
+	}
+	unknownFdp := &descriptorpb.FileDescriptorProto{}
+	err = proto.UnmarshalOptions{Resolver: new(protoregistry.Types)}.Unmarshal(fdpBytes, unknownFdp)
+	if err != nil {
+		t.Fatalf("unexpected error on proto.Unmarshal: %s", err.Error())
+	}
+	metaFd, err := builtinFileDescriptor("confluent/meta.proto")
+	if err != nil {
+		t.Fatalf("unexpected error on builtinFileDescriptor: %s", err.Error())
+	}
+	files := new(protoregistry.Files)
+	err = registerFileWithImports(metaFd, files)
+	if err != nil {
+		t.Fatalf("unexpected error on registerFileWithImports: %s", err.Error())
+	}
+	unknownFd, err := protodesc.NewFile(unknownFdp, files)
+	if err != nil {
+		t.Fatalf("unexpected error on protodesc.NewFile: %s", err.Error())
+	}
+
+	cases := []struct {
+		field protoreflect.Name
+		want  []string
+	}{
+		{"name", []string{"PII"}},
+		{"passport", []string{"PII", "SECRET"}},
+		{"emails", []string{"PII"}},
+		{"country", []string{"PUBLIC"}},
+		{"id", nil},
+	}
+	for _, c := range cases {
+		for _, fields := range []protoreflect.FieldDescriptors{md.Fields(), unknownFd.Messages().ByName("Customer").Fields()} {
+			got := fieldTags(fields.ByName(c.field))
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("fieldTags(%s) == %v, want %v", c.field, got, c.want)
+			}
+		}
+	}
+}
+
+func TestProtobufEncryption_SerializeEncryptionFields(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	ctx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+	kms := &countingKMS{KMS: newTestLocalKMS(t)}
+	fields := []string{"message.Nested1.test", "message.Nested2.id", "message.Nested2.additional_data"}
+
+	msgData := &message.MessageData{
+		Nest1: &message.Nested1{MessageId: 1, Test: "secret test"},
+		Nest2: &message.Nested2{Id: "secret id", Another: "plain", AdditionalData: map[string]string{"a": "secret a", "b": "secret b"}},
+	}
+	original := proto.Clone(msgData)
+
+	ps, err := NewProtobufSerializer(msgData.ProtoReflect().Descriptor(), msrc, ProtobufSerializerConfig{
+		EncryptionKMS:    kms,
+		EncryptionKeyID:  "k1",
+		EncryptionFields: fields,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+	var data [][]byte
+	for i := 0; i < 2; i++ {
+		got, err := ps.Serialize(msgData, ctx)
+		if err != nil {
+			t.Fatalf("unexpected error on Serialize: %s", err.Error())
+		}
+		data = append(data, got)
+	}
+	if !proto.Equal(msgData, original) {
+		t.Fatalf("ps.Serialize changed its argument to %v, want %v", msgData, original)
+	}
+	if kms.wraps != 1 {
+		t.Fatalf("WrapKey called %d times, want 1", kms.wraps)
+	}
+
+	// without key access the encrypted fields are left as they are
+	encrypted := &message.MessageData{}
+	err = NewProtobufDeserializer().Deserialize(data[0], encrypted)
+	if err != nil {
+		t.Fatalf("unexpected error on Deserialize: %s", err.Error())
+	}
+	if encrypted.Nest2.Another != "plain" || encrypted.Nest1.MessageId != 1 {
+		t.Fatalf("unencrypted fields == %v, want them unchanged", encrypted)
+	}
+	for _, value := range []string{encrypted.Nest1.Test, encrypted.Nest2.Id, encrypted.Nest2.AdditionalData["a"], encrypted.Nest2.AdditionalData["b"]} {
+		if _, err := base64.StdEncoding.DecodeString(value); err != nil || value == "" || value == "secret a" {
+			t.Fatalf("encrypted field == %q, want base64 ciphertext", value)
+		}
+	}
+
+	pd, err := NewProtobufDeserializerWithClient(msrc, ProtobufDeserializerConfig{EncryptionKMS: kms, EncryptionFields: fields})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufDeserializerWithClient: %s", err.Error())
+	}
+	for _, d := range data {
+		got := &message.MessageData{}
+		err = pd.Deserialize(d, got)
+		if err != nil {
+			t.Fatalf("unexpected error on Deserialize: %s", err.Error())
+		}
+		if !proto.Equal(got, original) {
+			t.Fatalf("pd.Deserialize(%v) == %v, want %v", d, got, original)
+		}
+		gotMessage, err := pd.DeserializeMessage(d)
+		if err != nil {
+			t.Fatalf("unexpected error on DeserializeMessage: %s", err.Error())
+		}
+		if !proto.Equal(gotMessage, original) {
+			t.Fatalf("pd.DeserializeMessage(%v) == %v, want %v", d, gotMessage, original)
+		}
+	}
+	// the data key is unwrapped once and cached
+	if kms.unwrap != 1 {
+		t.Fatalf("UnwrapKey called %d times, want 1", kms.unwrap)
+	}
+}
+
+func TestProtobufEncryption_SerializeEncryptionTags(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	ctx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+	kms := newTestLocalKMS(t)
+
+	md := taggedCustomerDescriptor(t, msrc)
+	customer := dynamicCustomer(md, "Ann", []byte("P123"), []string{"ann@example.com", "ann@example.org"}, "NL", 7)
+
+	ps, err := NewProtobufSerializer(md, msrc, ProtobufSerializerConfig{
+		EncryptionKMS:   kms,
+		EncryptionKeyID: "k2",
+		EncryptionTags:  []string{"PII"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+	data, err := ps.Serialize(customer, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error on Serialize: %s", err.Error())
+	}
+
+	plain, err := NewProtobufDeserializerWithClient(msrc, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufDeserializerWithClient: %s", err.Error())
+	}
+	encrypted, err := plain.DeserializeDynamic(data)
+	if err != nil {
+		t.Fatalf("unexpected error on DeserializeDynamic: %s", err.Error())
+	}
+	fields := encrypted.Descriptor().Fields()
+	if encrypted.Get(fields.ByName("name")).String() == "Ann" || string(encrypted.Get(fields.ByName("passport")).Bytes()) == "P123" {
+		t.Fatalf("PII fields of %v are not encrypted", encrypted)
+	}
+	if encrypted.Get(fields.ByName("country")).String() != "NL" || encrypted.Get(fields.ByName("id")).Int() != 7 {
+		t.Fatalf("untagged fields of %v are not unchanged", encrypted)
+	}
+
+	pd, err := NewProtobufDeserializerWithClient(msrc, ProtobufDeserializerConfig{EncryptionKMS: kms, EncryptionTags: []string{"PII"}})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufDeserializerWithClient: %s", err.Error())
+	}
+	got, err := pd.DeserializeDynamic(data)
+	if err != nil {
+		t.Fatalf("unexpected error on DeserializeDynamic: %s", err.Error())
+	}
+	// got is built from its own copy of the schema, so it is compared as a message of md
+	gotBytes, err := proto.Marshal(got)
+	if err != nil {
+		t.Fatalf("unexpected error on proto.Marshal: %s", err.Error())
+	}
+	gotCustomer := dynamicpb.NewMessage(md)
+	err = proto.Unmarshal(gotBytes, gotCustomer)
+	if err != nil {
+		t.Fatalf("unexpected error on proto.Unmarshal: %s", err.Error())
+	}
+	if !proto.Equal(gotCustomer, customer) {
+		t.Fatalf("pd.DeserializeDynamic(%v) == %v, want %v", data, got, customer)
+	}
+}
+
+func TestProtobufEncryption_dataKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	kms := &countingKMS{KMS: newTestLocalKMS(t)}
+	fd := taggedCustomerDescriptor(t, newRegistryMockSchemaRegistryClient()).Fields().ByName("name")
+
+	e := newFieldEncryptor(kms, "k1", nil, nil)
+	e.maxUses = 2
+
+	cases := []struct {
+		name      string
+		expire    bool // age the data key past maxAge before encrypting
+		wantWraps int
+	}{
+		{"first use", false, 1},
+		{"second use", false, 1},
+		{"used maxUses times", false, 2},
+		{"second use of the new key", false, 2},
+		{"used maxUses times again", false, 3},
+		{"maxAge old", true, 4},
+	}
+	var encrypted [][]byte
+	for _, c := range cases {
+		if c.expire {
+			e.dataKey.created = time.Now().Add(-e.maxAge)
+		}
+		value, err := e.encryptValue(ctx, fd, []byte(c.name))
+		if err != nil {
+			t.Fatalf("unexpected error on encryptValue: %s", err.Error())
+		}
+		if kms.wraps != c.wantWraps {
+			t.Fatalf("%s: WrapKey called %d times, want %d", c.name, kms.wraps, c.wantWraps)
+		}
+		encrypted = append(encrypted, value)
+	}
+
+	// values encrypted with replaced data keys can still be decrypted
+	d := newFieldEncryptor(kms, "", nil, nil)
+	for i, value := range encrypted {
+		got, err := d.decryptValue(ctx, fd, value)
+		if err != nil {
+			t.Fatalf("unexpected error on decryptValue: %s", err.Error())
+		}
+		if string(got) != cases[i].name {
+			t.Fatalf("d.decryptValue(%q) == %q, want %q", value, got, cases[i].name)
+		}
+	}
+}
+
+func TestProtobufEncryption_Errors(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	ctx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+	kms := newTestLocalKMS(t)
+	msgData := &message.MessageData{Nest2: &message.Nested2{Id: "secret id", AnotherMessage: 5}}
+
+	_, err := serializeEncrypted(msrc, msgData, kms, "k1", []string{"message.Nested2.another_message"})
+	want := "field message.Nested2.another_message is int64, only string and bytes fields can be encrypted"
+	if err == nil || err.Error() != want {
+		t.Fatalf("ps.Serialize(%v) == %v, want %s", msgData, err, want)
+	}
+
+	_, err = serializeEncrypted(msrc, msgData, kms, "k3", []string{"message.Nested2.id"})
+	want = "unable to wrap data key with key k3: unknown key k3"
+	if err == nil || err.Error() != want {
+		t.Fatalf("ps.Serialize(%v) == %v, want %s", msgData, err, want)
+	}
+
+	encrypted, err := serializeEncrypted(msrc, msgData, kms, "k1", []string{"message.Nested2.id"})
+	if err != nil {
+		t.Fatalf("unexpected error on Serialize: %s", err.Error())
+	}
+	plain, err := NewProtobufSerializer(msgData.ProtoReflect().Descriptor(), msrc, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+	notEncrypted, err := plain.Serialize(msgData, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error on Serialize: %s", err.Error())
+	}
+	otherKMS, err := NewLocalKMS(map[string][]byte{"k2": []byte("fedcba9876543210")})
+	if err != nil {
+		t.Fatalf("unexpected error on NewLocalKMS: %s", err.Error())
+	}
+
+	cases := []struct {
+		name string
+		kms  KMS
+		data []byte
+		want error
+	}{
+		{
+			"no access to the key",
+			otherKMS,
+			encrypted,
+			errors.New("unable to decrypt field message.Nested2.id: unknown key k1"),
+		},
+		{
+			"value not encrypted",
+			kms,
+			notEncrypted,
+			errors.New("unable to decrypt field message.Nested2.id: the value was not encrypted by this serializer"),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pd, err := NewProtobufDeserializerWithClient(msrc, ProtobufDeserializerConfig{EncryptionKMS: c.kms, EncryptionFields: []string{"message.Nested2.id"}})
+			if err != nil {
+				t.Fatalf("unexpected error on NewProtobufDeserializerWithClient: %s", err.Error())
+			}
+			err = pd.Deserialize(c.data, &message.MessageData{})
+			if err == nil || err.Error() != c.want.Error() {
+				t.Fatalf("pd.Deserialize(%v) == %v, want %v", c.data, err, c.want)
+			}
+		})
+	}
+}
+
+func serializeEncrypted(msrc srclient.ISchemaRegistryClient, pb proto.Message, kms KMS, keyID string, fields []string) ([]byte, error) {
+	ps, err := NewProtobufSerializer(pb.ProtoReflect().Descriptor(), msrc, ProtobufSerializerConfig{
+		EncryptionKMS:    kms,
+		EncryptionKeyID:  keyID,
+		EncryptionFields: fields,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ps.Serialize(pb, SerializationContext{Topic: "test", Field: MessageFieldValue})
+}
+
+func dynamicCustomer(md protoreflect.MessageDescriptor, name string, passport []byte, emails []string, country string, id int64) proto.Message {
+	msg := dynamicpb.NewMessage(md)
+	fields := md.Fields()
+	msg.Set(fields.ByName("name"), protoreflect.ValueOfString(name))
+	msg.Set(fields.ByName("passport"), protoreflect.ValueOfBytes(passport))
+	list := msg.Mutable(fields.ByName("emails")).List()
+	for _, email := range emails {
+		list.Append(protoreflect.ValueOfString(email))
+	}
+	msg.Set(fields.ByName("country"), protoreflect.ValueOfString(country))
+	msg.Set(fields.ByName("id"), protoreflect.ValueOfInt64(id))
+	return msg.Interface()
+}
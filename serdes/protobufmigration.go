@@ -0,0 +1,379 @@
+package serdes
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/riferrei/srclient"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	// Migrations the transforms between versions of subjects payloads are migrated with, a []Migration. The subject
+	// comes from the SerializationContext, so payloads can only be migrated by the methods that take one
+	Migrations = "migrations"
+	// MigrationTargetVersion the version of the subject payloads are migrated to, LatestMigrationVersion for the latest
+	// version and 0, the default, to read payloads with the schema they were written with
+	MigrationTargetVersion = "migration.target.version"
+)
+
+// LatestMigrationVersion migrates payloads to the latest version of their subject
+const LatestMigrationVersion = -1
+
+// migrationVersionsMaxAge how long the versions of a subject are migrated to its latest version before they are fetched
+// again, to find a version registered since
+const migrationVersionsMaxAge = 5 * time.Minute
+
+// MigrationFunc transforms from, a message of one version of a subject, into to, a message of the next version up or
+// down. to already holds the fields of from with the same name and a compatible type.
+type MigrationFunc func(ctx context.Context, from protoreflect.Message, to protoreflect.Message) error
+
+// Migration transforms payloads from one version of a subject to an adjacent registered version, From is greater than
+// To to downgrade. A nil Transform only copies the fields with the same name. Adjacent versions without a Migration are
+// only migrated between when they are compatible, by reading the payload with the other version.
+type Migration struct {
+	Subject   string
+	From      int
+	To        int
+	Transform MigrationFunc
+}
+
+// RenameField returns a MigrationFunc that copies the field named from into the field named to
+func RenameField(from protoreflect.Name, to protoreflect.Name) MigrationFunc {
+	return func(_ context.Context, fromMsg protoreflect.Message, toMsg protoreflect.Message) error {
+		fromField, toField, err := migrationFields(fromMsg, from, toMsg, to)
+		if err != nil {
+			return err
+		}
+		if !fromMsg.Has(fromField) {
+			return nil
+		}
+		return copyFieldByName(fromMsg, fromField, toMsg, toField)
+	}
+}
+
+// ConvertField returns a MigrationFunc that sets the field named name to convert of its value in the previous version,
+// such as for a change of unit
+func ConvertField(name protoreflect.Name, convert func(protoreflect.Value) (protoreflect.Value, error)) MigrationFunc {
+	return func(_ context.Context, fromMsg protoreflect.Message, toMsg protoreflect.Message) error {
+		fromField, toField, err := migrationFields(fromMsg, name, toMsg, name)
+		if err != nil {
+			return err
+		}
+		value, err := convert(fromMsg.Get(fromField))
+		if err != nil {
+			return fmt.Errorf("unable to convert field %s: %w", fromField.FullName(), err)
+		}
+		toMsg.Set(toField, value)
+		return nil
+	}
+}
+
+// ChainMigrations returns a MigrationFunc that applies each of migrations in turn
+func ChainMigrations(migrations ...MigrationFunc) MigrationFunc {
+	return func(ctx context.Context, fromMsg protoreflect.Message, toMsg protoreflect.Message) error {
+		for _, migration := range migrations {
+			if err := migration(ctx, fromMsg, toMsg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func migrationFields(fromMsg protoreflect.Message, from protoreflect.Name, toMsg protoreflect.Message, to protoreflect.Name) (protoreflect.FieldDescriptor, protoreflect.FieldDescriptor, error) {
+	fromField := fromMsg.Descriptor().Fields().ByName(from)
+	if fromField == nil {
+		return nil, nil, fmt.Errorf("unknown field %s in %s", from, fromMsg.Descriptor().FullName())
+	}
+	toField := toMsg.Descriptor().Fields().ByName(to)
+	if toField == nil {
+		return nil, nil, fmt.Errorf("unknown field %s in %s", to, toMsg.Descriptor().FullName())
+	}
+	return fromField, toField, nil
+}
+
+// migrationKey identifies a Migration
+type migrationKey struct {
+	subject string
+	from    int
+	to      int
+}
+
+// migrationVersions the schemas registered under a subject in version order, and when they were fetched
+type migrationVersions struct {
+	schemas []*srclient.Schema
+	fetched time.Time
+}
+
+// protobufMigrator migrates messages between versions of their subject
+type protobufMigrator struct {
+	client        srclient.ISchemaRegistryClient
+	resolver      *protobufSchemaResolver
+	targetVersion int
+	migrations    map[migrationKey]MigrationFunc
+	versions      map[string]*migrationVersions // map from subject to associated schemas in version order
+	versionsLock  sync.RWMutex
+	maxAge        time.Duration // fetch the versions again once they are this old, when migrating to the latest version
+}
+
+func newProtobufMigrator(resolver *protobufSchemaResolver, targetVersion int, migrations []Migration) (*protobufMigrator, error) {
+	m := &protobufMigrator{
+		client:        resolver.client,
+		resolver:      resolver,
+		targetVersion: targetVersion,
+		migrations:    make(map[migrationKey]MigrationFunc),
+		versions:      make(map[string]*migrationVersions),
+		maxAge:        migrationVersionsMaxAge,
+	}
+	for _, migration := range migrations {
+		if migration.From == migration.To {
+			return nil, fmt.Errorf("migration of subject %s must be between different versions, got %d to %d", migration.Subject, migration.From, migration.To)
+		}
+		key := migrationKey{subject: migration.Subject, from: migration.From, to: migration.To}
+		if _, ok := m.migrations[key]; ok {
+			return nil, fmt.Errorf("duplicate migration from version %d to version %d of subject %s", migration.From, migration.To, migration.Subject)
+		}
+		transform := migration.Transform
+		if transform == nil {
+			transform = func(context.Context, protoreflect.Message, protoreflect.Message) error { return nil }
+		}
+		m.migrations[key] = transform
+	}
+	return m, nil
+}
+
+// migrate returns msg, written with schemaID, migrated one version at a time to the target version of subject, along
+// with the schema ID of the target version
+func (m *protobufMigrator) migrate(ctx context.Context, subject string, schemaID int, msg *dynamicpb.Message) (*dynamicpb.Message, int, error) {
+	versions, writer, target, err := m.findVersions(ctx, subject, schemaID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	step := 1
+	if target < writer {
+		step = -1
+	}
+	for i := writer; i != target; i += step {
+		from, to := versions[i], versions[i+step]
+		fd, err := m.resolver.fileDescriptorForSchema(ctx, to)
+		if err != nil {
+			return nil, 0, err
+		}
+		md := findMessageDescriptor(fd, msg.Descriptor().FullName())
+		if md == nil {
+			return nil, 0, fmt.Errorf("message %s not found in version %d of subject %s", msg.Descriptor().FullName(), to.Version(), subject)
+		}
+
+		migrated := dynamicpb.NewMessage(md)
+		transform, ok := m.migrations[migrationKey{subject: subject, from: from.Version(), to: to.Version()}]
+		if !ok {
+			// versions that can read each other's payloads need no migration, the payload is read as it would be without one
+			err = checkMessageCompatibility(md, msg.Descriptor())
+			if err != nil {
+				return nil, 0, fmt.Errorf("no migration from version %d to version %d of subject %s, and they are not compatible: %w", from.Version(), to.Version(), subject, err)
+			}
+			err = remarshal(msg, migrated)
+			if err != nil {
+				return nil, 0, fmt.Errorf("unable to migrate %s from version %d to version %d of subject %s: %w", md.FullName(), from.Version(), to.Version(), subject, err)
+			}
+			msg = migrated
+			continue
+		}
+
+		err = copyMessageByName(msg, migrated)
+		if err != nil {
+			return nil, 0, err
+		}
+		err = transform(ctx, msg, migrated)
+		if err != nil {
+			return nil, 0, fmt.Errorf("unable to migrate %s from version %d to version %d of subject %s: %w", md.FullName(), from.Version(), to.Version(), subject, err)
+		}
+		msg = migrated
+	}
+	return msg, versions[target].ID(), nil
+}
+
+// findVersions returns the schemas registered under subject in version order, along with the positions in them of
+// the writer schema and the target version. The versions are fetched again when either is not known yet, as they may
+// have been registered since the versions were last fetched, and once they are maxAge old when migrating to the latest
+// version, as it may have been replaced by a version no payload was written with yet.
+func (m *protobufMigrator) findVersions(ctx context.Context, subject string, schemaID int) ([]*srclient.Schema, int, int, error) {
+	m.versionsLock.RLock()
+	cached, ok := m.versions[subject]
+	m.versionsLock.RUnlock()
+	if ok && (m.targetVersion != LatestMigrationVersion || time.Since(cached.fetched) < m.maxAge) {
+		writer, target, err := m.locateVersions(subject, cached.schemas, schemaID)
+		if err == nil {
+			return cached.schemas, writer, target, nil
+		}
+	}
+
+	versions, err := m.fetchVersions(ctx, subject)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	writer, target, err := m.locateVersions(subject, versions, schemaID)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return versions, writer, target, nil
+}
+
+// locateVersions returns the positions in versions of the writer schema and the target version
+func (m *protobufMigrator) locateVersions(subject string, versions []*srclient.Schema, schemaID int) (int, int, error) {
+	writer, target := -1, -1
+	if m.targetVersion == LatestMigrationVersion {
+		target = len(versions) - 1
+	}
+	for i, theSchema := range versions {
+		if theSchema.ID() == schemaID {
+			writer = i
+		}
+		if theSchema.Version() == m.targetVersion {
+			target = i
+		}
+	}
+	if writer < 0 {
+		return 0, 0, fmt.Errorf("schema ID %d is not registered under subject %s", schemaID, subject)
+	}
+	if target < 0 {
+		return 0, 0, fmt.Errorf("version %d of subject %s not found", m.targetVersion, subject)
+	}
+	return writer, target, nil
+}
+
+// fetchVersions fetches the schemas registered under subject from Schema Registry
+func (m *protobufMigrator) fetchVersions(ctx context.Context, subject string) ([]*srclient.Schema, error) {
+	versionNumbers, err := callRegistry(ctx, func() ([]int, error) {
+		return m.client.GetSchemaVersions(subject)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to find the versions of subject %s: %w", subject, err)
+	}
+	if len(versionNumbers) == 0 {
+		return nil, fmt.Errorf("subject %s has no versions", subject)
+	}
+
+	var versions []*srclient.Schema
+	for _, version := range versionNumbers {
+		theSchema, err := callRegistry(ctx, func() (*srclient.Schema, error) {
+			return m.client.GetSchemaByVersion(subject, version)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to find version %d of subject %s: %w", version, subject, err)
+		}
+		versions = append(versions, theSchema)
+	}
+
+	m.versionsLock.Lock()
+	m.versions[subject] = &migrationVersions{schemas: versions, fetched: time.Now()}
+	m.versionsLock.Unlock()
+
+	return versions, nil
+}
+
+// remarshal reads the wire encoding of from into to, which is a message of a compatible version of the same schema
+func remarshal(from protoreflect.Message, to protoreflect.Message) error {
+	data, err := proto.Marshal(from.Interface())
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(data, to.Interface())
+}
+
+// copyMessageByName copies the fields of from into to, which is a message of another version of the same schema.
+// Fields are matched by name and only copied when their types are compatible, as fields that changed type are left
+// for the MigrationFunc.
+func copyMessageByName(from protoreflect.Message, to protoreflect.Message) error {
+	var err error
+	from.Range(func(fromField protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		toField := to.Descriptor().Fields().ByName(fromField.Name())
+		if toField == nil || !compatibleFields(fromField, toField) {
+			return true
+		}
+		err = copyFieldByName(from, fromField, to, toField)
+		return err == nil
+	})
+	return err
+}
+
+// compatibleFields reports whether values of fromField can be copied to toField
+func compatibleFields(fromField protoreflect.FieldDescriptor, toField protoreflect.FieldDescriptor) bool {
+	if fromField.IsList() != toField.IsList() || fromField.IsMap() != toField.IsMap() {
+		return false
+	}
+	if fromField.IsMap() {
+		return fromField.MapKey().Kind() == toField.MapKey().Kind() && compatibleFields(fromField.MapValue(), toField.MapValue())
+	}
+	return fromField.Kind() == toField.Kind() || (fromField.Message() != nil && toField.Message() != nil)
+}
+
+// copyFieldByName copies the value of fromField in from to toField in to, converting messages and enums between versions
+func copyFieldByName(from protoreflect.Message, fromField protoreflect.FieldDescriptor, to protoreflect.Message, toField protoreflect.FieldDescriptor) error {
+	if !compatibleFields(fromField, toField) {
+		return fmt.Errorf("field %s cannot be copied to %s, their types differ", fromField.FullName(), toField.FullName())
+	}
+
+	switch {
+	case fromField.IsList():
+		fromList, toList := from.Get(fromField).List(), to.Mutable(toField).List()
+		for i := 0; i < fromList.Len(); i++ {
+			value, err := convertValueByName(fromList.Get(i), fromField, toField, toList.NewElement)
+			if err != nil {
+				return err
+			}
+			toList.Append(value)
+		}
+	case fromField.IsMap():
+		fromMap, toMap := from.Get(fromField).Map(), to.Mutable(toField).Map()
+		var err error
+		fromMap.Range(func(key protoreflect.MapKey, fromValue protoreflect.Value) bool {
+			var value protoreflect.Value
+			value, err = convertValueByName(fromValue, fromField.MapValue(), toField.MapValue(), toMap.NewValue)
+			if err != nil {
+				return false
+			}
+			toMap.Set(key, value)
+			return true
+		})
+		return err
+	default:
+		value, err := convertValueByName(from.Get(fromField), fromField, toField, func() protoreflect.Value {
+			return to.NewField(toField)
+		})
+		if err != nil {
+			return err
+		}
+		to.Set(toField, value)
+	}
+	return nil
+}
+
+// convertValueByName converts a single value of fromField into a value of toField, newValue returns an empty message
+// for toField
+func convertValueByName(value protoreflect.Value, fromField protoreflect.FieldDescriptor, toField protoreflect.FieldDescriptor, newValue func() protoreflect.Value) (protoreflect.Value, error) {
+	switch {
+	case fromField.Message() != nil:
+		converted := newValue()
+		err := copyMessageByName(value.Message(), converted.Message())
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return converted, nil
+	case fromField.Enum() != nil:
+		// enum values are matched by name, falling back to the number for values without a name
+		if fromValue := fromField.Enum().Values().ByNumber(value.Enum()); fromValue != nil {
+			if toValue := toField.Enum().Values().ByName(fromValue.Name()); toValue != nil {
+				return protoreflect.ValueOfEnum(toValue.Number()), nil
+			}
+		}
+		return value, nil
+	}
+	return value, nil
+}
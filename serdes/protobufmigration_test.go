@@ -0,0 +1,630 @@
+package serdes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/riferrei/srclient"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testMigrationSubject = "payments-value"
+
+var testMigrationSchemas = []string{
+	`syntax = "proto3";
+package test.migration;
+
+enum Status {
+  STATUS_UNKNOWN = 0;
+  OPEN = 1;
+  CLOSED = 2;
+}
+
+message Address {
+  string street = 1;
+}
+
+message Payment {
+  string id = 1;
+  int64 amount_cents = 2;
+  string full_name = 3;
+  Status status = 4;
+  repeated Address addresses = 5;
+  map<string, string> labels = 6;
+}
+`,
+	`syntax = "proto3";
+package test.migration;
+
+enum Status {
+  STATUS_UNKNOWN = 0;
+  OPEN = 1;
+  PENDING = 2;
+  CLOSED = 3;
+}
+
+message Address {
+  string street = 1;
+  string city = 2;
+}
+
+message Payment {
+  string id = 1;
+  double amount = 2;
+  string first_name = 3;
+  string last_name = 4;
+  Status status = 5;
+  repeated Address addresses = 6;
+  map<string, string> labels = 7;
+}
+`,
+	`syntax = "proto3";
+package test.migration;
+
+enum Status {
+  STATUS_UNKNOWN = 0;
+  OPEN = 1;
+  PENDING = 2;
+  CLOSED = 3;
+}
+
+message Address {
+  string street = 1;
+  string city = 2;
+}
+
+message Payment {
+  string payment_id = 1;
+  double amount = 2;
+  string first_name = 3;
+  string last_name = 4;
+  Status status = 5;
+  repeated Address addresses = 6;
+  map<string, string> labels = 7;
+}
+`,
+}
+
+// testMigrations upgrade and downgrade payments between the versions of testMigrationSchemas
+var testMigrations = []Migration{
+	{Subject: testMigrationSubject, From: 1, To: 2, Transform: func(_ context.Context, from protoreflect.Message, to protoreflect.Message) error {
+		cents := from.Get(from.Descriptor().Fields().ByName("amount_cents")).Int()
+		to.Set(to.Descriptor().Fields().ByName("amount"), protoreflect.ValueOfFloat64(float64(cents)/100))
+		names := strings.SplitN(from.Get(from.Descriptor().Fields().ByName("full_name")).String(), " ", 2)
+		to.Set(to.Descriptor().Fields().ByName("first_name"), protoreflect.ValueOfString(names[0]))
+		if len(names) > 1 {
+			to.Set(to.Descriptor().Fields().ByName("last_name"), protoreflect.ValueOfString(names[1]))
+		}
+		return nil
+	}},
+	{Subject: testMigrationSubject, From: 2, To: 1, Transform: func(_ context.Context, from protoreflect.Message, to protoreflect.Message) error {
+		amount := from.Get(from.Descriptor().Fields().ByName("amount")).Float()
+		to.Set(to.Descriptor().Fields().ByName("amount_cents"), protoreflect.ValueOfInt64(int64(amount*100)))
+		fullName := from.Get(from.Descriptor().Fields().ByName("first_name")).String() + " " + from.Get(from.Descriptor().Fields().ByName("last_name")).String()
+		to.Set(to.Descriptor().Fields().ByName("full_name"), protoreflect.ValueOfString(fullName))
+		return nil
+	}},
+	{Subject: testMigrationSubject, From: 2, To: 3, Transform: RenameField("id", "payment_id")},
+	{Subject: testMigrationSubject, From: 3, To: 2, Transform: RenameField("payment_id", "id")},
+}
+
+// registerTestMigrationSchemas registers the first versions of testMigrationSchemas and returns their Payment messages
+func registerTestMigrationSchemas(t *testing.T, msrc *registryMockSchemaRegistryClient, versions int) []protoreflect.MessageDescriptor {
+	resolver := newProtobufSchemaResolver(msrc)
+	var mds []protoreflect.MessageDescriptor
+	for _, schema := range testMigrationSchemas[:versions] {
+		theSchema, err := msrc.CreateSchema(testMigrationSubject, schema, srclient.Protobuf)
+		if err != nil {
+			t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+		}
+		fd, err := resolver.fileDescriptorByID(context.Background(), theSchema.ID())
+		if err != nil {
+			t.Fatalf("unexpected error on fileDescriptorByID: %s", err.Error())
+		}
+		mds = append(mds, fd.Messages().ByName("Payment"))
+	}
+	return mds
+}
+
+// newTestMigrationPayment returns a Payment of md with values set in the fields it has
+func newTestMigrationPayment(md protoreflect.MessageDescriptor) *dynamicpb.Message {
+	payment := dynamicpb.NewMessage(md)
+	fields := md.Fields()
+	values := map[protoreflect.Name]protoreflect.Value{
+		"id":           protoreflect.ValueOfString("p1"),
+		"payment_id":   protoreflect.ValueOfString("p1"),
+		"amount_cents": protoreflect.ValueOfInt64(1250),
+		"amount":       protoreflect.ValueOfFloat64(12.5),
+		"full_name":    protoreflect.ValueOfString("Ann Smith"),
+		"first_name":   protoreflect.ValueOfString("Ann"),
+		"last_name":    protoreflect.ValueOfString("Smith"),
+	}
+	for name, value := range values {
+		if fd := fields.ByName(name); fd != nil {
+			payment.Set(fd, value)
+		}
+	}
+	status := fields.ByName("status")
+	payment.Set(status, protoreflect.ValueOfEnum(status.Enum().Values().ByName("CLOSED").Number()))
+	addresses := payment.Mutable(fields.ByName("addresses")).List()
+	address := addresses.NewElement()
+	address.Message().Set(address.Message().Descriptor().Fields().ByName("street"), protoreflect.ValueOfString("Main Street"))
+	addresses.Append(address)
+	payment.Mutable(fields.ByName("labels")).Map().Set(protoreflect.ValueOfString("channel").MapKey(), protoreflect.ValueOfString("web"))
+	return payment
+}
+
+func serializeTestMigrationPayment(t *testing.T, msrc *registryMockSchemaRegistryClient, md protoreflect.MessageDescriptor, version int) []byte {
+	ps, err := NewProtobufSerializer(md, msrc, ProtobufSerializerConfig{
+		AutoRegisterSchemas: false,
+		UseSchemaID:         msrc.subjects[testMigrationSubject][version-1].ID(),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+	data, err := ps.Serialize(newTestMigrationPayment(md), SerializationContext{Topic: "payments", Field: MessageFieldValue})
+	if err != nil {
+		t.Fatalf("unexpected error on Serialize: %s", err.Error())
+	}
+	return data
+}
+
+func TestProtobufMigration_DeserializeDynamic(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	ctx := SerializationContext{Topic: "payments", Field: MessageFieldValue}
+	mds := registerTestMigrationSchemas(t, msrc, 3)
+	var data [][]byte
+	for i, md := range mds {
+		data = append(data, serializeTestMigrationPayment(t, msrc, md, i+1))
+	}
+
+	cases := []struct {
+		name   string
+		target int
+		want   protoreflect.MessageDescriptor
+	}{
+		{"latest", LatestMigrationVersion, mds[2]},
+		{"version 1", 1, mds[0]},
+		{"version 2", 2, mds[1]},
+		{"version 3", 3, mds[2]},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pd, err := NewProtobufDeserializerWithClient(msrc, ProtobufDeserializerConfig{
+				Migrations:             testMigrations,
+				MigrationTargetVersion: c.target,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error on NewProtobufDeserializerWithClient: %s", err.Error())
+			}
+			// payloads of every version, older and newer, read as the target version
+			for i, d := range data {
+				got, err := pd.DeserializeDynamicWithHeaders(nil, d, ctx)
+				if err != nil {
+					t.Fatalf("unexpected error on DeserializeDynamicWithHeaders of version %d: %s", i+1, err.Error())
+				}
+				assertMigratedPayment(t, got, newTestMigrationPayment(c.want))
+
+				pb := dynamicpb.NewMessage(c.want)
+				err = pd.DeserializeWithHeaders(nil, d, ctx, pb)
+				if err != nil {
+					t.Fatalf("unexpected error on DeserializeWithHeaders of version %d: %s", i+1, err.Error())
+				}
+				assertMigratedPayment(t, pb, newTestMigrationPayment(c.want))
+			}
+		})
+	}
+}
+
+// assertMigratedPayment compares got, which may be a message of another copy of the descriptor, to want
+func assertMigratedPayment(t *testing.T, got proto.Message, want *dynamicpb.Message) {
+	gotBytes, err := proto.Marshal(got)
+	if err != nil {
+		t.Fatalf("unexpected error on proto.Marshal: %s", err.Error())
+	}
+	gotPayment := dynamicpb.NewMessage(want.Descriptor())
+	err = proto.Unmarshal(gotBytes, gotPayment)
+	if err != nil {
+		t.Fatalf("unexpected error on proto.Unmarshal: %s", err.Error())
+	}
+	if !proto.Equal(gotPayment, want) {
+		t.Fatalf("migrated payment == %v, want %v", got, want)
+	}
+}
+
+func TestProtobufMigration_DeserializeWithoutContext(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	mds := registerTestMigrationSchemas(t, msrc, 3)
+	v1 := serializeTestMigrationPayment(t, msrc, mds[0], 1)
+	want := "payloads can only be migrated with a SerializationContext to find their subject, use the WithHeaders methods or DeserializeValue"
+
+	// DeserializeValue finds the message type of the migrated payload by name
+	types := new(protoregistry.Types)
+	err := types.RegisterMessage(dynamicpb.NewMessageType(mds[2]))
+	if err != nil {
+		t.Fatalf("unexpected error on RegisterMessage: %s", err.Error())
+	}
+	pd, err := NewProtobufDeserializerWithClient(msrc, ProtobufDeserializerConfig{
+		Migrations:              testMigrations,
+		MigrationTargetVersion:  LatestMigrationVersion,
+		MessageTypeResolverImpl: types,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufDeserializerWithClient: %s", err.Error())
+	}
+
+	cases := []struct {
+		name        string
+		deserialize func() error
+	}{
+		{"Deserialize", func() error {
+			return pd.Deserialize(v1, dynamicpb.NewMessage(mds[2]))
+		}},
+		{"DeserializeContext", func() error {
+			return pd.DeserializeContext(context.Background(), v1, dynamicpb.NewMessage(mds[2]))
+		}},
+		{"DeserializeDynamic", func() error {
+			_, err := pd.DeserializeDynamic(v1)
+			return err
+		}},
+		{"DeserializeDynamicContext", func() error {
+			_, err := pd.DeserializeDynamicContext(context.Background(), v1)
+			return err
+		}},
+		{"DeserializeMessage", func() error {
+			_, err := pd.DeserializeMessage(v1)
+			return err
+		}},
+		{"DeserializeMessageContext", func() error {
+			_, err := pd.DeserializeMessageContext(context.Background(), v1)
+			return err
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.deserialize()
+			if err == nil || err.Error() != want {
+				t.Fatalf("pd.%s(%v) == %v, want %s", c.name, v1, err, want)
+			}
+		})
+	}
+	if calls := msrc.callCount("GetSchemaVersions"); calls != 0 {
+		t.Fatalf("GetSchemaVersions called %d times, want 0", calls)
+	}
+
+	// DeserializeValue takes a SerializationContext
+	got, err := pd.DeserializeValue(context.Background(), v1, SerializationContext{Topic: "payments", Field: MessageFieldValue})
+	if err != nil {
+		t.Fatalf("unexpected error on DeserializeValue: %s", err.Error())
+	}
+	assertMigratedPayment(t, got.(proto.Message), newTestMigrationPayment(mds[2]))
+}
+
+func TestProtobufMigration_DeserializeNewVersion(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	ctx := SerializationContext{Topic: "payments", Field: MessageFieldValue}
+	mds := registerTestMigrationSchemas(t, msrc, 2)
+	v1 := serializeTestMigrationPayment(t, msrc, mds[0], 1)
+
+	pd, err := NewProtobufDeserializerWithClient(msrc, ProtobufDeserializerConfig{
+		Migrations:             testMigrations,
+		MigrationTargetVersion: 1,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufDeserializerWithClient: %s", err.Error())
+	}
+	for i := 0; i < 2; i++ {
+		_, err = pd.DeserializeDynamicWithHeaders(nil, v1, ctx)
+		if err != nil {
+			t.Fatalf("unexpected error on DeserializeDynamicWithHeaders: %s", err.Error())
+		}
+	}
+	if msrc.callCount("GetSchemaVersions") != 1 {
+		t.Fatalf("GetSchemaVersions called %d times, want 1", msrc.callCount("GetSchemaVersions"))
+	}
+
+	// a payload of a version registered since the versions were fetched
+	mds = append(mds, registerTestMigrationSchemas(t, msrc, 3)[2])
+	v3 := serializeTestMigrationPayment(t, msrc, mds[2], 3)
+	got, err := pd.DeserializeDynamicWithHeaders(nil, v3, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error on DeserializeDynamicWithHeaders: %s", err.Error())
+	}
+	assertMigratedPayment(t, got, newTestMigrationPayment(mds[0]))
+	if msrc.callCount("GetSchemaVersions") != 2 {
+		t.Fatalf("GetSchemaVersions called %d times, want 2", msrc.callCount("GetSchemaVersions"))
+	}
+}
+
+func TestProtobufMigration_DeserializeMissingCompatibleMigration(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	ctx := SerializationContext{Topic: "payments", Field: MessageFieldValue}
+	mds := registerTestMigrationSchemas(t, msrc, 3)
+	v1 := serializeTestMigrationPayment(t, msrc, mds[0], 1)
+	v3 := serializeTestMigrationPayment(t, msrc, mds[2], 3)
+
+	// versions 2 and 3 only differ by the name of field 1, so no migration is needed between them
+	pd, err := NewProtobufDeserializerWithClient(msrc, ProtobufDeserializerConfig{
+		Migrations:             testMigrations[:2],
+		MigrationTargetVersion: 3,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufDeserializerWithClient: %s", err.Error())
+	}
+	got, err := pd.DeserializeDynamicWithHeaders(nil, v1, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error on DeserializeDynamicWithHeaders: %s", err.Error())
+	}
+	assertMigratedPayment(t, got, newTestMigrationPayment(mds[2]))
+
+	pd, err = NewProtobufDeserializerWithClient(msrc, ProtobufDeserializerConfig{
+		Migrations:             testMigrations[:2],
+		MigrationTargetVersion: 1,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufDeserializerWithClient: %s", err.Error())
+	}
+	got, err = pd.DeserializeDynamicWithHeaders(nil, v3, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error on DeserializeDynamicWithHeaders: %s", err.Error())
+	}
+	assertMigratedPayment(t, got, newTestMigrationPayment(mds[0]))
+}
+
+func TestProtobufMigration_DeserializeNewLatestVersion(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	ctx := SerializationContext{Topic: "payments", Field: MessageFieldValue}
+	mds := registerTestMigrationSchemas(t, msrc, 2)
+	v1 := serializeTestMigrationPayment(t, msrc, mds[0], 1)
+
+	pd, err := NewProtobufDeserializerWithClient(msrc, ProtobufDeserializerConfig{
+		Migrations:             testMigrations,
+		MigrationTargetVersion: LatestMigrationVersion,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufDeserializerWithClient: %s", err.Error())
+	}
+	got, err := pd.DeserializeDynamicWithHeaders(nil, v1, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error on DeserializeDynamicWithHeaders: %s", err.Error())
+	}
+	assertMigratedPayment(t, got, newTestMigrationPayment(mds[1]))
+
+	// a new latest version registered mid-stream, while payloads are still written with version 1
+	mds = append(mds, registerTestMigrationSchemas(t, msrc, 3)[2])
+	cases := []struct {
+		name      string
+		expire    bool // age the versions past maxAge before deserializing
+		want      protoreflect.MessageDescriptor
+		wantCalls int
+	}{
+		{"versions fresh", false, mds[1], 1},
+		{"versions maxAge old", true, mds[2], 2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if c.expire {
+				pd.migrator.versions[testMigrationSubject].fetched = time.Now().Add(-pd.migrator.maxAge)
+			}
+			got, err := pd.DeserializeDynamicWithHeaders(nil, v1, ctx)
+			if err != nil {
+				t.Fatalf("unexpected error on DeserializeDynamicWithHeaders: %s", err.Error())
+			}
+			assertMigratedPayment(t, got, newTestMigrationPayment(c.want))
+			if calls := msrc.callCount("GetSchemaVersions"); calls != c.wantCalls {
+				t.Fatalf("GetSchemaVersions called %d times, want %d", calls, c.wantCalls)
+			}
+		})
+	}
+}
+
+func TestProtobufMigration_DeserializeErrors(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	ctx := SerializationContext{Topic: "payments", Field: MessageFieldValue}
+	mds := registerTestMigrationSchemas(t, msrc, 3)
+	v1 := serializeTestMigrationPayment(t, msrc, mds[0], 1)
+	failing := errors.New("bad amount")
+
+	cases := []struct {
+		name       string
+		migrations []Migration
+		target     int
+		ctx        SerializationContext
+		want       error
+	}{
+		{
+			"missing migration between incompatible versions",
+			testMigrations[2:],
+			3,
+			ctx,
+			fmt.Errorf("no migration from version 1 to version 2 of subject %s, and they are not compatible: field 2 of test.migration.Payment changed type from int64 to double", testMigrationSubject),
+		},
+		{
+			"unknown target version",
+			testMigrations,
+			4,
+			ctx,
+			fmt.Errorf("version 4 of subject %s not found", testMigrationSubject),
+		},
+		{
+			"writer schema not under the subject",
+			testMigrations,
+			2,
+			SerializationContext{Topic: "other", Field: MessageFieldValue},
+			errors.New("subject other-value has no versions"),
+		},
+		{
+			"failing migration",
+			[]Migration{{Subject: testMigrationSubject, From: 1, To: 2, Transform: func(context.Context, protoreflect.Message, protoreflect.Message) error {
+				return failing
+			}}},
+			2,
+			ctx,
+			fmt.Errorf("unable to migrate test.migration.Payment from version 1 to version 2 of subject %s: %w", testMigrationSubject, failing),
+		},
+		{
+			"field renamed to a field of another type",
+			[]Migration{{Subject: testMigrationSubject, From: 1, To: 2, Transform: RenameField("amount_cents", "first_name")}},
+			2,
+			ctx,
+			fmt.Errorf("unable to migrate test.migration.Payment from version 1 to version 2 of subject %s: field test.migration.Payment.amount_cents cannot be copied to test.migration.Payment.first_name, their types differ", testMigrationSubject),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pd, err := NewProtobufDeserializerWithClient(msrc, ProtobufDeserializerConfig{
+				Migrations:             c.migrations,
+				MigrationTargetVersion: c.target,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error on NewProtobufDeserializerWithClient: %s", err.Error())
+			}
+			_, err = pd.DeserializeDynamicWithHeaders(nil, v1, c.ctx)
+			if err == nil || err.Error() != c.want.Error() {
+				t.Fatalf("pd.DeserializeDynamicWithHeaders(%v) == %v, want %v", v1, err, c.want)
+			}
+		})
+	}
+}
+
+func TestProtobufMigration_NewProtobufDeserializerWithClientErrors(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+
+	cases := []struct {
+		name   string
+		config ProtobufDeserializerConfig
+		want   error
+	}{
+		{
+			fmt.Sprintf("wrong type for %s", Migrations),
+			ProtobufDeserializerConfig{Migrations: testMigrations[0]},
+			fmt.Errorf("%s must be a []Migration", Migrations),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", MigrationTargetVersion),
+			ProtobufDeserializerConfig{MigrationTargetVersion: "1"},
+			fmt.Errorf("%s must be an integer value", MigrationTargetVersion),
+		},
+		{
+			fmt.Sprintf("invalid %s", MigrationTargetVersion),
+			ProtobufDeserializerConfig{MigrationTargetVersion: -2},
+			fmt.Errorf("%s must be a version, or LatestMigrationVersion", MigrationTargetVersion),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", SubjectNameStrategyImpl),
+			ProtobufDeserializerConfig{SubjectNameStrategyImpl: "topic"},
+			fmt.Errorf("%s must be a SubjectNameStrategy", SubjectNameStrategyImpl),
+		},
+		{
+			fmt.Sprintf("%s without %s", Migrations, MigrationTargetVersion),
+			ProtobufDeserializerConfig{Migrations: testMigrations},
+			fmt.Errorf("%s must be set to apply %s", MigrationTargetVersion, Migrations),
+		},
+		{
+			"migration to the same version",
+			ProtobufDeserializerConfig{Migrations: []Migration{{Subject: "s", From: 1, To: 1}}, MigrationTargetVersion: 1},
+			fmt.Errorf("migration of subject s must be between different versions, got 1 to 1"),
+		},
+		{
+			"duplicate migration",
+			ProtobufDeserializerConfig{Migrations: []Migration{{Subject: "s", From: 1, To: 2}, {Subject: "s", From: 1, To: 2}}, MigrationTargetVersion: 1},
+			fmt.Errorf("duplicate migration from version 1 to version 2 of subject s"),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := NewProtobufDeserializerWithClient(msrc, c.config)
+			if err == nil || err.Error() != c.want.Error() {
+				t.Fatalf("NewProtobufDeserializerWithClient(%v) == %v, want %v", c.config, err, c.want)
+			}
+		})
+	}
+}
+
+func TestProtobufMigration_copyMessageByName(t *testing.T) {
+	mds := registerTestMigrationSchemas(t, newRegistryMockSchemaRegistryClient(), 2)
+	from := newTestMigrationPayment(mds[0])
+	to := dynamicpb.NewMessage(mds[1])
+
+	err := copyMessageByName(from, to)
+	if err != nil {
+		t.Fatalf("unexpected error on copyMessageByName: %s", err.Error())
+	}
+	fields := to.Descriptor().Fields()
+	// CLOSED is 2 in version 1 and 3 in version 2
+	if status := to.Get(fields.ByName("status")).Enum(); status != 3 {
+		t.Fatalf("status == %d, want 3", status)
+	}
+	if id := to.Get(fields.ByName("id")).String(); id != "p1" {
+		t.Fatalf("id == %s, want p1", id)
+	}
+	addresses := to.Get(fields.ByName("addresses")).List()
+	street := addresses.Get(0).Message().Get(fields.ByName("addresses").Message().Fields().ByName("street")).String()
+	if addresses.Len() != 1 || street != "Main Street" {
+		t.Fatalf("addresses == %v, want a single address on Main Street", addresses)
+	}
+	channel := to.Get(fields.ByName("labels")).Map().Get(protoreflect.ValueOfString("channel").MapKey())
+	if channel.String() != "web" {
+		t.Fatalf("labels[channel] == %v, want web", channel)
+	}
+	// fields that changed type or name are left for the MigrationFunc
+	for _, name := range []protoreflect.Name{"amount", "first_name", "last_name"} {
+		if to.Has(fields.ByName(name)) {
+			t.Fatalf("field %s is set, want it left unset", name)
+		}
+	}
+}
+
+func TestProtobufMigration_DeserializeRules(t *testing.T) {
+	msrc := newRuleMockSchemaRegistryClient()
+	ctx := SerializationContext{Topic: "payments", Field: MessageFieldValue}
+	mds := registerTestMigrationSchemas(t, msrc.registryMockSchemaRegistryClient, 3)
+	v1 := serializeTestMigrationPayment(t, msrc.registryMockSchemaRegistryClient, mds[0], 1)
+	v1ID, v3ID := msrc.subjects[testMigrationSubject][0].ID(), msrc.subjects[testMigrationSubject][2].ID()
+	msrc.rules[v1ID] = []Rule{{Name: "cents", Expr: "amount_cents > 0"}}
+	msrc.rules[v3ID] = []Rule{{Name: "payment id", Expr: "payment_id != 'p1'", Action: RuleActionDLQ}}
+
+	cases := []struct {
+		name     string
+		target   int
+		wantRule string // empty when the payload keeps to the rules
+		wantID   int
+	}{
+		{"rules of the target version", 3, "payment id", v3ID},
+		{"rules of the writer version when it is the target", 1, "", 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dlq := &testDLQ{}
+			pd, err := NewProtobufDeserializerWithClient(msrc, ProtobufDeserializerConfig{
+				Migrations:             testMigrations,
+				MigrationTargetVersion: c.target,
+				UseRegistryRules:       true,
+				RuleDLQHandlerImpl:     dlq,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error on NewProtobufDeserializerWithClient: %s", err.Error())
+			}
+			_, err = pd.DeserializeDynamicWithHeaders(nil, v1, ctx)
+			if c.wantRule == "" {
+				if err != nil {
+					t.Fatalf("unexpected error on DeserializeDynamicWithHeaders: %s", err.Error())
+				}
+				return
+			}
+			var violation *RuleViolation
+			if !errors.As(err, &violation) || violation.Rule.Name != c.wantRule {
+				t.Fatalf("pd.DeserializeDynamicWithHeaders(%v) == %v, want a violation of rule %s", v1, err, c.wantRule)
+			}
+			if len(dlq.violations) != 1 || dlq.violations[0].SchemaID != c.wantID {
+				t.Fatalf("DLQ received %+v, want a violation of schema ID %d", dlq.violations, c.wantID)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package serdes
+
+import (
+	"encoding/base64"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// normalizedFileDescriptorToString converts fd to a canonical file descriptor proto, then marshals it deterministically
+// and base64 encodes it for Schema Registry, so equivalent schemas built in different ways give the same string
+func normalizedFileDescriptorToString(fd protoreflect.FileDescriptor) (string, error) {
+	fileDescProto := protodesc.ToFileDescriptorProto(fd)
+	normalizeFileDescriptorProto(fileDescProto)
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(fileDescProto)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// normalizeFileDescriptorProto canonicalizes fdp in place: source info is stripped, imports and options are sorted
+// and every field has its json_name set
+func normalizeFileDescriptorProto(fdp *descriptorpb.FileDescriptorProto) {
+	fdp.SourceCodeInfo = nil
+	sortDependencies(fdp)
+	normalizeOptions(fdp.GetOptions())
+	for _, md := range fdp.GetMessageType() {
+		normalizeDescriptorProto(md)
+	}
+	for _, ed := range fdp.GetEnumType() {
+		normalizeEnumDescriptorProto(ed)
+	}
+	for _, field := range fdp.GetExtension() {
+		normalizeFieldDescriptorProto(field)
+	}
+	for _, sd := range fdp.GetService() {
+		normalizeOptions(sd.GetOptions())
+		for _, method := range sd.GetMethod() {
+			normalizeOptions(method.GetOptions())
+		}
+	}
+}
+
+// sortDependencies sorts the imports of fdp, keeping the public and weak import indexes pointing at the same files
+func sortDependencies(fdp *descriptorpb.FileDescriptorProto) {
+	deps := fdp.GetDependency()
+	public := make(map[string]bool)
+	for _, index := range fdp.GetPublicDependency() {
+		public[deps[index]] = true
+	}
+	weak := make(map[string]bool)
+	for _, index := range fdp.GetWeakDependency() {
+		weak[deps[index]] = true
+	}
+
+	sort.Strings(deps)
+
+	fdp.PublicDependency = nil
+	fdp.WeakDependency = nil
+	for i, dep := range deps {
+		if public[dep] {
+			fdp.PublicDependency = append(fdp.PublicDependency, int32(i))
+		}
+		if weak[dep] {
+			fdp.WeakDependency = append(fdp.WeakDependency, int32(i))
+		}
+	}
+}
+
+func normalizeDescriptorProto(md *descriptorpb.DescriptorProto) {
+	normalizeOptions(md.GetOptions())
+	for _, field := range md.GetField() {
+		normalizeFieldDescriptorProto(field)
+	}
+	for _, field := range md.GetExtension() {
+		normalizeFieldDescriptorProto(field)
+	}
+	for _, oneof := range md.GetOneofDecl() {
+		normalizeOptions(oneof.GetOptions())
+	}
+	for _, nested := range md.GetNestedType() {
+		// make recursive call
+		normalizeDescriptorProto(nested)
+	}
+	for _, ed := range md.GetEnumType() {
+		normalizeEnumDescriptorProto(ed)
+	}
+}
+
+func normalizeEnumDescriptorProto(ed *descriptorpb.EnumDescriptorProto) {
+	normalizeOptions(ed.GetOptions())
+	for _, value := range ed.GetValue() {
+		normalizeOptions(value.GetOptions())
+	}
+}
+
+func normalizeFieldDescriptorProto(field *descriptorpb.FieldDescriptorProto) {
+	normalizeOptions(field.GetOptions())
+	if field.JsonName == nil {
+		field.JsonName = proto.String(jsonName(field.GetName()))
+	}
+}
+
+// normalizeOptions sorts the uninterpreted options and any unknown option fields, such as custom options, of options
+func normalizeOptions(options proto.Message) {
+	if options == nil || !options.ProtoReflect().IsValid() {
+		return
+	}
+	m := options.ProtoReflect()
+
+	uninterpretedField := m.Descriptor().Fields().ByName("uninterpreted_option")
+	if uninterpretedField != nil && m.Has(uninterpretedField) {
+		list := m.Mutable(uninterpretedField).List()
+		uninterpreted := make([]*descriptorpb.UninterpretedOption, list.Len())
+		for i := 0; i < list.Len(); i++ {
+			uninterpreted[i] = list.Get(i).Message().Interface().(*descriptorpb.UninterpretedOption)
+		}
+		sort.SliceStable(uninterpreted, func(i, j int) bool {
+			return uninterpretedOptionName(uninterpreted[i]) < uninterpretedOptionName(uninterpreted[j])
+		})
+		for i, option := range uninterpreted {
+			list.Set(i, protoreflect.ValueOfMessage(option.ProtoReflect()))
+		}
+	}
+
+	m.SetUnknown(sortUnknownFields(m.GetUnknown()))
+}
+
+func uninterpretedOptionName(option *descriptorpb.UninterpretedOption) string {
+	var parts []string
+	for _, part := range option.GetName() {
+		parts = append(parts, part.GetNamePart())
+	}
+	return strings.Join(parts, ".")
+}
+
+// sortUnknownFields orders the fields in raw by field number, fields with the same number keep their relative order
+func sortUnknownFields(raw protoreflect.RawFields) protoreflect.RawFields {
+	type unknownField struct {
+		number protowire.Number
+		data   []byte
+	}
+	var fields []unknownField
+	for remaining := raw; len(remaining) > 0; {
+		number, _, length := protowire.ConsumeField(remaining)
+		if length < 0 {
+			// leave malformed data untouched rather than lose it
+			return raw
+		}
+		fields = append(fields, unknownField{number: number, data: remaining[:length]})
+		remaining = remaining[length:]
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	sort.SliceStable(fields, func(i, j int) bool {
+		return fields[i].number < fields[j].number
+	})
+	var sorted protoreflect.RawFields
+	for _, field := range fields {
+		sorted = append(sorted, field.data...)
+	}
+	return sorted
+}
+
+// jsonName returns the default json_name protoc gives a field called name
+func jsonName(name string) string {
+	var b strings.Builder
+	upperNext := false
+	for _, r := range name {
+		if r == '_' {
+			upperNext = true
+			continue
+		}
+		if upperNext && r >= 'a' && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		upperNext = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
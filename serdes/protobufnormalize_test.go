@@ -0,0 +1,98 @@
+package serdes
+
+import (
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"testing"
+)
+
+func TestProtobufNormalize_jsonName(t *testing.T) {
+	cases := []struct {
+		name string
+		want string
+	}{
+		{"id", "id"},
+		{"message_id", "messageId"},
+		{"test_2", "test2"},
+		{"another_message_part", "anotherMessagePart"},
+		{"_leading", "Leading"},
+		{"alreadyCamel", "alreadyCamel"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := jsonName(c.name)
+			if got != c.want {
+				t.Fatalf("jsonName(%v) == %v, want %v", c.name, got, c.want)
+			}
+		})
+	}
+}
+
+func TestProtobufNormalize_normalizedFileDescriptorToString(t *testing.T) {
+	files := new(protoregistry.Files)
+	for _, path := range []string{"a.proto", "b.proto"} {
+		fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{Name: proto.String(path), Syntax: proto.String("proto3")}, nil)
+		if err != nil {
+			t.Fatalf("unexpected error on protodesc.NewFile: %s", err.Error())
+		}
+		err = files.RegisterFile(fd)
+		if err != nil {
+			t.Fatalf("unexpected error on RegisterFile: %s", err.Error())
+		}
+	}
+
+	// two custom options as unknown fields, in either order
+	first := protowire.AppendTag(nil, 50001, protowire.VarintType)
+	first = protowire.AppendVarint(first, 1)
+	second := protowire.AppendTag(nil, 50002, protowire.BytesType)
+	second = protowire.AppendString(second, "two")
+
+	build := func(deps []string, public int32, setJSONName bool, unknown []byte, sourceInfo bool) string {
+		field := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String("message_id"),
+			Number: proto.Int32(1),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:   descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum(),
+		}
+		if setJSONName {
+			field.JsonName = proto.String("messageId")
+		}
+		options := &descriptorpb.MessageOptions{}
+		options.ProtoReflect().SetUnknown(unknown)
+		fdp := &descriptorpb.FileDescriptorProto{
+			Name:             proto.String("normalize.proto"),
+			Package:          proto.String("normalize"),
+			Syntax:           proto.String("proto3"),
+			Dependency:       deps,
+			PublicDependency: []int32{public},
+			MessageType:      []*descriptorpb.DescriptorProto{{Name: proto.String("Msg"), Field: []*descriptorpb.FieldDescriptorProto{field}, Options: options}},
+		}
+		if sourceInfo {
+			fdp.SourceCodeInfo = &descriptorpb.SourceCodeInfo{Location: []*descriptorpb.SourceCodeInfo_Location{{Path: []int32{4, 0}, Span: []int32{1, 0, 10}}}}
+		}
+		fd, err := protodesc.NewFile(fdp, files)
+		if err != nil {
+			t.Fatalf("unexpected error on protodesc.NewFile: %s", err.Error())
+		}
+		got, err := normalizedFileDescriptorToString(fd)
+		if err != nil {
+			t.Fatalf("unexpected error on normalizedFileDescriptorToString: %s", err.Error())
+		}
+		return got
+	}
+
+	want := build([]string{"a.proto", "b.proto"}, 1, true, append(append([]byte{}, first...), second...), false)
+	got := build([]string{"b.proto", "a.proto"}, 0, false, append(append([]byte{}, second...), first...), true)
+	if got != want {
+		t.Fatalf("normalizedFileDescriptorToString of equivalent schemas == %v, want %v", got, want)
+	}
+
+	// public imports must still refer to the same file after sorting
+	differentPublic := build([]string{"b.proto", "a.proto"}, 1, true, nil, false)
+	if differentPublic == want {
+		t.Fatalf("normalizedFileDescriptorToString of schemas with different public imports should differ")
+	}
+}
@@ -0,0 +1,611 @@
+package serdes
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// The rule expression language is a small boolean language over the fields of a protobuf message:
+//
+//	expr    = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | compare
+//	compare = operand [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" ) operand | "in" list ]
+//	operand = number | string | "true" | "false" | path | "size" "(" path ")" | "has" "(" path ")" | "(" expr ")"
+//	list    = "[" [ element { "," element } ] "]"
+//	element = number | string | "true" | "false" | identifier
+//	path    = identifier { "." identifier }
+//
+// Paths name fields, with nested messages separated by dots. Enum fields evaluate to the name of their value and bytes
+// fields to a string, so both compare with strings. A bare identifier in a list is a string, so currency in [EUR, USD]
+// works for an enum or a string field.
+
+// ruleExpr a compiled rule expression
+type ruleExpr interface {
+	eval(m protoreflect.Message) (interface{}, error)
+}
+
+type (
+	literalExpr struct{ value interface{} }
+	fieldExpr   struct{ path []protoreflect.Name }
+	sizeExpr    struct{ path []protoreflect.Name }
+	hasExpr     struct{ path []protoreflect.Name }
+	notExpr     struct{ operand ruleExpr }
+	andExpr     struct{ left, right ruleExpr }
+	orExpr      struct{ left, right ruleExpr }
+	compareExpr struct {
+		op          string
+		left, right ruleExpr
+	}
+	inExpr struct {
+		operand ruleExpr
+		values  []interface{}
+	}
+)
+
+// compileRuleExpr parses expr into a ruleExpr
+func compileRuleExpr(expr string) (ruleExpr, error) {
+	tokens, err := tokenizeRuleExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &ruleExprParser{tokens: tokens}
+	compiled, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != ruleTokenEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos)
+	}
+	return compiled, nil
+}
+
+// evalRuleExpr evaluates expr over m, expr must evaluate to a bool
+func evalRuleExpr(expr ruleExpr, m protoreflect.Message) (bool, error) {
+	value, err := expr.eval(m)
+	if err != nil {
+		return false, err
+	}
+	result, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected a bool result, got %s", ruleTypeName(value))
+	}
+	return result, nil
+}
+
+type ruleTokenKind int
+
+const (
+	ruleTokenEOF ruleTokenKind = iota
+	ruleTokenIdent
+	ruleTokenNumber
+	ruleTokenString
+	ruleTokenOperator
+)
+
+type ruleToken struct {
+	kind ruleTokenKind
+	text string // the unquoted value for strings
+	pos  int
+}
+
+// tokenizeRuleExpr splits expr into tokens, ending with an EOF token
+func tokenizeRuleExpr(expr string) ([]ruleToken, error) {
+	var tokens []ruleToken
+	for i := 0; i < len(expr); {
+		c := rune(expr[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(expr) && (expr[i] == '_' || unicode.IsLetter(rune(expr[i])) || unicode.IsDigit(rune(expr[i]))) {
+				i++
+			}
+			tokens = append(tokens, ruleToken{kind: ruleTokenIdent, text: expr[start:i], pos: start})
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(expr) && (unicode.IsDigit(rune(expr[i])) || expr[i] == '.' || expr[i] == 'e' || expr[i] == 'E' ||
+				((expr[i] == '+' || expr[i] == '-') && (expr[i-1] == 'e' || expr[i-1] == 'E'))) {
+				i++
+			}
+			tokens = append(tokens, ruleToken{kind: ruleTokenNumber, text: expr[start:i], pos: start})
+		case c == '"' || c == '\'':
+			start := i
+			var value strings.Builder
+			i++
+			for ; i < len(expr) && rune(expr[i]) != c; i++ {
+				if expr[i] == '\\' && i+1 < len(expr) {
+					i++
+				}
+				value.WriteByte(expr[i])
+			}
+			if i >= len(expr) {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			i++
+			tokens = append(tokens, ruleToken{kind: ruleTokenString, text: value.String(), pos: start})
+		default:
+			operator := ""
+			for _, op := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ",", ".", "-"} {
+				if strings.HasPrefix(expr[i:], op) {
+					operator = op
+					break
+				}
+			}
+			if operator == "" {
+				return nil, fmt.Errorf("unexpected %q at position %d", c, i)
+			}
+			tokens = append(tokens, ruleToken{kind: ruleTokenOperator, text: operator, pos: i})
+			i += len(operator)
+		}
+	}
+	return append(tokens, ruleToken{kind: ruleTokenEOF, pos: len(expr)}), nil
+}
+
+// ruleExprParser a recursive descent parser for rule expressions
+type ruleExprParser struct {
+	tokens []ruleToken
+	pos    int
+}
+
+func (p *ruleExprParser) peek() ruleToken {
+	return p.tokens[p.pos]
+}
+
+func (p *ruleExprParser) next() ruleToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != ruleTokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token when it is the operator op
+func (p *ruleExprParser) accept(op string) bool {
+	if tok := p.peek(); tok.kind == ruleTokenOperator && tok.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *ruleExprParser) expect(op string) error {
+	if !p.accept(op) {
+		return unexpectedRuleToken(p.peek(), op)
+	}
+	return nil
+}
+
+func unexpectedRuleToken(tok ruleToken, want string) error {
+	if tok.kind == ruleTokenEOF {
+		return fmt.Errorf("unexpected end of expression, want %s", want)
+	}
+	return fmt.Errorf("unexpected %q at position %d, want %s", tok.text, tok.pos, want)
+}
+
+func (p *ruleExprParser) parseOr() (ruleExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *ruleExprParser) parseAnd() (ruleExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *ruleExprParser) parseUnary() (ruleExpr, error) {
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{operand: operand}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *ruleExprParser) parseCompare() (ruleExpr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.peek()
+	switch {
+	case tok.kind == ruleTokenOperator && strings.Contains(" == != < <= > >= ", " "+tok.text+" "):
+		p.next()
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return compareExpr{op: tok.text, left: left, right: right}, nil
+	case tok.kind == ruleTokenIdent && tok.text == "in":
+		p.next()
+		values, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		return inExpr{operand: left, values: values}, nil
+	}
+	return left, nil
+}
+
+func (p *ruleExprParser) parseOperand() (ruleExpr, error) {
+	if p.accept("(") {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	}
+
+	tok := p.peek()
+	if tok.kind == ruleTokenIdent && (tok.text == "size" || tok.text == "has") && p.tokens[p.pos+1].text == "(" {
+		p.pos += 2
+		path, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		if tok.text == "size" {
+			return sizeExpr{path: path}, nil
+		}
+		return hasExpr{path: path}, nil
+	}
+	if tok.kind == ruleTokenIdent && tok.text != "true" && tok.text != "false" {
+		path, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		return fieldExpr{path: path}, nil
+	}
+
+	value, err := p.parseLiteral(false)
+	if err != nil {
+		return nil, err
+	}
+	return literalExpr{value: value}, nil
+}
+
+// parseLiteral parses a number, string or bool, and when bareIdent is set any other identifier as a string
+func (p *ruleExprParser) parseLiteral(bareIdent bool) (interface{}, error) {
+	negative := p.accept("-")
+	tok := p.next()
+	switch {
+	case tok.kind == ruleTokenNumber:
+		return parseRuleNumber(tok, negative)
+	case negative:
+		return nil, unexpectedRuleToken(tok, "a number")
+	case tok.kind == ruleTokenString:
+		return tok.text, nil
+	case tok.kind == ruleTokenIdent && tok.text == "true":
+		return true, nil
+	case tok.kind == ruleTokenIdent && tok.text == "false":
+		return false, nil
+	case tok.kind == ruleTokenIdent && bareIdent:
+		return tok.text, nil
+	}
+	return nil, unexpectedRuleToken(tok, "a value")
+}
+
+func parseRuleNumber(tok ruleToken, negative bool) (interface{}, error) {
+	text := tok.text
+	if negative {
+		text = "-" + text
+	}
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return i, nil
+	}
+	if u, err := strconv.ParseUint(text, 10, 64); err == nil {
+		return u, nil
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %q at position %d", text, tok.pos)
+	}
+	return f, nil
+}
+
+func (p *ruleExprParser) parseList() ([]interface{}, error) {
+	if err := p.expect("["); err != nil {
+		return nil, err
+	}
+	var values []interface{}
+	if p.accept("]") {
+		return values, nil
+	}
+	for {
+		value, err := p.parseLiteral(true)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		if p.accept("]") {
+			return values, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *ruleExprParser) parsePath() ([]protoreflect.Name, error) {
+	var path []protoreflect.Name
+	for {
+		tok := p.next()
+		if tok.kind != ruleTokenIdent {
+			return nil, unexpectedRuleToken(tok, "a field name")
+		}
+		path = append(path, protoreflect.Name(tok.text))
+		if !p.accept(".") {
+			return path, nil
+		}
+	}
+}
+
+func (e literalExpr) eval(protoreflect.Message) (interface{}, error) {
+	return e.value, nil
+}
+
+func (e fieldExpr) eval(m protoreflect.Message) (interface{}, error) {
+	m, fd, err := resolveRulePath(m, e.path)
+	if err != nil {
+		return nil, err
+	}
+	if fd.IsList() || fd.IsMap() {
+		return nil, fmt.Errorf("field %s is repeated, only size and has can be used with it", fd.FullName())
+	}
+	value := m.Get(fd)
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return value.Bool(), nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return value.Int(), nil
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if value.Uint() > math.MaxInt64 {
+			return value.Uint(), nil
+		}
+		return int64(value.Uint()), nil
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return value.Float(), nil
+	case protoreflect.StringKind:
+		return value.String(), nil
+	case protoreflect.BytesKind:
+		return string(value.Bytes()), nil
+	case protoreflect.EnumKind:
+		if enumValue := fd.Enum().Values().ByNumber(value.Enum()); enumValue != nil {
+			return string(enumValue.Name()), nil
+		}
+		return int64(value.Enum()), nil
+	}
+	return nil, fmt.Errorf("field %s is a message, only has can be used with it", fd.FullName())
+}
+
+func (e sizeExpr) eval(m protoreflect.Message) (interface{}, error) {
+	m, fd, err := resolveRulePath(m, e.path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case fd.IsList():
+		return int64(m.Get(fd).List().Len()), nil
+	case fd.IsMap():
+		return int64(m.Get(fd).Map().Len()), nil
+	case fd.Kind() == protoreflect.StringKind:
+		return int64(len(m.Get(fd).String())), nil
+	case fd.Kind() == protoreflect.BytesKind:
+		return int64(len(m.Get(fd).Bytes())), nil
+	}
+	return nil, fmt.Errorf("size cannot be used with field %s, it is %s", fd.FullName(), fd.Kind())
+}
+
+func (e hasExpr) eval(m protoreflect.Message) (interface{}, error) {
+	m, fd, err := resolveRulePath(m, e.path)
+	if err != nil {
+		return nil, err
+	}
+	return m.Has(fd), nil
+}
+
+func (e notExpr) eval(m protoreflect.Message) (interface{}, error) {
+	operand, err := evalRuleBool(e.operand, m, "!")
+	if err != nil {
+		return nil, err
+	}
+	return !operand, nil
+}
+
+func (e andExpr) eval(m protoreflect.Message) (interface{}, error) {
+	left, err := evalRuleBool(e.left, m, "&&")
+	if err != nil || !left {
+		return false, err
+	}
+	return evalRuleBool(e.right, m, "&&")
+}
+
+func (e orExpr) eval(m protoreflect.Message) (interface{}, error) {
+	left, err := evalRuleBool(e.left, m, "||")
+	if err != nil || left {
+		return left, err
+	}
+	return evalRuleBool(e.right, m, "||")
+}
+
+func (e compareExpr) eval(m protoreflect.Message) (interface{}, error) {
+	left, err := e.left.eval(m)
+	if err != nil {
+		return nil, err
+	}
+	right, err := e.right.eval(m)
+	if err != nil {
+		return nil, err
+	}
+	cmp, err := compareRuleValues(left, right, e.op)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "==":
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	}
+	return cmp >= 0, nil
+}
+
+func (e inExpr) eval(m protoreflect.Message) (interface{}, error) {
+	operand, err := e.operand.eval(m)
+	if err != nil {
+		return nil, err
+	}
+	for _, value := range e.values {
+		cmp, err := compareRuleValues(operand, value, "in")
+		if err != nil {
+			return nil, err
+		}
+		if cmp == 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// evalRuleBool evaluates an operand of op, which must be a bool
+func evalRuleBool(expr ruleExpr, m protoreflect.Message, op string) (bool, error) {
+	value, err := expr.eval(m)
+	if err != nil {
+		return false, err
+	}
+	result, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s needs bool operands, got %s", op, ruleTypeName(value))
+	}
+	return result, nil
+}
+
+// resolveRulePath returns the field path names and the message holding it, path walks through nested messages
+func resolveRulePath(m protoreflect.Message, path []protoreflect.Name) (protoreflect.Message, protoreflect.FieldDescriptor, error) {
+	for i, name := range path {
+		fd := m.Descriptor().Fields().ByName(name)
+		if fd == nil {
+			return nil, nil, fmt.Errorf("unknown field %s in %s", name, m.Descriptor().FullName())
+		}
+		if i == len(path)-1 {
+			return m, fd, nil
+		}
+		if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			return nil, nil, fmt.Errorf("field %s is not a message, %s cannot be selected from it", fd.FullName(), path[i+1])
+		}
+		// an unset message reads as empty, so its fields have their default values
+		m = m.Get(fd).Message()
+	}
+	return nil, nil, fmt.Errorf("empty field path")
+}
+
+// compareRuleValues returns -1, 0 or 1 as left is less than, equal to or greater than right, bools only support equality.
+// Numbers are int64 unless they are too big for it, when they are uint64, or have a fraction, when they are float64.
+func compareRuleValues(left interface{}, right interface{}, op string) (int, error) {
+	switch l := left.(type) {
+	case int64:
+		switch r := right.(type) {
+		case int64:
+			return compareOrdered(l, r), nil
+		case uint64:
+			return -1, nil
+		case float64:
+			return compareOrdered(float64(l), r), nil
+		}
+	case uint64:
+		switch r := right.(type) {
+		case int64:
+			return 1, nil
+		case uint64:
+			return compareOrdered(l, r), nil
+		case float64:
+			return compareOrdered(float64(l), r), nil
+		}
+	case float64:
+		switch r := right.(type) {
+		case int64:
+			return compareOrdered(l, float64(r)), nil
+		case uint64:
+			return compareOrdered(l, float64(r)), nil
+		case float64:
+			return compareOrdered(l, r), nil
+		}
+	case string:
+		if r, ok := right.(string); ok {
+			return strings.Compare(l, r), nil
+		}
+	case bool:
+		if r, ok := right.(bool); ok {
+			if op != "==" && op != "!=" && op != "in" {
+				return 0, fmt.Errorf("%s cannot be used with bool values", op)
+			}
+			if l == r {
+				return 0, nil
+			}
+			return 1, nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %s with %s", ruleTypeName(left), ruleTypeName(right))
+}
+
+func compareOrdered[T int64 | uint64 | float64](l T, r T) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+	return 0
+}
+
+func ruleTypeName(value interface{}) string {
+	switch value.(type) {
+	case int64, uint64, float64:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	}
+	return fmt.Sprintf("%T", value)
+}
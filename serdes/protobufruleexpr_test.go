@@ -0,0 +1,194 @@
+package serdes
+
+import (
+	"context"
+	"github.com/riferrei/srclient"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"testing"
+)
+
+const testPaymentSchema = `syntax = "proto3";
+package test.rules;
+
+enum Currency {
+  CURRENCY_UNSPECIFIED = 0;
+  EUR = 1;
+  USD = 2;
+  GBP = 3;
+}
+
+message Party {
+  string name = 1;
+  string country = 2;
+}
+
+message Payment {
+  double amount = 1;
+  Currency currency = 2;
+  int32 count = 3;
+  bool approved = 4;
+  string reference = 5;
+  bytes signature = 6;
+  repeated string tags = 7;
+  map<string, string> attributes = 8;
+  Party payer = 9;
+  uint64 sequence = 10;
+  optional string note = 11;
+}
+`
+
+// testPaymentDescriptor registers testPaymentSchema with msrc and returns its Payment message
+func testPaymentDescriptor(t *testing.T, msrc *registryMockSchemaRegistryClient) protoreflect.MessageDescriptor {
+	theSchema, err := msrc.CreateSchema("payment", testPaymentSchema, srclient.Protobuf)
+	if err != nil {
+		t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+	}
+	fd, err := newProtobufSchemaResolver(msrc).fileDescriptorByID(context.Background(), theSchema.ID())
+	if err != nil {
+		t.Fatalf("unexpected error on fileDescriptorByID: %s", err.Error())
+	}
+	return fd.Messages().ByName("Payment")
+}
+
+// newTestPayment returns a Payment with amount and currency set along with a few other fields
+func newTestPayment(md protoreflect.MessageDescriptor, amount float64, currency protoreflect.Name) *dynamicpb.Message {
+	payment := dynamicpb.NewMessage(md)
+	fields := md.Fields()
+	payment.Set(fields.ByName("amount"), protoreflect.ValueOfFloat64(amount))
+	payment.Set(fields.ByName("currency"), protoreflect.ValueOfEnum(fields.ByName("currency").Enum().Values().ByName(currency).Number()))
+	payment.Set(fields.ByName("count"), protoreflect.ValueOfInt32(3))
+	payment.Set(fields.ByName("approved"), protoreflect.ValueOfBool(true))
+	payment.Set(fields.ByName("reference"), protoreflect.ValueOfString("INV-42"))
+	payment.Set(fields.ByName("signature"), protoreflect.ValueOfBytes([]byte("sig")))
+	payment.Set(fields.ByName("sequence"), protoreflect.ValueOfUint64(1<<63+1))
+	tags := payment.Mutable(fields.ByName("tags")).List()
+	tags.Append(protoreflect.ValueOfString("urgent"))
+	tags.Append(protoreflect.ValueOfString("b2b"))
+	payer := payment.Mutable(fields.ByName("payer")).Message()
+	payer.Set(md.Fields().ByName("payer").Message().Fields().ByName("country"), protoreflect.ValueOfString("NL"))
+	return payment
+}
+
+func TestProtobufRuleExpr_eval(t *testing.T) {
+	payment := newTestPayment(testPaymentDescriptor(t, newRegistryMockSchemaRegistryClient()), 12.5, "EUR")
+
+	cases := []struct {
+		expr string
+		want bool
+	}{
+		{"amount > 0", true},
+		{"amount > 12.5", false},
+		{"amount >= 12.5", true},
+		{"amount < 100 && amount != 0", true},
+		{"amount == 12.5", true},
+		{"count == 3", true},
+		{"count <= 2", false},
+		{"count > 2.5", true},
+		{"count > -1", true},
+		{"sequence > 9223372036854775807", true},
+		{"sequence == 9223372036854775809", true},
+		{"sequence > 9223372036854775810", false},
+		{"count < 9223372036854775810", true},
+		{"currency in [EUR, USD]", true},
+		{"currency in [GBP]", false},
+		{"currency in []", false},
+		{"currency == 'EUR'", true},
+		{`currency != "USD"`, true},
+		{"approved", true},
+		{"approved == false", false},
+		{"!approved || amount > 1000", false},
+		{"!(amount > 1000)", true},
+		{"reference == 'INV-42'", true},
+		{"reference < 'INV-5'", true},
+		{`reference == "INV-\"42"`, false},
+		{"signature == 'sig'", true},
+		{"size(reference) == 6", true},
+		{"size(signature) == 3", true},
+		{"size(tags) == 2", true},
+		{"size(attributes) == 0", true},
+		{"has(payer)", true},
+		{"has(note)", false},
+		{"has(payer.name)", false},
+		{"payer.country in [NL, BE]", true},
+		{"payer.name == ''", true},
+		{"amount > 0 && (currency == 'EUR' || currency == 'USD') && payer.country != 'US'", true},
+		{"false || true && false", false},
+		{"amount > 1000 && unknown > 0", false},
+		{"amount > 0 || unknown > 0", true},
+	}
+	for _, c := range cases {
+		expr, err := compileRuleExpr(c.expr)
+		if err != nil {
+			t.Fatalf("unexpected error on compileRuleExpr(%q): %s", c.expr, err.Error())
+		}
+		got, err := evalRuleExpr(expr, payment)
+		if err != nil {
+			t.Fatalf("unexpected error on evalRuleExpr(%q): %s", c.expr, err.Error())
+		}
+		if got != c.want {
+			t.Fatalf("evalRuleExpr(%q) == %v, want %v", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestProtobufRuleExpr_compileErrors(t *testing.T) {
+	cases := []struct {
+		expr string
+		want string
+	}{
+		{"", "unexpected end of expression, want a value"},
+		{"amount >", "unexpected end of expression, want a value"},
+		{"amount > 0 &&", "unexpected end of expression, want a value"},
+		{"amount > 0 0", `unexpected "0" at position 11`},
+		{"amount = 0", `unexpected '=' at position 7`},
+		{"(amount > 0", "unexpected end of expression, want )"},
+		{"currency in EUR", `unexpected "EUR" at position 12, want [`},
+		{"currency in [EUR USD]", `unexpected "USD" at position 17, want ,`},
+		{"currency in [(EUR)]", `unexpected "(" at position 13, want a value`},
+		{"reference == 'INV", "unterminated string at position 13"},
+		{"amount > 1.2.3", `invalid number "1.2.3" at position 9`},
+		{"amount > -'a'", `unexpected "a" at position 10, want a number`},
+		{"payer. > 0", `unexpected ">" at position 7, want a field name`},
+		{"size(1)", `unexpected "1" at position 5, want a field name`},
+	}
+	for _, c := range cases {
+		_, err := compileRuleExpr(c.expr)
+		if err == nil || err.Error() != c.want {
+			t.Fatalf("compileRuleExpr(%q) == %v, want %s", c.expr, err, c.want)
+		}
+	}
+}
+
+func TestProtobufRuleExpr_evalErrors(t *testing.T) {
+	payment := newTestPayment(testPaymentDescriptor(t, newRegistryMockSchemaRegistryClient()), 12.5, "EUR")
+
+	cases := []struct {
+		expr string
+		want string
+	}{
+		{"amount", "expected a bool result, got number"},
+		{"unknown > 0", "unknown field unknown in test.rules.Payment"},
+		{"payer.unknown == ''", "unknown field unknown in test.rules.Party"},
+		{"amount.value > 0", "field test.rules.Payment.amount is not a message, value cannot be selected from it"},
+		{"tags == 'urgent'", "field test.rules.Payment.tags is repeated, only size and has can be used with it"},
+		{"payer == 'NL'", "field test.rules.Payment.payer is a message, only has can be used with it"},
+		{"size(amount) > 0", "size cannot be used with field test.rules.Payment.amount, it is double"},
+		{"amount == 'EUR'", "cannot compare number with string"},
+		{"currency in [USD, 1]", "cannot compare string with number"},
+		{"approved > false", "> cannot be used with bool values"},
+		{"amount && approved", "&& needs bool operands, got number"},
+		{"!approved || reference", "|| needs bool operands, got string"},
+		{"!count", "! needs bool operands, got number"},
+	}
+	for _, c := range cases {
+		expr, err := compileRuleExpr(c.expr)
+		if err != nil {
+			t.Fatalf("unexpected error on compileRuleExpr(%q): %s", c.expr, err.Error())
+		}
+		_, err = evalRuleExpr(expr, payment)
+		if err == nil || err.Error() != c.want {
+			t.Fatalf("evalRuleExpr(%q) == %v, want %s", c.expr, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package serdes
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// Rules the data contract rules messages are checked against, a []Rule
+	Rules = "rules"
+	// UseRegistryRules also check messages against the rules in the metadata of their schema in Schema Registry, the
+	// client must implement RuleSchemaRegistryClient
+	UseRegistryRules = "use.registry.rules"
+	// RuleDLQHandlerImpl the handler messages breaking a rule with the DLQ action are passed to
+	RuleDLQHandlerImpl = "rule.dlq.handler"
+	// RuleLoggerImpl the logger messages breaking a rule with the LOG action are reported to, the standard logger is the
+	// default. Only the rule, topic and schema ID are logged, never the contents of the message
+	RuleLoggerImpl = "rule.logger"
+)
+
+// RuleAction what happens when a message breaks a rule
+type RuleAction string
+
+const (
+	// RuleActionError fails serialization or deserialization, this is the default
+	RuleActionError RuleAction = "ERROR"
+	// RuleActionDLQ passes the message to the RuleDLQHandler and then fails serialization or deserialization
+	RuleActionDLQ RuleAction = "DLQ"
+	// RuleActionLog reports the rule broken, the topic and the schema ID to the RuleLogger and carries on, use
+	// RuleActionDLQ to get at the message itself
+	RuleActionLog RuleAction = "LOG"
+)
+
+// Rule a data contract rule, Expr is a boolean expression over the fields of the message such as
+// amount > 0 && currency in [EUR, USD], see protobufruleexpr.go for the full language
+type Rule struct {
+	Name   string
+	Expr   string
+	Action RuleAction // RuleActionError when empty
+}
+
+// RuleViolation a message that broke a rule
+type RuleViolation struct {
+	Rule     Rule
+	Message  proto.Message
+	SchemaID int
+	Data     []byte // the serialized record when deserializing, nil when serializing
+	Context  SerializationContext
+}
+
+// Error for RuleViolation
+func (v *RuleViolation) Error() string {
+	return fmt.Sprintf("message breaks rule %s: %s", v.Rule.Name, v.Rule.Expr)
+}
+
+// RuleDLQHandler receives the messages that break a rule with the DLQ action, such as to produce them to a dead letter queue
+type RuleDLQHandler interface {
+	Handle(ctx context.Context, violation *RuleViolation) error
+}
+
+// RuleDLQHandlerFunc a function that implements RuleDLQHandler
+type RuleDLQHandlerFunc func(ctx context.Context, violation *RuleViolation) error
+
+// Handle for RuleDLQHandlerFunc
+func (f RuleDLQHandlerFunc) Handle(ctx context.Context, violation *RuleViolation) error {
+	return f(ctx, violation)
+}
+
+// RuleLogger reports the rules broken by messages with the LOG action, *log.Logger implements this
+type RuleLogger interface {
+	Printf(format string, v ...interface{})
+}
+
+// compiledRule a Rule along with its compiled expression
+type compiledRule struct {
+	rule Rule
+	expr ruleExpr
+}
+
+// ruleExecutor checks messages against the configured rules and those in Schema Registry
+type ruleExecutor struct {
+	rules             []compiledRule
+	client            RuleSchemaRegistryClient // nil unless UseRegistryRules is enabled
+	registryRules     map[int][]compiledRule   // map from schema ID to associated rules from Schema Registry
+	registryRulesLock sync.RWMutex
+	dlqHandler        RuleDLQHandler
+	logger            RuleLogger
+}
+
+// newRuleExecutor returns a ruleExecutor for rules, or nil when there are no rules to check
+func newRuleExecutor(rules []Rule, client RuleSchemaRegistryClient, dlqHandler RuleDLQHandler, logger RuleLogger) (*ruleExecutor, error) {
+	if len(rules) == 0 && client == nil {
+		return nil, nil
+	}
+	if logger == nil {
+		logger = log.Default()
+	}
+	r := &ruleExecutor{
+		client:        client,
+		registryRules: make(map[int][]compiledRule),
+		dlqHandler:    dlqHandler,
+		logger:        logger,
+	}
+	compiled, err := r.compileRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	r.rules = compiled
+	return r, nil
+}
+
+// compileRules checks rules are complete and compiles their expressions
+func (r *ruleExecutor) compileRules(rules []Rule) ([]compiledRule, error) {
+	var compiled []compiledRule
+	for _, rule := range rules {
+		if rule.Action == "" {
+			rule.Action = RuleActionError
+		}
+		switch rule.Action {
+		case RuleActionError, RuleActionLog:
+		case RuleActionDLQ:
+			if r.dlqHandler == nil {
+				return nil, fmt.Errorf("rule %s has action %s but %s is not set", rule.Name, rule.Action, RuleDLQHandlerImpl)
+			}
+		default:
+			return nil, fmt.Errorf("rule %s has unknown action %s", rule.Name, rule.Action)
+		}
+		expr, err := compileRuleExpr(rule.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %s: %w", rule.Name, err)
+		}
+		compiled = append(compiled, compiledRule{rule: rule, expr: expr})
+	}
+	return compiled, nil
+}
+
+// execute checks pb against the rules, calling the action of any rule it breaks. The error is a *RuleViolation for
+// the first rule broken with the ERROR or DLQ action.
+func (r *ruleExecutor) execute(ctx context.Context, pb proto.Message, schemaID int, data []byte, serCtx SerializationContext) error {
+	registryRules, err := r.getRegistryRules(ctx, schemaID)
+	if err != nil {
+		return err
+	}
+
+	m := pb.ProtoReflect()
+	for _, rules := range [][]compiledRule{r.rules, registryRules} {
+		for _, rule := range rules {
+			ok, err := evalRuleExpr(rule.expr, m)
+			if err != nil {
+				return fmt.Errorf("unable to evaluate rule %s: %w", rule.rule.Name, err)
+			}
+			if ok {
+				continue
+			}
+
+			violation := &RuleViolation{Rule: rule.rule, Message: pb, SchemaID: schemaID, Data: data, Context: serCtx}
+			switch rule.rule.Action {
+			case RuleActionLog:
+				// the message may hold personal data, so it is left out
+				r.logger.Printf("message breaks rule %s with schema ID %d on the %s of topic %s", rule.rule.Name, schemaID, serCtx.Field, serCtx.Topic)
+				continue
+			case RuleActionDLQ:
+				err = r.dlqHandler.Handle(ctx, violation)
+				if err != nil {
+					return fmt.Errorf("%s, and the DLQ handler failed: %w", violation.Error(), err)
+				}
+			}
+			return violation
+		}
+	}
+	return nil
+}
+
+// getRegistryRules returns the rules in the metadata of the schema registered with schemaID, fetching them from
+// Schema Registry on first use
+func (r *ruleExecutor) getRegistryRules(ctx context.Context, schemaID int) ([]compiledRule, error) {
+	if r.client == nil {
+		return nil, nil
+	}
+
+	r.registryRulesLock.RLock()
+	compiled, ok := r.registryRules[schemaID]
+	r.registryRulesLock.RUnlock()
+	if ok {
+		return compiled, nil
+	}
+
+	rules, err := callRegistry(ctx, func() ([]Rule, error) {
+		return r.client.GetSchemaRules(schemaID)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to find the rules of schema ID %d: %w", schemaID, err)
+	}
+	compiled, err = r.compileRules(rules)
+	if err != nil {
+		return nil, fmt.Errorf("rules of schema ID %d: %w", schemaID, err)
+	}
+
+	r.registryRulesLock.Lock()
+	r.registryRules[schemaID] = compiled
+	r.registryRulesLock.Unlock()
+
+	return compiled, nil
+}
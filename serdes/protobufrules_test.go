@@ -0,0 +1,297 @@
+package serdes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/finncolman/kafka-go-serdes/internal/message"
+	"google.golang.org/protobuf/proto"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// ruleMockSchemaRegistryClient keeps data contract rules in the metadata of schemas
+type ruleMockSchemaRegistryClient struct {
+	*registryMockSchemaRegistryClient
+	rules map[int][]Rule // map from schema ID to associated rules
+}
+
+func newRuleMockSchemaRegistryClient() *ruleMockSchemaRegistryClient {
+	return &ruleMockSchemaRegistryClient{registryMockSchemaRegistryClient: newRegistryMockSchemaRegistryClient(), rules: make(map[int][]Rule)}
+}
+
+func (m *ruleMockSchemaRegistryClient) GetSchemaRules(schemaID int) ([]Rule, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.calls["GetSchemaRules"]++
+	if _, ok := m.schemas[schemaID]; !ok {
+		return nil, fmt.Errorf("schema %d not found", schemaID)
+	}
+	return m.rules[schemaID], nil
+}
+
+// testDLQ records the violations passed to it
+type testDLQ struct {
+	violations []*RuleViolation
+	err        error
+}
+
+func (d *testDLQ) Handle(_ context.Context, violation *RuleViolation) error {
+	d.violations = append(d.violations, violation)
+	return d.err
+}
+
+var testPaymentRules = []Rule{
+	{Name: "positive", Expr: "amount > 0"},
+	{Name: "currency", Expr: "currency in [EUR, USD]", Action: RuleActionDLQ},
+	{Name: "small", Expr: "amount < 1000", Action: RuleActionLog},
+}
+
+func TestProtobufRules_Serialize(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	ctx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+	md := testPaymentDescriptor(t, msrc)
+	dlq := &testDLQ{}
+	var logs bytes.Buffer
+
+	ps, err := NewProtobufSerializer(md, msrc, ProtobufSerializerConfig{
+		Rules:              testPaymentRules,
+		RuleDLQHandlerImpl: dlq,
+		RuleLoggerImpl:     log.New(&logs, "", 0),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+
+	cases := []struct {
+		name    string
+		payment proto.Message
+		want    string // the rule broken, empty for none
+		dlq     int
+		logged  string
+	}{
+		{"valid", newTestPayment(md, 12.5, "EUR"), "", 0, ""},
+		{"error action", newTestPayment(md, -1, "USD"), "positive", 0, ""},
+		{"dlq action", newTestPayment(md, 12.5, "GBP"), "currency", 1, ""},
+		{"first rule broken wins", newTestPayment(md, 0, "GBP"), "positive", 0, ""},
+		{"log action", newTestPayment(md, 5000, "EUR"), "", 0, "message breaks rule small with schema ID 2 on the value of topic test"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dlq.violations = nil
+			logs.Reset()
+
+			data, err := ps.Serialize(c.payment, ctx)
+			if c.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error on Serialize: %s", err.Error())
+				}
+				if len(data) == 0 {
+					t.Fatalf("ps.Serialize(%v) returned no bytes", c.payment)
+				}
+			} else {
+				var violation *RuleViolation
+				if !errors.As(err, &violation) || violation.Rule.Name != c.want {
+					t.Fatalf("ps.Serialize(%v) == %v, want a violation of rule %s", c.payment, err, c.want)
+				}
+				if violation.Message != c.payment || violation.Data != nil || violation.Context != ctx || violation.SchemaID == 0 {
+					t.Fatalf("violation == %+v, want the message, its schema ID and the serialization context", violation)
+				}
+			}
+			if len(dlq.violations) != c.dlq {
+				t.Fatalf("DLQ received %d messages, want %d", len(dlq.violations), c.dlq)
+			}
+			if !strings.Contains(logs.String(), c.logged) || (c.logged == "") != (logs.Len() == 0) {
+				t.Fatalf("logged %q, want %q", logs.String(), c.logged)
+			}
+			if strings.Contains(logs.String(), "5000") || strings.Contains(logs.String(), "EUR") {
+				t.Fatalf("logged %q, want it to leave out the contents of the message", logs.String())
+			}
+		})
+	}
+}
+
+func TestProtobufRules_SerializeDLQError(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	md := testPaymentDescriptor(t, msrc)
+	dlq := &testDLQ{err: errors.New("broker unavailable")}
+
+	ps, err := NewProtobufSerializer(md, msrc, ProtobufSerializerConfig{
+		Rules:              testPaymentRules,
+		RuleDLQHandlerImpl: dlq,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+	_, err = ps.Serialize(newTestPayment(md, 12.5, "GBP"), SerializationContext{Topic: "test", Field: MessageFieldValue})
+	want := "message breaks rule currency: currency in [EUR, USD], and the DLQ handler failed: broker unavailable"
+	if err == nil || err.Error() != want {
+		t.Fatalf("ps.Serialize() == %v, want %s", err, want)
+	}
+	if !errors.Is(err, dlq.err) {
+		t.Fatalf("errors.Is(%v, %v) == false, want true", err, dlq.err)
+	}
+}
+
+func TestProtobufRules_SerializeRegistryRules(t *testing.T) {
+	msrc := newRuleMockSchemaRegistryClient()
+	ctx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+	msgData := &message.MessageData{Nest1: &message.Nested1{MessageId: 1}}
+
+	ps, err := NewProtobufSerializer(msgData.ProtoReflect().Descriptor(), msrc, ProtobufSerializerConfig{
+		Rules:            []Rule{{Name: "has nest1", Expr: "has(nest1)"}},
+		UseRegistryRules: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+	// registers the schema so that rules can be attached to it
+	_, err = ps.Serialize(msgData, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error on Serialize: %s", err.Error())
+	}
+
+	msrc.rules[1] = []Rule{{Name: "message id", Expr: "nest1.message_id > 1"}}
+	ps, err = NewProtobufSerializer(msgData.ProtoReflect().Descriptor(), msrc, ProtobufSerializerConfig{
+		Rules:            []Rule{{Name: "has nest1", Expr: "has(nest1)"}},
+		UseRegistryRules: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+	for i := 0; i < 2; i++ {
+		_, err = ps.Serialize(msgData, ctx)
+		want := "message breaks rule message id: nest1.message_id > 1"
+		if err == nil || err.Error() != want {
+			t.Fatalf("ps.Serialize(%v) == %v, want %s", msgData, err, want)
+		}
+	}
+	// the configured rules are checked first
+	_, err = ps.Serialize(&message.MessageData{}, ctx)
+	want := "message breaks rule has nest1: has(nest1)"
+	if err == nil || err.Error() != want {
+		t.Fatalf("ps.Serialize() == %v, want %s", err, want)
+	}
+	_, err = ps.Serialize(&message.MessageData{Nest1: &message.Nested1{MessageId: 2}}, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error on Serialize: %s", err.Error())
+	}
+	// the rules are fetched once for each serializer
+	if msrc.calls["GetSchemaRules"] != 2 {
+		t.Fatalf("GetSchemaRules called %d times, want 2", msrc.calls["GetSchemaRules"])
+	}
+
+	msrc.rules[1] = []Rule{{Name: "broken", Expr: "nest1.message_id >"}}
+	ps, err = NewProtobufSerializer(msgData.ProtoReflect().Descriptor(), msrc, ProtobufSerializerConfig{UseRegistryRules: true})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+	_, err = ps.Serialize(msgData, ctx)
+	want = "rules of schema ID 1: invalid rule broken: unexpected end of expression, want a value"
+	if err == nil || err.Error() != want {
+		t.Fatalf("ps.Serialize(%v) == %v, want %s", msgData, err, want)
+	}
+}
+
+func TestProtobufRules_Deserialize(t *testing.T) {
+	msrc := newRuleMockSchemaRegistryClient()
+	ctx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+	dlq := &testDLQ{}
+
+	ps, err := NewProtobufSerializer((&message.MessageData{}).ProtoReflect().Descriptor(), msrc, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+	valid, err := ps.Serialize(&message.MessageData{Nest2: &message.Nested2{Id: "a1"}}, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error on Serialize: %s", err.Error())
+	}
+	invalid, err := ps.Serialize(&message.MessageData{Nest2: &message.Nested2{Id: "b1"}}, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error on Serialize: %s", err.Error())
+	}
+	msrc.rules[1] = []Rule{{Name: "id", Expr: "nest2.id < 'b'", Action: RuleActionDLQ}}
+
+	// without rules configured consumers accept what they are given
+	pd, err := NewProtobufDeserializerWithClient(msrc, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufDeserializerWithClient: %s", err.Error())
+	}
+	err = pd.Deserialize(invalid, &message.MessageData{})
+	if err != nil {
+		t.Fatalf("unexpected error on Deserialize: %s", err.Error())
+	}
+
+	pd, err = NewProtobufDeserializerWithClient(msrc, ProtobufDeserializerConfig{UseRegistryRules: true, RuleDLQHandlerImpl: dlq})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufDeserializerWithClient: %s", err.Error())
+	}
+	err = pd.Deserialize(valid, &message.MessageData{})
+	if err != nil {
+		t.Fatalf("unexpected error on Deserialize: %s", err.Error())
+	}
+
+	deserializers := []func() error{
+		func() error { return pd.Deserialize(invalid, &message.MessageData{}) },
+		func() error { _, err := pd.DeserializeDynamic(invalid); return err },
+		func() error { _, err := pd.DeserializeMessage(invalid); return err },
+		func() error { _, err := pd.DeserializeValue(context.Background(), invalid, ctx); return err },
+	}
+	for i, deserialize := range deserializers {
+		err = deserialize()
+		var violation *RuleViolation
+		if !errors.As(err, &violation) || violation.Rule.Name != "id" {
+			t.Fatalf("deserializer %d returned %v, want a violation of rule id", i, err)
+		}
+		if len(dlq.violations) != i+1 || !reflect.DeepEqual(dlq.violations[i].Data, invalid) || dlq.violations[i].SchemaID != 1 {
+			t.Fatalf("DLQ received %+v, want the serialized record", dlq.violations)
+		}
+	}
+	if dlq.violations[3].Context != ctx {
+		t.Fatalf("DLQ received context %v, want %v", dlq.violations[3].Context, ctx)
+	}
+
+	_, err = NewProtobufDeserializerWithClient(msrc, ProtobufDeserializerConfig{Rules: []Rule{{Name: "broken", Expr: "id ="}}})
+	want := `invalid rule broken: unexpected '=' at position 3`
+	if err == nil || err.Error() != want {
+		t.Fatalf("NewProtobufDeserializerWithClient() == %v, want %s", err, want)
+	}
+}
+
+func TestProtobufRules_DeserializeSchemaGUID(t *testing.T) {
+	msrc := &guidRuleMockSchemaRegistryClient{guidMockSchemaRegistryClient: newGUIDMockSchemaRegistryClient(), rules: make(map[int][]Rule)}
+	ctx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+
+	ps, err := NewProtobufSerializer((&message.MessageData{}).ProtoReflect().Descriptor(), msrc, ProtobufSerializerConfig{UseSchemaGUID: true})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+	data, err := ps.Serialize(&message.MessageData{}, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error on Serialize: %s", err.Error())
+	}
+	msrc.rules[1] = []Rule{{Name: "has nest1", Expr: "has(nest1)"}}
+
+	pd, err := NewProtobufDeserializerWithClient(msrc, ProtobufDeserializerConfig{UseRegistryRules: true})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufDeserializerWithClient: %s", err.Error())
+	}
+	err = pd.Deserialize(data, &message.MessageData{})
+	want := "message breaks rule has nest1: has(nest1)"
+	if err == nil || err.Error() != want {
+		t.Fatalf("pd.Deserialize(%v) == %v, want %s", data, err, want)
+	}
+}
+
+// guidRuleMockSchemaRegistryClient implements both GUIDSchemaRegistryClient and RuleSchemaRegistryClient
+type guidRuleMockSchemaRegistryClient struct {
+	*guidMockSchemaRegistryClient
+	rules map[int][]Rule // map from schema ID to associated rules
+}
+
+func (m *guidRuleMockSchemaRegistryClient) GetSchemaRules(schemaID int) ([]Rule, error) {
+	return m.rules[schemaID], nil
+}
@@ -0,0 +1,474 @@
+package serdes
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// errUnknownExtension custom options whose extension cannot be found are left uninterpreted rather than failing the file
+var errUnknownExtension = errors.New("unknown extension")
+
+// protoLinker resolves the names in a file descriptor proto parsed from .proto text
+type protoLinker struct {
+	files  *protoregistry.Files
+	local  map[string]descriptorpb.FieldDescriptorProto_Type // map from full name of a message or enum in the file to associated field type
+	extras *protoregistry.Types                              // extensions found in the files being linked
+}
+
+// linkFileDescriptorProto resolves the relative type names of fdp and interprets its options, the files fdp imports
+// must already be registered in files, file descriptor protos built by protoc are left unchanged
+func linkFileDescriptorProto(fdp *descriptorpb.FileDescriptorProto, files *protoregistry.Files) error {
+	l := &protoLinker{
+		files:  files,
+		local:  make(map[string]descriptorpb.FieldDescriptorProto_Type),
+		extras: new(protoregistry.Types),
+	}
+	scope := fdp.GetPackage()
+	for _, md := range fdp.GetMessageType() {
+		l.addMessageSymbols(scope, md)
+	}
+	for _, ed := range fdp.GetEnumType() {
+		l.local[qualifyName(scope, ed.GetName())] = descriptorpb.FieldDescriptorProto_TYPE_ENUM
+	}
+
+	for _, md := range fdp.GetMessageType() {
+		if err := l.linkMessage(scope, md); err != nil {
+			return err
+		}
+	}
+	for _, field := range fdp.GetExtension() {
+		if err := l.linkExtension(scope, field); err != nil {
+			return err
+		}
+	}
+	for _, sd := range fdp.GetService() {
+		for _, method := range sd.GetMethod() {
+			inputType, err := l.resolveMessageName(scope, method.GetInputType())
+			if err != nil {
+				return fmt.Errorf("input type of %s.%s: %w", sd.GetName(), method.GetName(), err)
+			}
+			outputType, err := l.resolveMessageName(scope, method.GetOutputType())
+			if err != nil {
+				return fmt.Errorf("output type of %s.%s: %w", sd.GetName(), method.GetName(), err)
+			}
+			method.InputType, method.OutputType = proto.String(inputType), proto.String(outputType)
+		}
+	}
+
+	return l.interpretFileOptions(fdp)
+}
+
+func (l *protoLinker) addMessageSymbols(scope string, md *descriptorpb.DescriptorProto) {
+	name := qualifyName(scope, md.GetName())
+	l.local[name] = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+	for _, nested := range md.GetNestedType() {
+		// make recursive call
+		l.addMessageSymbols(name, nested)
+	}
+	for _, ed := range md.GetEnumType() {
+		l.local[qualifyName(name, ed.GetName())] = descriptorpb.FieldDescriptorProto_TYPE_ENUM
+	}
+}
+
+func (l *protoLinker) linkMessage(scope string, md *descriptorpb.DescriptorProto) error {
+	name := qualifyName(scope, md.GetName())
+	for _, field := range md.GetField() {
+		if err := l.linkFieldType(name, field); err != nil {
+			return fmt.Errorf("field %s.%s: %w", name, field.GetName(), err)
+		}
+	}
+	for _, field := range md.GetExtension() {
+		if err := l.linkExtension(name, field); err != nil {
+			return err
+		}
+	}
+	for _, nested := range md.GetNestedType() {
+		// make recursive call
+		if err := l.linkMessage(name, nested); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *protoLinker) linkExtension(scope string, field *descriptorpb.FieldDescriptorProto) error {
+	extendee, err := l.resolveMessageName(scope, field.GetExtendee())
+	if err != nil {
+		return fmt.Errorf("extendee of %s: %w", qualifyName(scope, field.GetName()), err)
+	}
+	field.Extendee = proto.String(extendee)
+	if err := l.linkFieldType(scope, field); err != nil {
+		return fmt.Errorf("extension %s: %w", qualifyName(scope, field.GetName()), err)
+	}
+	return nil
+}
+
+// linkFieldType fully qualifies the type name of field and sets whether it is a message or an enum
+func (l *protoLinker) linkFieldType(scope string, field *descriptorpb.FieldDescriptorProto) error {
+	if field.TypeName == nil {
+		return nil
+	}
+	name, fieldType, err := l.resolveTypeName(scope, field.GetTypeName())
+	if err != nil {
+		return err
+	}
+	field.TypeName = proto.String(name)
+	if field.Type == nil {
+		field.Type = fieldType.Enum()
+	}
+	return nil
+}
+
+// resolveMessageName resolves name, which must be a message, from scope
+func (l *protoLinker) resolveMessageName(scope string, name string) (string, error) {
+	resolved, fieldType, err := l.resolveTypeName(scope, name)
+	if err != nil {
+		return "", err
+	}
+	if fieldType != descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+		return "", fmt.Errorf("%s is not a message", name)
+	}
+	return resolved, nil
+}
+
+// resolveTypeName resolves name used in scope to the fully qualified name, with a leading dot, of a message or enum,
+// searching from the innermost scope outwards as protoc does
+func (l *protoLinker) resolveTypeName(scope string, name string) (string, descriptorpb.FieldDescriptorProto_Type, error) {
+	if strings.HasPrefix(name, ".") {
+		if fieldType, ok := l.findType(name[1:]); ok {
+			return name, fieldType, nil
+		}
+		return "", 0, fmt.Errorf("unable to resolve type %s", name)
+	}
+	for {
+		candidate := qualifyName(scope, name)
+		if fieldType, ok := l.findType(candidate); ok {
+			return "." + candidate, fieldType, nil
+		}
+		if scope == "" {
+			return "", 0, fmt.Errorf("unable to resolve type %s", name)
+		}
+		if i := strings.LastIndex(scope, "."); i >= 0 {
+			scope = scope[:i]
+		} else {
+			scope = ""
+		}
+	}
+}
+
+// findType looks up the message or enum called fullName in the file being linked and then in its imports
+func (l *protoLinker) findType(fullName string) (descriptorpb.FieldDescriptorProto_Type, bool) {
+	if fieldType, ok := l.local[fullName]; ok {
+		return fieldType, true
+	}
+	d, err := l.files.FindDescriptorByName(protoreflect.FullName(fullName))
+	if err != nil {
+		return 0, false
+	}
+	switch d.(type) {
+	case protoreflect.MessageDescriptor:
+		return descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, true
+	case protoreflect.EnumDescriptor:
+		return descriptorpb.FieldDescriptorProto_TYPE_ENUM, true
+	}
+	return 0, false
+}
+
+// qualifyName joins scope and name
+func qualifyName(scope string, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+// interpretFileOptions interprets the uninterpreted options throughout fdp
+func (l *protoLinker) interpretFileOptions(fdp *descriptorpb.FileDescriptorProto) error {
+	if err := l.interpretOptions(fdp.GetOptions()); err != nil {
+		return err
+	}
+	for _, md := range fdp.GetMessageType() {
+		if err := l.interpretMessageOptions(md); err != nil {
+			return err
+		}
+	}
+	for _, ed := range fdp.GetEnumType() {
+		if err := l.interpretEnumOptions(ed); err != nil {
+			return err
+		}
+	}
+	for _, field := range fdp.GetExtension() {
+		if err := l.interpretOptions(field.GetOptions()); err != nil {
+			return err
+		}
+	}
+	for _, sd := range fdp.GetService() {
+		if err := l.interpretOptions(sd.GetOptions()); err != nil {
+			return err
+		}
+		for _, method := range sd.GetMethod() {
+			if err := l.interpretOptions(method.GetOptions()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (l *protoLinker) interpretMessageOptions(md *descriptorpb.DescriptorProto) error {
+	if err := l.interpretOptions(md.GetOptions()); err != nil {
+		return err
+	}
+	for _, field := range md.GetField() {
+		if err := l.interpretOptions(field.GetOptions()); err != nil {
+			return err
+		}
+	}
+	for _, field := range md.GetExtension() {
+		if err := l.interpretOptions(field.GetOptions()); err != nil {
+			return err
+		}
+	}
+	for _, oneof := range md.GetOneofDecl() {
+		if err := l.interpretOptions(oneof.GetOptions()); err != nil {
+			return err
+		}
+	}
+	for _, extensionRange := range md.GetExtensionRange() {
+		if err := l.interpretOptions(extensionRange.GetOptions()); err != nil {
+			return err
+		}
+	}
+	for _, nested := range md.GetNestedType() {
+		// make recursive call
+		if err := l.interpretMessageOptions(nested); err != nil {
+			return err
+		}
+	}
+	for _, ed := range md.GetEnumType() {
+		if err := l.interpretEnumOptions(ed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *protoLinker) interpretEnumOptions(ed *descriptorpb.EnumDescriptorProto) error {
+	if err := l.interpretOptions(ed.GetOptions()); err != nil {
+		return err
+	}
+	for _, value := range ed.GetValue() {
+		if err := l.interpretOptions(value.GetOptions()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// interpretOptions sets the fields of options from its uninterpreted options, those using unknown extensions are kept
+func (l *protoLinker) interpretOptions(options proto.Message) error {
+	if options == nil || !options.ProtoReflect().IsValid() {
+		return nil
+	}
+	m := options.ProtoReflect()
+	uninterpretedField := m.Descriptor().Fields().ByName("uninterpreted_option")
+	if uninterpretedField == nil || !m.Has(uninterpretedField) {
+		return nil
+	}
+
+	list := m.Get(uninterpretedField).List()
+	var remaining []protoreflect.Value
+	for i := 0; i < list.Len(); i++ {
+		option := list.Get(i).Message().Interface().(*descriptorpb.UninterpretedOption)
+		err := l.interpretOption(m, option)
+		if errors.Is(err, errUnknownExtension) {
+			remaining = append(remaining, list.Get(i))
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("option %s: %w", uninterpretedOptionDisplayName(option), err)
+		}
+	}
+
+	m.Clear(uninterpretedField)
+	if len(remaining) > 0 {
+		kept := m.Mutable(uninterpretedField).List()
+		for _, value := range remaining {
+			kept.Append(value)
+		}
+	}
+	return nil
+}
+
+func uninterpretedOptionDisplayName(option *descriptorpb.UninterpretedOption) string {
+	var parts []string
+	for _, part := range option.GetName() {
+		if part.GetIsExtension() {
+			parts = append(parts, "("+part.GetNamePart()+")")
+		} else {
+			parts = append(parts, part.GetNamePart())
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// interpretOption sets the field of m named by option to its value, following the name through nested messages
+func (l *protoLinker) interpretOption(m protoreflect.Message, option *descriptorpb.UninterpretedOption) error {
+	parts := option.GetName()
+	for i, part := range parts {
+		var fd protoreflect.FieldDescriptor
+		if part.GetIsExtension() {
+			xt := l.findExtension(m.Descriptor().FullName(), strings.TrimPrefix(part.GetNamePart(), "."))
+			if xt == nil {
+				return errUnknownExtension
+			}
+			fd = xt.TypeDescriptor()
+		} else {
+			fd = m.Descriptor().Fields().ByName(protoreflect.Name(part.GetNamePart()))
+			if fd == nil {
+				return fmt.Errorf("%s has no field %s", m.Descriptor().FullName(), part.GetNamePart())
+			}
+		}
+
+		if i < len(parts)-1 {
+			if fd.Message() == nil || fd.IsList() {
+				return fmt.Errorf("%s is not a singular message", fd.FullName())
+			}
+			m = m.Mutable(fd).Message()
+			continue
+		}
+
+		value, err := optionFieldValue(m, fd, option)
+		if err != nil {
+			return err
+		}
+		if fd.IsList() {
+			m.Mutable(fd).List().Append(value)
+		} else {
+			m.Set(fd, value)
+		}
+	}
+	return nil
+}
+
+// findExtension finds the extension of extendee called name, preferring generated types linked into this binary
+func (l *protoLinker) findExtension(extendee protoreflect.FullName, name string) protoreflect.ExtensionType {
+	fullName := protoreflect.FullName(name)
+	if xt, err := protoregistry.GlobalTypes.FindExtensionByName(fullName); err == nil && xt.TypeDescriptor().ContainingMessage().FullName() == extendee {
+		return xt
+	}
+	if xt, err := l.extras.FindExtensionByName(fullName); err == nil {
+		return xt
+	}
+	d, err := l.files.FindDescriptorByName(fullName)
+	if err != nil {
+		return nil
+	}
+	xd, ok := d.(protoreflect.ExtensionDescriptor)
+	if !ok || xd.ContainingMessage().FullName() != extendee {
+		return nil
+	}
+	xt := dynamicpb.NewExtensionType(xd)
+	// the same extension is usually set many times
+	_ = l.extras.RegisterExtension(xt)
+	return xt
+}
+
+// optionFieldValue converts the value of option to a value for fd, a field of m
+func optionFieldValue(m protoreflect.Message, fd protoreflect.FieldDescriptor, option *descriptorpb.UninterpretedOption) (protoreflect.Value, error) {
+	invalid := fmt.Errorf("invalid value for %s field %s", fd.Kind(), fd.FullName())
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		switch option.GetIdentifierValue() {
+		case "true":
+			return protoreflect.ValueOfBool(true), nil
+		case "false":
+			return protoreflect.ValueOfBool(false), nil
+		}
+	case protoreflect.EnumKind:
+		if option.IdentifierValue != nil {
+			if value := fd.Enum().Values().ByName(protoreflect.Name(option.GetIdentifierValue())); value != nil {
+				return protoreflect.ValueOfEnum(value.Number()), nil
+			}
+		}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		if value, ok := optionIntValue(option, math.MinInt32, math.MaxInt32); ok {
+			return protoreflect.ValueOfInt32(int32(value)), nil
+		}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		if value, ok := optionIntValue(option, math.MinInt64, math.MaxInt64); ok {
+			return protoreflect.ValueOfInt64(value), nil
+		}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		if option.PositiveIntValue != nil && option.GetPositiveIntValue() <= math.MaxUint32 {
+			return protoreflect.ValueOfUint32(uint32(option.GetPositiveIntValue())), nil
+		}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if option.PositiveIntValue != nil {
+			return protoreflect.ValueOfUint64(option.GetPositiveIntValue()), nil
+		}
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		var value float64
+		switch {
+		case option.DoubleValue != nil:
+			value = option.GetDoubleValue()
+		case option.PositiveIntValue != nil:
+			value = float64(option.GetPositiveIntValue())
+		case option.NegativeIntValue != nil:
+			value = float64(option.GetNegativeIntValue())
+		default:
+			return protoreflect.Value{}, invalid
+		}
+		if fd.Kind() == protoreflect.FloatKind {
+			return protoreflect.ValueOfFloat32(float32(value)), nil
+		}
+		return protoreflect.ValueOfFloat64(value), nil
+	case protoreflect.StringKind:
+		if option.StringValue != nil && utf8.Valid(option.GetStringValue()) {
+			return protoreflect.ValueOfString(string(option.GetStringValue())), nil
+		}
+	case protoreflect.BytesKind:
+		if option.StringValue != nil {
+			return protoreflect.ValueOfBytes(option.GetStringValue()), nil
+		}
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		if option.AggregateValue != nil {
+			var value protoreflect.Value
+			if fd.IsList() {
+				value = m.Mutable(fd).List().NewElement()
+			} else {
+				value = m.NewField(fd)
+			}
+			err := prototext.Unmarshal([]byte(option.GetAggregateValue()), value.Message().Interface())
+			if err != nil {
+				return protoreflect.Value{}, fmt.Errorf("invalid value for message field %s: %w", fd.FullName(), err)
+			}
+			return value, nil
+		}
+	}
+	return protoreflect.Value{}, invalid
+}
+
+// optionIntValue returns the integer value of option if it is between min and max
+func optionIntValue(option *descriptorpb.UninterpretedOption, min int64, max int64) (int64, bool) {
+	switch {
+	case option.PositiveIntValue != nil:
+		if option.GetPositiveIntValue() > uint64(max) {
+			return 0, false
+		}
+		return int64(option.GetPositiveIntValue()), true
+	case option.NegativeIntValue != nil:
+		return option.GetNegativeIntValue(), option.GetNegativeIntValue() >= min
+	}
+	return 0, false
+}
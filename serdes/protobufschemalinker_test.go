@@ -0,0 +1,238 @@
+package serdes
+
+import (
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"testing"
+)
+
+// registerProtoText parses, links and registers text as the file called path in files
+func registerProtoText(t *testing.T, path string, text string, files *protoregistry.Files) protoreflect.FileDescriptor {
+	fd := parseAndLinkProtoText(t, path, text, files)
+	err := files.RegisterFile(fd)
+	if err != nil {
+		t.Fatalf("unexpected error on RegisterFile: %s", err.Error())
+	}
+	return fd
+}
+
+// optionValues returns the option fields set in options, including extensions, by full name
+func optionValues(options proto.Message) map[protoreflect.FullName]protoreflect.Value {
+	values := make(map[protoreflect.FullName]protoreflect.Value)
+	options.ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		values[fd.FullName()] = v
+		return true
+	})
+	return values
+}
+
+func TestProtobufSchemaLinker_resolveTypeNames(t *testing.T) {
+	files := new(protoregistry.Files)
+	registerProtoText(t, "dep.proto", `
+syntax = "proto3";
+package test.link.dep;
+message Other {}
+`, files)
+	fd := registerProtoText(t, "main.proto", `
+syntax = "proto3";
+package test.link;
+import "dep.proto";
+message Outer {
+  message Inner {
+    Status status = 1;
+  }
+  enum Status {
+    OUTER = 0;
+  }
+  Inner inner = 1;
+  dep.Other other = 2;
+  .test.link.Status top_status = 3;
+}
+enum Status {
+  TOP = 0;
+}
+message Top {
+  Status status = 1;
+  Outer.Inner inner = 2;
+}
+service Lookup {
+  rpc Find (Top) returns (dep.Other);
+}
+`, files)
+
+	outer := fd.Messages().ByName("Outer")
+	top := fd.Messages().ByName("Top")
+	cases := []struct {
+		name  string
+		field protoreflect.FieldDescriptor
+		want  protoreflect.FullName
+	}{
+		{"innermost scope wins", outer.Messages().ByName("Inner").Fields().ByName("status"), "test.link.Outer.Status"},
+		{"nested message", outer.Fields().ByName("inner"), "test.link.Outer.Inner"},
+		{"sub package of an enclosing package", outer.Fields().ByName("other"), "test.link.dep.Other"},
+		{"fully qualified", outer.Fields().ByName("top_status"), "test.link.Status"},
+		{"package level enum", top.Fields().ByName("status"), "test.link.Status"},
+		{"dotted relative name", top.Fields().ByName("inner"), "test.link.Outer.Inner"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var got protoreflect.FullName
+			if c.field.Enum() != nil {
+				got = c.field.Enum().FullName()
+			} else {
+				got = c.field.Message().FullName()
+			}
+			if got != c.want {
+				t.Fatalf("type of %s == %s, want %s", c.field.FullName(), got, c.want)
+			}
+		})
+	}
+
+	method := fd.Services().ByName("Lookup").Methods().ByName("Find")
+	if method.Input().FullName() != "test.link.Top" || method.Output().FullName() != "test.link.dep.Other" {
+		t.Fatalf("Find(%s) returns %s, want Find(test.link.Top) returns test.link.dep.Other", method.Input().FullName(), method.Output().FullName())
+	}
+}
+
+func TestProtobufSchemaLinker_interpretOptions(t *testing.T) {
+	files := new(protoregistry.Files)
+	err := registerFileWithImports(descriptorpb.File_google_protobuf_descriptor_proto, files)
+	if err != nil {
+		t.Fatalf("unexpected error on registerFileWithImports: %s", err.Error())
+	}
+	registerProtoText(t, "options.proto", `
+syntax = "proto2";
+package test.opts;
+import "google/protobuf/descriptor.proto";
+message Meta {
+  optional string owner = 1;
+  repeated string tags = 2;
+}
+extend google.protobuf.FieldOptions {
+  optional string sensitivity = 50000;
+  optional Meta meta = 50001;
+}
+extend google.protobuf.MessageOptions {
+  repeated int32 versions = 50002;
+}
+`, files)
+	fd := registerProtoText(t, "uses.proto", `
+syntax = "proto3";
+package test.uses;
+import "options.proto";
+option java_package = "com.example.uses";
+message Record {
+  option deprecated = true;
+  option (test.opts.versions) = 1;
+  option (test.opts.versions) = 2;
+  string ssn = 1 [(test.opts.sensitivity) = "high", (test.opts.meta) = { owner: "team" tags: ["a", "b"] }];
+  string name = 2 [(test.opts.meta).owner = "other", (unknown.ext) = 5];
+}
+`, files)
+
+	fileOptions := fd.Options().(*descriptorpb.FileOptions)
+	if fileOptions.GetJavaPackage() != "com.example.uses" {
+		t.Fatalf("java_package == %s, want com.example.uses", fileOptions.GetJavaPackage())
+	}
+
+	record := fd.Messages().ByName("Record")
+	messageOptions := optionValues(record.Options())
+	if !messageOptions["google.protobuf.MessageOptions.deprecated"].Bool() {
+		t.Fatalf("deprecated == false, want true")
+	}
+	if versions := messageOptions["test.opts.versions"].List(); versions.Len() != 2 || versions.Get(1).Int() != 2 {
+		t.Fatalf("(test.opts.versions) has %d values, want [1 2]", versions.Len())
+	}
+
+	ssnOptions := optionValues(record.Fields().ByName("ssn").Options())
+	if got := ssnOptions["test.opts.sensitivity"].String(); got != "high" {
+		t.Fatalf("(test.opts.sensitivity) == %s, want high", got)
+	}
+	meta := optionValues(ssnOptions["test.opts.meta"].Message().Interface())
+	if meta["test.opts.Meta.owner"].String() != "team" || meta["test.opts.Meta.tags"].List().Len() != 2 {
+		t.Fatalf("(test.opts.meta) == %v, want owner team and tags [a b]", meta)
+	}
+
+	nameOptions := record.Fields().ByName("name").Options().(*descriptorpb.FieldOptions)
+	nameMeta := optionValues(optionValues(nameOptions)["test.opts.meta"].Message().Interface())
+	if nameMeta["test.opts.Meta.owner"].String() != "other" {
+		t.Fatalf("(test.opts.meta).owner == %v, want other", nameMeta["test.opts.Meta.owner"])
+	}
+	// options using extensions that cannot be found are kept as they were
+	if len(nameOptions.GetUninterpretedOption()) != 1 || uninterpretedOptionDisplayName(nameOptions.GetUninterpretedOption()[0]) != "(unknown.ext)" {
+		t.Fatalf("uninterpreted options == %v, want (unknown.ext)", nameOptions.GetUninterpretedOption())
+	}
+}
+
+func TestProtobufSchemaLinker_linkFileDescriptorProtoErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			"unknown type",
+			`syntax = "proto3"; package test; message A { Missing m = 1; }`,
+			"field test.A.m: unable to resolve type Missing",
+		},
+		{
+			"unknown fully qualified type",
+			`syntax = "proto3"; message A { .test.Missing m = 1; }`,
+			"field A.m: unable to resolve type .test.Missing",
+		},
+		{
+			"rpc with an enum",
+			`syntax = "proto3"; enum E { X = 0; } message A {} service S { rpc M (E) returns (A); }`,
+			"input type of S.M: E is not a message",
+		},
+		{
+			"unknown option",
+			`syntax = "proto3"; option no_such = true;`,
+			"option no_such: google.protobuf.FileOptions has no field no_such",
+		},
+		{
+			"option of the wrong type",
+			`syntax = "proto3"; option java_package = 5;`,
+			"option java_package: invalid value for string field google.protobuf.FileOptions.java_package",
+		},
+		{
+			"enum option with an unknown value",
+			`syntax = "proto3"; option optimize_for = FASTEST;`,
+			"option optimize_for: invalid value for enum field google.protobuf.FileOptions.optimize_for",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fdp, err := parseProtoText("test.proto", c.text)
+			if err != nil {
+				t.Fatalf("unexpected error on parseProtoText: %s", err.Error())
+			}
+			err = linkFileDescriptorProto(fdp, new(protoregistry.Files))
+			if err == nil || err.Error() != c.want {
+				t.Fatalf("linkFileDescriptorProto(%q) == %v, want %s", c.text, err, c.want)
+			}
+		})
+	}
+}
+
+// FuzzProtobufSchemaLinker_linkFileDescriptorProto checks linking whatever parses never panics
+func FuzzProtobufSchemaLinker_linkFileDescriptorProto(f *testing.F) {
+	for _, text := range []string{
+		testPaymentSchema,
+		`syntax = "proto3"; package test; message A { Missing m = 1; }`,
+		`syntax = "proto3"; enum E { X = 0; } message A {} service S { rpc M (E) returns (A); }`,
+		`syntax = "proto3"; option optimize_for = FASTEST;`,
+		`syntax = "proto3"; message A { message B { A a = 1; } B b = 1; map<string, B> m = 2; }`,
+	} {
+		f.Add(text)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		fdp, err := parseProtoText("fuzz.proto", text)
+		if err != nil {
+			return
+		}
+		_ = linkFileDescriptorProto(fdp, new(protoregistry.Files))
+	})
+}
@@ -0,0 +1,1261 @@
+package serdes
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// protoTokenKind the kind of a token in .proto source
+type protoTokenKind int
+
+const (
+	protoTokenEOF protoTokenKind = iota
+	protoTokenIdent
+	protoTokenInt
+	protoTokenFloat
+	protoTokenString
+	protoTokenSymbol
+)
+
+// protoToken a single token of .proto source
+type protoToken struct {
+	kind   protoTokenKind
+	text   string // the source text, or the unescaped value of a string
+	line   int
+	column int
+	offset int // offset of the first byte after the token, used to capture aggregate option values
+}
+
+// scalarFieldTypes map from .proto scalar type name to associated field descriptor type
+var scalarFieldTypes = map[string]descriptorpb.FieldDescriptorProto_Type{
+	"double":   descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	"float":    descriptorpb.FieldDescriptorProto_TYPE_FLOAT,
+	"int32":    descriptorpb.FieldDescriptorProto_TYPE_INT32,
+	"int64":    descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	"uint32":   descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+	"uint64":   descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+	"sint32":   descriptorpb.FieldDescriptorProto_TYPE_SINT32,
+	"sint64":   descriptorpb.FieldDescriptorProto_TYPE_SINT64,
+	"fixed32":  descriptorpb.FieldDescriptorProto_TYPE_FIXED32,
+	"fixed64":  descriptorpb.FieldDescriptorProto_TYPE_FIXED64,
+	"sfixed32": descriptorpb.FieldDescriptorProto_TYPE_SFIXED32,
+	"sfixed64": descriptorpb.FieldDescriptorProto_TYPE_SFIXED64,
+	"bool":     descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	"string":   descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	"bytes":    descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+}
+
+// protoParser parses .proto source into a file descriptor proto, type names are left as written and options are left
+// uninterpreted, as protoc does, until the file is linked against its imports with linkFileDescriptorProto
+type protoParser struct {
+	source string
+	tokens []protoToken
+	pos    int
+	proto3 bool
+}
+
+// parseProtoText parses the .proto source text into a file descriptor proto called path
+func parseProtoText(path string, text string) (*descriptorpb.FileDescriptorProto, error) {
+	tokens, err := tokenizeProto(text)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+	p := &protoParser{source: text, tokens: tokens}
+	fdp, err := p.parseFile()
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+	fdp.Name = proto.String(path)
+	return fdp, nil
+}
+
+// tokenizeProto splits .proto source into tokens, skipping whitespace and comments
+func tokenizeProto(text string) ([]protoToken, error) {
+	var tokens []protoToken
+	line, lineStart := 1, 0
+	for i := 0; i < len(text); {
+		c := text[i]
+		column := i - lineStart + 1
+		switch {
+		case c == '\n':
+			line++
+			i++
+			lineStart = i
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			i++
+		case strings.HasPrefix(text[i:], "//"):
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(text[i:], "/*"):
+			end := strings.Index(text[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d, column %d: unterminated comment", line, column)
+			}
+			comment := text[i : i+2+end+2]
+			if newlines := strings.Count(comment, "\n"); newlines > 0 {
+				line += newlines
+				lineStart = i + strings.LastIndex(comment, "\n") + 1
+			}
+			i += len(comment)
+		case isProtoLetter(c):
+			start := i
+			for i < len(text) && (isProtoLetter(text[i]) || isProtoDigit(text[i])) {
+				i++
+			}
+			tokens = append(tokens, protoToken{kind: protoTokenIdent, text: text[start:i], line: line, column: column, offset: i})
+		case isProtoDigit(c) || (c == '.' && i+1 < len(text) && isProtoDigit(text[i+1])):
+			start := i
+			hex := strings.HasPrefix(strings.ToLower(text[i:]), "0x")
+			for i < len(text) {
+				d := text[i]
+				if isProtoLetter(d) || isProtoDigit(d) || d == '.' {
+					i++
+					continue
+				}
+				// the sign of an exponent belongs to the number
+				if (d == '+' || d == '-') && !hex && (text[i-1] == 'e' || text[i-1] == 'E') {
+					i++
+					continue
+				}
+				break
+			}
+			number := text[start:i]
+			kind := protoTokenInt
+			if _, err := strconv.ParseUint(number, 0, 64); err != nil {
+				if _, err := strconv.ParseFloat(number, 64); err != nil || hex {
+					return nil, fmt.Errorf("line %d, column %d: invalid number %s", line, column, number)
+				}
+				kind = protoTokenFloat
+			}
+			tokens = append(tokens, protoToken{kind: kind, text: number, line: line, column: column, offset: i})
+		case c == '"' || c == '\'':
+			value, length, err := unquoteProtoString(text[i:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d, column %d: %w", line, column, err)
+			}
+			i += length
+			tokens = append(tokens, protoToken{kind: protoTokenString, text: value, line: line, column: column, offset: i})
+		default:
+			i++
+			tokens = append(tokens, protoToken{kind: protoTokenSymbol, text: string(c), line: line, column: column, offset: i})
+		}
+	}
+	return append(tokens, protoToken{kind: protoTokenEOF, line: line, column: len(text) - lineStart + 1, offset: len(text)}), nil
+}
+
+func isProtoLetter(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isProtoDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// unquoteProtoString unescapes the string literal at the start of s, returning its value and the length of the literal
+func unquoteProtoString(s string) (string, int, error) {
+	quote := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); {
+		c := s[i]
+		switch {
+		case c == quote:
+			return b.String(), i + 1, nil
+		case c == '\n':
+			return "", 0, fmt.Errorf("unterminated string")
+		case c != '\\':
+			b.WriteByte(c)
+			i++
+			continue
+		}
+		i++
+		if i >= len(s) {
+			break
+		}
+		c = s[i]
+		i++
+		switch c {
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case '\\', '\'', '"', '?':
+			b.WriteByte(c)
+		case 'x', 'X':
+			start := i
+			for i < len(s) && i < start+2 && strings.IndexByte("0123456789abcdefABCDEF", s[i]) >= 0 {
+				i++
+			}
+			value, err := strconv.ParseUint(s[start:i], 16, 8)
+			if err != nil {
+				return "", 0, fmt.Errorf("invalid hex escape in string")
+			}
+			b.WriteByte(byte(value))
+		case 'u', 'U':
+			digits := 4
+			if c == 'U' {
+				digits = 8
+			}
+			if i+digits > len(s) {
+				return "", 0, fmt.Errorf("invalid unicode escape in string")
+			}
+			value, err := strconv.ParseUint(s[i:i+digits], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(value)) {
+				return "", 0, fmt.Errorf("invalid unicode escape in string")
+			}
+			b.WriteRune(rune(value))
+			i += digits
+		default:
+			if c < '0' || c > '7' {
+				return "", 0, fmt.Errorf("invalid escape \\%c in string", c)
+			}
+			start := i - 1
+			for i < len(s) && i < start+3 && s[i] >= '0' && s[i] <= '7' {
+				i++
+			}
+			value, err := strconv.ParseUint(s[start:i], 8, 16)
+			if err != nil || value > math.MaxUint8 {
+				return "", 0, fmt.Errorf("invalid octal escape in string")
+			}
+			b.WriteByte(byte(value))
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+func (p *protoParser) peek() protoToken {
+	return p.tokens[p.pos]
+}
+
+// peekAfter returns the token after the next one, or the end of file token when there is none
+func (p *protoParser) peekAfter() protoToken {
+	if p.pos+1 < len(p.tokens) {
+		return p.tokens[p.pos+1]
+	}
+	return p.tokens[len(p.tokens)-1]
+}
+
+func (p *protoParser) next() protoToken {
+	token := p.tokens[p.pos]
+	if token.kind != protoTokenEOF {
+		p.pos++
+	}
+	return token
+}
+
+// errorf returns an error at the position of token
+func (p *protoParser) errorf(token protoToken, format string, args ...interface{}) error {
+	return fmt.Errorf("line %d, column %d: %s", token.line, token.column, fmt.Sprintf(format, args...))
+}
+
+// unexpected returns an error describing token when something else was expected
+func (p *protoParser) unexpected(token protoToken, expected string) error {
+	found := strconv.Quote(token.text)
+	switch token.kind {
+	case protoTokenEOF:
+		found = "end of file"
+	case protoTokenString:
+		found = "string " + quoteProtoString(token.text)
+	}
+	return p.errorf(token, "expected %s but found %s", expected, found)
+}
+
+// is reports whether the next token is the symbol or identifier text
+func (p *protoParser) is(text string) bool {
+	token := p.peek()
+	return (token.kind == protoTokenSymbol || token.kind == protoTokenIdent) && token.text == text
+}
+
+// accept consumes the next token if it is the symbol or identifier text
+func (p *protoParser) accept(text string) bool {
+	if p.is(text) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *protoParser) expect(text string) error {
+	if !p.accept(text) {
+		return p.unexpected(p.peek(), strconv.Quote(text))
+	}
+	return nil
+}
+
+func (p *protoParser) expectIdent() (string, error) {
+	token := p.next()
+	if token.kind != protoTokenIdent {
+		return "", p.unexpected(token, "identifier")
+	}
+	return token.text, nil
+}
+
+// expectFullIdent consumes a dotted name, such as a package name
+func (p *protoParser) expectFullIdent() (string, error) {
+	name, err := p.expectIdent()
+	if err != nil {
+		return "", err
+	}
+	for p.accept(".") {
+		part, err := p.expectIdent()
+		if err != nil {
+			return "", err
+		}
+		name += "." + part
+	}
+	return name, nil
+}
+
+// expectTypeName consumes a type name, which may be fully qualified with a leading dot
+func (p *protoParser) expectTypeName() (string, error) {
+	prefix := ""
+	if p.accept(".") {
+		prefix = "."
+	}
+	name, err := p.expectFullIdent()
+	if err != nil {
+		return "", err
+	}
+	return prefix + name, nil
+}
+
+// expectString consumes one or more adjacent string literals, which are concatenated
+func (p *protoParser) expectString() (string, error) {
+	token := p.next()
+	if token.kind != protoTokenString {
+		return "", p.unexpected(token, "string")
+	}
+	value := token.text
+	for p.peek().kind == protoTokenString {
+		value += p.next().text
+	}
+	return value, nil
+}
+
+// expectInt consumes an integer, which may be negative when signed is set
+func (p *protoParser) expectInt(signed bool, min int64, max int64) (int64, error) {
+	negative := signed && p.accept("-")
+	token := p.next()
+	if token.kind != protoTokenInt {
+		return 0, p.unexpected(token, "integer")
+	}
+	value, err := strconv.ParseUint(token.text, 0, 64)
+	if err != nil || value > math.MaxInt64 {
+		return 0, p.errorf(token, "integer %s out of range", token.text)
+	}
+	result := int64(value)
+	if negative {
+		result = -result
+	}
+	if result < min || result > max {
+		return 0, p.errorf(token, "integer %d out of range", result)
+	}
+	return result, nil
+}
+
+func (p *protoParser) parseFile() (*descriptorpb.FileDescriptorProto, error) {
+	fdp := &descriptorpb.FileDescriptorProto{}
+
+	if p.accept("syntax") {
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		token := p.peek()
+		syntax, err := p.expectString()
+		if err != nil {
+			return nil, err
+		}
+		switch syntax {
+		case "proto2":
+			// protoc leaves the syntax unset for proto2 files
+		case "proto3":
+			p.proto3 = true
+			fdp.Syntax = proto.String(syntax)
+		default:
+			return nil, p.errorf(token, "unsupported syntax %s", quoteProtoString(syntax))
+		}
+		if err := p.expect(";"); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		token := p.peek()
+		var err error
+		switch {
+		case token.kind == protoTokenEOF:
+			return fdp, nil
+		case p.accept(";"):
+		case p.accept("package"):
+			if fdp.Package != nil {
+				return nil, p.errorf(token, "multiple package statements")
+			}
+			var pkg string
+			pkg, err = p.expectFullIdent()
+			if err == nil {
+				fdp.Package = proto.String(pkg)
+				err = p.expect(";")
+			}
+		case p.accept("import"):
+			err = p.parseImport(fdp)
+		case p.accept("option"):
+			if fdp.Options == nil {
+				fdp.Options = &descriptorpb.FileOptions{}
+			}
+			err = p.parseOptionStatement(&fdp.Options.UninterpretedOption)
+		case p.accept("message"):
+			var md *descriptorpb.DescriptorProto
+			md, err = p.parseMessage()
+			fdp.MessageType = append(fdp.MessageType, md)
+		case p.accept("enum"):
+			var ed *descriptorpb.EnumDescriptorProto
+			ed, err = p.parseEnum()
+			fdp.EnumType = append(fdp.EnumType, ed)
+		case p.accept("extend"):
+			err = p.parseExtend(&fdp.Extension, &fdp.MessageType)
+		case p.accept("service"):
+			var sd *descriptorpb.ServiceDescriptorProto
+			sd, err = p.parseService()
+			fdp.Service = append(fdp.Service, sd)
+		default:
+			err = p.unexpected(token, "top level declaration")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *protoParser) parseImport(fdp *descriptorpb.FileDescriptorProto) error {
+	index := int32(len(fdp.Dependency))
+	switch {
+	case p.accept("public"):
+		fdp.PublicDependency = append(fdp.PublicDependency, index)
+	case p.accept("weak"):
+		fdp.WeakDependency = append(fdp.WeakDependency, index)
+	}
+	path, err := p.expectString()
+	if err != nil {
+		return err
+	}
+	fdp.Dependency = append(fdp.Dependency, path)
+	return p.expect(";")
+}
+
+func (p *protoParser) parseMessage() (*descriptorpb.DescriptorProto, error) {
+	name, err := p.expectIdent()
+	if err != nil {
+		return nil, err
+	}
+	md := &descriptorpb.DescriptorProto{Name: proto.String(name)}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	if err := p.parseMessageBody(md); err != nil {
+		return nil, err
+	}
+	return md, nil
+}
+
+// parseMessageBody parses the declarations of md up to and including the closing brace
+func (p *protoParser) parseMessageBody(md *descriptorpb.DescriptorProto) error {
+	for !p.accept("}") {
+		token := p.peek()
+		var err error
+		switch {
+		case token.kind == protoTokenEOF:
+			return p.unexpected(token, `"}"`)
+		case p.accept(";"):
+		case p.accept("option"):
+			if md.Options == nil {
+				md.Options = &descriptorpb.MessageOptions{}
+			}
+			err = p.parseOptionStatement(&md.Options.UninterpretedOption)
+		case p.accept("message"):
+			var nested *descriptorpb.DescriptorProto
+			// make recursive call
+			nested, err = p.parseMessage()
+			md.NestedType = append(md.NestedType, nested)
+		case p.accept("enum"):
+			var ed *descriptorpb.EnumDescriptorProto
+			ed, err = p.parseEnum()
+			md.EnumType = append(md.EnumType, ed)
+		case p.accept("extend"):
+			err = p.parseExtend(&md.Extension, &md.NestedType)
+		case p.accept("oneof"):
+			err = p.parseOneof(md)
+		case p.accept("reserved"):
+			err = p.parseMessageReserved(md)
+		case p.accept("extensions"):
+			err = p.parseExtensionRanges(md)
+		default:
+			var field *descriptorpb.FieldDescriptorProto
+			field, err = p.parseField(&md.NestedType, true)
+			md.Field = append(md.Field, field)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	addSyntheticOneofs(md)
+	return nil
+}
+
+// addSyntheticOneofs adds the oneofs that protoc generates for proto3 optional fields, after all the real oneofs
+func addSyntheticOneofs(md *descriptorpb.DescriptorProto) {
+	for _, field := range md.GetField() {
+		if field.GetProto3Optional() {
+			field.OneofIndex = proto.Int32(int32(len(md.OneofDecl)))
+			md.OneofDecl = append(md.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String("_" + field.GetName())})
+		}
+	}
+}
+
+// parseField parses a normal, map or group field, map entries and groups add their message to nestedTypes
+func (p *protoParser) parseField(nestedTypes *[]*descriptorpb.DescriptorProto, allowLabel bool) (*descriptorpb.FieldDescriptorProto, error) {
+	field := &descriptorpb.FieldDescriptorProto{Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()}
+	labelToken := p.peek()
+	explicitLabel := true
+	switch {
+	case p.accept("optional"):
+		if p.proto3 {
+			field.Proto3Optional = proto.Bool(true)
+		}
+	case p.accept("required"):
+		field.Label = descriptorpb.FieldDescriptorProto_LABEL_REQUIRED.Enum()
+	case p.accept("repeated"):
+		field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	default:
+		explicitLabel = false
+	}
+	if explicitLabel && !allowLabel {
+		return nil, p.errorf(labelToken, "fields in a oneof must not have labels")
+	}
+
+	// map<key, value> is a repeated field of a generated map entry message
+	var mapEntry *descriptorpb.DescriptorProto
+	if p.is("map") && p.peekAfter().text == "<" {
+		mapToken := p.next()
+		p.next()
+		if explicitLabel {
+			return nil, p.errorf(mapToken, "map fields must not have labels")
+		}
+		key, err := p.parseFieldType()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		value, err := p.parseFieldType()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(">"); err != nil {
+			return nil, err
+		}
+		key.Name, key.Number, key.JsonName = proto.String("key"), proto.Int32(1), proto.String("key")
+		value.Name, value.Number, value.JsonName = proto.String("value"), proto.Int32(2), proto.String("value")
+		mapEntry = &descriptorpb.DescriptorProto{
+			Field:   []*descriptorpb.FieldDescriptorProto{key, value},
+			Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+		}
+		field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+	} else if p.is("group") && p.peekAfter().kind == protoTokenIdent {
+		return p.parseGroup(field, nestedTypes)
+	} else {
+		fieldType, err := p.parseFieldType()
+		if err != nil {
+			return nil, err
+		}
+		field.Type, field.TypeName = fieldType.Type, fieldType.TypeName
+	}
+
+	name, err := p.expectIdent()
+	if err != nil {
+		return nil, err
+	}
+	field.Name = proto.String(name)
+	if mapEntry != nil {
+		mapEntry.Name = proto.String(mapEntryName(name))
+		field.TypeName = mapEntry.Name
+		*nestedTypes = append(*nestedTypes, mapEntry)
+	}
+	if err := p.expect("="); err != nil {
+		return nil, err
+	}
+	number, err := p.expectInt(false, 1, messageRangeMax-1)
+	if err != nil {
+		return nil, err
+	}
+	field.Number = proto.Int32(int32(number))
+	if err := p.parseFieldOptions(field); err != nil {
+		return nil, err
+	}
+	return field, p.expect(";")
+}
+
+// parseFieldType parses a scalar type or a message or enum type name into a field with only its type set
+func (p *protoParser) parseFieldType() (*descriptorpb.FieldDescriptorProto, error) {
+	field := &descriptorpb.FieldDescriptorProto{Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()}
+	typeName, err := p.expectTypeName()
+	if err != nil {
+		return nil, err
+	}
+	if scalarType, ok := scalarFieldTypes[typeName]; ok {
+		field.Type = scalarType.Enum()
+	} else {
+		// whether this is a message or an enum is only known once it is linked
+		field.TypeName = proto.String(typeName)
+	}
+	return field, nil
+}
+
+// parseGroup parses a proto2 group, which declares a nested message and a field of that type together
+func (p *protoParser) parseGroup(field *descriptorpb.FieldDescriptorProto, nestedTypes *[]*descriptorpb.DescriptorProto) (*descriptorpb.FieldDescriptorProto, error) {
+	p.next()
+	name, err := p.expectIdent()
+	if err != nil {
+		return nil, err
+	}
+	field.Name = proto.String(strings.ToLower(name))
+	field.Type = descriptorpb.FieldDescriptorProto_TYPE_GROUP.Enum()
+	field.TypeName = proto.String(name)
+	if err := p.expect("="); err != nil {
+		return nil, err
+	}
+	number, err := p.expectInt(false, 1, messageRangeMax-1)
+	if err != nil {
+		return nil, err
+	}
+	field.Number = proto.Int32(int32(number))
+	if err := p.parseFieldOptions(field); err != nil {
+		return nil, err
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	md := &descriptorpb.DescriptorProto{Name: proto.String(name)}
+	if err := p.parseMessageBody(md); err != nil {
+		return nil, err
+	}
+	*nestedTypes = append(*nestedTypes, md)
+	return field, nil
+}
+
+// mapEntryName the name protoc gives the map entry message of the map field called fieldName
+func mapEntryName(fieldName string) string {
+	name := jsonName(fieldName)
+	if name == "" {
+		return "Entry"
+	}
+	return strings.ToUpper(name[:1]) + name[1:] + "Entry"
+}
+
+// parseFieldOptions parses the optional square bracketed options of field, default and json_name are set on the field
+func (p *protoParser) parseFieldOptions(field *descriptorpb.FieldDescriptorProto) error {
+	if !p.accept("[") {
+		return nil
+	}
+	for {
+		switch {
+		case p.is("default") && p.peekAfter().text == "=":
+			p.pos += 2
+			value, err := p.parseDefaultValue(field)
+			if err != nil {
+				return err
+			}
+			field.DefaultValue = proto.String(value)
+		case p.is("json_name") && p.peekAfter().text == "=":
+			p.pos += 2
+			value, err := p.expectString()
+			if err != nil {
+				return err
+			}
+			field.JsonName = proto.String(value)
+		default:
+			option, err := p.parseOption()
+			if err != nil {
+				return err
+			}
+			if field.Options == nil {
+				field.Options = &descriptorpb.FieldOptions{}
+			}
+			field.Options.UninterpretedOption = append(field.Options.UninterpretedOption, option)
+		}
+		if p.accept("]") {
+			return nil
+		}
+		if !p.accept(",") {
+			return p.unexpected(p.peek(), `"," or "]"`)
+		}
+	}
+}
+
+// parseDefaultValue parses the default of field into the form used by the default_value of a field descriptor
+func (p *protoParser) parseDefaultValue(field *descriptorpb.FieldDescriptorProto) (string, error) {
+	if field.Type == nil {
+		// an enum default, the type is only known once the field is linked
+		return p.expectIdent()
+	}
+	token := p.peek()
+	switch field.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING:
+		return p.expectString()
+	case descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		value, err := p.expectString()
+		if err != nil {
+			return "", err
+		}
+		// bytes defaults are stored escaped
+		quoted := quoteProtoString(value)
+		return quoted[1 : len(quoted)-1], nil
+	case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
+		value, err := p.expectIdent()
+		if err != nil {
+			return "", err
+		}
+		if value != "true" && value != "false" {
+			return "", p.unexpected(token, "true or false")
+		}
+		return value, nil
+	case descriptorpb.FieldDescriptorProto_TYPE_FLOAT, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE:
+		negative := p.accept("-")
+		token = p.next()
+		var value float64
+		switch {
+		case token.kind == protoTokenIdent && (token.text == "inf" || token.text == "nan"):
+			value = math.Inf(1)
+			if token.text == "nan" {
+				value = math.NaN()
+			}
+		case token.kind == protoTokenInt || token.kind == protoTokenFloat:
+			parsed, err := parseProtoNumber(token.text)
+			if err != nil {
+				return "", p.errorf(token, "invalid number %s", token.text)
+			}
+			value = parsed
+		default:
+			return "", p.unexpected(token, "number")
+		}
+		if negative {
+			value = -value
+		}
+		return formatProtoFloat(value), nil
+	}
+	min, max := int64(math.MinInt64), int64(math.MaxInt64)
+	switch field.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_UINT64, descriptorpb.FieldDescriptorProto_TYPE_FIXED64:
+		// values above MaxInt64 are kept as written
+		if token.kind == protoTokenInt {
+			value, err := strconv.ParseUint(token.text, 0, 64)
+			if err == nil {
+				p.next()
+				return strconv.FormatUint(value, 10), nil
+			}
+		}
+		min = 0
+	case descriptorpb.FieldDescriptorProto_TYPE_UINT32, descriptorpb.FieldDescriptorProto_TYPE_FIXED32:
+		min, max = 0, math.MaxUint32
+	case descriptorpb.FieldDescriptorProto_TYPE_INT32, descriptorpb.FieldDescriptorProto_TYPE_SINT32, descriptorpb.FieldDescriptorProto_TYPE_SFIXED32:
+		min, max = math.MinInt32, math.MaxInt32
+	}
+	value, err := p.expectInt(true, min, max)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(value, 10), nil
+}
+
+// parseProtoNumber parses an integer or float literal as a float
+func parseProtoNumber(text string) (float64, error) {
+	if value, err := strconv.ParseUint(text, 0, 64); err == nil {
+		return float64(value), nil
+	}
+	return strconv.ParseFloat(text, 64)
+}
+
+// formatProtoFloat formats f the way protoc writes float defaults
+func formatProtoFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func (p *protoParser) parseOneof(md *descriptorpb.DescriptorProto) error {
+	name, err := p.expectIdent()
+	if err != nil {
+		return err
+	}
+	oneof := &descriptorpb.OneofDescriptorProto{Name: proto.String(name)}
+	index := int32(len(md.OneofDecl))
+	md.OneofDecl = append(md.OneofDecl, oneof)
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	for !p.accept("}") {
+		switch {
+		case p.peek().kind == protoTokenEOF:
+			return p.unexpected(p.peek(), `"}"`)
+		case p.accept(";"):
+		case p.accept("option"):
+			if oneof.Options == nil {
+				oneof.Options = &descriptorpb.OneofOptions{}
+			}
+			if err := p.parseOptionStatement(&oneof.Options.UninterpretedOption); err != nil {
+				return err
+			}
+		default:
+			field, err := p.parseField(&md.NestedType, false)
+			if err != nil {
+				return err
+			}
+			field.OneofIndex = proto.Int32(index)
+			md.Field = append(md.Field, field)
+		}
+	}
+	return nil
+}
+
+// parseRanges parses comma separated numbers and inclusive ranges, an end of max is returned as max
+func (p *protoParser) parseRanges(max int64, signed bool) ([][2]int64, error) {
+	var ranges [][2]int64
+	min := int64(1)
+	if signed {
+		min = math.MinInt32
+	}
+	for {
+		start, err := p.expectInt(signed, min, max)
+		if err != nil {
+			return nil, err
+		}
+		end := start
+		if p.accept("to") {
+			if p.accept("max") {
+				end = max
+			} else {
+				end, err = p.expectInt(signed, start, max)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		ranges = append(ranges, [2]int64{start, end})
+		if !p.accept(",") {
+			return ranges, nil
+		}
+	}
+}
+
+// parseReservedNames parses comma separated reserved names
+func (p *protoParser) parseReservedNames() ([]string, error) {
+	var names []string
+	for {
+		name, err := p.expectString()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if !p.accept(",") {
+			return names, nil
+		}
+	}
+}
+
+func (p *protoParser) parseMessageReserved(md *descriptorpb.DescriptorProto) error {
+	if p.peek().kind == protoTokenString {
+		names, err := p.parseReservedNames()
+		if err != nil {
+			return err
+		}
+		md.ReservedName = append(md.ReservedName, names...)
+		return p.expect(";")
+	}
+	ranges, err := p.parseRanges(messageRangeMax-1, false)
+	if err != nil {
+		return err
+	}
+	for _, r := range ranges {
+		// message ranges are end exclusive
+		md.ReservedRange = append(md.ReservedRange, &descriptorpb.DescriptorProto_ReservedRange{Start: proto.Int32(int32(r[0])), End: proto.Int32(int32(r[1] + 1))})
+	}
+	return p.expect(";")
+}
+
+func (p *protoParser) parseExtensionRanges(md *descriptorpb.DescriptorProto) error {
+	ranges, err := p.parseRanges(messageRangeMax-1, false)
+	if err != nil {
+		return err
+	}
+	var options *descriptorpb.ExtensionRangeOptions
+	if p.accept("[") {
+		options = &descriptorpb.ExtensionRangeOptions{}
+		for {
+			option, err := p.parseOption()
+			if err != nil {
+				return err
+			}
+			options.UninterpretedOption = append(options.UninterpretedOption, option)
+			if p.accept("]") {
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return err
+			}
+		}
+	}
+	for _, r := range ranges {
+		extensionRange := &descriptorpb.DescriptorProto_ExtensionRange{Start: proto.Int32(int32(r[0])), End: proto.Int32(int32(r[1] + 1))}
+		if options != nil {
+			extensionRange.Options = proto.Clone(options).(*descriptorpb.ExtensionRangeOptions)
+		}
+		md.ExtensionRange = append(md.ExtensionRange, extensionRange)
+	}
+	return p.expect(";")
+}
+
+func (p *protoParser) parseEnum() (*descriptorpb.EnumDescriptorProto, error) {
+	name, err := p.expectIdent()
+	if err != nil {
+		return nil, err
+	}
+	ed := &descriptorpb.EnumDescriptorProto{Name: proto.String(name)}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	for !p.accept("}") {
+		token := p.peek()
+		switch {
+		case token.kind == protoTokenEOF:
+			return nil, p.unexpected(token, `"}"`)
+		case p.accept(";"):
+		case p.accept("option"):
+			if ed.Options == nil {
+				ed.Options = &descriptorpb.EnumOptions{}
+			}
+			if err := p.parseOptionStatement(&ed.Options.UninterpretedOption); err != nil {
+				return nil, err
+			}
+		case p.accept("reserved"):
+			if p.peek().kind == protoTokenString {
+				names, err := p.parseReservedNames()
+				if err != nil {
+					return nil, err
+				}
+				ed.ReservedName = append(ed.ReservedName, names...)
+			} else {
+				ranges, err := p.parseRanges(enumRangeMax, true)
+				if err != nil {
+					return nil, err
+				}
+				for _, r := range ranges {
+					// enum ranges are inclusive
+					ed.ReservedRange = append(ed.ReservedRange, &descriptorpb.EnumDescriptorProto_EnumReservedRange{Start: proto.Int32(int32(r[0])), End: proto.Int32(int32(r[1]))})
+				}
+			}
+			if err := p.expect(";"); err != nil {
+				return nil, err
+			}
+		default:
+			value, err := p.parseEnumValue()
+			if err != nil {
+				return nil, err
+			}
+			ed.Value = append(ed.Value, value)
+		}
+	}
+	return ed, nil
+}
+
+func (p *protoParser) parseEnumValue() (*descriptorpb.EnumValueDescriptorProto, error) {
+	name, err := p.expectIdent()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("="); err != nil {
+		return nil, err
+	}
+	number, err := p.expectInt(true, math.MinInt32, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+	value := &descriptorpb.EnumValueDescriptorProto{Name: proto.String(name), Number: proto.Int32(int32(number))}
+	if p.accept("[") {
+		value.Options = &descriptorpb.EnumValueOptions{}
+		for {
+			option, err := p.parseOption()
+			if err != nil {
+				return nil, err
+			}
+			value.Options.UninterpretedOption = append(value.Options.UninterpretedOption, option)
+			if p.accept("]") {
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return value, p.expect(";")
+}
+
+// parseExtend parses an extend block, adding its fields to extensions and any groups to nestedTypes
+func (p *protoParser) parseExtend(extensions *[]*descriptorpb.FieldDescriptorProto, nestedTypes *[]*descriptorpb.DescriptorProto) error {
+	extendee, err := p.expectTypeName()
+	if err != nil {
+		return err
+	}
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	for !p.accept("}") {
+		switch {
+		case p.peek().kind == protoTokenEOF:
+			return p.unexpected(p.peek(), `"}"`)
+		case p.accept(";"):
+		default:
+			field, err := p.parseField(nestedTypes, true)
+			if err != nil {
+				return err
+			}
+			// extensions are never part of a synthetic oneof
+			field.Proto3Optional = nil
+			field.Extendee = proto.String(extendee)
+			*extensions = append(*extensions, field)
+		}
+	}
+	return nil
+}
+
+func (p *protoParser) parseService() (*descriptorpb.ServiceDescriptorProto, error) {
+	name, err := p.expectIdent()
+	if err != nil {
+		return nil, err
+	}
+	sd := &descriptorpb.ServiceDescriptorProto{Name: proto.String(name)}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	for !p.accept("}") {
+		token := p.peek()
+		switch {
+		case token.kind == protoTokenEOF:
+			return nil, p.unexpected(token, `"}"`)
+		case p.accept(";"):
+		case p.accept("option"):
+			if sd.Options == nil {
+				sd.Options = &descriptorpb.ServiceOptions{}
+			}
+			if err := p.parseOptionStatement(&sd.Options.UninterpretedOption); err != nil {
+				return nil, err
+			}
+		case p.accept("rpc"):
+			method, err := p.parseMethod()
+			if err != nil {
+				return nil, err
+			}
+			sd.Method = append(sd.Method, method)
+		default:
+			return nil, p.unexpected(token, "rpc")
+		}
+	}
+	return sd, nil
+}
+
+func (p *protoParser) parseMethod() (*descriptorpb.MethodDescriptorProto, error) {
+	name, err := p.expectIdent()
+	if err != nil {
+		return nil, err
+	}
+	method := &descriptorpb.MethodDescriptorProto{Name: proto.String(name)}
+
+	parseType := func() (string, bool, error) {
+		if err := p.expect("("); err != nil {
+			return "", false, err
+		}
+		// stream is only a keyword when a type name follows it, rather than ")" or a "." continuing a type called stream
+		following := p.peekAfter()
+		streaming := p.is("stream") && following.text != ")" && !(following.text == "." && following.offset-1 == p.peek().offset)
+		if streaming {
+			p.next()
+		}
+		typeName, err := p.expectTypeName()
+		if err != nil {
+			return "", false, err
+		}
+		return typeName, streaming, p.expect(")")
+	}
+
+	inputType, clientStreaming, err := parseType()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("returns"); err != nil {
+		return nil, err
+	}
+	outputType, serverStreaming, err := parseType()
+	if err != nil {
+		return nil, err
+	}
+	method.InputType, method.OutputType = proto.String(inputType), proto.String(outputType)
+	if clientStreaming {
+		method.ClientStreaming = proto.Bool(true)
+	}
+	if serverStreaming {
+		method.ServerStreaming = proto.Bool(true)
+	}
+
+	if p.accept("{") {
+		for !p.accept("}") {
+			switch {
+			case p.peek().kind == protoTokenEOF:
+				return nil, p.unexpected(p.peek(), `"}"`)
+			case p.accept(";"):
+			case p.accept("option"):
+				if method.Options == nil {
+					method.Options = &descriptorpb.MethodOptions{}
+				}
+				if err := p.parseOptionStatement(&method.Options.UninterpretedOption); err != nil {
+					return nil, err
+				}
+			default:
+				return nil, p.unexpected(p.peek(), "option")
+			}
+		}
+		p.accept(";")
+		return method, nil
+	}
+	return method, p.expect(";")
+}
+
+// parseOptionStatement parses the rest of an option statement, adding it to options
+func (p *protoParser) parseOptionStatement(options *[]*descriptorpb.UninterpretedOption) error {
+	option, err := p.parseOption()
+	if err != nil {
+		return err
+	}
+	*options = append(*options, option)
+	return p.expect(";")
+}
+
+// parseOption parses an option name and its value
+func (p *protoParser) parseOption() (*descriptorpb.UninterpretedOption, error) {
+	option := &descriptorpb.UninterpretedOption{}
+	for {
+		if p.accept("(") {
+			name, err := p.expectTypeName()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			option.Name = append(option.Name, &descriptorpb.UninterpretedOption_NamePart{NamePart: proto.String(name), IsExtension: proto.Bool(true)})
+		} else {
+			name, err := p.expectIdent()
+			if err != nil {
+				return nil, err
+			}
+			option.Name = append(option.Name, &descriptorpb.UninterpretedOption_NamePart{NamePart: proto.String(name), IsExtension: proto.Bool(false)})
+		}
+		if !p.accept(".") {
+			break
+		}
+	}
+	if err := p.expect("="); err != nil {
+		return nil, err
+	}
+
+	token := p.peek()
+	switch {
+	case token.kind == protoTokenString:
+		value, err := p.expectString()
+		if err != nil {
+			return nil, err
+		}
+		option.StringValue = []byte(value)
+	case p.accept("{"):
+		start := token.offset
+		depth := 1
+		for depth > 0 {
+			token := p.next()
+			switch {
+			case token.kind == protoTokenEOF:
+				return nil, p.unexpected(token, `"}"`)
+			case token.kind == protoTokenSymbol && (token.text == "{" || token.text == "<"):
+				depth++
+			case token.kind == protoTokenSymbol && (token.text == "}" || token.text == ">"):
+				depth--
+			}
+		}
+		end := p.tokens[p.pos-1].offset - 1
+		option.AggregateValue = proto.String(strings.TrimSpace(p.source[start:end]))
+	case token.kind == protoTokenIdent:
+		p.next()
+		option.IdentifierValue = proto.String(token.text)
+	default:
+		negative := p.accept("-")
+		if !negative {
+			p.accept("+")
+		}
+		token = p.next()
+		switch {
+		case token.kind == protoTokenInt:
+			value, err := strconv.ParseUint(token.text, 0, 64)
+			if err != nil {
+				return nil, p.errorf(token, "integer %s out of range", token.text)
+			}
+			if !negative {
+				option.PositiveIntValue = proto.Uint64(value)
+			} else if value <= math.MaxInt64+1 {
+				option.NegativeIntValue = proto.Int64(int64(-value))
+			} else {
+				return nil, p.errorf(token, "integer -%s out of range", token.text)
+			}
+		case token.kind == protoTokenFloat:
+			value, err := strconv.ParseFloat(token.text, 64)
+			if err != nil {
+				return nil, p.errorf(token, "invalid number %s", token.text)
+			}
+			if negative {
+				value = -value
+			}
+			option.DoubleValue = proto.Float64(value)
+		case token.kind == protoTokenIdent && (token.text == "inf" || token.text == "nan"):
+			value := math.Inf(1)
+			if token.text == "nan" {
+				value = math.NaN()
+			}
+			if negative {
+				value = -value
+			}
+			option.DoubleValue = proto.Float64(value)
+		default:
+			return nil, p.unexpected(token, "option value")
+		}
+	}
+	return option, nil
+}
@@ -0,0 +1,229 @@
+package serdes
+
+import (
+	"fmt"
+	"github.com/finncolman/kafka-go-serdes/internal/message"
+	"github.com/finncolman/kafka-go-serdes/internal/messagerefs"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"testing"
+)
+
+// parseAndLinkProtoText parses text into a file called path, then links and builds it against files
+func parseAndLinkProtoText(t *testing.T, path string, text string, files *protoregistry.Files) protoreflect.FileDescriptor {
+	fdp, err := parseProtoText(path, text)
+	if err != nil {
+		t.Fatalf("unexpected error on parseProtoText: %s", err.Error())
+	}
+	err = linkFileDescriptorProto(fdp, files)
+	if err != nil {
+		t.Fatalf("unexpected error on linkFileDescriptorProto: %s", err.Error())
+	}
+	fd, err := protodesc.NewFile(fdp, files)
+	if err != nil {
+		t.Fatalf("unexpected error on protodesc.NewFile: %s", err.Error())
+	}
+	return fd
+}
+
+func TestProtobufSchemaParser_parseProtoTextRoundTrip(t *testing.T) {
+	cases := []struct {
+		name string
+		fd   protoreflect.FileDescriptor
+	}{
+		{
+			"generated file",
+			(&message.MessageData{}).ProtoReflect().Descriptor().ParentFile(),
+		},
+		{
+			"generated file with imports",
+			(&messagerefs.MessageData{}).ProtoReflect().Descriptor().ParentFile(),
+		},
+		{
+			"proto2 file using every kind of declaration",
+			testFileDescriptor(t, testProto2FileDescriptorProto()),
+		},
+		{
+			"proto3 optional fields",
+			testFileDescriptor(t, testProto3FileDescriptorProto()),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			files := new(protoregistry.Files)
+			for i := 0; i < c.fd.Imports().Len(); i++ {
+				err := registerFileWithImports(c.fd.Imports().Get(i).FileDescriptor, files)
+				if err != nil {
+					t.Fatalf("unexpected error on registerFileWithImports: %s", err.Error())
+				}
+			}
+
+			text := fileDescriptorToProtoText(c.fd, false)
+			got := parseAndLinkProtoText(t, c.fd.Path(), text, files)
+
+			if gotText := fileDescriptorToProtoText(got, false); gotText != text {
+				t.Fatalf("rendering parsed %s == \n%s, want \n%s", c.fd.Path(), gotText, text)
+			}
+			want := protodesc.ToFileDescriptorProto(c.fd)
+			normalizeFileDescriptorProto(want)
+			gotProto := protodesc.ToFileDescriptorProto(got)
+			normalizeFileDescriptorProto(gotProto)
+			if !proto.Equal(gotProto, want) {
+				t.Fatalf("parseProtoText(%s) == %v, want %v", c.fd.Path(), gotProto, want)
+			}
+		})
+	}
+}
+
+func TestProtobufSchemaParser_parseProtoText(t *testing.T) {
+	text := `// comments are ignored
+syntax = "proto2";
+
+package test.parse;
+
+/* block comments
+   span lines */
+message Outer {
+  optional int32 hex = 1 [default = 0x10];
+  optional double ratio = 2 [default = -1.5e3];
+  optional string greeting = 3 [default = "hello " 'world'];
+  optional bytes raw = 4 [default = "\001\xff"];
+  repeated group Item = 5 {
+    optional string id = 1;
+  }
+  reserved 10 to 12;
+  extensions 100 to max;
+}
+`
+	fd := parseAndLinkProtoText(t, "test/parse.proto", text, new(protoregistry.Files))
+	outer := fd.Messages().ByName("Outer")
+
+	cases := []struct {
+		field string
+		want  string
+	}{
+		{"hex", "16"},
+		{"ratio", "-1500"},
+		{"greeting", "hello world"},
+		{"raw", "\x01\xff"},
+	}
+	for _, c := range cases {
+		fieldDesc := outer.Fields().ByName(protoreflect.Name(c.field))
+		got := fmt.Sprint(fieldDesc.Default().Interface())
+		if fieldDesc.Kind() == protoreflect.BytesKind {
+			got = string(fieldDesc.Default().Bytes())
+		}
+		if got != c.want {
+			t.Fatalf("default of %s == %q, want %q", c.field, got, c.want)
+		}
+	}
+
+	item := outer.Fields().ByName("item")
+	if item.Kind() != protoreflect.GroupKind || item.Message().FullName() != "test.parse.Outer.Item" || !item.IsList() {
+		t.Fatalf("field item == %v %v, want repeated group test.parse.Outer.Item", item.Cardinality(), item.Kind())
+	}
+	if got := outer.ReservedRanges().Get(0); got != [2]protoreflect.FieldNumber{10, 13} {
+		t.Fatalf("reserved range == %v, want [10 13]", got)
+	}
+	if got := outer.ExtensionRanges().Get(0); got != [2]protoreflect.FieldNumber{100, messageRangeMax} {
+		t.Fatalf("extension range == %v, want [100 %d]", got, messageRangeMax)
+	}
+}
+
+func TestProtobufSchemaParser_parseProtoTextErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			"unsupported syntax",
+			`syntax = "proto4";`,
+			`unable to parse test.proto: line 1, column 10: unsupported syntax "proto4"`,
+		},
+		{
+			"missing semicolon",
+			"syntax = \"proto3\";\nmessage A {\n  string a = 1\n}",
+			`unable to parse test.proto: line 4, column 1: expected ";" but found "}"`,
+		},
+		{
+			"unterminated message",
+			`syntax = "proto3"; message A {`,
+			`unable to parse test.proto: line 1, column 31: expected "}" but found end of file`,
+		},
+		{
+			"unterminated string",
+			`syntax = "proto3`,
+			`unable to parse test.proto: line 1, column 10: unterminated string`,
+		},
+		{
+			"unterminated comment",
+			"/* never closed",
+			`unable to parse test.proto: line 1, column 1: unterminated comment`,
+		},
+		{
+			"field number out of range",
+			`message A { optional int32 a = 0; }`,
+			`unable to parse test.proto: line 1, column 32: integer 0 out of range`,
+		},
+		{
+			"label in oneof",
+			`message A { oneof choice { optional int32 a = 1; } }`,
+			`unable to parse test.proto: line 1, column 28: fields in a oneof must not have labels`,
+		},
+		{
+			"invalid number",
+			`message A { optional int32 a = 1x; }`,
+			`unable to parse test.proto: line 1, column 32: invalid number 1x`,
+		},
+		{
+			"truncated rpc",
+			`syntax = "proto3"; service A{rpc A(`,
+			`unable to parse test.proto: line 1, column 36: expected identifier but found end of file`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := parseProtoText("test.proto", c.text)
+			if err == nil || err.Error() != c.want {
+				t.Fatalf("parseProtoText(%q) == %v, want %s", c.text, err, c.want)
+			}
+		})
+	}
+}
+
+// FuzzProtobufSchemaParser_parseProtoText checks parsing never panics, the text comes from Schema Registry so it cannot be trusted
+func FuzzProtobufSchemaParser_parseProtoText(f *testing.F) {
+	for _, text := range []string{
+		testPaymentSchema,
+		testMigrationSchemas[0],
+		`syntax = "proto3"; service A{rpc A(`,
+		`syntax = "proto2"; message A { map<string, int32> m = 1; optional group G = 2 { optional int32 a = 1; } optional int32 d = 3 [default = -1, json_name = "dd"]; }`,
+		`syntax = "proto3"; service S { rpc M (stream A) returns (stream .B) { option deprecated = true; } }`,
+		`option (custom) = { a: 1 b: "x" };`,
+	} {
+		f.Add(text)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		_, _ = parseProtoText("fuzz.proto", text)
+	})
+}
+
+func TestProtobufSchemaParser_mapEntryName(t *testing.T) {
+	cases := []struct {
+		fieldName string
+		want      string
+	}{
+		{"additional_data", "AdditionalDataEntry"},
+		{"counts", "CountsEntry"},
+		{"field_2", "Field2Entry"},
+	}
+	for _, c := range cases {
+		got := mapEntryName(c.fieldName)
+		if got != c.want {
+			t.Fatalf("mapEntryName(%s) == %s, want %s", c.fieldName, got, c.want)
+		}
+	}
+}
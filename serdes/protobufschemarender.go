@@ -0,0 +1,629 @@
+package serdes
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const (
+	// messageRangeMax exclusive end of the largest field number range, rendered as max
+	messageRangeMax = 536870912
+	// enumRangeMax inclusive end of the largest enum value range, rendered as max
+	enumRangeMax = math.MaxInt32
+	// indent used for each level of nesting
+	indent = "  "
+)
+
+// fileDescriptorToProtoText renders fd as .proto source for Schema Registry. The output parses back to the same
+// descriptor, but it is not checked against Confluent's Java ProtobufSchema, so the same file registered from Go and
+// from Java may not give the same schema string and Schema Registry may give it two versions.
+func fileDescriptorToProtoText(fd protoreflect.FileDescriptor, normalize bool) string {
+	fdp := protodesc.ToFileDescriptorProto(fd)
+	if normalize {
+		normalizeFileDescriptorProto(fdp)
+	}
+	return renderFileDescriptorProto(fdp)
+}
+
+// renderFileDescriptorProto renders fdp as .proto source
+func renderFileDescriptorProto(fdp *descriptorpb.FileDescriptorProto) string {
+	var b strings.Builder
+
+	syntax := fdp.GetSyntax()
+	if syntax == "" {
+		syntax = "proto2"
+	}
+	proto3 := syntax == "proto3"
+	b.WriteString(fmt.Sprintf("syntax = %s;\n", quoteProtoString(syntax)))
+	if fdp.Package != nil {
+		b.WriteString(fmt.Sprintf("package %s;\n", fdp.GetPackage()))
+	}
+
+	if len(fdp.GetDependency()) > 0 {
+		public := make(map[int32]bool)
+		for _, index := range fdp.GetPublicDependency() {
+			public[index] = true
+		}
+		weak := make(map[int32]bool)
+		for _, index := range fdp.GetWeakDependency() {
+			weak[index] = true
+		}
+		b.WriteString("\n")
+		for i, dep := range fdp.GetDependency() {
+			modifier := ""
+			if public[int32(i)] {
+				modifier = "public "
+			} else if weak[int32(i)] {
+				modifier = "weak "
+			}
+			b.WriteString(fmt.Sprintf("import %s%s;\n", modifier, quoteProtoString(dep)))
+		}
+	}
+
+	if options := renderOptionDeclarations(fdp.GetOptions()); len(options) > 0 {
+		b.WriteString("\n")
+		for _, option := range options {
+			b.WriteString(option)
+		}
+	}
+
+	// groups declared by extensions are rendered inside their extend block rather than as messages
+	groups := groupTypes(fdp.GetMessageType(), fdp.GetExtension())
+	for _, md := range fdp.GetMessageType() {
+		if groups[md.GetName()] != nil {
+			continue
+		}
+		b.WriteString("\n")
+		b.WriteString(renderMessage(md, proto3))
+	}
+	for _, ed := range fdp.GetEnumType() {
+		b.WriteString("\n")
+		b.WriteString(renderEnum(ed))
+	}
+	for _, extend := range renderExtends(fdp.GetExtension(), proto3, groups) {
+		b.WriteString("\n")
+		b.WriteString(extend)
+	}
+	for _, sd := range fdp.GetService() {
+		b.WriteString("\n")
+		b.WriteString(renderService(sd))
+	}
+
+	return b.String()
+}
+
+func renderMessage(md *descriptorpb.DescriptorProto, proto3 bool) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("message %s {", md.GetName()))
+
+	var reservedRanges [][2]int32
+	for _, reservedRange := range md.GetReservedRange() {
+		reservedRanges = append(reservedRanges, [2]int32{reservedRange.GetStart(), reservedRange.GetEnd() - 1})
+	}
+	if reserved := renderReserved(reservedRanges, md.GetReservedName(), messageRangeMax-1); reserved != "" {
+		b.WriteString("\n")
+		appendIndented(&b, reserved)
+	}
+
+	if options := renderOptionDeclarations(md.GetOptions()); len(options) > 0 {
+		b.WriteString("\n")
+		for _, option := range options {
+			appendIndented(&b, option)
+		}
+	}
+
+	// fields in real oneofs are rendered inside their oneof, synthetic oneofs only exist for proto3 optional fields
+	mapEntries := make(map[string]*descriptorpb.DescriptorProto)
+	for _, nested := range md.GetNestedType() {
+		if nested.GetOptions().GetMapEntry() {
+			mapEntries[nested.GetName()] = nested
+		}
+	}
+	groups := groupTypes(md.GetNestedType(), md.GetField(), md.GetExtension())
+	syntheticOneofs := make(map[int32]bool)
+	for _, field := range md.GetField() {
+		if field.GetProto3Optional() {
+			syntheticOneofs[field.GetOneofIndex()] = true
+		}
+	}
+	oneofFields := make(map[int32][]*descriptorpb.FieldDescriptorProto)
+	var fields []string
+	for _, field := range md.GetField() {
+		if field.OneofIndex != nil && !syntheticOneofs[field.GetOneofIndex()] {
+			oneofFields[field.GetOneofIndex()] = append(oneofFields[field.GetOneofIndex()], field)
+			continue
+		}
+		fields = append(fields, renderField(field, proto3, mapEntries, groups, true))
+	}
+	var oneofs []string
+	for i, oneof := range md.GetOneofDecl() {
+		if syntheticOneofs[int32(i)] {
+			continue
+		}
+		oneofs = append(oneofs, renderOneof(oneof, oneofFields[int32(i)], proto3, mapEntries, groups))
+	}
+	if len(fields) > 0 || len(oneofs) > 0 {
+		b.WriteString("\n")
+		for _, field := range fields {
+			appendIndented(&b, field)
+		}
+		for _, oneof := range oneofs {
+			appendIndented(&b, oneof)
+		}
+	}
+
+	if len(md.GetExtensionRange()) > 0 {
+		b.WriteString("\n")
+		for _, extensionRange := range md.GetExtensionRange() {
+			extensions := fmt.Sprintf("extensions %s", renderRange(extensionRange.GetStart(), extensionRange.GetEnd()-1, messageRangeMax-1))
+			if options := renderInlineOptions(extensionRange.GetOptions(), nil); options != "" {
+				extensions += " " + options
+			}
+			appendIndented(&b, extensions+";\n")
+		}
+	}
+
+	var nestedTypes []string
+	for _, nested := range md.GetNestedType() {
+		if nested.GetOptions().GetMapEntry() || groups[nested.GetName()] != nil {
+			continue
+		}
+		// make recursive call
+		nestedTypes = append(nestedTypes, renderMessage(nested, proto3))
+	}
+	for _, ed := range md.GetEnumType() {
+		nestedTypes = append(nestedTypes, renderEnum(ed))
+	}
+	nestedTypes = append(nestedTypes, renderExtends(md.GetExtension(), proto3, groups)...)
+	if len(nestedTypes) > 0 {
+		b.WriteString("\n")
+		for _, nested := range nestedTypes {
+			appendIndented(&b, nested)
+		}
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func renderOneof(oneof *descriptorpb.OneofDescriptorProto, fields []*descriptorpb.FieldDescriptorProto, proto3 bool, mapEntries map[string]*descriptorpb.DescriptorProto, groups map[string]*descriptorpb.DescriptorProto) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("oneof %s {", oneof.GetName()))
+	if options := renderOptionDeclarations(oneof.GetOptions()); len(options) > 0 {
+		b.WriteString("\n")
+		for _, option := range options {
+			appendIndented(&b, option)
+		}
+	}
+	if len(fields) > 0 {
+		b.WriteString("\n")
+		for _, field := range fields {
+			// fields in a oneof never have a label
+			appendIndented(&b, renderField(field, proto3, mapEntries, groups, false))
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// renderField renders field, groups are rendered with the group syntax so that they keep their wire encoding
+func renderField(field *descriptorpb.FieldDescriptorProto, proto3 bool, mapEntries map[string]*descriptorpb.DescriptorProto, groups map[string]*descriptorpb.DescriptorProto, withLabel bool) string {
+	var b strings.Builder
+	var group *descriptorpb.DescriptorProto
+	if field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_GROUP {
+		group = groups[lastNamePart(field.GetTypeName())]
+	}
+
+	entry := mapEntries[lastNamePart(field.GetTypeName())]
+	if field.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED && field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE && entry != nil {
+		var key, value *descriptorpb.FieldDescriptorProto
+		for _, entryField := range entry.GetField() {
+			switch entryField.GetNumber() {
+			case 1:
+				key = entryField
+			case 2:
+				value = entryField
+			}
+		}
+		b.WriteString(fmt.Sprintf("map<%s, %s> ", fieldTypeName(key), fieldTypeName(value)))
+	} else {
+		if withLabel {
+			switch field.GetLabel() {
+			case descriptorpb.FieldDescriptorProto_LABEL_REPEATED:
+				b.WriteString("repeated ")
+			case descriptorpb.FieldDescriptorProto_LABEL_REQUIRED:
+				b.WriteString("required ")
+			case descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL:
+				if !proto3 || field.GetProto3Optional() {
+					b.WriteString("optional ")
+				}
+			}
+		}
+		if group != nil {
+			b.WriteString(fmt.Sprintf("group %s = %d", group.GetName(), field.GetNumber()))
+		} else {
+			b.WriteString(fieldTypeName(field))
+			b.WriteString(" ")
+		}
+	}
+
+	if group == nil {
+		b.WriteString(fmt.Sprintf("%s = %d", field.GetName(), field.GetNumber()))
+	}
+
+	// default and json_name live on the field rather than in its options but are written as options
+	var pseudoOptions []string
+	if field.DefaultValue != nil {
+		pseudoOptions = append(pseudoOptions, "default = "+renderDefaultValue(field))
+	}
+	if field.JsonName != nil && field.GetJsonName() != jsonName(field.GetName()) {
+		pseudoOptions = append(pseudoOptions, "json_name = "+quoteProtoString(field.GetJsonName()))
+	}
+	if options := renderInlineOptions(field.GetOptions(), pseudoOptions); options != "" {
+		b.WriteString(" ")
+		b.WriteString(options)
+	}
+	if group != nil {
+		// the body of the group is the body of its message
+		b.WriteString(" ")
+		b.WriteString(strings.TrimPrefix(renderMessage(group, proto3), fmt.Sprintf("message %s ", group.GetName())))
+		return b.String()
+	}
+	b.WriteString(";\n")
+	return b.String()
+}
+
+// groupTypes returns the messages among types that are declared by group fields among fields, by name
+func groupTypes(types []*descriptorpb.DescriptorProto, fields ...[]*descriptorpb.FieldDescriptorProto) map[string]*descriptorpb.DescriptorProto {
+	byName := make(map[string]*descriptorpb.DescriptorProto)
+	for _, md := range types {
+		byName[md.GetName()] = md
+	}
+	groups := make(map[string]*descriptorpb.DescriptorProto)
+	for _, declared := range fields {
+		for _, field := range declared {
+			if field.GetType() != descriptorpb.FieldDescriptorProto_TYPE_GROUP {
+				continue
+			}
+			if md, ok := byName[lastNamePart(field.GetTypeName())]; ok {
+				groups[md.GetName()] = md
+			}
+		}
+	}
+	return groups
+}
+
+// fieldTypeName the scalar type name, or the fully qualified name of the message or enum, of field
+func fieldTypeName(field *descriptorpb.FieldDescriptorProto) string {
+	switch field.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, descriptorpb.FieldDescriptorProto_TYPE_ENUM, descriptorpb.FieldDescriptorProto_TYPE_GROUP:
+		return field.GetTypeName()
+	}
+	return strings.ToLower(strings.TrimPrefix(field.GetType().String(), "TYPE_"))
+}
+
+func renderDefaultValue(field *descriptorpb.FieldDescriptorProto) string {
+	switch field.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING:
+		return quoteProtoString(field.GetDefaultValue())
+	case descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		// bytes defaults are already escaped in the descriptor
+		return `"` + field.GetDefaultValue() + `"`
+	}
+	return field.GetDefaultValue()
+}
+
+func renderEnum(ed *descriptorpb.EnumDescriptorProto) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("enum %s {", ed.GetName()))
+
+	var reservedRanges [][2]int32
+	for _, reservedRange := range ed.GetReservedRange() {
+		// enum reserved ranges are inclusive, unlike message reserved ranges
+		reservedRanges = append(reservedRanges, [2]int32{reservedRange.GetStart(), reservedRange.GetEnd()})
+	}
+	if reserved := renderReserved(reservedRanges, ed.GetReservedName(), enumRangeMax); reserved != "" {
+		b.WriteString("\n")
+		appendIndented(&b, reserved)
+	}
+
+	if options := renderOptionDeclarations(ed.GetOptions()); len(options) > 0 {
+		b.WriteString("\n")
+		for _, option := range options {
+			appendIndented(&b, option)
+		}
+	}
+
+	if len(ed.GetValue()) > 0 {
+		b.WriteString("\n")
+		for _, value := range ed.GetValue() {
+			constant := fmt.Sprintf("%s = %d", value.GetName(), value.GetNumber())
+			if options := renderInlineOptions(value.GetOptions(), nil); options != "" {
+				constant += " " + options
+			}
+			appendIndented(&b, constant+";\n")
+		}
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// renderExtends renders one extend block per extended message, in the order they are first extended
+func renderExtends(extensions []*descriptorpb.FieldDescriptorProto, proto3 bool, groups map[string]*descriptorpb.DescriptorProto) []string {
+	var extendees []string
+	fields := make(map[string][]*descriptorpb.FieldDescriptorProto)
+	for _, extension := range extensions {
+		if _, ok := fields[extension.GetExtendee()]; !ok {
+			extendees = append(extendees, extension.GetExtendee())
+		}
+		fields[extension.GetExtendee()] = append(fields[extension.GetExtendee()], extension)
+	}
+
+	var extends []string
+	for _, extendee := range extendees {
+		var b strings.Builder
+		b.WriteString(fmt.Sprintf("extend %s {\n", extendee))
+		for _, field := range fields[extendee] {
+			appendIndented(&b, renderField(field, proto3, nil, groups, true))
+		}
+		b.WriteString("}\n")
+		extends = append(extends, b.String())
+	}
+	return extends
+}
+
+func renderService(sd *descriptorpb.ServiceDescriptorProto) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("service %s {", sd.GetName()))
+
+	if options := renderOptionDeclarations(sd.GetOptions()); len(options) > 0 {
+		b.WriteString("\n")
+		for _, option := range options {
+			appendIndented(&b, option)
+		}
+	}
+
+	if len(sd.GetMethod()) > 0 {
+		b.WriteString("\n")
+		for _, method := range sd.GetMethod() {
+			var rpc strings.Builder
+			rpc.WriteString(fmt.Sprintf("rpc %s (", method.GetName()))
+			if method.GetClientStreaming() {
+				rpc.WriteString("stream ")
+			}
+			rpc.WriteString(fmt.Sprintf("%s) returns (", method.GetInputType()))
+			if method.GetServerStreaming() {
+				rpc.WriteString("stream ")
+			}
+			rpc.WriteString(fmt.Sprintf("%s)", method.GetOutputType()))
+			if options := renderOptionDeclarations(method.GetOptions()); len(options) > 0 {
+				rpc.WriteString(" {\n")
+				for _, option := range options {
+					appendIndented(&rpc, option)
+				}
+				rpc.WriteString("}")
+			}
+			rpc.WriteString(";\n")
+			appendIndented(&b, rpc.String())
+		}
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// renderReserved renders the reserved inclusive ranges of numbers and the reserved names, max is the largest number allowed
+func renderReserved(ranges [][2]int32, names []string, max int32) string {
+	var b strings.Builder
+	if len(ranges) > 0 {
+		var values []string
+		for _, reservedRange := range ranges {
+			values = append(values, renderRange(reservedRange[0], reservedRange[1], max))
+		}
+		b.WriteString(fmt.Sprintf("reserved %s;\n", strings.Join(values, ", ")))
+	}
+	if len(names) > 0 {
+		var values []string
+		for _, name := range names {
+			values = append(values, quoteProtoString(name))
+		}
+		b.WriteString(fmt.Sprintf("reserved %s;\n", strings.Join(values, ", ")))
+	}
+	return b.String()
+}
+
+// renderRange renders the inclusive range from start to end, an end of max is rendered as max
+func renderRange(start int32, end int32, max int32) string {
+	switch {
+	case start == end:
+		return strconv.Itoa(int(start))
+	case end >= max:
+		return fmt.Sprintf("%d to max", start)
+	default:
+		return fmt.Sprintf("%d to %d", start, end)
+	}
+}
+
+// optionValue a single option name and rendered value
+type optionValue struct {
+	fd    protoreflect.FieldDescriptor
+	name  string
+	value string
+}
+
+// collectOptions returns every option set in options ordered by field number, repeated options give one entry per element
+func collectOptions(options proto.Message) []optionValue {
+	if options == nil || !options.ProtoReflect().IsValid() {
+		return nil
+	}
+	m := options.ProtoReflect()
+
+	var fields []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		// uninterpreted options only exist while a .proto file is being parsed
+		if fd.Name() != "uninterpreted_option" {
+			fields = append(fields, fd)
+		}
+		return true
+	})
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Number() < fields[j].Number()
+	})
+
+	var values []optionValue
+	for _, fd := range fields {
+		name := string(fd.Name())
+		if fd.IsExtension() {
+			name = "(" + string(fd.FullName()) + ")"
+		}
+		value := m.Get(fd)
+		if fd.IsList() {
+			list := value.List()
+			for i := 0; i < list.Len(); i++ {
+				values = append(values, optionValue{fd: fd, name: name, value: renderOptionValue(fd, list.Get(i))})
+			}
+			continue
+		}
+		values = append(values, optionValue{fd: fd, name: name, value: renderOptionValue(fd, value)})
+	}
+	return values
+}
+
+// renderOptionDeclarations renders options as option statements
+func renderOptionDeclarations(options proto.Message) []string {
+	var declarations []string
+	for _, option := range collectOptions(options) {
+		declarations = append(declarations, fmt.Sprintf("option %s = %s;\n", option.name, option.value))
+	}
+	return declarations
+}
+
+// renderInlineOptions renders pseudoOptions followed by options in square brackets, one per line when there are several
+func renderInlineOptions(options proto.Message, pseudoOptions []string) string {
+	inline := append([]string{}, pseudoOptions...)
+	for _, option := range collectOptions(options) {
+		inline = append(inline, fmt.Sprintf("%s = %s", option.name, option.value))
+	}
+	switch len(inline) {
+	case 0:
+		return ""
+	case 1:
+		return "[" + inline[0] + "]"
+	}
+	var b strings.Builder
+	b.WriteString("[\n")
+	for i, option := range inline {
+		if i < len(inline)-1 {
+			option += ","
+		}
+		appendIndented(&b, option+"\n")
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+// renderOptionValue renders a single option value as a .proto constant
+func renderOptionValue(fd protoreflect.FieldDescriptor, value protoreflect.Value) string {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return quoteProtoString(value.String())
+	case protoreflect.BytesKind:
+		return quoteProtoString(string(value.Bytes()))
+	case protoreflect.EnumKind:
+		if enumValue := fd.Enum().Values().ByNumber(value.Enum()); enumValue != nil {
+			return string(enumValue.Name())
+		}
+		return strconv.Itoa(int(value.Enum()))
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		f := value.Float()
+		switch {
+		case math.IsInf(f, 1):
+			return "inf"
+		case math.IsInf(f, -1):
+			return "-inf"
+		case math.IsNaN(f):
+			return "nan"
+		}
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		var parts []string
+		for _, option := range collectOptions(value.Message().Interface()) {
+			parts = append(parts, fmt.Sprintf("%s: %s", aggregateFieldName(option.fd), option.value))
+		}
+		if len(parts) == 0 {
+			return "{}"
+		}
+		return "{ " + strings.Join(parts, " ") + " }"
+	}
+	return value.String()
+}
+
+// aggregateFieldName the name of fd in the text format of an aggregate option value, where extensions are written in
+// square brackets and groups by the name of their message
+func aggregateFieldName(fd protoreflect.FieldDescriptor) string {
+	switch {
+	case fd.IsExtension():
+		return "[" + string(fd.FullName()) + "]"
+	case fd.Kind() == protoreflect.GroupKind:
+		return string(fd.Message().Name())
+	}
+	return string(fd.Name())
+}
+
+// quoteProtoString quotes s as a .proto string literal, escaping anything that is not printable ASCII
+func quoteProtoString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '"':
+			b.WriteString(`\"`)
+		case '\'':
+			b.WriteString(`\'`)
+		case '\\':
+			b.WriteString(`\\`)
+		default:
+			if c < 0x20 || c >= 0x7f {
+				b.WriteString(fmt.Sprintf(`\%03o`, c))
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// appendIndented appends every line of s to b indented by one level
+func appendIndented(b *strings.Builder, s string) {
+	for _, line := range strings.SplitAfter(s, "\n") {
+		if line == "" {
+			continue
+		}
+		if line != "\n" {
+			b.WriteString(indent)
+		}
+		b.WriteString(line)
+	}
+}
+
+// lastNamePart the last part of a dotted name such as a type name
+func lastNamePart(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
@@ -0,0 +1,439 @@
+package serdes
+
+import (
+	"github.com/finncolman/kafka-go-serdes/internal/message"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"testing"
+)
+
+func testFileDescriptor(t *testing.T, fdp *descriptorpb.FileDescriptorProto) protoreflect.FileDescriptor {
+	fd, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatalf("unexpected error on protodesc.NewFile: %s", err.Error())
+	}
+	return fd
+}
+
+// testProto2FileDescriptorProto builds test/item.proto, a proto2 file using every kind of declaration
+func testProto2FileDescriptorProto() *descriptorpb.FileDescriptorProto {
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	required := descriptorpb.FieldDescriptorProto_LABEL_REQUIRED.Enum()
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	stringType := descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+	int32Type := descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum()
+	int64Type := descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum()
+	boolType := descriptorpb.FieldDescriptorProto_TYPE_BOOL.Enum()
+	enumType := descriptorpb.FieldDescriptorProto_TYPE_ENUM.Enum()
+	messageType := descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+
+	return &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("test/item.proto"),
+		Package: proto.String("test"),
+		Options: &descriptorpb.FileOptions{
+			JavaPackage:       proto.String("com.example.test"),
+			JavaMultipleFiles: proto.Bool(true),
+		},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Item"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{Name: proto.String("name"), Number: proto.Int32(1), Label: required, Type: stringType},
+					{Name: proto.String("count"), Number: proto.Int32(3), Label: optional, Type: int32Type, DefaultValue: proto.String("10")},
+					{Name: proto.String("label"), Number: proto.Int32(4), Label: optional, Type: stringType, DefaultValue: proto.String(`a"b`), JsonName: proto.String("lbl")},
+					{Name: proto.String("values"), Number: proto.Int32(5), Label: repeated, Type: int32Type, Options: &descriptorpb.FieldOptions{Packed: proto.Bool(true)}},
+					{Name: proto.String("status"), Number: proto.Int32(6), Label: optional, Type: enumType, TypeName: proto.String(".test.Status"), DefaultValue: proto.String("ACTIVE"), Options: &descriptorpb.FieldOptions{Deprecated: proto.Bool(true)}},
+					{Name: proto.String("counts"), Number: proto.Int32(7), Label: repeated, Type: messageType, TypeName: proto.String(".test.Item.CountsEntry")},
+					{Name: proto.String("inner"), Number: proto.Int32(10), Label: optional, Type: messageType, TypeName: proto.String(".test.Item.Inner")},
+					{Name: proto.String("text"), Number: proto.Int32(8), Label: optional, Type: stringType, OneofIndex: proto.Int32(0)},
+					{Name: proto.String("number"), Number: proto.Int32(9), Label: optional, Type: int64Type, OneofIndex: proto.Int32(0)},
+				},
+				NestedType: []*descriptorpb.DescriptorProto{
+					{
+						Name: proto.String("CountsEntry"),
+						Field: []*descriptorpb.FieldDescriptorProto{
+							{Name: proto.String("key"), Number: proto.Int32(1), Label: optional, Type: stringType},
+							{Name: proto.String("value"), Number: proto.Int32(2), Label: optional, Type: int64Type},
+						},
+						Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+					},
+					{
+						Name: proto.String("Inner"),
+						Field: []*descriptorpb.FieldDescriptorProto{
+							{Name: proto.String("flag"), Number: proto.Int32(1), Label: optional, Type: boolType},
+						},
+					},
+				},
+				OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("choice")}},
+				ExtensionRange: []*descriptorpb.DescriptorProto_ExtensionRange{
+					{Start: proto.Int32(100), End: proto.Int32(200)},
+				},
+				ReservedRange: []*descriptorpb.DescriptorProto_ReservedRange{
+					{Start: proto.Int32(2), End: proto.Int32(3)},
+					{Start: proto.Int32(15), End: proto.Int32(18)},
+					{Start: proto.Int32(1000), End: proto.Int32(messageRangeMax)},
+				},
+				ReservedName: []string{"old"},
+				Options:      &descriptorpb.MessageOptions{Deprecated: proto.Bool(true)},
+			},
+		},
+		EnumType: []*descriptorpb.EnumDescriptorProto{
+			{
+				Name: proto.String("Status"),
+				Value: []*descriptorpb.EnumValueDescriptorProto{
+					{Name: proto.String("UNKNOWN"), Number: proto.Int32(0)},
+					{Name: proto.String("ACTIVE"), Number: proto.Int32(1)},
+					{Name: proto.String("ENABLED"), Number: proto.Int32(1), Options: &descriptorpb.EnumValueOptions{Deprecated: proto.Bool(true)}},
+				},
+				Options: &descriptorpb.EnumOptions{AllowAlias: proto.Bool(true)},
+				ReservedRange: []*descriptorpb.EnumDescriptorProto_EnumReservedRange{
+					{Start: proto.Int32(10), End: proto.Int32(20)},
+				},
+			},
+		},
+		Extension: []*descriptorpb.FieldDescriptorProto{
+			{Name: proto.String("note"), Number: proto.Int32(100), Label: optional, Type: stringType, Extendee: proto.String(".test.Item")},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{
+			{
+				Name: proto.String("ItemService"),
+				Method: []*descriptorpb.MethodDescriptorProto{
+					{Name: proto.String("Get"), InputType: proto.String(".test.Item"), OutputType: proto.String(".test.Item")},
+					{
+						Name:            proto.String("Watch"),
+						InputType:       proto.String(".test.Item"),
+						OutputType:      proto.String(".test.Item"),
+						ClientStreaming: proto.Bool(true),
+						ServerStreaming: proto.Bool(true),
+						Options:         &descriptorpb.MethodOptions{Deprecated: proto.Bool(true)},
+					},
+				},
+			},
+		},
+	}
+}
+
+// testProto3FileDescriptorProto builds test/optional.proto, a proto3 file with an optional field and an import
+func testProto3FileDescriptorProto() *descriptorpb.FileDescriptorProto {
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	stringType := descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+	messageType := descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+
+	return &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("test/optional.proto"),
+		Package:    proto.String("test.optional"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/descriptor.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Event"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{Name: proto.String("id"), Number: proto.Int32(1), Label: optional, Type: stringType, OneofIndex: proto.Int32(0), Proto3Optional: proto.Bool(true)},
+					{Name: proto.String("tags"), Number: proto.Int32(2), Label: repeated, Type: stringType},
+					{Name: proto.String("options"), Number: proto.Int32(3), Label: optional, Type: messageType, TypeName: proto.String(".google.protobuf.FileOptions")},
+				},
+				OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("_id")}},
+			},
+		},
+	}
+}
+
+func TestProtobufSchemaRender_fileDescriptorToProtoText(t *testing.T) {
+	cases := []struct {
+		name string
+		fd   protoreflect.FileDescriptor
+		want string
+	}{
+		{
+			"generated proto3 file",
+			(&message.MessageData{}).ProtoReflect().Descriptor().ParentFile(),
+			`syntax = "proto3";
+package message;
+
+option go_package = "internal/message";
+
+message Nested1 {
+  int64 message_id = 1;
+  string test = 2;
+  string test_2 = 3;
+}
+
+message Nested2 {
+  string id = 1;
+  string another = 2;
+  int64 another_message = 3;
+  map<string, string> additional_data = 4;
+  string another_part = 5;
+}
+
+message MessageData {
+  .message.Nested1 nest1 = 1;
+  .message.Nested2 nest2 = 2;
+}
+`,
+		},
+		{
+			"proto2 file using every kind of declaration",
+			testFileDescriptor(t, testProto2FileDescriptorProto()),
+			`syntax = "proto2";
+package test;
+
+option java_package = "com.example.test";
+option java_multiple_files = true;
+
+message Item {
+  reserved 2, 15 to 17, 1000 to max;
+  reserved "old";
+
+  option deprecated = true;
+
+  required string name = 1;
+  optional int32 count = 3 [default = 10];
+  optional string label = 4 [
+    default = "a\"b",
+    json_name = "lbl"
+  ];
+  repeated int32 values = 5 [packed = true];
+  optional .test.Status status = 6 [
+    default = ACTIVE,
+    deprecated = true
+  ];
+  map<string, int64> counts = 7;
+  optional .test.Item.Inner inner = 10;
+  oneof choice {
+    string text = 8;
+    int64 number = 9;
+  }
+
+  extensions 100 to 199;
+
+  message Inner {
+    optional bool flag = 1;
+  }
+}
+
+enum Status {
+  reserved 10 to 20;
+
+  option allow_alias = true;
+
+  UNKNOWN = 0;
+  ACTIVE = 1;
+  ENABLED = 1 [deprecated = true];
+}
+
+extend .test.Item {
+  optional string note = 100;
+}
+
+service ItemService {
+  rpc Get (.test.Item) returns (.test.Item);
+  rpc Watch (stream .test.Item) returns (stream .test.Item) {
+    option deprecated = true;
+  };
+}
+`,
+		},
+		{
+			"proto3 optional fields and imports",
+			testFileDescriptor(t, testProto3FileDescriptorProto()),
+			`syntax = "proto3";
+package test.optional;
+
+import "google/protobuf/descriptor.proto";
+
+message Event {
+  optional string id = 1;
+  repeated string tags = 2;
+  .google.protobuf.FileOptions options = 3;
+}
+`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := fileDescriptorToProtoText(c.fd, false)
+			if got != c.want {
+				t.Fatalf("fileDescriptorToProtoText(%s) == \n%s, want \n%s", c.fd.Path(), got, c.want)
+			}
+		})
+	}
+}
+
+// testLinkProtoText parses and links text as path against files, and registers the result in files
+func testLinkProtoText(t *testing.T, path string, text string, files *protoregistry.Files, options func(*descriptorpb.FileDescriptorProto)) protoreflect.FileDescriptor {
+	fdp, err := parseProtoText(path, text)
+	if err != nil {
+		t.Fatalf("unexpected error on parseProtoText: %s", err.Error())
+	}
+	err = linkFileDescriptorProto(fdp, files)
+	if err != nil {
+		t.Fatalf("unexpected error on linkFileDescriptorProto: %s", err.Error())
+	}
+	if options != nil {
+		options(fdp)
+	}
+	fd, err := protodesc.NewFile(fdp, files)
+	if err != nil {
+		t.Fatalf("unexpected error on protodesc.NewFile: %s", err.Error())
+	}
+	err = files.RegisterFile(fd)
+	if err != nil {
+		t.Fatalf("unexpected error on RegisterFile: %s", err.Error())
+	}
+	return fd
+}
+
+func TestProtobufSchemaRender_fileDescriptorToProtoTextGroupsAndAggregates(t *testing.T) {
+	files := new(protoregistry.Files)
+	descriptorFd, err := protoregistry.GlobalFiles.FindFileByPath("google/protobuf/descriptor.proto")
+	if err != nil {
+		t.Fatalf("unexpected error on FindFileByPath: %s", err.Error())
+	}
+	err = files.RegisterFile(descriptorFd)
+	if err != nil {
+		t.Fatalf("unexpected error on RegisterFile: %s", err.Error())
+	}
+	optionsFd := testLinkProtoText(t, "test/config.proto", `syntax = "proto2";
+package test.config;
+import "google/protobuf/descriptor.proto";
+message Config {
+  optional string name = 1;
+  extensions 100 to 200;
+}
+extend Config {
+  optional string region = 100;
+}
+extend google.protobuf.MessageOptions {
+  optional Config config = 50000;
+}
+`, files, nil)
+	configExt := dynamicpb.NewExtensionType(optionsFd.Extensions().ByName("config"))
+	regionExt := dynamicpb.NewExtensionType(optionsFd.Extensions().ByName("region"))
+	configMd := optionsFd.Messages().ByName("Config")
+
+	fd := testLinkProtoText(t, "test/groups.proto", `syntax = "proto2";
+package test.groups;
+import "test/config.proto";
+message Search {
+  repeated group Result = 1 {
+    optional string url = 2;
+  }
+  oneof choice {
+    group Page = 3 {
+      optional int32 number = 4;
+    }
+  }
+  extensions 100 to 200;
+}
+message Empty {
+}
+extend Search {
+  optional group Extra = 100 {
+    optional string note = 1;
+  }
+}
+`, files, func(fdp *descriptorpb.FileDescriptorProto) {
+		config := dynamicpb.NewMessage(configMd)
+		config.Set(configMd.Fields().ByName("name"), protoreflect.ValueOfString("search"))
+		config.Set(regionExt.TypeDescriptor(), protoreflect.ValueOfString("eu"))
+		searchOptions := &descriptorpb.MessageOptions{}
+		searchOptions.ProtoReflect().Set(configExt.TypeDescriptor(), protoreflect.ValueOfMessage(config))
+		fdp.GetMessageType()[0].Options = searchOptions
+
+		emptyOptions := &descriptorpb.MessageOptions{}
+		emptyOptions.ProtoReflect().Set(configExt.TypeDescriptor(), protoreflect.ValueOfMessage(dynamicpb.NewMessage(configMd)))
+		fdp.GetMessageType()[1].Options = emptyOptions
+	})
+
+	want := `syntax = "proto2";
+package test.groups;
+
+import "test/config.proto";
+
+message Search {
+  option (test.config.config) = { name: "search" [test.config.region]: "eu" };
+
+  repeated group Result = 1 {
+    optional string url = 2;
+  }
+  oneof choice {
+    group Page = 3 {
+      optional int32 number = 4;
+    }
+  }
+
+  extensions 100 to 200;
+}
+
+message Empty {
+  option (test.config.config) = {};
+}
+
+extend .test.groups.Search {
+  optional group Extra = 100 {
+    optional string note = 1;
+  }
+}
+`
+	got := fileDescriptorToProtoText(fd, false)
+	if got != want {
+		t.Fatalf("fileDescriptorToProtoText(%s) == \n%s, want \n%s", fd.Path(), got, want)
+	}
+
+	// the groups keep their wire encoding when the rendered text is parsed again
+	fdp, err := parseProtoText(fd.Path(), got)
+	if err != nil {
+		t.Fatalf("unexpected error on parseProtoText: %s", err.Error())
+	}
+	groups := append(append([]*descriptorpb.FieldDescriptorProto{}, fdp.GetMessageType()[0].GetField()...), fdp.GetExtension()...)
+	for _, field := range groups {
+		if field.GetType() != descriptorpb.FieldDescriptorProto_TYPE_GROUP {
+			t.Fatalf("type of %s == %s, want %s", field.GetName(), field.GetType(), descriptorpb.FieldDescriptorProto_TYPE_GROUP)
+		}
+	}
+}
+
+func TestProtobufSchemaRender_fileDescriptorToProtoTextNormalize(t *testing.T) {
+	build := func(deps ...string) protoreflect.FileDescriptor {
+		return testFileDescriptor(t, &descriptorpb.FileDescriptorProto{
+			Name:       proto.String("test/imports.proto"),
+			Syntax:     proto.String("proto3"),
+			Dependency: deps,
+		})
+	}
+	a := build("message.proto", "google/protobuf/descriptor.proto")
+	b := build("google/protobuf/descriptor.proto", "message.proto")
+
+	if fileDescriptorToProtoText(a, false) == fileDescriptorToProtoText(b, false) {
+		t.Fatalf("fileDescriptorToProtoText(a, false) == fileDescriptorToProtoText(b, false), want different schemas")
+	}
+	if got, want := fileDescriptorToProtoText(a, true), fileDescriptorToProtoText(b, true); got != want {
+		t.Fatalf("fileDescriptorToProtoText(a, true) == %q, want %q", got, want)
+	}
+}
+
+func TestProtobufSchemaRender_quoteProtoString(t *testing.T) {
+	cases := []struct {
+		s    string
+		want string
+	}{
+		{"plain", `"plain"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{"it's", `"it\'s"`},
+		{`back\slash`, `"back\\slash"`},
+		{"tab\tnew\nline", `"tab\tnew\nline"`},
+		{"\x00\xff", `"\000\377"`},
+	}
+	for _, c := range cases {
+		got := quoteProtoString(c.s)
+		if got != c.want {
+			t.Fatalf("quoteProtoString(%q) == %s, want %s", c.s, got, c.want)
+		}
+	}
+}
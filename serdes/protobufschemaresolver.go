@@ -0,0 +1,251 @@
+package serdes
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sync"
+
+	"github.com/riferrei/srclient"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// protobufSchemaResolver builds file descriptors, including their references, from schemas registered in Schema Registry
+type protobufSchemaResolver struct {
+	client    srclient.ISchemaRegistryClient
+	files     map[int]protoreflect.FileDescriptor // map from schema ID to associated file descriptor
+	filesLock sync.RWMutex
+	guids     map[string]int // map from schema GUID to associated schema ID
+	guidsLock sync.RWMutex
+}
+
+func newProtobufSchemaResolver(schemaRegistryClient srclient.ISchemaRegistryClient) *protobufSchemaResolver {
+	return &protobufSchemaResolver{
+		client: schemaRegistryClient,
+		files:  make(map[int]protoreflect.FileDescriptor),
+		guids:  make(map[string]int),
+	}
+}
+
+// fileDescriptorByID returns the file descriptor for the schema registered with schemaID
+func (r *protobufSchemaResolver) fileDescriptorByID(ctx context.Context, schemaID int) (protoreflect.FileDescriptor, error) {
+	r.filesLock.RLock()
+	fd, ok := r.files[schemaID]
+	r.filesLock.RUnlock()
+	if ok {
+		return fd, nil
+	}
+
+	theSchema, err := callRegistry(ctx, func() (*srclient.Schema, error) {
+		return r.client.GetSchema(schemaID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.fileDescriptorForSchema(ctx, theSchema)
+}
+
+// fileDescriptorByGUID returns the file descriptor for the schema with guid, the client must implement GUIDSchemaRegistryClient
+func (r *protobufSchemaResolver) fileDescriptorByGUID(ctx context.Context, guid string) (protoreflect.FileDescriptor, error) {
+	r.guidsLock.RLock()
+	schemaID, ok := r.guids[guid]
+	r.guidsLock.RUnlock()
+	if ok {
+		return r.fileDescriptorByID(ctx, schemaID)
+	}
+
+	guidClient, ok := r.client.(GUIDSchemaRegistryClient)
+	if !ok {
+		return nil, fmt.Errorf("schema GUID %s cannot be resolved, the Schema Registry client does not implement GUIDSchemaRegistryClient", guid)
+	}
+	theSchema, err := callRegistry(ctx, func() (*srclient.Schema, error) {
+		return guidClient.GetSchemaByGUID(guid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	fd, err := r.fileDescriptorForSchema(ctx, theSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	r.guidsLock.Lock()
+	r.guids[guid] = theSchema.ID()
+	r.guidsLock.Unlock()
+
+	return fd, nil
+}
+
+// schemaID returns the ID of schema, looking up the schema a GUID belongs to on first use
+func (r *protobufSchemaResolver) schemaID(ctx context.Context, schema schemaIdentifier) (int, error) {
+	if schema.guid == "" {
+		return schema.id, nil
+	}
+	_, err := r.fileDescriptorByGUID(ctx, schema.guid)
+	if err != nil {
+		return 0, err
+	}
+	r.guidsLock.RLock()
+	defer r.guidsLock.RUnlock()
+	return r.guids[schema.guid], nil
+}
+
+// fileDescriptorForSchema returns the file descriptor for theSchema, which has already been fetched from Schema Registry
+func (r *protobufSchemaResolver) fileDescriptorForSchema(ctx context.Context, theSchema *srclient.Schema) (protoreflect.FileDescriptor, error) {
+	r.filesLock.RLock()
+	fd, ok := r.files[theSchema.ID()]
+	r.filesLock.RUnlock()
+	if ok {
+		return fd, nil
+	}
+
+	references, err := r.schemaReferences(ctx, theSchema)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find the references of schema ID %d: %w", theSchema.ID(), err)
+	}
+	fd, err = r.buildFileDescriptor(ctx, fmt.Sprintf("%d.proto", theSchema.ID()), theSchema.Schema(), references, new(protoregistry.Files))
+	if err != nil {
+		return nil, fmt.Errorf("unable to build file descriptor for schema ID %d: %w", theSchema.ID(), err)
+	}
+
+	r.filesLock.Lock()
+	r.files[theSchema.ID()] = fd
+	r.filesLock.Unlock()
+
+	return fd, nil
+}
+
+// schemaReferences returns the references theSchema was registered with. Schemas fetched by ID from srclient come
+// without them, so they are looked up separately when the client implements ReferenceSchemaRegistryClient.
+func (r *protobufSchemaResolver) schemaReferences(ctx context.Context, theSchema *srclient.Schema) ([]srclient.Reference, error) {
+	if len(theSchema.References()) > 0 {
+		return theSchema.References(), nil
+	}
+	refClient, ok := r.client.(ReferenceSchemaRegistryClient)
+	if !ok {
+		return nil, nil
+	}
+	return callRegistry(ctx, func() ([]srclient.Reference, error) {
+		return refClient.GetSchemaReferences(theSchema.ID())
+	})
+}
+
+// buildFileDescriptor builds the file descriptor for schema, registering it and all of its imports in files, path
+// names the file when the schema is .proto text, which unlike a file descriptor does not name itself. Every import
+// other than the built in ones must be among references, which pin the subject and version it was registered with.
+func (r *protobufSchemaResolver) buildFileDescriptor(ctx context.Context, path string, schema string, references []srclient.Reference, files *protoregistry.Files) (protoreflect.FileDescriptor, error) {
+	fdp, err := stringToFileDescriptorProto(path, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	referencesByName := make(map[string]srclient.Reference)
+	for _, reference := range references {
+		referencesByName[reference.Name] = reference
+	}
+
+	for _, dep := range fdp.GetDependency() {
+		if _, err := files.FindFileByPath(dep); err == nil {
+			// already built as an import of another file
+			continue
+		}
+		if isBuiltinProto(dep) {
+			// Schema Registry has these built in, so they are never fetched
+			builtinFd, err := builtinFileDescriptor(dep)
+			if err != nil {
+				return nil, err
+			}
+			err = registerFileWithImports(builtinFd, files)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		reference, ok := referencesByName[dep]
+		if !ok {
+			// guessing the subject and version would build the wrong descriptor whenever the import has changed since
+			return nil, fmt.Errorf("unable to resolve import %s: it is not among the references of the schema, "+
+				"the Schema Registry client must return them or implement ReferenceSchemaRegistryClient", dep)
+		}
+		depSchema, err := callRegistry(ctx, func() (*srclient.Schema, error) {
+			if reference.Version == LatestReferenceVersion {
+				return r.client.GetLatestSchema(reference.Subject)
+			}
+			return r.client.GetSchemaByVersion(reference.Subject, reference.Version)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve import %s: %w", dep, err)
+		}
+		// make recursive call
+		_, err = r.buildFileDescriptor(ctx, dep, depSchema.Schema(), depSchema.References(), files)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = linkFileDescriptorProto(fdp, files)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := protodesc.NewFile(fdp, files)
+	if err != nil {
+		return nil, err
+	}
+	err = files.RegisterFile(fd)
+	if err != nil {
+		return nil, err
+	}
+	return fd, nil
+}
+
+// registerFileWithImports registers fd and everything it imports in files
+func registerFileWithImports(fd protoreflect.FileDescriptor, files *protoregistry.Files) error {
+	if _, err := files.FindFileByPath(fd.Path()); err == nil {
+		return nil
+	}
+	fileImports := fd.Imports()
+	for i := 0; i < fileImports.Len(); i++ {
+		err := registerFileWithImports(fileImports.Get(i).FileDescriptor, files)
+		if err != nil {
+			return err
+		}
+	}
+	return files.RegisterFile(fd)
+}
+
+// messageDescriptorForIndex finds the message in fd referred to by msgIndex, an empty msgIndex refers to the first message
+func messageDescriptorForIndex(fd protoreflect.FileDescriptor, msgIndex []int) (protoreflect.MessageDescriptor, error) {
+	if len(msgIndex) == 0 {
+		msgIndex = []int{defaultIndex}
+	}
+	var md protoreflect.MessageDescriptor
+	messages := fd.Messages()
+	for _, index := range msgIndex {
+		if index < 0 || index >= messages.Len() {
+			return nil, fmt.Errorf("message index %v not found in schema %s", msgIndex, fd.Path())
+		}
+		md = messages.Get(index)
+		messages = md.Messages()
+	}
+	return md, nil
+}
+
+// stringToFileDescriptorProto converts schemaString from Schema Registry into a file descriptor proto, schemaString
+// is either a base64 encoded file descriptor or .proto text, which is parsed into a file called path
+func stringToFileDescriptorProto(path string, schemaString string) (*descriptorpb.FileDescriptorProto, error) {
+	data, err := base64.StdEncoding.DecodeString(schemaString)
+	if err != nil {
+		// .proto text is never valid base64 as it always contains spaces or punctuation
+		return parseProtoText(path, schemaString)
+	}
+	fdp := &descriptorpb.FileDescriptorProto{}
+	err = proto.Unmarshal(data, fdp)
+	if err != nil {
+		return nil, err
+	}
+	return fdp, nil
+}
@@ -0,0 +1,421 @@
+package serdes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/finncolman/kafka-go-serdes/internal/message"
+	"github.com/finncolman/kafka-go-serdes/internal/messagerefs"
+	"github.com/riferrei/srclient"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProtobufSchemaResolver_messageDescriptorForIndex(t *testing.T) {
+	fd := (&message.MessageData{}).ProtoReflect().Descriptor().ParentFile()
+
+	cases := []struct {
+		name     string
+		msgIndex []int
+		want     protoreflect.FullName
+	}{
+		{
+			"empty index is the first message",
+			nil,
+			"message.Nested1",
+		},
+		{
+			"first element at top level",
+			[]int{0},
+			"message.Nested1",
+		},
+		{
+			"third element at top level",
+			[]int{2},
+			"message.MessageData",
+		},
+		{
+			"map entry nested under second top level element",
+			[]int{1, 0},
+			"message.Nested2.AdditionalDataEntry",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := messageDescriptorForIndex(fd, c.msgIndex)
+			if err != nil {
+				t.Fatalf("unexpected error on messageDescriptorForIndex: %s", err.Error())
+			}
+			if got.FullName() != c.want {
+				t.Fatalf("messageDescriptorForIndex(%v, %v) == %v, want %v", fd.Path(), c.msgIndex, got.FullName(), c.want)
+			}
+		})
+	}
+}
+
+// idMockSchemaRegistryClient leaves the references out of schemas fetched by ID, as srclient does
+type idMockSchemaRegistryClient struct {
+	*registryMockSchemaRegistryClient
+}
+
+func (m *idMockSchemaRegistryClient) GetSchema(schemaID int) (*srclient.Schema, error) {
+	theSchema, err := m.registryMockSchemaRegistryClient.GetSchema(schemaID)
+	if err != nil {
+		return nil, err
+	}
+	return srclient.NewSchema(theSchema.ID(), theSchema.Schema(), *theSchema.SchemaType(), theSchema.Version(), nil, nil, nil)
+}
+
+// referencesMockSchemaRegistryClient leaves the references out of schemas fetched by ID, but can look them up
+type referencesMockSchemaRegistryClient struct {
+	idMockSchemaRegistryClient
+}
+
+func (m *referencesMockSchemaRegistryClient) GetSchemaReferences(schemaID int) ([]srclient.Reference, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.calls["GetSchemaReferences"]++
+	theSchema, ok := m.schemas[schemaID]
+	if !ok {
+		return nil, fmt.Errorf("404 Not Found: schema %d not found", schemaID)
+	}
+	return theSchema.References(), nil
+}
+
+func TestProtobufSchemaResolver_fileDescriptorByIDWithoutReferences(t *testing.T) {
+	msrc := &idMockSchemaRegistryClient{newRegistryMockSchemaRegistryClient()}
+	fd := (&messagerefs.MessageData{}).ProtoReflect().Descriptor().ParentFile()
+
+	// register the imports under subjects named after them, which used to be guessed when the references were missing
+	var references []srclient.Reference
+	for i := 0; i < fd.Imports().Len(); i++ {
+		fileImport := fd.Imports().Get(i)
+		schemaString, err := fileDescriptorToString(fileImport.FileDescriptor)
+		if err != nil {
+			t.Fatalf("unexpected error on fileDescriptorToString: %s", err.Error())
+		}
+		importSchema, err := msrc.CreateSchema(fileImport.Path(), schemaString, srclient.Protobuf)
+		if err != nil {
+			t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+		}
+		references = append(references, srclient.Reference{Name: fileImport.Path(), Subject: fileImport.Path(), Version: importSchema.Version()})
+	}
+	schemaString, err := fileDescriptorToString(fd)
+	if err != nil {
+		t.Fatalf("unexpected error on fileDescriptorToString: %s", err.Error())
+	}
+	theSchema, err := msrc.CreateSchema("test-value", schemaString, srclient.Protobuf, references...)
+	if err != nil {
+		t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+	}
+
+	r := newProtobufSchemaResolver(msrc)
+	_, err = r.fileDescriptorByID(context.Background(), theSchema.ID())
+	want := fmt.Sprintf("unable to build file descriptor for schema ID %d: unable to resolve import nested1.proto: it is "+
+		"not among the references of the schema, the Schema Registry client must return them or implement "+
+		"ReferenceSchemaRegistryClient", theSchema.ID())
+	if err == nil || err.Error() != want {
+		t.Fatalf("r.fileDescriptorByID(%d) == %v, want %s", theSchema.ID(), err, want)
+	}
+	for _, method := range []string{"GetLatestSchema", "GetSchemaByVersion"} {
+		if calls := msrc.callCount(method); calls != 0 {
+			t.Fatalf("%s called %d times, want 0", method, calls)
+		}
+	}
+	if len(r.files) != 0 {
+		t.Fatalf("r.files == %v, want nothing cached after a failure", r.files)
+	}
+
+	// with a client that can look the references up they are used instead
+	refClient := &referencesMockSchemaRegistryClient{*msrc}
+	r = newProtobufSchemaResolver(refClient)
+	got, err := r.fileDescriptorByID(context.Background(), theSchema.ID())
+	if err != nil {
+		t.Fatalf("unexpected error on fileDescriptorByID: %s", err.Error())
+	}
+	if got.Path() != fd.Path() || got.Imports().Len() != fd.Imports().Len() {
+		t.Fatalf("r.fileDescriptorByID(%d) == %v, want %v", theSchema.ID(), got.Path(), fd.Path())
+	}
+	if calls := refClient.callCount("GetSchemaReferences"); calls != 1 {
+		t.Fatalf("GetSchemaReferences called %d times, want 1", calls)
+	}
+}
+
+func TestProtobufSchemaResolver_fileDescriptorByIDPinnedReferences(t *testing.T) {
+	msrc := &referencesMockSchemaRegistryClient{idMockSchemaRegistryClient{newRegistryMockSchemaRegistryClient()}}
+
+	// the shared file gains a field after the schema referencing version 1 was registered
+	for _, text := range []string{
+		`syntax = "proto3"; package test.shared; message Address { string street = 1; }`,
+		`syntax = "proto3"; package test.shared; message Address { string street = 1; string city = 2; }`,
+	} {
+		_, err := msrc.CreateSchema("shared-address", text, srclient.Protobuf)
+		if err != nil {
+			t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+		}
+	}
+	text := `syntax = "proto3"; package test.pinned; import "address.proto"; message Customer { test.shared.Address address = 1; }`
+	theSchema, err := msrc.CreateSchema("test-value", text, srclient.Protobuf, srclient.Reference{Name: "address.proto", Subject: "shared-address", Version: 1})
+	if err != nil {
+		t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+	}
+
+	r := newProtobufSchemaResolver(msrc)
+	fd, err := r.fileDescriptorByID(context.Background(), theSchema.ID())
+	if err != nil {
+		t.Fatalf("unexpected error on fileDescriptorByID: %s", err.Error())
+	}
+	address := fd.Messages().ByName("Customer").Fields().ByName("address").Message()
+	if address.Fields().Len() != 1 || address.Fields().ByName("street") == nil {
+		t.Fatalf("fields of %s == %v, want only street as in version 1", address.FullName(), address.Fields())
+	}
+	if calls := msrc.callCount("GetLatestSchema"); calls != 0 {
+		t.Fatalf("GetLatestSchema called %d times, want 0", calls)
+	}
+}
+
+func TestProtobufSchemaResolver_stringToFileDescriptorProto(t *testing.T) {
+	fd := (&message.MessageData{}).ProtoReflect().Descriptor().ParentFile()
+	base64String, err := fileDescriptorToString(fd)
+	if err != nil {
+		t.Fatalf("unexpected error on fileDescriptorToString: %s", err.Error())
+	}
+
+	cases := []struct {
+		name         string
+		schemaString string
+		want         string
+	}{
+		{"base64 file descriptor keeps its own name", base64String, fd.Path()},
+		{".proto text is named after the path", fileDescriptorToProtoText(fd, false), "1.proto"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := stringToFileDescriptorProto("1.proto", c.schemaString)
+			if err != nil {
+				t.Fatalf("unexpected error on stringToFileDescriptorProto: %s", err.Error())
+			}
+			if got.GetName() != c.want || len(got.GetMessageType()) != fd.Messages().Len() {
+				t.Fatalf("stringToFileDescriptorProto(%q) == %v, want %s with %d messages", c.schemaString, got, c.want, fd.Messages().Len())
+			}
+		})
+	}
+
+	_, err = stringToFileDescriptorProto("1.proto", "message {")
+	if err == nil {
+		t.Fatalf("stringToFileDescriptorProto(\"message {\") == nil, want error")
+	}
+}
+
+func TestProtobufSchemaResolver_fileDescriptorByIDLatestReferences(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	fd := (&messagerefs.MessageData{}).ProtoReflect().Descriptor().ParentFile()
+
+	var references []srclient.Reference
+	for i := 0; i < fd.Imports().Len(); i++ {
+		fileImport := fd.Imports().Get(i)
+		schemaString, err := fileDescriptorToString(fileImport.FileDescriptor)
+		if err != nil {
+			t.Fatalf("unexpected error on fileDescriptorToString: %s", err.Error())
+		}
+		_, err = msrc.CreateSchema("shared-"+fileImport.Path(), schemaString, srclient.Protobuf)
+		if err != nil {
+			t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+		}
+		references = append(references, srclient.Reference{Name: fileImport.Path(), Subject: "shared-" + fileImport.Path(), Version: LatestReferenceVersion})
+	}
+	schemaString, err := fileDescriptorToString(fd)
+	if err != nil {
+		t.Fatalf("unexpected error on fileDescriptorToString: %s", err.Error())
+	}
+	theSchema, err := msrc.CreateSchema("test-value", schemaString, srclient.Protobuf, references...)
+	if err != nil {
+		t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+	}
+
+	r := newProtobufSchemaResolver(msrc)
+	_, err = r.fileDescriptorByID(context.Background(), theSchema.ID())
+	if err != nil {
+		t.Fatalf("unexpected error on fileDescriptorByID: %s", err.Error())
+	}
+	if calls := msrc.callCount("GetLatestSchema"); calls != len(references) {
+		t.Fatalf("GetLatestSchema called %d times, want %d", calls, len(references))
+	}
+	if calls := msrc.callCount("GetSchemaByVersion"); calls != 0 {
+		t.Fatalf("GetSchemaByVersion called %d times, want 0", calls)
+	}
+}
+
+// unavailableMockSchemaRegistryClient fails to find any version of a subject with err
+type unavailableMockSchemaRegistryClient struct {
+	*registryMockSchemaRegistryClient
+	err error
+}
+
+func (m *unavailableMockSchemaRegistryClient) GetSchemaByVersion(subject string, version int) (*srclient.Schema, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return m.registryMockSchemaRegistryClient.GetSchemaByVersion(subject, version)
+}
+
+func TestProtobufSchemaResolver_fileDescriptorByIDUnavailableImports(t *testing.T) {
+	msrc := &unavailableMockSchemaRegistryClient{
+		registryMockSchemaRegistryClient: newRegistryMockSchemaRegistryClient(),
+		err:                              errors.New("503 Service Unavailable"),
+	}
+	fd := (&messagerefs.MessageData{}).ProtoReflect().Descriptor().ParentFile()
+
+	var references []srclient.Reference
+	for i := 0; i < fd.Imports().Len(); i++ {
+		fileImport := fd.Imports().Get(i)
+		schemaString, err := fileDescriptorToString(fileImport.FileDescriptor)
+		if err != nil {
+			t.Fatalf("unexpected error on fileDescriptorToString: %s", err.Error())
+		}
+		_, err = msrc.CreateSchema(fileImport.Path(), schemaString, srclient.Protobuf)
+		if err != nil {
+			t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+		}
+		references = append(references, srclient.Reference{Name: fileImport.Path(), Subject: fileImport.Path(), Version: 1})
+	}
+	schemaString, err := fileDescriptorToString(fd)
+	if err != nil {
+		t.Fatalf("unexpected error on fileDescriptorToString: %s", err.Error())
+	}
+	theSchema, err := msrc.CreateSchema("test-value", schemaString, srclient.Protobuf, references...)
+	if err != nil {
+		t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+	}
+
+	r := newProtobufSchemaResolver(msrc)
+	_, err = r.fileDescriptorByID(context.Background(), theSchema.ID())
+	if !errors.Is(err, ErrRegistryUnavailable) {
+		t.Fatalf("r.fileDescriptorByID(%d) == %v, want %v", theSchema.ID(), err, ErrRegistryUnavailable)
+	}
+	if len(r.files) != 0 {
+		t.Fatalf("r.files == %v, want nothing cached after a failure", r.files)
+	}
+
+	msrc.err = nil
+	got, err := r.fileDescriptorByID(context.Background(), theSchema.ID())
+	if err != nil {
+		t.Fatalf("unexpected error on fileDescriptorByID: %s", err.Error())
+	}
+	if got.Path() != fd.Path() || got.Imports().Len() != fd.Imports().Len() {
+		t.Fatalf("r.fileDescriptorByID(%d) == %v, want %v", theSchema.ID(), got.Path(), fd.Path())
+	}
+	if calls := msrc.callCount("GetSchema"); calls != 2 {
+		t.Fatalf("GetSchema called %d times, want 2", calls)
+	}
+}
+
+func TestProtobufSchemaResolver_fileDescriptorByIDProtoText(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+
+	// register the .proto source, as clients in other languages do
+	var references []srclient.Reference
+	for _, path := range []string{"nested1.proto", "nested2.proto"} {
+		text, err := os.ReadFile(filepath.Join("..", "testdata", path))
+		if err != nil {
+			t.Fatalf("unexpected error on ReadFile: %s", err.Error())
+		}
+		theSchema, err := msrc.CreateSchema(path, string(text), srclient.Protobuf)
+		if err != nil {
+			t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+		}
+		references = append(references, srclient.Reference{Name: path, Subject: path, Version: theSchema.Version()})
+	}
+	text, err := os.ReadFile(filepath.Join("..", "testdata", "messagerefs.proto"))
+	if err != nil {
+		t.Fatalf("unexpected error on ReadFile: %s", err.Error())
+	}
+	theSchema, err := msrc.CreateSchema("test-value", string(text), srclient.Protobuf, references...)
+	if err != nil {
+		t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+	}
+
+	r := newProtobufSchemaResolver(msrc)
+	fd, err := r.fileDescriptorByID(context.Background(), theSchema.ID())
+	if err != nil {
+		t.Fatalf("unexpected error on fileDescriptorByID: %s", err.Error())
+	}
+	md, err := messageDescriptorForIndex(fd, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on messageDescriptorForIndex: %s", err.Error())
+	}
+
+	// data written with the generated code reads back the same through the parsed descriptor
+	msgData := &messagerefs.MessageData{
+		Nest1: &messagerefs.Nested1{MessageId: 1, Test: "test"},
+		Nest2: &messagerefs.Nested2{Id: "id", AdditionalData: map[string]string{"key": "value"}},
+	}
+	want, err := proto.MarshalOptions{Deterministic: true}.Marshal(msgData)
+	if err != nil {
+		t.Fatalf("unexpected error on Marshal: %s", err.Error())
+	}
+	dynamicMsg := dynamicpb.NewMessage(md)
+	err = proto.Unmarshal(want, dynamicMsg)
+	if err != nil {
+		t.Fatalf("unexpected error on Unmarshal: %s", err.Error())
+	}
+	got, err := proto.MarshalOptions{Deterministic: true}.Marshal(dynamicMsg)
+	if err != nil {
+		t.Fatalf("unexpected error on Marshal: %s", err.Error())
+	}
+	if md.FullName() != "messagerefs.MessageData" || !bytes.Equal(got, want) {
+		t.Fatalf("round trip through %s == %v, want %v", md.FullName(), got, want)
+	}
+}
+
+func TestProtobufSchemaResolver_fileDescriptorByIDBuiltinImports(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	text := `syntax = "proto3";
+package test.builtin;
+
+import "google/protobuf/timestamp.proto";
+import "confluent/meta.proto";
+import "confluent/type/decimal.proto";
+
+message Payment {
+  google.protobuf.Timestamp paid_at = 1 [(confluent.field_meta) = { doc: "when" }];
+  confluent.type.Decimal amount = 2;
+}
+`
+	// built in imports are never registered as references
+	theSchema, err := msrc.CreateSchema("test-value", text, srclient.Protobuf)
+	if err != nil {
+		t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+	}
+
+	r := newProtobufSchemaResolver(msrc)
+	fd, err := r.fileDescriptorByID(context.Background(), theSchema.ID())
+	if err != nil {
+		t.Fatalf("unexpected error on fileDescriptorByID: %s", err.Error())
+	}
+	for _, method := range []string{"GetLatestSchema", "GetSchemaByVersion"} {
+		if calls := msrc.callCount(method); calls != 0 {
+			t.Fatalf("%s called %d times, want 0", method, calls)
+		}
+	}
+
+	paidAt := fd.Messages().ByName("Payment").Fields().ByName("paid_at")
+	if paidAt.Message().FullName() != "google.protobuf.Timestamp" {
+		t.Fatalf("type of paid_at == %s, want google.protobuf.Timestamp", paidAt.Message().FullName())
+	}
+	var doc string
+	paidAt.Options().ProtoReflect().Range(func(field protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if field.FullName() == "confluent.field_meta" {
+			doc = v.Message().Get(field.Message().Fields().ByName("doc")).String()
+		}
+		return true
+	})
+	if doc != "when" {
+		t.Fatalf("(confluent.field_meta).doc == %q, want when", doc)
+	}
+}
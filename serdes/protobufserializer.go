@@ -1,8 +1,11 @@
 package serdes
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"sort"
 	"strings"
@@ -16,6 +19,9 @@ import (
 
 const (
 	defaultIndex = 0
+	// maxFingerprints the number of file descriptors fingerprints are kept for, producers building descriptors for
+	// every message would otherwise grow the cache without limit
+	maxFingerprints = 1024
 	// MessageFieldKey message field is key
 	MessageFieldKey = "key"
 	// MessageFieldValue  message field is value
@@ -24,12 +30,34 @@ const (
 	AutoRegisterSchemas = "auto.register.schemas"
 	// UseLatestVersion use latest version of schema
 	UseLatestVersion = "use.latest.version"
-	// SkipKnownTypes skips known types for schema references
+	// LatestCompatibilityStrict check the local schema is compatible with the latest version, requires UseLatestVersion
+	LatestCompatibilityStrict = "latest.compatibility.strict"
+	// UseSchemaID always use this schema ID when serializing, -1 disables it
+	UseSchemaID = "use.schema.id"
+	// UseSchemaIDCheckSubject check that the schema ID set with UseSchemaID is registered under the subject
+	UseSchemaIDCheckSubject = "use.schema.id.check.subject"
+	// NormalizeSchemas canonicalize schemas before they are registered or looked up
+	NormalizeSchemas = "normalize.schemas"
+	// UseProtoText register and look up schemas as .proto text, as Confluent's Java serializer does, rather than as base64 encoded file descriptors
+	UseProtoText = "use.proto.text"
+	// UseSchemaGUID write the GUID of the schema after magic byte 1 instead of the schema ID after magic byte 0, the
+	// Schema Registry client must implement GUIDSchemaRegistryClient
+	UseSchemaGUID = "use.schema.guid"
+	// SkipKnownTypes skips the imports Schema Registry has built in, such as the well known types, for schema references
 	SkipKnownTypes = "skip.known.types"
 	// SubjectNameStrategyImpl the implementation to use for determining subject naming strategy
 	SubjectNameStrategyImpl = "subject.name.strategy"
 	// ReferenceSubjectNameStrategyImpl the implementation to use for determining the subject naming strategy for references
 	ReferenceSubjectNameStrategyImpl = "reference.subject.name.strategy"
+	// ReferenceVersionStrategyImpl the implementation to use for choosing the version of each schema reference
+	ReferenceVersionStrategyImpl = "reference.version.strategy"
+)
+
+const (
+	// ExactReferenceVersion references the version registered for the locally compiled import
+	ExactReferenceVersion = 0
+	// LatestReferenceVersion references whatever the latest version of the subject is when Schema Registry resolves the reference
+	LatestReferenceVersion = -1
 )
 
 // ProtobufSerializerConfigValue config values for protobuf serialization
@@ -86,17 +114,113 @@ func (ReferenceSubjectNameStrategy) Subject(_ SerializationContext, schemaRef pr
 	return schemaRef.Path()
 }
 
+// ReferenceVersionStrategy chooses the version of the schema reference to an import, return ExactReferenceVersion to
+// pin the version registered for the locally compiled import, LatestReferenceVersion or a specific version
+type ReferenceVersionStrategy interface {
+	Version(ctx SerializationContext, schemaRef protoreflect.FileImport) int
+}
+
+// ExactReferenceVersionStrategy pins each reference to the version registered for the locally compiled import
+type ExactReferenceVersionStrategy struct{}
+
+// Version for ExactReferenceVersionStrategy
+func (ExactReferenceVersionStrategy) Version(SerializationContext, protoreflect.FileImport) int {
+	return ExactReferenceVersion
+}
+
+// LatestReferenceVersionStrategy references the latest version of each subject, so shared files can evolve without
+// re-registering the schemas that import them
+type LatestReferenceVersionStrategy struct{}
+
+// Version for LatestReferenceVersionStrategy
+func (LatestReferenceVersionStrategy) Version(SerializationContext, protoreflect.FileImport) int {
+	return LatestReferenceVersion
+}
+
+// PathReferenceVersionStrategy uses the version in Versions for imports with a matching path, other imports use Default,
+// or ExactReferenceVersionStrategy when Default is nil
+type PathReferenceVersionStrategy struct {
+	Versions map[string]int
+	Default  ReferenceVersionStrategy
+}
+
+// Version for PathReferenceVersionStrategy
+func (s PathReferenceVersionStrategy) Version(ctx SerializationContext, schemaRef protoreflect.FileImport) int {
+	if version, ok := s.Versions[schemaRef.Path()]; ok {
+		return version
+	}
+	if s.Default == nil {
+		return ExactReferenceVersion
+	}
+	return s.Default.Version(ctx, schemaRef)
+}
+
+// NormalizingSchemaRegistryClient is implemented by Schema Registry clients that can ask Schema Registry to normalize
+// schemas, srclient does not expose the normalize flag so wrap it with your own client to use it
+type NormalizingSchemaRegistryClient interface {
+	CreateSchemaWithNormalize(subject string, schema string, schemaType srclient.SchemaType, normalize bool, references ...srclient.Reference) (*srclient.Schema, error)
+	LookupSchemaWithNormalize(subject string, schema string, schemaType srclient.SchemaType, normalize bool, references ...srclient.Reference) (*srclient.Schema, error)
+}
+
 // ProtobufSerializer using the schema registry client
 type ProtobufSerializer struct {
 	client                       srclient.ISchemaRegistryClient
-	msgIndexBytes                []byte
+	msgIndexBytes                map[protoreflect.FullName][]byte // map from message full name to associated message index bytes
+	msgIndexBytesLock            sync.RWMutex
 	autoRegisterSchemas          bool
 	useLatestVersion             bool
+	latestCompatibilityStrict    bool
+	useSchemaID                  int
+	useSchemaIDCheckSubject      bool
+	normalizeSchemas             bool
+	useProtoText                 bool
 	skipKnownTypes               bool
-	knownSubjects                map[string]int // map from subject name to associated schema ID
+	useSchemaGUID                bool
+	encryptionKMS                KMS
+	encryptionKeyID              string
+	encryptionTags               []string
+	encryptionFields             []string
+	encryptor                    *fieldEncryptor // nil when no fields are encrypted
+	rules                        []Rule
+	useRegistryRules             bool
+	ruleDLQHandler               RuleDLQHandler
+	ruleLogger                   RuleLogger
+	ruleExecutor                 *ruleExecutor            // nil when there are no rules to check
+	knownSubjects                map[subjectSchemaKey]int // map from subject name, schema fingerprint and references to associated schema ID
 	knownSubjectsLock            sync.RWMutex
+	knownGUIDs                   map[int][]byte // map from schema ID to associated schema GUID bytes
+	knownGUIDsLock               sync.RWMutex
+	fingerprints                 map[protoreflect.FileDescriptor]string // map from file descriptor to fingerprint of it and its references
+	fingerprintsLock             sync.RWMutex
+	knownReferences              map[referenceKey]srclient.Reference   // map from resolved import to associated schema reference
+	resolvingReferences          map[referenceKey]*referenceResolution // map from import being resolved to its resolution
+	knownReferencesLock          sync.Mutex                            // guards both knownReferences and resolvingReferences
 	subjectNameStrategy          SubjectNameStrategy
 	referenceSubjectNameStrategy SubjectNameStrategyForReferences
+	referenceVersionStrategy     ReferenceVersionStrategy
+	resolver                     *protobufSchemaResolver
+}
+
+// subjectSchemaKey identifies a schema registered under a subject
+type subjectSchemaKey struct {
+	subject     string
+	fingerprint string
+	references  string // the references the schema is registered with, see referencesKey
+}
+
+// referenceKey identifies an import resolved to a schema reference
+type referenceKey struct {
+	path        string
+	subject     string
+	fingerprint string
+	version     int // the version chosen by the ReferenceVersionStrategy, which may depend on the SerializationContext
+}
+
+// referenceResolution an import being resolved to a schema reference, done is closed once schemaRef or err is set
+type referenceResolution struct {
+	done      chan struct{}
+	schemaRef srclient.Reference
+	err       error
 }
 
 func createMsgIndex(md protoreflect.MessageDescriptor) []int {
@@ -142,25 +266,41 @@ func createMsgIndexBytes(msgIndex []int) []byte {
 
 // NewProtobufSerializer returns a new ProtobufSerializer
 func NewProtobufSerializer(md protoreflect.MessageDescriptor, schemaRegistryClient srclient.ISchemaRegistryClient, config ProtobufSerializerConfig) (*ProtobufSerializer, error) {
-	msgIndex := createMsgIndex(md)
-
-	msgIndexBytes := createMsgIndexBytes(msgIndex)
+	msgIndexBytes := map[protoreflect.FullName][]byte{
+		md.FullName(): createMsgIndexBytes(createMsgIndex(md)),
+	}
 
-	knownSubjects := make(map[string]int)
+	knownSubjects := make(map[subjectSchemaKey]int)
+	fingerprints := make(map[protoreflect.FileDescriptor]string)
+	knownReferences := make(map[referenceKey]srclient.Reference)
 
 	ps := &ProtobufSerializer{
-		client:        schemaRegistryClient,
-		msgIndexBytes: msgIndexBytes,
-		knownSubjects: knownSubjects,
+		client:              schemaRegistryClient,
+		msgIndexBytes:       msgIndexBytes,
+		useSchemaID:         -1,
+		knownSubjects:       knownSubjects,
+		knownGUIDs:          make(map[int][]byte),
+		fingerprints:        fingerprints,
+		knownReferences:     knownReferences,
+		resolvingReferences: make(map[referenceKey]*referenceResolution),
+		resolver:            newProtobufSchemaResolver(schemaRegistryClient),
 	}
 
 	// set all the defaults
 	configToUse := ProtobufSerializerConfig{
 		AutoRegisterSchemas:              true,
 		UseLatestVersion:                 false,
+		LatestCompatibilityStrict:        false,
+		UseSchemaID:                      -1,
+		UseSchemaIDCheckSubject:          false,
+		NormalizeSchemas:                 false,
+		UseProtoText:                     false,
 		SkipKnownTypes:                   false,
-		SubjectNameStrategyImpl:          TopicSubjectNameStrategy{},     // TopicSubjectNameStrategy is the default
-		ReferenceSubjectNameStrategyImpl: ReferenceSubjectNameStrategy{}, // ReferenceSubjectNameStrategy is the default
+		UseSchemaGUID:                    false,
+		UseRegistryRules:                 false,
+		SubjectNameStrategyImpl:          TopicSubjectNameStrategy{},      // TopicSubjectNameStrategy is the default
+		ReferenceSubjectNameStrategyImpl: ReferenceSubjectNameStrategy{},  // ReferenceSubjectNameStrategy is the default
+		ReferenceVersionStrategyImpl:     ExactReferenceVersionStrategy{}, // ExactReferenceVersionStrategy is the default
 	}
 
 	// handle configuration
@@ -181,11 +321,98 @@ func NewProtobufSerializer(md protoreflect.MessageDescriptor, schemaRegistryClie
 		return nil, err
 	}
 
+	err = ps.SetLatestCompatibilityStrict(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ps.SetUseSchemaID(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ps.SetUseSchemaIDCheckSubject(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ps.SetNormalizeSchemas(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ps.SetUseProtoText(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
 	err = ps.SetSkipKnownTypes(configToUse)
 	if err != nil {
 		return nil, err
 	}
 
+	err = ps.SetUseSchemaGUID(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ps.SetEncryptionKMS(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ps.SetEncryptionKeyID(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ps.SetEncryptionTags(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ps.SetEncryptionFields(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkEncryptionConfig(ps.encryptionKMS, ps.encryptionKeyID, ps.encryptionTags, ps.encryptionFields)
+	if err != nil {
+		return nil, err
+	}
+	if len(ps.encryptionTags) > 0 || len(ps.encryptionFields) > 0 {
+		ps.encryptor = newFieldEncryptor(ps.encryptionKMS, ps.encryptionKeyID, ps.encryptionTags, ps.encryptionFields)
+	}
+
+	err = ps.SetRules(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ps.SetUseRegistryRules(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ps.SetRuleDLQHandler(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ps.SetRuleLogger(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
+	var ruleClient RuleSchemaRegistryClient
+	if ps.useRegistryRules {
+		ruleClient = ps.client.(RuleSchemaRegistryClient)
+	}
+	ps.ruleExecutor, err = newRuleExecutor(ps.rules, ruleClient, ps.ruleDLQHandler, ps.ruleLogger)
+	if err != nil {
+		return nil, err
+	}
+
 	err = ps.SetSubjectNameStrategy(configToUse)
 	if err != nil {
 		return nil, err
@@ -196,6 +423,11 @@ func NewProtobufSerializer(md protoreflect.MessageDescriptor, schemaRegistryClie
 		return nil, err
 	}
 
+	err = ps.SetReferenceVersionStrategy(configToUse)
+	if err != nil {
+		return nil, err
+	}
+
 	// check for left over unrecognized properties
 	if len(configToUse) > 0 {
 		var keys []string
@@ -220,7 +452,11 @@ func (ps *ProtobufSerializer) SetAutoRegisterSchemas(config ProtobufSerializerCo
 		ps.autoRegisterSchemas = autoRegisterSchemas
 		delete(config, AutoRegisterSchemas)
 	}
-	return ps.isUseLatestVersionAndAutoRegisterSchemas()
+	err := ps.isUseLatestVersionAndAutoRegisterSchemas()
+	if err != nil {
+		return err
+	}
+	return ps.isUseSchemaIDAndOtherSchemaSelection()
 }
 
 // SetUseLatestVersion using the supplied ProtobufSerializerConfig
@@ -234,7 +470,15 @@ func (ps *ProtobufSerializer) SetUseLatestVersion(config ProtobufSerializerConfi
 		ps.useLatestVersion = useLatestVersion
 		delete(config, UseLatestVersion)
 	}
-	return ps.isUseLatestVersionAndAutoRegisterSchemas()
+	err := ps.isUseLatestVersionAndAutoRegisterSchemas()
+	if err != nil {
+		return err
+	}
+	err = ps.isLatestCompatibilityStrictWithoutUseLatestVersion()
+	if err != nil {
+		return err
+	}
+	return ps.isUseSchemaIDAndOtherSchemaSelection()
 }
 
 func (ps *ProtobufSerializer) isUseLatestVersionAndAutoRegisterSchemas() error {
@@ -244,6 +488,102 @@ func (ps *ProtobufSerializer) isUseLatestVersionAndAutoRegisterSchemas() error {
 	return nil
 }
 
+// SetLatestCompatibilityStrict using the supplied ProtobufSerializerConfig
+func (ps *ProtobufSerializer) SetLatestCompatibilityStrict(config ProtobufSerializerConfig) error {
+	latestCompatibilityStrictConf, ok := config[LatestCompatibilityStrict]
+	if ok {
+		latestCompatibilityStrict, okTypeCast := latestCompatibilityStrictConf.(bool)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a boolean value", LatestCompatibilityStrict)
+		}
+		ps.latestCompatibilityStrict = latestCompatibilityStrict
+		delete(config, LatestCompatibilityStrict)
+	}
+	return ps.isLatestCompatibilityStrictWithoutUseLatestVersion()
+}
+
+// isLatestCompatibilityStrictWithoutUseLatestVersion the compatibility check is only made against the latest version
+// when that is the version being used
+func (ps *ProtobufSerializer) isLatestCompatibilityStrictWithoutUseLatestVersion() error {
+	if ps.latestCompatibilityStrict && !ps.useLatestVersion {
+		return fmt.Errorf("cannot enable %s without %s", LatestCompatibilityStrict, UseLatestVersion)
+	}
+	return nil
+}
+
+// SetUseSchemaID using the supplied ProtobufSerializerConfig
+func (ps *ProtobufSerializer) SetUseSchemaID(config ProtobufSerializerConfig) error {
+	useSchemaIDConf, ok := config[UseSchemaID]
+	if ok {
+		useSchemaID, okTypeCast := useSchemaIDConf.(int)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be an integer value", UseSchemaID)
+		}
+		if useSchemaID < -1 {
+			return fmt.Errorf("%s must be a schema ID or -1 to disable it", UseSchemaID)
+		}
+		ps.useSchemaID = useSchemaID
+		delete(config, UseSchemaID)
+	}
+	return ps.isUseSchemaIDAndOtherSchemaSelection()
+}
+
+// isUseSchemaIDAndOtherSchemaSelection a pinned schema ID cannot be combined with any other way of selecting the schema
+func (ps *ProtobufSerializer) isUseSchemaIDAndOtherSchemaSelection() error {
+	if ps.useSchemaID < 0 {
+		return nil
+	}
+	if ps.autoRegisterSchemas {
+		return fmt.Errorf("cannot enable both %s and %s", UseSchemaID, AutoRegisterSchemas)
+	}
+	if ps.useLatestVersion {
+		return fmt.Errorf("cannot enable both %s and %s", UseSchemaID, UseLatestVersion)
+	}
+	return nil
+}
+
+// SetUseSchemaIDCheckSubject using the supplied ProtobufSerializerConfig
+func (ps *ProtobufSerializer) SetUseSchemaIDCheckSubject(config ProtobufSerializerConfig) error {
+	useSchemaIDCheckSubjectConf, ok := config[UseSchemaIDCheckSubject]
+	if ok {
+		useSchemaIDCheckSubject, okTypeCast := useSchemaIDCheckSubjectConf.(bool)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a boolean value", UseSchemaIDCheckSubject)
+		}
+		ps.useSchemaIDCheckSubject = useSchemaIDCheckSubject
+		delete(config, UseSchemaIDCheckSubject)
+	}
+	return nil
+}
+
+// SetNormalizeSchemas using the supplied ProtobufSerializerConfig
+func (ps *ProtobufSerializer) SetNormalizeSchemas(config ProtobufSerializerConfig) error {
+	normalizeSchemasConf, ok := config[NormalizeSchemas]
+	if ok {
+		normalizeSchemas, okTypeCast := normalizeSchemasConf.(bool)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a boolean value", NormalizeSchemas)
+		}
+		ps.normalizeSchemas = normalizeSchemas
+		delete(config, NormalizeSchemas)
+	}
+	return nil
+}
+
+// SetUseProtoText using the supplied ProtobufSerializerConfig
+func (ps *ProtobufSerializer) SetUseProtoText(config ProtobufSerializerConfig) error {
+	useProtoTextConf, ok := config[UseProtoText]
+	if ok {
+		useProtoText, okTypeCast := useProtoTextConf.(bool)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a boolean value", UseProtoText)
+		}
+		ps.useProtoText = useProtoText
+		delete(config, UseProtoText)
+	}
+	return nil
+}
+
 // SetSkipKnownTypes using the supplied ProtobufSerializerConfig
 func (ps *ProtobufSerializer) SetSkipKnownTypes(config ProtobufSerializerConfig) error {
 	skipKnownTypesConf, ok := config[SkipKnownTypes]
@@ -258,6 +598,138 @@ func (ps *ProtobufSerializer) SetSkipKnownTypes(config ProtobufSerializerConfig)
 	return nil
 }
 
+// SetUseSchemaGUID using the supplied ProtobufSerializerConfig
+func (ps *ProtobufSerializer) SetUseSchemaGUID(config ProtobufSerializerConfig) error {
+	useSchemaGUIDConf, ok := config[UseSchemaGUID]
+	if ok {
+		useSchemaGUID, okTypeCast := useSchemaGUIDConf.(bool)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a boolean value", UseSchemaGUID)
+		}
+		if _, okClient := ps.client.(GUIDSchemaRegistryClient); useSchemaGUID && !okClient {
+			return fmt.Errorf("%s requires a Schema Registry client that implements GUIDSchemaRegistryClient", UseSchemaGUID)
+		}
+		ps.useSchemaGUID = useSchemaGUID
+		delete(config, UseSchemaGUID)
+	}
+	return nil
+}
+
+// SetEncryptionKMS using the supplied ProtobufSerializerConfig
+func (ps *ProtobufSerializer) SetEncryptionKMS(config ProtobufSerializerConfig) error {
+	encryptionKMSConf, ok := config[EncryptionKMS]
+	if ok {
+		encryptionKMS, okTypeCast := encryptionKMSConf.(KMS)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a KMS", EncryptionKMS)
+		}
+		ps.encryptionKMS = encryptionKMS
+		delete(config, EncryptionKMS)
+	}
+	return nil
+}
+
+// SetEncryptionKeyID using the supplied ProtobufSerializerConfig
+func (ps *ProtobufSerializer) SetEncryptionKeyID(config ProtobufSerializerConfig) error {
+	encryptionKeyIDConf, ok := config[EncryptionKeyID]
+	if ok {
+		encryptionKeyID, okTypeCast := encryptionKeyIDConf.(string)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a string value", EncryptionKeyID)
+		}
+		ps.encryptionKeyID = encryptionKeyID
+		delete(config, EncryptionKeyID)
+	}
+	return nil
+}
+
+// SetEncryptionTags using the supplied ProtobufSerializerConfig
+func (ps *ProtobufSerializer) SetEncryptionTags(config ProtobufSerializerConfig) error {
+	encryptionTagsConf, ok := config[EncryptionTags]
+	if ok {
+		encryptionTags, okTypeCast := encryptionTagsConf.([]string)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a []string", EncryptionTags)
+		}
+		ps.encryptionTags = encryptionTags
+		delete(config, EncryptionTags)
+	}
+	return nil
+}
+
+// SetEncryptionFields using the supplied ProtobufSerializerConfig
+func (ps *ProtobufSerializer) SetEncryptionFields(config ProtobufSerializerConfig) error {
+	encryptionFieldsConf, ok := config[EncryptionFields]
+	if ok {
+		encryptionFields, okTypeCast := encryptionFieldsConf.([]string)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a []string", EncryptionFields)
+		}
+		ps.encryptionFields = encryptionFields
+		delete(config, EncryptionFields)
+	}
+	return nil
+}
+
+// SetRules using the supplied ProtobufSerializerConfig
+func (ps *ProtobufSerializer) SetRules(config ProtobufSerializerConfig) error {
+	rulesConf, ok := config[Rules]
+	if ok {
+		rules, okTypeCast := rulesConf.([]Rule)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a []Rule", Rules)
+		}
+		ps.rules = rules
+		delete(config, Rules)
+	}
+	return nil
+}
+
+// SetUseRegistryRules using the supplied ProtobufSerializerConfig
+func (ps *ProtobufSerializer) SetUseRegistryRules(config ProtobufSerializerConfig) error {
+	useRegistryRulesConf, ok := config[UseRegistryRules]
+	if ok {
+		useRegistryRules, okTypeCast := useRegistryRulesConf.(bool)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a boolean value", UseRegistryRules)
+		}
+		if _, okClient := ps.client.(RuleSchemaRegistryClient); useRegistryRules && !okClient {
+			return fmt.Errorf("%s requires a Schema Registry client that implements RuleSchemaRegistryClient", UseRegistryRules)
+		}
+		ps.useRegistryRules = useRegistryRules
+		delete(config, UseRegistryRules)
+	}
+	return nil
+}
+
+// SetRuleDLQHandler using the supplied ProtobufSerializerConfig
+func (ps *ProtobufSerializer) SetRuleDLQHandler(config ProtobufSerializerConfig) error {
+	ruleDLQHandlerConf, ok := config[RuleDLQHandlerImpl]
+	if ok {
+		ruleDLQHandler, okTypeCast := ruleDLQHandlerConf.(RuleDLQHandler)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a RuleDLQHandler", RuleDLQHandlerImpl)
+		}
+		ps.ruleDLQHandler = ruleDLQHandler
+		delete(config, RuleDLQHandlerImpl)
+	}
+	return nil
+}
+
+// SetRuleLogger using the supplied ProtobufSerializerConfig
+func (ps *ProtobufSerializer) SetRuleLogger(config ProtobufSerializerConfig) error {
+	ruleLoggerConf, ok := config[RuleLoggerImpl]
+	if ok {
+		ruleLogger, okTypeCast := ruleLoggerConf.(RuleLogger)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a RuleLogger", RuleLoggerImpl)
+		}
+		ps.ruleLogger = ruleLogger
+		delete(config, RuleLoggerImpl)
+	}
+	return nil
+}
+
 // SetSubjectNameStrategy using the supplied ProtobufSerializerConfig
 func (ps *ProtobufSerializer) SetSubjectNameStrategy(config ProtobufSerializerConfig) error {
 	subjectNameStrategyConf, ok := config[SubjectNameStrategyImpl]
@@ -286,121 +758,477 @@ func (ps *ProtobufSerializer) SetReferenceSubjectNameStrategy(config ProtobufSer
 	return nil
 }
 
+// SetReferenceVersionStrategy using the supplied ProtobufSerializerConfig
+func (ps *ProtobufSerializer) SetReferenceVersionStrategy(config ProtobufSerializerConfig) error {
+	referenceVersionStrategyConf, ok := config[ReferenceVersionStrategyImpl]
+	if ok {
+		referenceVersionStrategy, okTypeCast := referenceVersionStrategyConf.(ReferenceVersionStrategy)
+		if !okTypeCast {
+			return fmt.Errorf("%s must be a ReferenceVersionStrategy", ReferenceVersionStrategyImpl)
+		}
+		ps.referenceVersionStrategy = referenceVersionStrategy
+		delete(config, ReferenceVersionStrategyImpl)
+	}
+	return nil
+}
+
 // Serialize using the Confluent Schema Registry wire format
 func (ps *ProtobufSerializer) Serialize(pb proto.Message, ctx SerializationContext) ([]byte, error) {
+	return ps.SerializeContext(context.Background(), pb, ctx)
+}
+
+// SerializeContext using the Confluent Schema Registry wire format, ctx is used for cancellation and deadlines of any Schema Registry calls
+func (ps *ProtobufSerializer) SerializeContext(ctx context.Context, pb proto.Message, serCtx SerializationContext) ([]byte, error) {
+	schemaIDBytes, bytes, err := ps.serialize(ctx, pb, serCtx)
+	if err != nil {
+		return nil, err
+	}
+	return append(schemaIDBytes, bytes...), nil
+}
+
+// SerializeWithHeaders returns the schema ID in a record header along with the plain protobuf payload, the header is
+// KeySchemaIDHeader or ValueSchemaIDHeader depending on the field being serialized
+func (ps *ProtobufSerializer) SerializeWithHeaders(pb proto.Message, ctx SerializationContext) ([]Header, []byte, error) {
+	return ps.SerializeWithHeadersContext(context.Background(), pb, ctx)
+}
+
+// SerializeWithHeadersContext returns the schema ID in a record header along with the plain protobuf payload, ctx is
+// used for cancellation and deadlines of any Schema Registry calls
+func (ps *ProtobufSerializer) SerializeWithHeadersContext(ctx context.Context, pb proto.Message, serCtx SerializationContext) ([]Header, []byte, error) {
+	schemaIDBytes, bytes, err := ps.serialize(ctx, pb, serCtx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return []Header{{Key: schemaIDHeaderKey(serCtx.Field), Value: schemaIDBytes}}, bytes, nil
+}
+
+// serialize returns the wire format prefix identifying the writer schema and the protobuf payload separately
+func (ps *ProtobufSerializer) serialize(ctx context.Context, pb proto.Message, serCtx SerializationContext) ([]byte, []byte, error) {
 	md := pb.ProtoReflect().Descriptor()
 
-	subject := ps.subjectNameStrategy.Subject(ctx, string(md.FullName()))
+	subject := ps.subjectNameStrategy.Subject(serCtx, string(md.FullName()))
 
-	schemaID, err := ps.getSchemaID(ctx, md, subject)
+	schemaID, prefix, bytes, err := ps.write(ctx, pb, serCtx, md, subject)
 	if err != nil {
-		return nil, err
+		return nil, nil, newSerdeError(serCtx, subject, schemaIdentifier{id: schemaID}, err)
 	}
+	return prefix, bytes, nil
+}
 
-	schemaIDBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(schemaIDBytes, uint32(schemaID))
+// write returns the schema ID, the wire format prefix and the protobuf payload of pb, the schema ID is 0 when the
+// error is in finding it
+func (ps *ProtobufSerializer) write(ctx context.Context, pb proto.Message, serCtx SerializationContext, md protoreflect.MessageDescriptor, subject string) (int, []byte, []byte, error) {
+	schemaID, err := ps.getSchemaID(ctx, serCtx, md, subject)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	// rules are checked before encryption as they are written against the plain values
+	if ps.ruleExecutor != nil {
+		err = ps.ruleExecutor.execute(ctx, pb, schemaID, nil, serCtx)
+		if err != nil {
+			return schemaID, nil, nil, err
+		}
+	}
+
+	if ps.encryptor != nil {
+		pb, err = ps.encryptor.encrypt(ctx, pb)
+		if err != nil {
+			return schemaID, nil, nil, err
+		}
+	}
 
 	bytes, err := proto.Marshal(pb)
 	if err != nil {
-		//fmt.Printf("failed serialize: %v", err)
-		return nil, err
+		return schemaID, nil, nil, err
 	}
 
 	var msgBytes []byte
-	// schema serialization protocol version number
-	msgBytes = append(msgBytes, byte(0))
-	// schema id
-	msgBytes = append(msgBytes, schemaIDBytes...)
+	if ps.useSchemaGUID {
+		schemaGUIDBytes, err := ps.getSchemaGUID(ctx, schemaID)
+		if err != nil {
+			return schemaID, nil, nil, err
+		}
+		// schema serialization protocol version number for GUIDs
+		msgBytes = append(msgBytes, byte(1))
+		// schema guid
+		msgBytes = append(msgBytes, schemaGUIDBytes...)
+	} else {
+		schemaIDBytes := make([]byte, 4)
+		binary.BigEndian.PutUint32(schemaIDBytes, uint32(schemaID))
+
+		// schema serialization protocol version number
+		msgBytes = append(msgBytes, byte(0))
+		// schema id
+		msgBytes = append(msgBytes, schemaIDBytes...)
+	}
 	// zig zag encoded array of message indexes preceded by length of array
-	msgBytes = append(msgBytes, ps.msgIndexBytes...)
+	msgBytes = append(msgBytes, ps.getMsgIndexBytes(md)...)
+
+	return schemaID, msgBytes, bytes, nil
+}
+
+// SerializeValue for ProtobufSerializer, value must be a proto.Message
+func (ps *ProtobufSerializer) SerializeValue(ctx context.Context, value interface{}, serCtx SerializationContext) ([]byte, error) {
+	pb, ok := value.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("value must be a proto.Message, got %T", value)
+	}
+	return ps.SerializeContext(ctx, pb, serCtx)
+}
 
-	msgBytes = append(msgBytes, bytes...)
+// getMsgIndexBytes returns the message index bytes for md, computing and caching them on first use
+func (ps *ProtobufSerializer) getMsgIndexBytes(md protoreflect.MessageDescriptor) []byte {
+	ps.msgIndexBytesLock.RLock()
+	msgIndexBytes, ok := ps.msgIndexBytes[md.FullName()]
+	ps.msgIndexBytesLock.RUnlock()
+	if ok {
+		return msgIndexBytes
+	}
+
+	msgIndexBytes = createMsgIndexBytes(createMsgIndex(md))
 
-	return msgBytes, nil
+	ps.msgIndexBytesLock.Lock()
+	ps.msgIndexBytes[md.FullName()] = msgIndexBytes
+	ps.msgIndexBytesLock.Unlock()
+
+	return msgIndexBytes
 }
 
 // resolveDependencies resolves and optionally registers schema references recursively.
-func (ps *ProtobufSerializer) resolveDependencies(ctx SerializationContext, fd protoreflect.FileDescriptor) ([]srclient.Reference, error) {
+// Each import is only resolved once for each version chosen by the ReferenceVersionStrategy, however many files import it.
+func (ps *ProtobufSerializer) resolveDependencies(ctx context.Context, serCtx SerializationContext, fd protoreflect.FileDescriptor) ([]srclient.Reference, error) {
 	var schemaRefs []srclient.Reference
 	fileImports := fd.Imports()
 	for i := 0; i < fileImports.Len(); i++ {
 		fileImport := fileImports.Get(i)
-		if ps.skipKnownTypes && strings.HasPrefix(fileImport.Path(), "google/protobuf/") {
+		if ps.skipKnownTypes && isBuiltinProto(fileImport.Path()) {
 			continue
 		}
-		// make recursive call
-		depRefs, err := ps.resolveDependencies(ctx, fileImport.FileDescriptor)
+		subject := ps.referenceSubjectNameStrategy.Subject(serCtx, fileImport)
+		fingerprint, err := ps.getFingerprint(fileImport.FileDescriptor)
 		if err != nil {
 			return nil, err
 		}
-		subject := ps.referenceSubjectNameStrategy.Subject(ctx, fileImport)
-		schemaString, err := fileDescriptorToString(fileImport.FileDescriptor)
+		version := ps.referenceVersionStrategy.Version(serCtx, fileImport)
+		key := referenceKey{path: fileImport.Path(), subject: subject, fingerprint: fingerprint, version: version}
+
+		schemaRef, err := ps.getReference(ctx, serCtx, fileImport, key)
 		if err != nil {
 			return nil, err
 		}
-		if ps.autoRegisterSchemas {
-			_, err = ps.client.CreateSchema(subject, schemaString, srclient.Protobuf, depRefs...)
-			if err != nil {
-				return nil, err
+		// schemaRefs are per file descriptor
+		schemaRefs = append(schemaRefs, schemaRef)
+	}
+	return schemaRefs, nil
+}
+
+// getReference returns the schema reference for fileImport, resolving it on first use. Concurrent calls for the same
+// key wait for the one resolving it rather than registering and looking up the import again, and try again themselves
+// when it fails.
+func (ps *ProtobufSerializer) getReference(ctx context.Context, serCtx SerializationContext, fileImport protoreflect.FileImport, key referenceKey) (srclient.Reference, error) {
+	for {
+		ps.knownReferencesLock.Lock()
+		schemaRef, ok := ps.knownReferences[key]
+		if ok {
+			ps.knownReferencesLock.Unlock()
+			return schemaRef, nil
+		}
+		resolution, ok := ps.resolvingReferences[key]
+		if !ok {
+			resolution = &referenceResolution{done: make(chan struct{})}
+			ps.resolvingReferences[key] = resolution
+		}
+		ps.knownReferencesLock.Unlock()
+
+		if ok {
+			select {
+			case <-resolution.done:
+			case <-ctx.Done():
+				return srclient.Reference{}, ctx.Err()
+			}
+			if resolution.err == nil {
+				return resolution.schemaRef, nil
 			}
+			// the error may be down to the context of the failed call, so resolve it with this one
+			continue
+		}
+
+		resolution.schemaRef, resolution.err = ps.resolveReference(ctx, serCtx, fileImport, key)
+		ps.knownReferencesLock.Lock()
+		if resolution.err == nil {
+			ps.knownReferences[key] = resolution.schemaRef
 		}
-		reference, err := ps.client.LookupSchema(subject, schemaString, srclient.Protobuf, depRefs...)
+		delete(ps.resolvingReferences, key)
+		ps.knownReferencesLock.Unlock()
+		close(resolution.done)
+		return resolution.schemaRef, resolution.err
+	}
+}
+
+// resolveReference resolves fileImport to a schema reference, registering it first when AutoRegisterSchemas is enabled
+func (ps *ProtobufSerializer) resolveReference(ctx context.Context, serCtx SerializationContext, fileImport protoreflect.FileImport, key referenceKey) (srclient.Reference, error) {
+	// make recursive call
+	depRefs, err := ps.resolveDependencies(ctx, serCtx, fileImport.FileDescriptor)
+	if err != nil {
+		return srclient.Reference{}, err
+	}
+	schemaString, err := ps.renderSchema(fileImport.FileDescriptor)
+	if err != nil {
+		return srclient.Reference{}, err
+	}
+	if ps.autoRegisterSchemas {
+		_, err = ps.createSchema(ctx, key.subject, schemaString, depRefs)
 		if err != nil {
-			return nil, err
+			return srclient.Reference{}, err
 		}
-		// schemaRefs are per file descriptor
-		schemaRef := srclient.Reference{Name: fileImport.Path(), Subject: subject, Version: reference.Version()}
-		schemaRefs = append(schemaRefs, schemaRef)
 	}
-	return schemaRefs, nil
+	version := key.version
+	if version == ExactReferenceVersion {
+		reference, err := ps.lookupSchema(ctx, key.subject, schemaString, depRefs)
+		if err != nil {
+			return srclient.Reference{}, err
+		}
+		version = reference.Version()
+	}
+	return srclient.Reference{Name: fileImport.Path(), Subject: key.subject, Version: version}, nil
 }
 
-func (ps *ProtobufSerializer) getSchemaID(ctx SerializationContext, md protoreflect.MessageDescriptor, subject string) (int, error) {
+// getFingerprint returns a fingerprint of the rendered schema of fd and of all the references it would be registered with.
+// Fingerprints are cached for up to maxFingerprints file descriptors, so descriptors built on the fly do not pile up.
+func (ps *ProtobufSerializer) getFingerprint(fd protoreflect.FileDescriptor) (string, error) {
+	ps.fingerprintsLock.RLock()
+	fingerprint, ok := ps.fingerprints[fd]
+	ps.fingerprintsLock.RUnlock()
+	if ok {
+		return fingerprint, nil
+	}
+
+	schemaString, err := ps.renderSchema(fd)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	hash.Write([]byte(schemaString))
+	fileImports := fd.Imports()
+	for i := 0; i < fileImports.Len(); i++ {
+		fileImport := fileImports.Get(i)
+		if ps.skipKnownTypes && isBuiltinProto(fileImport.Path()) {
+			continue
+		}
+		// make recursive call
+		depFingerprint, err := ps.getFingerprint(fileImport.FileDescriptor)
+		if err != nil {
+			return "", err
+		}
+		// separate each entry so that different imports can never produce the same input
+		hash.Write([]byte{0})
+		hash.Write([]byte(fileImport.Path()))
+		hash.Write([]byte{0})
+		hash.Write([]byte(depFingerprint))
+	}
+	fingerprint = hex.EncodeToString(hash.Sum(nil))
+
+	ps.fingerprintsLock.Lock()
+	if len(ps.fingerprints) >= maxFingerprints {
+		// the schema IDs and references are cached by fingerprint, so starting over only costs rendering the files again
+		ps.fingerprints = make(map[protoreflect.FileDescriptor]string)
+	}
+	ps.fingerprints[fd] = fingerprint
+	ps.fingerprintsLock.Unlock()
+
+	return fingerprint, nil
+}
+
+// getSchemaID returns the schema ID to use for md, the cache is only updated once every Schema Registry call has succeeded
+func (ps *ProtobufSerializer) getSchemaID(ctx context.Context, serCtx SerializationContext, md protoreflect.MessageDescriptor, subject string) (int, error) {
+	fingerprint, err := ps.getFingerprint(md.ParentFile())
+	if err != nil {
+		return 0, err
+	}
+	var schemaRefs []srclient.Reference
+	if ps.useSchemaID < 0 && !ps.useLatestVersion {
+		// the ReferenceVersionStrategy may reference other versions of the imports for another SerializationContext,
+		// which registers the same file as another schema
+		schemaRefs, err = ps.resolveDependencies(ctx, serCtx, md.ParentFile())
+		if err != nil {
+			return 0, err
+		}
+	}
+	key := subjectSchemaKey{subject: subject, fingerprint: fingerprint, references: referencesKey(schemaRefs)}
+
 	ps.knownSubjectsLock.RLock()
-	schemaID, ok := ps.knownSubjects[subject]
+	schemaID, ok := ps.knownSubjects[key]
 	ps.knownSubjectsLock.RUnlock()
 	if ok {
 		return schemaID, nil
 	}
 
-	if ps.useLatestVersion {
-		theSchema, err := ps.client.GetLatestSchema(subject)
+	if ps.useSchemaID >= 0 {
+		err = ps.checkUseSchemaID(ctx, subject)
 		if err != nil {
 			return 0, err
 		}
-		schemaID = theSchema.ID()
-	} else {
-		fd := md.ParentFile()
-		schemaRefs, err := ps.resolveDependencies(ctx, fd)
+		schemaID = ps.useSchemaID
+	} else if ps.useLatestVersion {
+		theSchema, err := callRegistry(ctx, func() (*srclient.Schema, error) {
+			return ps.client.GetLatestSchema(subject)
+		})
 		if err != nil {
-			return 0, err
+			return 0, fmt.Errorf("unable to find the latest schema of subject %s: %w", subject, err)
 		}
-		schemaString, err := fileDescriptorToString(fd)
+		if ps.latestCompatibilityStrict {
+			err = ps.checkLatestCompatibility(ctx, md, subject, theSchema)
+			if err != nil {
+				return 0, err
+			}
+		}
+		schemaID = theSchema.ID()
+	} else {
+		schemaString, err := ps.renderSchema(md.ParentFile())
 		if err != nil {
 			return 0, err
 		}
 		if ps.autoRegisterSchemas {
-			theSchema, err := ps.client.CreateSchema(subject, schemaString, srclient.Protobuf, schemaRefs...)
+			theSchema, err := ps.createSchema(ctx, subject, schemaString, schemaRefs)
 			if err != nil {
-				return 0, err
+				return 0, fmt.Errorf("unable to register the schema of subject %s: %w", subject, err)
 			}
 			schemaID = theSchema.ID()
 		} else {
-			theSchema, err := ps.client.LookupSchema(subject, schemaString, srclient.Protobuf, schemaRefs...)
+			theSchema, err := ps.lookupSchema(ctx, subject, schemaString, schemaRefs)
 			if err != nil {
-				return 0, err
+				return 0, fmt.Errorf("unable to look up the schema of subject %s: %w", subject, err)
 			}
 			schemaID = theSchema.ID()
 		}
 	}
 
 	ps.knownSubjectsLock.Lock()
-	ps.knownSubjects[subject] = schemaID
+	ps.knownSubjects[key] = schemaID
 	ps.knownSubjectsLock.Unlock()
 
 	return schemaID, nil
 }
 
+// referencesKey returns schemaRefs as a string for use in a map key
+func referencesKey(schemaRefs []srclient.Reference) string {
+	var b strings.Builder
+	for _, schemaRef := range schemaRefs {
+		// separate each entry so that different references can never produce the same key
+		b.WriteString(fmt.Sprintf("%s\x00%s\x00%d\x00", schemaRef.Name, schemaRef.Subject, schemaRef.Version))
+	}
+	return b.String()
+}
+
+// getSchemaGUID returns the GUID bytes of the schema registered with schemaID, fetching it from Schema Registry on first use
+func (ps *ProtobufSerializer) getSchemaGUID(ctx context.Context, schemaID int) ([]byte, error) {
+	ps.knownGUIDsLock.RLock()
+	schemaGUIDBytes, ok := ps.knownGUIDs[schemaID]
+	ps.knownGUIDsLock.RUnlock()
+	if ok {
+		return schemaGUIDBytes, nil
+	}
+
+	guidClient := ps.client.(GUIDSchemaRegistryClient)
+	guid, err := callRegistry(ctx, func() (string, error) {
+		return guidClient.GetSchemaGUID(schemaID)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to find the GUID of schema ID %d: %w", schemaID, err)
+	}
+	schemaGUIDBytes, err = guidToBytes(guid)
+	if err != nil {
+		return nil, err
+	}
+
+	ps.knownGUIDsLock.Lock()
+	ps.knownGUIDs[schemaID] = schemaGUIDBytes
+	ps.knownGUIDsLock.Unlock()
+
+	return schemaGUIDBytes, nil
+}
+
+// checkLatestCompatibility checks data written with md can be read with the latest schema registered under subject and vice versa
+func (ps *ProtobufSerializer) checkLatestCompatibility(ctx context.Context, md protoreflect.MessageDescriptor, subject string, latest *srclient.Schema) error {
+	fd, err := ps.resolver.fileDescriptorForSchema(ctx, latest)
+	if err != nil {
+		return err
+	}
+	latestMd := findMessageDescriptor(fd, md.FullName())
+	if latestMd == nil {
+		return fmt.Errorf("schema is not compatible with latest version %d of subject %s: message %s not found", latest.Version(), subject, md.FullName())
+	}
+	err = checkMessageCompatibility(md, latestMd)
+	if err != nil {
+		return fmt.Errorf("schema is not compatible with latest version %d of subject %s: %w", latest.Version(), subject, err)
+	}
+	return nil
+}
+
+// checkUseSchemaID checks the pinned schema ID exists and, if configured, that it is registered under subject
+func (ps *ProtobufSerializer) checkUseSchemaID(ctx context.Context, subject string) error {
+	_, err := callRegistry(ctx, func() (*srclient.Schema, error) {
+		return ps.client.GetSchema(ps.useSchemaID)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to find schema ID %d set with %s: %w", ps.useSchemaID, UseSchemaID, err)
+	}
+	if !ps.useSchemaIDCheckSubject {
+		return nil
+	}
+
+	versions, err := callRegistry(ctx, func() ([]int, error) {
+		return ps.client.GetSchemaVersions(subject)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to find the versions of subject %s: %w", subject, err)
+	}
+	for _, version := range versions {
+		theSchema, err := callRegistry(ctx, func() (*srclient.Schema, error) {
+			return ps.client.GetSchemaByVersion(subject, version)
+		})
+		if err != nil {
+			return fmt.Errorf("unable to find version %d of subject %s: %w", version, subject, err)
+		}
+		if theSchema.ID() == ps.useSchemaID {
+			return nil
+		}
+	}
+	return fmt.Errorf("schema ID %d set with %s is not registered under subject %s", ps.useSchemaID, UseSchemaID, subject)
+}
+
+// createSchema registers schemaString under subject, asking Schema Registry to normalize it when enabled and supported by the client
+func (ps *ProtobufSerializer) createSchema(ctx context.Context, subject string, schemaString string, references []srclient.Reference) (*srclient.Schema, error) {
+	return callRegistry(ctx, func() (*srclient.Schema, error) {
+		if normalizingClient, ok := ps.client.(NormalizingSchemaRegistryClient); ok && ps.normalizeSchemas {
+			return normalizingClient.CreateSchemaWithNormalize(subject, schemaString, srclient.Protobuf, true, references...)
+		}
+		return ps.client.CreateSchema(subject, schemaString, srclient.Protobuf, references...)
+	})
+}
+
+// lookupSchema looks up schemaString under subject, asking Schema Registry to normalize it when enabled and supported by the client
+func (ps *ProtobufSerializer) lookupSchema(ctx context.Context, subject string, schemaString string, references []srclient.Reference) (*srclient.Schema, error) {
+	return callRegistry(ctx, func() (*srclient.Schema, error) {
+		if normalizingClient, ok := ps.client.(NormalizingSchemaRegistryClient); ok && ps.normalizeSchemas {
+			return normalizingClient.LookupSchemaWithNormalize(subject, schemaString, srclient.Protobuf, true, references...)
+		}
+		return ps.client.LookupSchema(subject, schemaString, srclient.Protobuf, references...)
+	})
+}
+
+// renderSchema converts fd to the schema string sent to Schema Registry, normalizing it first when enabled
+func (ps *ProtobufSerializer) renderSchema(fd protoreflect.FileDescriptor) (string, error) {
+	if ps.useProtoText {
+		return fileDescriptorToProtoText(fd, ps.normalizeSchemas), nil
+	}
+	if ps.normalizeSchemas {
+		return normalizedFileDescriptorToString(fd)
+	}
+	return fileDescriptorToString(fd)
+}
+
 // fileDescriptorToString converts fd to a file descriptor proto, then marshals it and base64 encodes it for Schema Registry
 func fileDescriptorToString(fd protoreflect.FileDescriptor) (string, error) {
 	fileDescProto := protodesc.ToFileDescriptorProto(fd)
@@ -1,11 +1,23 @@
 package serdes
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"github.com/finncolman/kafka-go-serdes/internal/message"
 	"github.com/finncolman/kafka-go-serdes/internal/messagerefs"
 	"github.com/riferrei/srclient"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -141,46 +153,1149 @@ func (*mockSchemaRegistryClient) LookupSchema(subject string, schema string, sch
 func (*mockSchemaRegistryClient) ResetCache() {
 }
 
+// registryMockSchemaRegistryClient keeps registered schemas in memory so that tests can check the schema IDs used
+type registryMockSchemaRegistryClient struct {
+	mockSchemaRegistryClient
+	lock     sync.Mutex
+	schemas  map[int]*srclient.Schema
+	subjects map[string][]*srclient.Schema // map from subject name to registered versions
+	calls    map[string]int                // map from method name to number of calls
+}
+
+func newRegistryMockSchemaRegistryClient() *registryMockSchemaRegistryClient {
+	return &registryMockSchemaRegistryClient{
+		schemas:  make(map[int]*srclient.Schema),
+		subjects: make(map[string][]*srclient.Schema),
+		calls:    make(map[string]int),
+	}
+}
+
+func (m *registryMockSchemaRegistryClient) callCount(method string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.calls[method]
+}
+
+// findSchema returns the version of subject with schema and references, which as in Schema Registry make a different
+// schema when either differs
+func (m *registryMockSchemaRegistryClient) findSchema(subject string, schema string, schemaType srclient.SchemaType, references []srclient.Reference) *srclient.Schema {
+	for _, s := range m.subjects[subject] {
+		if s.Schema() == schema && *s.SchemaType() == schemaType && sameReferences(s.References(), references) {
+			return s
+		}
+	}
+	return nil
+}
+
+func sameReferences(a []srclient.Reference, b []srclient.Reference) bool {
+	return (len(a) == 0 && len(b) == 0) || reflect.DeepEqual(a, b)
+}
+
+func (m *registryMockSchemaRegistryClient) register(subject string, schema string, schemaType srclient.SchemaType, references ...srclient.Reference) (*srclient.Schema, error) {
+	// the same schema registered under another subject keeps its ID, as it does in Schema Registry
+	id := len(m.schemas) + 1
+	for existingID, s := range m.schemas {
+		if s.Schema() == schema && *s.SchemaType() == schemaType && sameReferences(s.References(), references) {
+			id = existingID
+		}
+	}
+	theSchema, err := srclient.NewSchema(id, schema, schemaType, len(m.subjects[subject])+1, references, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	m.schemas[id] = theSchema
+	m.subjects[subject] = append(m.subjects[subject], theSchema)
+	return theSchema, nil
+}
+
+func (m *registryMockSchemaRegistryClient) CreateSchema(subject string, schema string, schemaType srclient.SchemaType, references ...srclient.Reference) (*srclient.Schema, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.calls["CreateSchema"]++
+	if theSchema := m.findSchema(subject, schema, schemaType, references); theSchema != nil {
+		return theSchema, nil
+	}
+	return m.register(subject, schema, schemaType, references...)
+}
+
+func (m *registryMockSchemaRegistryClient) LookupSchema(subject string, schema string, schemaType srclient.SchemaType, references ...srclient.Reference) (*srclient.Schema, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.calls["LookupSchema"]++
+	if theSchema := m.findSchema(subject, schema, schemaType, references); theSchema != nil {
+		return theSchema, nil
+	}
+	return nil, fmt.Errorf("schema not found for subject %s", subject)
+}
+
+func (m *registryMockSchemaRegistryClient) GetSchema(schemaID int) (*srclient.Schema, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.calls["GetSchema"]++
+	theSchema, ok := m.schemas[schemaID]
+	if !ok {
+		return nil, fmt.Errorf("schema %d not found", schemaID)
+	}
+	return theSchema, nil
+}
+
+func (m *registryMockSchemaRegistryClient) GetLatestSchema(subject string) (*srclient.Schema, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.calls["GetLatestSchema"]++
+	versions := m.subjects[subject]
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("404 Not Found: subject %s not found", subject)
+	}
+	return versions[len(versions)-1], nil
+}
+
+func (m *registryMockSchemaRegistryClient) GetSchemaVersions(subject string) ([]int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.calls["GetSchemaVersions"]++
+	var versions []int
+	for _, theSchema := range m.subjects[subject] {
+		versions = append(versions, theSchema.Version())
+	}
+	return versions, nil
+}
+
+func (m *registryMockSchemaRegistryClient) GetSchemaByVersion(subject string, version int) (*srclient.Schema, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.calls["GetSchemaByVersion"]++
+	versions := m.subjects[subject]
+	if version < 1 || version > len(versions) {
+		return nil, fmt.Errorf("404 Not Found: version %d not found for subject %s", version, subject)
+	}
+	return versions[version-1], nil
+}
+
+// fixedSubjectNameStrategy always uses the same subject name
+type fixedSubjectNameStrategy struct {
+	subject string
+}
+
+func (s fixedSubjectNameStrategy) Subject(SerializationContext, string) string {
+	return s.subject
+}
+
 func TestProtobufSerializer_Serialize(t *testing.T) {
 	msrc := &mockSchemaRegistryClient{}
 	ctx := SerializationContext{Topic: "test", Field: MessageFieldValue}
 
 	msgData := &message.MessageData{}
-	msgDescriptor := msgData.ProtoReflect().Descriptor()
+	msgDescriptor := msgData.ProtoReflect().Descriptor()
+
+	ps, err := NewProtobufSerializer(msgDescriptor, msrc, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+
+	cases := []struct {
+		name string
+		msg  proto.Message
+		want []byte
+	}{
+		{
+			"default case",
+			msgData,
+			[]byte{0, 0, 0, 0, 0, 2, 4}, // magic byte + all zeros schema id + third element at top level msg index
+		},
+		{
+			"first element at top level in same file",
+			&message.Nested1{},
+			[]byte{0, 0, 0, 0, 0, 0}, // magic byte + all zeros schema id + default msg index
+		},
+		{
+			"second element at top level in same file",
+			&message.Nested2{},
+			[]byte{0, 0, 0, 0, 0, 2, 2}, // magic byte + all zeros schema id + second element at top level msg index
+		},
+		{
+			"default case again after other types",
+			msgData,
+			[]byte{0, 0, 0, 0, 0, 2, 4},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := ps.Serialize(c.msg, ctx)
+			if err != nil {
+				t.Fatalf("unexpected error on Serialize: %s", err.Error())
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("ps.Serialize(%v) == %v, want %v", c.msg, got, c.want)
+			}
+		})
+	}
+}
+
+func TestProtobufSerializer_SerializeSchemaIDPerDescriptor(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	ctx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+
+	msgData := &message.MessageData{}
+	msgRefsData := &messagerefs.MessageData{}
+
+	ps, err := NewProtobufSerializer(msgData.ProtoReflect().Descriptor(), msrc, ProtobufSerializerConfig{SubjectNameStrategyImpl: fixedSubjectNameStrategy{subject: "shared"}})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+
+	cases := []struct {
+		name string
+		msg  proto.Message
+		want []byte
+	}{
+		{
+			"first descriptor registered under subject",
+			msgData,
+			[]byte{0, 0, 0, 0, 1, 2, 4},
+		},
+		{
+			"different descriptor registered under same subject",
+			msgRefsData,
+			[]byte{0, 0, 0, 0, 4, 0}, // schema IDs 2 and 3 are used by the two references
+		},
+		{
+			"first descriptor still uses its own schema ID",
+			msgData,
+			[]byte{0, 0, 0, 0, 1, 2, 4},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := ps.Serialize(c.msg, ctx)
+			if err != nil {
+				t.Fatalf("unexpected error on Serialize: %s", err.Error())
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("ps.Serialize(%v) == %v, want %v", c.msg, got, c.want)
+			}
+		})
+	}
+}
+
+// blockingMockSchemaRegistryClient blocks schema registration until release is closed
+type blockingMockSchemaRegistryClient struct {
+	*registryMockSchemaRegistryClient
+	release chan struct{}
+}
+
+func (m *blockingMockSchemaRegistryClient) CreateSchema(subject string, schema string, schemaType srclient.SchemaType, references ...srclient.Reference) (*srclient.Schema, error) {
+	<-m.release
+	return m.registryMockSchemaRegistryClient.CreateSchema(subject, schema, schemaType, references...)
+}
+
+func TestProtobufSerializer_SerializeContext(t *testing.T) {
+	serCtx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+	msgData := &messagerefs.MessageData{}
+
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	deadlineCtx, cancelDeadline := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelDeadline()
+
+	cases := []struct {
+		name string
+		ctx  context.Context
+		want error
+	}{
+		{
+			"cancelled before any registry call",
+			cancelledCtx,
+			context.Canceled,
+		},
+		{
+			"deadline exceeded while registry call blocks",
+			deadlineCtx,
+			context.DeadlineExceeded,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			msrc := &blockingMockSchemaRegistryClient{registryMockSchemaRegistryClient: newRegistryMockSchemaRegistryClient(), release: make(chan struct{})}
+			ps, err := NewProtobufSerializer(msgData.ProtoReflect().Descriptor(), msrc, nil)
+			if err != nil {
+				t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+			}
+
+			_, err = ps.SerializeContext(c.ctx, msgData, serCtx)
+			if !errors.Is(err, c.want) {
+				t.Fatalf("ps.SerializeContext(%v) error == %v, want %v", msgData, err, c.want)
+			}
+			if len(ps.knownSubjects) != 0 {
+				t.Fatalf("ps.knownSubjects == %v, want no entries after a failed call", ps.knownSubjects)
+			}
+
+			// a later call with a live context must still resolve the schema
+			close(msrc.release)
+			got, err := ps.SerializeContext(context.Background(), msgData, serCtx)
+			if err != nil {
+				t.Fatalf("unexpected error on SerializeContext: %s", err.Error())
+			}
+			want := []byte{0, 0, 0, 0, 3, 0}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("ps.SerializeContext(%v) == %v, want %v", msgData, got, want)
+			}
+		})
+	}
+}
+
+func TestProtobufSerializer_SerializeWithHeaders(t *testing.T) {
+	msgData := &message.MessageData{Nest1: &message.Nested1{MessageId: 232}}
+	payload, err := proto.Marshal(msgData)
+	if err != nil {
+		t.Fatalf("unexpected error on proto.Marshal: %s", err.Error())
+	}
+
+	cases := []struct {
+		name        string
+		ctx         SerializationContext
+		wantHeaders []Header
+	}{
+		{
+			"value",
+			SerializationContext{Topic: "test", Field: MessageFieldValue},
+			[]Header{{Key: ValueSchemaIDHeader, Value: []byte{0, 0, 0, 0, 1, 2, 4}}},
+		},
+		{
+			"key",
+			SerializationContext{Topic: "test", Field: MessageFieldKey},
+			[]Header{{Key: KeySchemaIDHeader, Value: []byte{0, 0, 0, 0, 1, 2, 4}}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ps, err := NewProtobufSerializer(msgData.ProtoReflect().Descriptor(), newRegistryMockSchemaRegistryClient(), nil)
+			if err != nil {
+				t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+			}
+			headers, got, err := ps.SerializeWithHeaders(msgData, c.ctx)
+			if err != nil {
+				t.Fatalf("unexpected error on SerializeWithHeaders: %s", err.Error())
+			}
+			if !reflect.DeepEqual(headers, c.wantHeaders) {
+				t.Fatalf("ps.SerializeWithHeaders(%v) headers == %v, want %v", msgData, headers, c.wantHeaders)
+			}
+			if !reflect.DeepEqual(got, payload) {
+				t.Fatalf("ps.SerializeWithHeaders(%v) == %v, want %v", msgData, got, payload)
+			}
+
+			// the payload framing is the header value followed by the same payload
+			framed, err := ps.Serialize(msgData, c.ctx)
+			if err != nil {
+				t.Fatalf("unexpected error on Serialize: %s", err.Error())
+			}
+			want := append(append([]byte{}, c.wantHeaders[0].Value...), payload...)
+			if !reflect.DeepEqual(framed, want) {
+				t.Fatalf("ps.Serialize(%v) == %v, want %v", msgData, framed, want)
+			}
+		})
+	}
+}
+
+func TestProtobufSerializer_SerializeUseSchemaID(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	ctx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+	msgData := &message.MessageData{}
+
+	// register two versions, the pinned ID is the older one
+	first, err := msrc.CreateSchema("test-value", "first", srclient.Protobuf)
+	if err != nil {
+		t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+	}
+	_, err = msrc.CreateSchema("test-value", "second", srclient.Protobuf)
+	if err != nil {
+		t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+	}
+	other, err := msrc.CreateSchema("other-value", "other", srclient.Protobuf)
+	if err != nil {
+		t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+	}
+
+	cases := []struct {
+		name   string
+		config ProtobufSerializerConfig
+		want   []byte
+		err    string // empty when no error is expected
+	}{
+		{
+			"pinned older schema ID",
+			ProtobufSerializerConfig{AutoRegisterSchemas: false, UseSchemaID: first.ID()},
+			[]byte{0, 0, 0, 0, byte(first.ID()), 2, 4},
+			"",
+		},
+		{
+			"pinned schema ID registered under subject",
+			ProtobufSerializerConfig{AutoRegisterSchemas: false, UseSchemaID: first.ID(), UseSchemaIDCheckSubject: true},
+			[]byte{0, 0, 0, 0, byte(first.ID()), 2, 4},
+			"",
+		},
+		{
+			"pinned schema ID registered under another subject without check",
+			ProtobufSerializerConfig{AutoRegisterSchemas: false, UseSchemaID: other.ID()},
+			[]byte{0, 0, 0, 0, byte(other.ID()), 2, 4},
+			"",
+		},
+		{
+			"pinned schema ID registered under another subject with check",
+			ProtobufSerializerConfig{AutoRegisterSchemas: false, UseSchemaID: other.ID(), UseSchemaIDCheckSubject: true},
+			nil,
+			fmt.Sprintf("schema ID %d set with %s is not registered under subject test-value", other.ID(), UseSchemaID),
+		},
+		{
+			"pinned schema ID does not exist",
+			ProtobufSerializerConfig{AutoRegisterSchemas: false, UseSchemaID: 99},
+			nil,
+			fmt.Sprintf("unable to find schema ID 99 set with %s: schema 99 not found", UseSchemaID),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ps, err := NewProtobufSerializer(msgData.ProtoReflect().Descriptor(), msrc, c.config)
+			if err != nil {
+				t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+			}
+			got, err := ps.Serialize(msgData, ctx)
+			if c.err != "" {
+				if err == nil {
+					t.Fatalf("expected error on Serialize, got none")
+				}
+				if err.Error() != c.err {
+					t.Fatalf("ps.Serialize(%v) == %v, want %v", msgData, err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error on Serialize: %s", err.Error())
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("ps.Serialize(%v) == %v, want %v", msgData, got, c.want)
+			}
+		})
+	}
+}
+
+func TestProtobufSerializer_SerializeLatestCompatibilityStrict(t *testing.T) {
+	ctx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+	msgData := &message.MessageData{}
+	fd := msgData.ProtoReflect().Descriptor().ParentFile()
+
+	compatibleSchema, err := fileDescriptorToString(fd)
+	if err != nil {
+		t.Fatalf("unexpected error on fileDescriptorToString: %s", err.Error())
+	}
+	// the registered version has nest1 as a string, which the local MessageData cannot read
+	fdp := protodesc.ToFileDescriptorProto(fd)
+	for _, field := range fdp.GetMessageType()[2].GetField() {
+		if field.GetName() == "nest1" {
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+			field.TypeName = nil
+		}
+	}
+	incompatibleBytes, err := proto.Marshal(fdp)
+	if err != nil {
+		t.Fatalf("unexpected error on proto.Marshal: %s", err.Error())
+	}
+	incompatibleSchema := base64.StdEncoding.EncodeToString(incompatibleBytes)
+
+	cases := []struct {
+		name   string
+		latest string
+		strict bool
+		want   []byte
+		err    string // empty when no error is expected
+	}{
+		{
+			"strict with compatible latest version",
+			compatibleSchema,
+			true,
+			[]byte{0, 0, 0, 0, 1, 2, 4},
+			"",
+		},
+		{
+			"strict with incompatible latest version",
+			incompatibleSchema,
+			true,
+			nil,
+			"schema is not compatible with latest version 1 of subject test-value: field 1 of message.MessageData changed type from string to message",
+		},
+		{
+			"not strict with incompatible latest version",
+			incompatibleSchema,
+			false,
+			[]byte{0, 0, 0, 0, 1, 2, 4},
+			"",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			msrc := newRegistryMockSchemaRegistryClient()
+			_, err := msrc.CreateSchema("test-value", c.latest, srclient.Protobuf)
+			if err != nil {
+				t.Fatalf("unexpected error on CreateSchema: %s", err.Error())
+			}
+			ps, err := NewProtobufSerializer(msgData.ProtoReflect().Descriptor(), msrc, ProtobufSerializerConfig{AutoRegisterSchemas: false, UseLatestVersion: true, LatestCompatibilityStrict: c.strict})
+			if err != nil {
+				t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+			}
+			got, err := ps.Serialize(msgData, ctx)
+			if c.err != "" {
+				if err == nil {
+					t.Fatalf("expected error on Serialize, got none")
+				}
+				if err.Error() != c.err {
+					t.Fatalf("ps.Serialize(%v) == %v, want %v", msgData, err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error on Serialize: %s", err.Error())
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("ps.Serialize(%v) == %v, want %v", msgData, got, c.want)
+			}
+		})
+	}
+}
+
+// normalizingMockSchemaRegistryClient records whether Schema Registry was asked to normalize schemas
+type normalizingMockSchemaRegistryClient struct {
+	*registryMockSchemaRegistryClient
+	normalize []bool
+}
+
+func (m *normalizingMockSchemaRegistryClient) CreateSchemaWithNormalize(subject string, schema string, schemaType srclient.SchemaType, normalize bool, references ...srclient.Reference) (*srclient.Schema, error) {
+	m.normalize = append(m.normalize, normalize)
+	return m.CreateSchema(subject, schema, schemaType, references...)
+}
+
+func (m *normalizingMockSchemaRegistryClient) LookupSchemaWithNormalize(subject string, schema string, schemaType srclient.SchemaType, normalize bool, references ...srclient.Reference) (*srclient.Schema, error) {
+	m.normalize = append(m.normalize, normalize)
+	return m.LookupSchema(subject, schema, schemaType, references...)
+}
+
+func TestProtobufSerializer_SerializeNormalizeSchemas(t *testing.T) {
+	ctx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+	msgData := &messagerefs.MessageData{}
+
+	cases := []struct {
+		name      string
+		normalize bool
+		want      []bool
+	}{
+		{
+			"normalize enabled",
+			true,
+			[]bool{true, true, true, true, true}, // create and lookup for both references, then create
+		},
+		{
+			"normalize disabled uses plain calls",
+			false,
+			nil,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			msrc := &normalizingMockSchemaRegistryClient{registryMockSchemaRegistryClient: newRegistryMockSchemaRegistryClient()}
+			ps, err := NewProtobufSerializer(msgData.ProtoReflect().Descriptor(), msrc, ProtobufSerializerConfig{NormalizeSchemas: c.normalize})
+			if err != nil {
+				t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+			}
+			_, err = ps.Serialize(msgData, ctx)
+			if err != nil {
+				t.Fatalf("unexpected error on Serialize: %s", err.Error())
+			}
+			if !reflect.DeepEqual(msrc.normalize, c.want) {
+				t.Fatalf("normalize flags sent == %v, want %v", msrc.normalize, c.want)
+			}
+		})
+	}
+}
+
+// guidMockSchemaRegistryClient gives every schema a GUID derived from its text, so that the same schema has the same
+// GUID in different registries even when its schema ID is not
+type guidMockSchemaRegistryClient struct {
+	*registryMockSchemaRegistryClient
+	guids map[int]string // map from schema ID to a GUID returned instead of the derived one, empty for none
+}
+
+func newGUIDMockSchemaRegistryClient() *guidMockSchemaRegistryClient {
+	return &guidMockSchemaRegistryClient{registryMockSchemaRegistryClient: newRegistryMockSchemaRegistryClient(), guids: make(map[int]string)}
+}
+
+func testSchemaGUID(schema string) string {
+	sum := sha256.Sum256([]byte(schema))
+	return bytesToGUID(sum[:16])
+}
+
+func (m *guidMockSchemaRegistryClient) GetSchemaGUID(schemaID int) (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.calls["GetSchemaGUID"]++
+	if guid, ok := m.guids[schemaID]; ok {
+		if guid == "" {
+			return "", fmt.Errorf("schema %d has no GUID", schemaID)
+		}
+		return guid, nil
+	}
+	theSchema, ok := m.schemas[schemaID]
+	if !ok {
+		return "", fmt.Errorf("schema %d not found", schemaID)
+	}
+	return testSchemaGUID(theSchema.Schema()), nil
+}
+
+func (m *guidMockSchemaRegistryClient) GetSchemaByGUID(guid string) (*srclient.Schema, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.calls["GetSchemaByGUID"]++
+	for _, theSchema := range m.schemas {
+		if testSchemaGUID(theSchema.Schema()) == guid {
+			return theSchema, nil
+		}
+	}
+	return nil, fmt.Errorf("schema %s not found", guid)
+}
+
+func TestProtobufSerializer_SerializeUseSchemaGUID(t *testing.T) {
+	ctx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+	msgData := &message.MessageData{}
+	schemaString, err := fileDescriptorToString(msgData.ProtoReflect().Descriptor().ParentFile())
+	if err != nil {
+		t.Fatalf("unexpected error on fileDescriptorToString: %s", err.Error())
+	}
+	guidBytes, err := guidToBytes(testSchemaGUID(schemaString))
+	if err != nil {
+		t.Fatalf("unexpected error on guidToBytes: %s", err.Error())
+	}
+
+	msrc := newGUIDMockSchemaRegistryClient()
+	ps, err := NewProtobufSerializer(msgData.ProtoReflect().Descriptor(), msrc, ProtobufSerializerConfig{UseSchemaGUID: true})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+	want := append(append([]byte{1}, guidBytes...), 2, 4)
+	// the second call uses the cached GUID
+	for i := 0; i < 2; i++ {
+		got, err := ps.Serialize(msgData, ctx)
+		if err != nil {
+			t.Fatalf("unexpected error on Serialize: %s", err.Error())
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("ps.Serialize(%v) == %v, want %v", msgData, got, want)
+		}
+	}
+	if got := msrc.callCount("GetSchemaGUID"); got != 1 {
+		t.Fatalf("GetSchemaGUID called %d times, want 1", got)
+	}
+
+	headers, _, err := ps.SerializeWithHeaders(msgData, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error on SerializeWithHeaders: %s", err.Error())
+	}
+	wantHeaders := []Header{{Key: ValueSchemaIDHeader, Value: want}}
+	if !reflect.DeepEqual(headers, wantHeaders) {
+		t.Fatalf("ps.SerializeWithHeaders(%v) headers == %v, want %v", msgData, headers, wantHeaders)
+	}
+}
+
+func TestProtobufSerializer_SerializeUseSchemaGUIDErrors(t *testing.T) {
+	ctx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+	msgData := &message.MessageData{}
 
 	cases := []struct {
-		name string
-		want []byte
+		name  string
+		guids map[int]string
+		want  string
 	}{
 		{
-			"default case",
-			[]byte{0, 0, 0, 0, 0, 2, 4}, // magic byte + all zeros schema id + third element at top level msg index
+			"GUID not found",
+			map[int]string{1: ""},
+			"unable to find the GUID of schema ID 1: schema 1 has no GUID",
+		},
+		{
+			"invalid GUID",
+			map[int]string{1: "not-a-guid"},
+			`invalid schema GUID "not-a-guid"`,
 		},
 	}
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
-			ps, err := NewProtobufSerializer(msgDescriptor, msrc, nil)
+			msrc := newGUIDMockSchemaRegistryClient()
+			msrc.guids = c.guids
+			ps, err := NewProtobufSerializer(msgData.ProtoReflect().Descriptor(), msrc, ProtobufSerializerConfig{UseSchemaGUID: true})
 			if err != nil {
 				t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
 			}
-			got, err := ps.Serialize(msgData, ctx)
+			_, err = ps.Serialize(msgData, ctx)
+			if err == nil || err.Error() != c.want {
+				t.Fatalf("ps.Serialize(%v) == %v, want %v", msgData, err, c.want)
+			}
+		})
+	}
+}
+
+func TestProtobufSerializer_SerializeUseProtoText(t *testing.T) {
+	ctx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+	msgData := &messagerefs.MessageData{}
+
+	msrc := newRegistryMockSchemaRegistryClient()
+	ps, err := NewProtobufSerializer(msgData.ProtoReflect().Descriptor(), msrc, ProtobufSerializerConfig{UseProtoText: true})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+	_, err = ps.Serialize(msgData, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error on Serialize: %s", err.Error())
+	}
+
+	subjects := []string{"nested1.proto", "nested2.proto", "test-value"}
+	for _, subject := range subjects {
+		versions := msrc.subjects[subject]
+		if len(versions) != 1 {
+			t.Fatalf("len(subjects[%s]) == %d, want 1", subject, len(versions))
+		}
+		if !strings.HasPrefix(versions[0].Schema(), "syntax = \"proto3\";\n") {
+			t.Fatalf("schema registered under %s == %q, want .proto text", subject, versions[0].Schema())
+		}
+	}
+	if got := msrc.subjects["test-value"][0].Schema(); !strings.Contains(got, "import \"nested1.proto\";\nimport \"nested2.proto\";\n") {
+		t.Fatalf("schema registered under test-value == %q, want imports of nested1.proto and nested2.proto", got)
+	}
+}
+
+func TestProtobufSerializer_SerializeReferenceVersionStrategy(t *testing.T) {
+	ctx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+	msgData := &messagerefs.MessageData{}
+
+	cases := []struct {
+		name        string
+		strategy    ReferenceVersionStrategy
+		want        []srclient.Reference
+		wantLookups int
+	}{
+		{
+			"exact versions by default",
+			nil,
+			[]srclient.Reference{{Name: "nested1.proto", Subject: "nested1.proto", Version: 1}, {Name: "nested2.proto", Subject: "nested2.proto", Version: 1}},
+			2,
+		},
+		{
+			"latest versions",
+			LatestReferenceVersionStrategy{},
+			[]srclient.Reference{{Name: "nested1.proto", Subject: "nested1.proto", Version: LatestReferenceVersion}, {Name: "nested2.proto", Subject: "nested2.proto", Version: LatestReferenceVersion}},
+			0,
+		},
+		{
+			"path overrides with a latest default",
+			PathReferenceVersionStrategy{Versions: map[string]int{"nested2.proto": 1}, Default: LatestReferenceVersionStrategy{}},
+			[]srclient.Reference{{Name: "nested1.proto", Subject: "nested1.proto", Version: LatestReferenceVersion}, {Name: "nested2.proto", Subject: "nested2.proto", Version: 1}},
+			0,
+		},
+		{
+			"path overrides with the exact default",
+			PathReferenceVersionStrategy{Versions: map[string]int{"nested1.proto": LatestReferenceVersion}},
+			[]srclient.Reference{{Name: "nested1.proto", Subject: "nested1.proto", Version: LatestReferenceVersion}, {Name: "nested2.proto", Subject: "nested2.proto", Version: 1}},
+			1,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			msrc := newRegistryMockSchemaRegistryClient()
+			config := ProtobufSerializerConfig{}
+			if c.strategy != nil {
+				config[ReferenceVersionStrategyImpl] = c.strategy
+			}
+			ps, err := NewProtobufSerializer(msgData.ProtoReflect().Descriptor(), msrc, config)
+			if err != nil {
+				t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+			}
+			_, err = ps.Serialize(msgData, ctx)
 			if err != nil {
 				t.Fatalf("unexpected error on Serialize: %s", err.Error())
 			}
+
+			got := msrc.subjects["test-value"][0].References()
 			if !reflect.DeepEqual(got, c.want) {
-				t.Fatalf("ps.Serialize(%v) == %v, want %v", msgData, got, c.want)
+				t.Fatalf("references registered under test-value == %v, want %v", got, c.want)
+			}
+			if lookups := msrc.callCount("LookupSchema"); lookups != c.wantLookups {
+				t.Fatalf("LookupSchema called %d times, want %d", lookups, c.wantLookups)
+			}
+		})
+	}
+}
+
+// topicReferenceVersionStrategy pins the references of the topics in Pinned to version 1 and references the latest
+// version for any other topic
+type topicReferenceVersionStrategy struct {
+	Pinned map[string]bool
+}
+
+// Version for topicReferenceVersionStrategy
+func (s topicReferenceVersionStrategy) Version(ctx SerializationContext, _ protoreflect.FileImport) int {
+	if s.Pinned[ctx.Topic] {
+		return 1
+	}
+	return LatestReferenceVersion
+}
+
+func TestProtobufSerializer_SerializeReferenceVersionStrategyByContext(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	msgData := &messagerefs.MessageData{}
+
+	ps, err := NewProtobufSerializer(msgData.ProtoReflect().Descriptor(), msrc, ProtobufSerializerConfig{
+		ReferenceVersionStrategyImpl: topicReferenceVersionStrategy{Pinned: map[string]bool{"pinned": true}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+
+	cases := []struct {
+		topic   string
+		version int
+	}{
+		{"latest", LatestReferenceVersion},
+		{"pinned", 1},
+		{"latest", LatestReferenceVersion},
+	}
+	for _, c := range cases {
+		_, err = ps.Serialize(msgData, SerializationContext{Topic: c.topic, Field: MessageFieldValue})
+		if err != nil {
+			t.Fatalf("unexpected error on Serialize: %s", err.Error())
+		}
+		want := []srclient.Reference{{Name: "nested1.proto", Subject: "nested1.proto", Version: c.version}, {Name: "nested2.proto", Subject: "nested2.proto", Version: c.version}}
+		if got := msrc.subjects[c.topic+"-value"][0].References(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("references registered under %s-value == %v, want %v", c.topic, got, want)
+		}
+	}
+}
+
+func TestProtobufSerializer_SerializeReferenceVersionStrategyBySharedSubject(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	msgData := &messagerefs.MessageData{}
+	subject := string(msgData.ProtoReflect().Descriptor().FullName())
+
+	// both topics share the subject, but reference different versions of the imports
+	ps, err := NewProtobufSerializer(msgData.ProtoReflect().Descriptor(), msrc, ProtobufSerializerConfig{
+		SubjectNameStrategyImpl:      RecordSubjectNameStrategy{},
+		ReferenceVersionStrategyImpl: topicReferenceVersionStrategy{Pinned: map[string]bool{"pinned": true}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+
+	cases := []struct {
+		topic   string
+		version int
+	}{
+		{"latest", LatestReferenceVersion},
+		{"pinned", 1},
+		{"latest", LatestReferenceVersion},
+		{"pinned", 1},
+	}
+	for _, c := range cases {
+		data, err := ps.Serialize(msgData, SerializationContext{Topic: c.topic, Field: MessageFieldValue})
+		if err != nil {
+			t.Fatalf("unexpected error on Serialize: %s", err.Error())
+		}
+		schemaID, _, err := parseWireFormat(data)
+		if err != nil {
+			t.Fatalf("unexpected error on parseWireFormat: %s", err.Error())
+		}
+		var theSchema *srclient.Schema
+		for _, version := range msrc.subjects[subject] {
+			if version.ID() == schemaID {
+				theSchema = version
+			}
+		}
+		if theSchema == nil {
+			t.Fatalf("schema ID %d of topic %s is not registered under %s", schemaID, c.topic, subject)
+		}
+		want := []srclient.Reference{{Name: "nested1.proto", Subject: "nested1.proto", Version: c.version}, {Name: "nested2.proto", Subject: "nested2.proto", Version: c.version}}
+		if got := theSchema.References(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("references of schema ID %d used for topic %s == %v, want %v", schemaID, c.topic, got, want)
+		}
+	}
+	if got := len(msrc.subjects[subject]); got != 2 {
+		t.Fatalf("%s has %d versions, want 2", subject, got)
+	}
+}
+
+// buildDiamondMessageDescriptor builds top.Top from top.proto, which imports left.proto and right.proto, which both import common.proto
+func buildDiamondMessageDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	files := new(protoregistry.Files)
+	build := func(path string, deps ...string) protoreflect.FileDescriptor {
+		fdp := &descriptorpb.FileDescriptorProto{
+			Name:        proto.String(path),
+			Package:     proto.String(strings.TrimSuffix(path, ".proto")),
+			Syntax:      proto.String("proto3"),
+			Dependency:  deps,
+			MessageType: []*descriptorpb.DescriptorProto{{Name: proto.String("Msg")}},
+		}
+		fd, err := protodesc.NewFile(fdp, files)
+		if err != nil {
+			t.Fatalf("unexpected error on protodesc.NewFile: %s", err.Error())
+		}
+		err = files.RegisterFile(fd)
+		if err != nil {
+			t.Fatalf("unexpected error on RegisterFile: %s", err.Error())
+		}
+		return fd
+	}
+	build("common.proto")
+	build("left.proto", "common.proto")
+	build("right.proto", "common.proto")
+	return build("top.proto", "left.proto", "right.proto").Messages().Get(0)
+}
+
+func TestProtobufSerializer_getFingerprintBounded(t *testing.T) {
+	msgData := &message.MessageData{}
+	fdp := protodesc.ToFileDescriptorProto(msgData.ProtoReflect().Descriptor().ParentFile())
+	ps, err := NewProtobufSerializer(msgData.ProtoReflect().Descriptor(), newRegistryMockSchemaRegistryClient(), nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+
+	want, err := ps.getFingerprint(msgData.ProtoReflect().Descriptor().ParentFile())
+	if err != nil {
+		t.Fatalf("unexpected error on getFingerprint: %s", err.Error())
+	}
+	// a descriptor rebuilt for every message is a new map key each time
+	for i := 0; i < maxFingerprints+10; i++ {
+		fd, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+		if err != nil {
+			t.Fatalf("unexpected error on protodesc.NewFile: %s", err.Error())
+		}
+		got, err := ps.getFingerprint(fd)
+		if err != nil {
+			t.Fatalf("unexpected error on getFingerprint: %s", err.Error())
+		}
+		if got != want {
+			t.Fatalf("getFingerprint(rebuilt %d) == %v, want %v", i, got, want)
+		}
+	}
+	if got := len(ps.fingerprints); got > maxFingerprints {
+		t.Fatalf("len(fingerprints) == %d, want at most %d", got, maxFingerprints)
+	}
+}
+
+func TestProtobufSerializer_SerializeResolvesEachImportOnce(t *testing.T) {
+	msrc := newRegistryMockSchemaRegistryClient()
+	md := buildDiamondMessageDescriptor(t)
+	msg := dynamicpb.NewMessage(md)
+
+	ps, err := NewProtobufSerializer(md, msrc, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+
+	// concurrent calls must be safe, run with -race to check
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ps.Serialize(msg, SerializationContext{Topic: "test", Field: MessageFieldValue})
+			if err != nil {
+				t.Errorf("unexpected error on Serialize: %s", err.Error())
+			}
+		}()
+	}
+	wg.Wait()
+
+	// every file registered once, and every import looked up once, when called sequentially
+	sequential := newRegistryMockSchemaRegistryClient()
+	ps, err = NewProtobufSerializer(md, sequential, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+	cases := []struct {
+		name        string
+		topic       string
+		wantCreates int
+		wantLookups int
+	}{
+		{
+			"first subject resolves common.proto once",
+			"test",
+			4,
+			3,
+		},
+		{
+			"second subject reuses every resolved import",
+			"other",
+			5,
+			3,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := ps.Serialize(msg, SerializationContext{Topic: c.topic, Field: MessageFieldValue})
+			if err != nil {
+				t.Fatalf("unexpected error on Serialize: %s", err.Error())
+			}
+			if got := sequential.callCount("CreateSchema"); got != c.wantCreates {
+				t.Fatalf("CreateSchema called %d times, want %d", got, c.wantCreates)
+			}
+			if got := sequential.callCount("LookupSchema"); got != c.wantLookups {
+				t.Fatalf("LookupSchema called %d times, want %d", got, c.wantLookups)
 			}
 		})
 	}
 }
 
+// subjectCountingMockSchemaRegistryClient counts the schemas registered and looked up under each subject, registration
+// blocks until release is closed
+type subjectCountingMockSchemaRegistryClient struct {
+	*blockingMockSchemaRegistryClient
+	lock    sync.Mutex
+	creates map[string]int
+	lookups map[string]int
+}
+
+// arrivingReferenceVersionStrategy references exact versions, and marks arrived done each time the reference to path is chosen
+type arrivingReferenceVersionStrategy struct {
+	path    string
+	arrived *sync.WaitGroup
+}
+
+// Version for arrivingReferenceVersionStrategy
+func (s arrivingReferenceVersionStrategy) Version(_ SerializationContext, schemaRef protoreflect.FileImport) int {
+	if schemaRef.Path() == s.path {
+		s.arrived.Done()
+	}
+	return ExactReferenceVersion
+}
+
+func (m *subjectCountingMockSchemaRegistryClient) CreateSchema(subject string, schema string, schemaType srclient.SchemaType, references ...srclient.Reference) (*srclient.Schema, error) {
+	m.lock.Lock()
+	m.creates[subject]++
+	m.lock.Unlock()
+	return m.blockingMockSchemaRegistryClient.CreateSchema(subject, schema, schemaType, references...)
+}
+
+func (m *subjectCountingMockSchemaRegistryClient) LookupSchema(subject string, schema string, schemaType srclient.SchemaType, references ...srclient.Reference) (*srclient.Schema, error) {
+	m.lock.Lock()
+	m.lookups[subject]++
+	m.lock.Unlock()
+	return m.blockingMockSchemaRegistryClient.LookupSchema(subject, schema, schemaType, references...)
+}
+
+func TestProtobufSerializer_SerializeResolvesEachImportOnceConcurrently(t *testing.T) {
+	msrc := &subjectCountingMockSchemaRegistryClient{
+		blockingMockSchemaRegistryClient: &blockingMockSchemaRegistryClient{registryMockSchemaRegistryClient: newRegistryMockSchemaRegistryClient(), release: make(chan struct{})},
+		creates:                          make(map[string]int),
+		lookups:                          make(map[string]int),
+	}
+	md := buildDiamondMessageDescriptor(t)
+	msg := dynamicpb.NewMessage(md)
+	calls := 10
+	var arrived sync.WaitGroup
+	arrived.Add(calls)
+
+	ps, err := NewProtobufSerializer(md, msrc, ProtobufSerializerConfig{
+		ReferenceVersionStrategyImpl: arrivingReferenceVersionStrategy{path: "left.proto", arrived: &arrived},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+
+	// registration blocks until every call has reached left.proto, the first import of top.proto, so they all find it
+	// being resolved by one of them
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ps.Serialize(msg, SerializationContext{Topic: "test", Field: MessageFieldValue})
+			if err != nil {
+				t.Errorf("unexpected error on Serialize: %s", err.Error())
+			}
+		}()
+	}
+	arrived.Wait()
+	close(msrc.release)
+	wg.Wait()
+
+	for _, subject := range []string{"common.proto", "left.proto", "right.proto"} {
+		if got := msrc.creates[subject]; got != 1 {
+			t.Fatalf("CreateSchema called %d times for %s, want 1", got, subject)
+		}
+		if got := msrc.lookups[subject]; got != 1 {
+			t.Fatalf("LookupSchema called %d times for %s, want 1", got, subject)
+		}
+	}
+	if len(ps.resolvingReferences) != 0 {
+		t.Fatalf("ps.resolvingReferences == %v, want no entries once every call returned", ps.resolvingReferences)
+	}
+}
+
+func TestProtobufSerializer_resolveDependenciesSkipKnownTypes(t *testing.T) {
+	files := new(protoregistry.Files)
+	timestamp, err := builtinFileDescriptor("google/protobuf/timestamp.proto")
+	if err != nil {
+		t.Fatalf("unexpected error on builtinFileDescriptor: %s", err.Error())
+	}
+	meta, err := builtinFileDescriptor("confluent/meta.proto")
+	if err != nil {
+		t.Fatalf("unexpected error on builtinFileDescriptor: %s", err.Error())
+	}
+	for _, fd := range []protoreflect.FileDescriptor{timestamp, meta} {
+		err = registerFileWithImports(fd, files)
+		if err != nil {
+			t.Fatalf("unexpected error on registerFileWithImports: %s", err.Error())
+		}
+	}
+	// under google/protobuf/ but not one of the files Schema Registry has built in
+	registerProtoText(t, "google/protobuf/extra.proto", `
+syntax = "proto3";
+package google.protobuf.extra;
+message Extra {}
+`, files)
+	fd := registerProtoText(t, "test/skip.proto", `
+syntax = "proto3";
+package test.skip;
+import "google/protobuf/timestamp.proto";
+import "google/protobuf/extra.proto";
+import "confluent/meta.proto";
+message Event {
+  google.protobuf.Timestamp at = 1 [(confluent.field_meta) = { doc: "when" }];
+  google.protobuf.extra.Extra extra = 2;
+}
+`, files)
+
+	msrc := newRegistryMockSchemaRegistryClient()
+	ps, err := NewProtobufSerializer(fd.Messages().ByName("Event"), msrc, ProtobufSerializerConfig{SkipKnownTypes: true})
+	if err != nil {
+		t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
+	}
+	got, err := ps.resolveDependencies(context.Background(), SerializationContext{}, fd)
+	if err != nil {
+		t.Fatalf("unexpected error on ps.resolveDependencies: %s", err.Error())
+	}
+	want := []srclient.Reference{{Name: "google/protobuf/extra.proto", Subject: "google/protobuf/extra.proto", Version: 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ps.resolveDependencies(%v, %v) == %v, want %v", SerializationContext{}, fd.Path(), got, want)
+	}
+}
+
 func TestProtobufSerializer_NewProtobufSerializer(t *testing.T) {
 	msrc := &mockSchemaRegistryClient{}
 
 	msgData := &message.MessageData{}
 	msgDescriptor := msgData.ProtoReflect().Descriptor()
 
-	knownSubjects := make(map[string]int)
+	knownSubjects := make(map[subjectSchemaKey]int)
+	knownGUIDs := make(map[int][]byte)
+	fingerprints := make(map[protoreflect.FileDescriptor]string)
+	knownReferences := make(map[referenceKey]srclient.Reference)
+	resolvingReferences := make(map[referenceKey]*referenceResolution)
+	msgIndexBytes := map[protoreflect.FullName][]byte{msgDescriptor.FullName(): {2, 4}}
 
 	cases := []struct {
 		name   string
@@ -192,13 +1307,25 @@ func TestProtobufSerializer_NewProtobufSerializer(t *testing.T) {
 			nil,
 			&ProtobufSerializer{
 				client:                       msrc,
-				msgIndexBytes:                []byte{2, 4},
+				msgIndexBytes:                msgIndexBytes,
 				autoRegisterSchemas:          true,
 				useLatestVersion:             false,
+				latestCompatibilityStrict:    false,
+				useSchemaID:                  -1,
+				useSchemaIDCheckSubject:      false,
+				normalizeSchemas:             false,
+				useProtoText:                 false,
 				skipKnownTypes:               false,
+				useSchemaGUID:                false,
 				knownSubjects:                knownSubjects,
+				knownGUIDs:                   knownGUIDs,
+				fingerprints:                 fingerprints,
+				knownReferences:              knownReferences,
+				resolvingReferences:          resolvingReferences,
 				subjectNameStrategy:          TopicSubjectNameStrategy{},
 				referenceSubjectNameStrategy: ReferenceSubjectNameStrategy{},
+				referenceVersionStrategy:     ExactReferenceVersionStrategy{},
+				resolver:                     newProtobufSchemaResolver(msrc),
 			},
 		},
 		{
@@ -206,13 +1333,25 @@ func TestProtobufSerializer_NewProtobufSerializer(t *testing.T) {
 			ProtobufSerializerConfig{},
 			&ProtobufSerializer{
 				client:                       msrc,
-				msgIndexBytes:                []byte{2, 4},
+				msgIndexBytes:                msgIndexBytes,
 				autoRegisterSchemas:          true,
 				useLatestVersion:             false,
+				latestCompatibilityStrict:    false,
+				useSchemaID:                  -1,
+				useSchemaIDCheckSubject:      false,
+				normalizeSchemas:             false,
+				useProtoText:                 false,
 				skipKnownTypes:               false,
+				useSchemaGUID:                false,
 				knownSubjects:                knownSubjects,
+				knownGUIDs:                   knownGUIDs,
+				fingerprints:                 fingerprints,
+				knownReferences:              knownReferences,
+				resolvingReferences:          resolvingReferences,
 				subjectNameStrategy:          TopicSubjectNameStrategy{},
 				referenceSubjectNameStrategy: ReferenceSubjectNameStrategy{},
+				referenceVersionStrategy:     ExactReferenceVersionStrategy{},
+				resolver:                     newProtobufSchemaResolver(msrc),
 			},
 		},
 		{
@@ -223,13 +1362,25 @@ func TestProtobufSerializer_NewProtobufSerializer(t *testing.T) {
 			},
 			&ProtobufSerializer{
 				client:                       msrc,
-				msgIndexBytes:                []byte{2, 4},
+				msgIndexBytes:                msgIndexBytes,
 				autoRegisterSchemas:          false,
 				useLatestVersion:             true,
+				latestCompatibilityStrict:    false,
+				useSchemaID:                  -1,
+				useSchemaIDCheckSubject:      false,
+				normalizeSchemas:             false,
+				useProtoText:                 false,
 				skipKnownTypes:               false,
+				useSchemaGUID:                false,
 				knownSubjects:                knownSubjects,
+				knownGUIDs:                   knownGUIDs,
+				fingerprints:                 fingerprints,
+				knownReferences:              knownReferences,
+				resolvingReferences:          resolvingReferences,
 				subjectNameStrategy:          TopicSubjectNameStrategy{},
 				referenceSubjectNameStrategy: ReferenceSubjectNameStrategy{},
+				referenceVersionStrategy:     ExactReferenceVersionStrategy{},
+				resolver:                     newProtobufSchemaResolver(msrc),
 			},
 		},
 		{
@@ -239,13 +1390,25 @@ func TestProtobufSerializer_NewProtobufSerializer(t *testing.T) {
 			},
 			&ProtobufSerializer{
 				client:                       msrc,
-				msgIndexBytes:                []byte{2, 4},
+				msgIndexBytes:                msgIndexBytes,
 				autoRegisterSchemas:          true,
 				useLatestVersion:             false,
+				latestCompatibilityStrict:    false,
+				useSchemaID:                  -1,
+				useSchemaIDCheckSubject:      false,
+				normalizeSchemas:             false,
+				useProtoText:                 false,
 				skipKnownTypes:               false,
+				useSchemaGUID:                false,
 				knownSubjects:                knownSubjects,
+				knownGUIDs:                   knownGUIDs,
+				fingerprints:                 fingerprints,
+				knownReferences:              knownReferences,
+				resolvingReferences:          resolvingReferences,
 				subjectNameStrategy:          TopicRecordSubjectNameStrategy{},
 				referenceSubjectNameStrategy: ReferenceSubjectNameStrategy{},
+				referenceVersionStrategy:     ExactReferenceVersionStrategy{},
+				resolver:                     newProtobufSchemaResolver(msrc),
 			},
 		},
 	}
@@ -288,6 +1451,27 @@ func TestProtobufSerializer_NewProtobufSerializerErrors(t *testing.T) {
 			},
 			fmt.Errorf("%s must be a boolean value", UseLatestVersion),
 		},
+		{
+			fmt.Sprintf("wrong type for %s", NormalizeSchemas),
+			ProtobufSerializerConfig{
+				NormalizeSchemas: "true",
+			},
+			fmt.Errorf("%s must be a boolean value", NormalizeSchemas),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", ReferenceVersionStrategyImpl),
+			ProtobufSerializerConfig{
+				ReferenceVersionStrategyImpl: LatestReferenceVersion,
+			},
+			fmt.Errorf("%s must be a ReferenceVersionStrategy", ReferenceVersionStrategyImpl),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", UseProtoText),
+			ProtobufSerializerConfig{
+				UseProtoText: "true",
+			},
+			fmt.Errorf("%s must be a boolean value", UseProtoText),
+		},
 		{
 			fmt.Sprintf("wrong type for %s", SkipKnownTypes),
 			ProtobufSerializerConfig{
@@ -295,6 +1479,112 @@ func TestProtobufSerializer_NewProtobufSerializerErrors(t *testing.T) {
 			},
 			fmt.Errorf("%s must be a boolean value", SkipKnownTypes),
 		},
+		{
+			fmt.Sprintf("wrong type for %s", UseSchemaGUID),
+			ProtobufSerializerConfig{
+				UseSchemaGUID: "true",
+			},
+			fmt.Errorf("%s must be a boolean value", UseSchemaGUID),
+		},
+		{
+			fmt.Sprintf("%s without a GUIDSchemaRegistryClient", UseSchemaGUID),
+			ProtobufSerializerConfig{
+				UseSchemaGUID: true,
+			},
+			fmt.Errorf("%s requires a Schema Registry client that implements GUIDSchemaRegistryClient", UseSchemaGUID),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", EncryptionKMS),
+			ProtobufSerializerConfig{
+				EncryptionKMS: "kms",
+			},
+			fmt.Errorf("%s must be a KMS", EncryptionKMS),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", EncryptionKeyID),
+			ProtobufSerializerConfig{
+				EncryptionKeyID: 1,
+			},
+			fmt.Errorf("%s must be a string value", EncryptionKeyID),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", EncryptionTags),
+			ProtobufSerializerConfig{
+				EncryptionTags: "PII",
+			},
+			fmt.Errorf("%s must be a []string", EncryptionTags),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", EncryptionFields),
+			ProtobufSerializerConfig{
+				EncryptionFields: "message.Nested2.id",
+			},
+			fmt.Errorf("%s must be a []string", EncryptionFields),
+		},
+		{
+			fmt.Sprintf("%s without %s and %s", EncryptionTags, EncryptionKMS, EncryptionKeyID),
+			ProtobufSerializerConfig{
+				EncryptionTags: []string{"PII"},
+			},
+			fmt.Errorf("%s and %s must be set to encrypt fields", EncryptionKeyID, EncryptionKMS),
+		},
+		{
+			fmt.Sprintf("%s without %s", EncryptionFields, EncryptionKeyID),
+			ProtobufSerializerConfig{
+				EncryptionKMS:    &LocalKMS{},
+				EncryptionFields: []string{"message.Nested2.id"},
+			},
+			fmt.Errorf("%s must be set to encrypt fields", EncryptionKeyID),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", Rules),
+			ProtobufSerializerConfig{
+				Rules: Rule{Name: "positive", Expr: "amount > 0"},
+			},
+			fmt.Errorf("%s must be a []Rule", Rules),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", UseRegistryRules),
+			ProtobufSerializerConfig{
+				UseRegistryRules: "true",
+			},
+			fmt.Errorf("%s must be a boolean value", UseRegistryRules),
+		},
+		{
+			fmt.Sprintf("%s without a RuleSchemaRegistryClient", UseRegistryRules),
+			ProtobufSerializerConfig{
+				UseRegistryRules: true,
+			},
+			fmt.Errorf("%s requires a Schema Registry client that implements RuleSchemaRegistryClient", UseRegistryRules),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", RuleDLQHandlerImpl),
+			ProtobufSerializerConfig{
+				RuleDLQHandlerImpl: "dlq",
+			},
+			fmt.Errorf("%s must be a RuleDLQHandler", RuleDLQHandlerImpl),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", RuleLoggerImpl),
+			ProtobufSerializerConfig{
+				RuleLoggerImpl: "log",
+			},
+			fmt.Errorf("%s must be a RuleLogger", RuleLoggerImpl),
+		},
+		{
+			fmt.Sprintf("%s rule without %s", RuleActionDLQ, RuleDLQHandlerImpl),
+			ProtobufSerializerConfig{
+				Rules: []Rule{{Name: "positive", Expr: "amount > 0", Action: RuleActionDLQ}},
+			},
+			fmt.Errorf("rule positive has action %s but %s is not set", RuleActionDLQ, RuleDLQHandlerImpl),
+		},
+		{
+			"rule with unknown action",
+			ProtobufSerializerConfig{
+				Rules: []Rule{{Name: "positive", Expr: "amount > 0", Action: "IGNORE"}},
+			},
+			fmt.Errorf("rule positive has unknown action IGNORE"),
+		},
 		{
 			fmt.Sprintf("cannot enable both %s and %s", UseLatestVersion, AutoRegisterSchemas),
 			ProtobufSerializerConfig{
@@ -310,6 +1600,68 @@ func TestProtobufSerializer_NewProtobufSerializerErrors(t *testing.T) {
 			},
 			fmt.Errorf("cannot enable both %s and %s", UseLatestVersion, AutoRegisterSchemas),
 		},
+		{
+			fmt.Sprintf("wrong type for %s", LatestCompatibilityStrict),
+			ProtobufSerializerConfig{
+				LatestCompatibilityStrict: "true",
+			},
+			fmt.Errorf("%s must be a boolean value", LatestCompatibilityStrict),
+		},
+		{
+			fmt.Sprintf("cannot enable %s without %s", LatestCompatibilityStrict, UseLatestVersion),
+			ProtobufSerializerConfig{
+				LatestCompatibilityStrict: true,
+			},
+			fmt.Errorf("cannot enable %s without %s", LatestCompatibilityStrict, UseLatestVersion),
+		},
+		{
+			fmt.Sprintf("cannot enable %s with %s disabled", LatestCompatibilityStrict, UseLatestVersion),
+			ProtobufSerializerConfig{
+				AutoRegisterSchemas:       false,
+				UseLatestVersion:          false,
+				LatestCompatibilityStrict: true,
+			},
+			fmt.Errorf("cannot enable %s without %s", LatestCompatibilityStrict, UseLatestVersion),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", UseSchemaID),
+			ProtobufSerializerConfig{
+				AutoRegisterSchemas: false,
+				UseSchemaID:         "1",
+			},
+			fmt.Errorf("%s must be an integer value", UseSchemaID),
+		},
+		{
+			fmt.Sprintf("negative %s", UseSchemaID),
+			ProtobufSerializerConfig{
+				AutoRegisterSchemas: false,
+				UseSchemaID:         -2,
+			},
+			fmt.Errorf("%s must be a schema ID or -1 to disable it", UseSchemaID),
+		},
+		{
+			fmt.Sprintf("cannot enable both %s and %s", UseSchemaID, AutoRegisterSchemas),
+			ProtobufSerializerConfig{
+				UseSchemaID: 1,
+			},
+			fmt.Errorf("cannot enable both %s and %s", UseSchemaID, AutoRegisterSchemas),
+		},
+		{
+			fmt.Sprintf("cannot enable both %s and %s", UseSchemaID, UseLatestVersion),
+			ProtobufSerializerConfig{
+				AutoRegisterSchemas: false,
+				UseLatestVersion:    true,
+				UseSchemaID:         1,
+			},
+			fmt.Errorf("cannot enable both %s and %s", UseSchemaID, UseLatestVersion),
+		},
+		{
+			fmt.Sprintf("wrong type for %s", UseSchemaIDCheckSubject),
+			ProtobufSerializerConfig{
+				UseSchemaIDCheckSubject: "true",
+			},
+			fmt.Errorf("%s must be a boolean value", UseSchemaIDCheckSubject),
+		},
 		{
 			fmt.Sprintf("wrong type for %s", SubjectNameStrategyImpl),
 			ProtobufSerializerConfig{
@@ -389,7 +1741,7 @@ func TestProtobufSerializer_resolveDependencies(t *testing.T) {
 				t.Fatalf("unexpected error on NewProtobufSerializer: %s", err.Error())
 			}
 
-			got, err := ps.resolveDependencies(SerializationContext{}, msgDescriptor.ParentFile())
+			got, err := ps.resolveDependencies(context.Background(), SerializationContext{}, msgDescriptor.ParentFile())
 			if err != nil {
 				t.Fatalf("unexpected error on ps.resolveDependencies: %s", err.Error())
 			}
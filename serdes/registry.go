@@ -0,0 +1,70 @@
+package serdes
+
+import (
+	"context"
+
+	"github.com/riferrei/srclient"
+)
+
+// GUIDSchemaRegistryClient is implemented by Schema Registry clients that can look up the GUIDs newer versions of
+// Schema Registry give every schema, srclient does not expose them so wrap it with your own client to use them
+type GUIDSchemaRegistryClient interface {
+	GetSchemaGUID(schemaID int) (string, error)
+	GetSchemaByGUID(guid string) (*srclient.Schema, error)
+}
+
+// RuleSchemaRegistryClient is implemented by Schema Registry clients that can look up the data contract rules kept in
+// the metadata of a schema, srclient does not expose them so wrap it with your own client to use them
+type RuleSchemaRegistryClient interface {
+	GetSchemaRules(schemaID int) ([]Rule, error)
+}
+
+// ReferenceSchemaRegistryClient is implemented by Schema Registry clients that can look up the references a schema was
+// registered with by its ID. srclient leaves them out of schemas fetched by ID, so wrap it with your own client to
+// dynamically deserialize schemas with references.
+type ReferenceSchemaRegistryClient interface {
+	GetSchemaReferences(schemaID int) ([]srclient.Reference, error)
+}
+
+// registryResult the result of a Schema Registry call
+type registryResult[T any] struct {
+	value T
+	err   error
+}
+
+// callRegistry runs a blocking Schema Registry call, returning the context error as soon as ctx is done.
+// The srclient calls cannot be interrupted, so an abandoned call carries on in the background until the
+// client's own timeout, but its result is discarded and never cached. Errors of the call match ErrSchemaNotFound or
+// ErrRegistryUnavailable with errors.Is when they are either.
+func callRegistry[T any](ctx context.Context, call func() (T, error)) (T, error) {
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+	if ctx.Done() == nil {
+		// the context can never be cancelled so there is nothing to wait on
+		return classifiedCall(call)
+	}
+
+	results := make(chan registryResult[T], 1)
+	go func() {
+		value, err := classifiedCall(call)
+		results <- registryResult[T]{value: value, err: err}
+	}()
+
+	select {
+	case result := <-results:
+		return result.value, result.err
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
+// classifiedCall runs call, wrapping its error in a registryError
+func classifiedCall[T any](call func() (T, error)) (T, error) {
+	value, err := call()
+	if err != nil {
+		return value, &registryError{err: err}
+	}
+	return value, nil
+}
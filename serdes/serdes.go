@@ -0,0 +1,224 @@
+package serdes
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/riferrei/srclient"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// SerdeConfigValue config values for any format
+type SerdeConfigValue interface{}
+
+// SerdeConfig map of string to SerdeConfigValue, the keys are those of the config for the chosen format
+type SerdeConfig map[string]SerdeConfigValue
+
+// Serializer serializes values of any format using the Confluent Schema Registry wire format
+type Serializer interface {
+	// SerializeValue serializes value, ctx is used for cancellation and deadlines of any Schema Registry calls
+	SerializeValue(ctx context.Context, value interface{}, serCtx SerializationContext) ([]byte, error)
+}
+
+// Deserializer deserializes values of any format using the Confluent Schema Registry wire format
+type Deserializer interface {
+	// DeserializeValue deserializes bytes, ctx is used for cancellation and deadlines of any Schema Registry calls
+	DeserializeValue(ctx context.Context, bytes []byte, serCtx SerializationContext) (interface{}, error)
+}
+
+// NewSerializer returns the Serializer for schemaType. schema is the protoreflect.MessageDescriptor to serialize for
+// Protobuf, or the schema as a string for Avro and JSON. Schema Registry leaves out the type of Avro schemas, so an
+// empty schemaType is Avro
+func NewSerializer(schemaType srclient.SchemaType, schema interface{}, schemaRegistryClient srclient.ISchemaRegistryClient, config SerdeConfig) (Serializer, error) {
+	switch schemaType {
+	case srclient.Protobuf:
+		md, ok := schema.(protoreflect.MessageDescriptor)
+		if !ok {
+			return nil, fmt.Errorf("schema must be a protoreflect.MessageDescriptor for %s, got %T", schemaType, schema)
+		}
+		protobufConfig := make(ProtobufSerializerConfig)
+		for key, value := range config {
+			protobufConfig[key] = value
+		}
+		ps, err := NewProtobufSerializer(md, schemaRegistryClient, protobufConfig)
+		if err != nil {
+			return nil, err
+		}
+		return ps, nil
+	case srclient.Avro, "":
+		schemaString, ok := schema.(string)
+		if !ok {
+			return nil, fmt.Errorf("schema must be a string for %s, got %T", srclient.Avro, schema)
+		}
+		avroConfig := make(AvroSerializerConfig)
+		for key, value := range config {
+			avroConfig[key] = value
+		}
+		as, err := NewAvroSerializer(schemaString, schemaRegistryClient, avroConfig)
+		if err != nil {
+			return nil, err
+		}
+		return as, nil
+	case srclient.Json:
+		schemaString, ok := schema.(string)
+		if !ok {
+			return nil, fmt.Errorf("schema must be a string for %s, got %T", schemaType, schema)
+		}
+		jsonConfig := make(JSONSchemaSerializerConfig)
+		for key, value := range config {
+			jsonConfig[key] = value
+		}
+		js, err := NewJSONSchemaSerializer(schemaString, schemaRegistryClient, jsonConfig)
+		if err != nil {
+			return nil, err
+		}
+		return js, nil
+	default:
+		return nil, fmt.Errorf("unsupported schema type %s", schemaType)
+	}
+}
+
+// NewDeserializer returns the Deserializer for schemaType, Schema Registry leaves out the type of Avro schemas, so an
+// empty schemaType is Avro
+func NewDeserializer(schemaType srclient.SchemaType, schemaRegistryClient srclient.ISchemaRegistryClient, config SerdeConfig) (Deserializer, error) {
+	switch schemaType {
+	case srclient.Protobuf:
+		protobufConfig := make(ProtobufDeserializerConfig)
+		for key, value := range config {
+			protobufConfig[key] = value
+		}
+		pd, err := NewProtobufDeserializerWithClient(schemaRegistryClient, protobufConfig)
+		if err != nil {
+			return nil, err
+		}
+		return pd, nil
+	case srclient.Avro, "":
+		avroConfig := make(AvroDeserializerConfig)
+		for key, value := range config {
+			avroConfig[key] = value
+		}
+		ad, err := NewAvroDeserializer(schemaRegistryClient, avroConfig)
+		if err != nil {
+			return nil, err
+		}
+		return ad, nil
+	case srclient.Json:
+		jsonConfig := make(JSONSchemaDeserializerConfig)
+		for key, value := range config {
+			jsonConfig[key] = value
+		}
+		jd, err := NewJSONSchemaDeserializer(schemaRegistryClient, jsonConfig)
+		if err != nil {
+			return nil, err
+		}
+		return jd, nil
+	default:
+		return nil, fmt.Errorf("unsupported schema type %s", schemaType)
+	}
+}
+
+// SchemaTypeDeserializer deserializes values of any format, dispatching each payload to the Deserializer for the type
+// of the schema its schema ID is registered with
+type SchemaTypeDeserializer struct {
+	client        srclient.ISchemaRegistryClient
+	deserializers map[srclient.SchemaType]Deserializer
+	types         map[int]srclient.SchemaType // map from schema ID to the type of the schema
+	typesLock     sync.RWMutex
+}
+
+// NewSchemaTypeDeserializer returns a SchemaTypeDeserializer for topics mixing formats, configs holds the config of
+// each format by its schemaType and formats left out use their defaults. An empty schemaType is Avro, as for
+// NewDeserializer
+func NewSchemaTypeDeserializer(schemaRegistryClient srclient.ISchemaRegistryClient, configs map[srclient.SchemaType]SerdeConfig) (*SchemaTypeDeserializer, error) {
+	configsToUse := make(map[srclient.SchemaType]SerdeConfig)
+	for schemaType, config := range configs {
+		switch schemaType {
+		case srclient.Protobuf, srclient.Avro, srclient.Json:
+		case "":
+			schemaType = srclient.Avro
+		default:
+			return nil, fmt.Errorf("unsupported schema type %s", schemaType)
+		}
+		configsToUse[schemaType] = config
+	}
+
+	sd := &SchemaTypeDeserializer{
+		client:        schemaRegistryClient,
+		deserializers: make(map[srclient.SchemaType]Deserializer),
+		types:         make(map[int]srclient.SchemaType),
+	}
+	for _, schemaType := range []srclient.SchemaType{srclient.Avro, srclient.Json, srclient.Protobuf} {
+		deserializer, err := NewDeserializer(schemaType, schemaRegistryClient, configsToUse[schemaType])
+		if err != nil {
+			return nil, err
+		}
+		sd.deserializers[schemaType] = deserializer
+	}
+	return sd, nil
+}
+
+// DeserializeValue for SchemaTypeDeserializer, returns the value as the Deserializer for the type of the writer schema
+// does. The type is fetched from Schema Registry on first use of each schema ID.
+func (sd *SchemaTypeDeserializer) DeserializeValue(ctx context.Context, bytes []byte, serCtx SerializationContext) (interface{}, error) {
+	const guidMagicByte = byte(1)
+
+	// only Protobuf payloads are written with the schema GUID
+	if len(bytes) > 0 && bytes[0] == guidMagicByte {
+		return sd.deserializers[srclient.Protobuf].DeserializeValue(ctx, bytes, serCtx)
+	}
+
+	schemaID, _, err := parseWireFormat(bytes)
+	if err != nil {
+		return nil, newSerdeError(serCtx, "", schemaIdentifier{}, err)
+	}
+	schemaType, err := sd.getSchemaType(ctx, schemaID)
+	if err != nil {
+		return nil, newSerdeError(serCtx, "", schemaIdentifier{id: schemaID}, err)
+	}
+	deserializer, ok := sd.deserializers[schemaType]
+	if !ok {
+		err = fmt.Errorf("unsupported schema type %s of schema ID %d", schemaType, schemaID)
+		return nil, newSerdeError(serCtx, "", schemaIdentifier{id: schemaID}, err)
+	}
+	return deserializer.DeserializeValue(ctx, bytes, serCtx)
+}
+
+// getSchemaType returns the type of the schema registered with schemaID, fetching it from Schema Registry on first use
+func (sd *SchemaTypeDeserializer) getSchemaType(ctx context.Context, schemaID int) (srclient.SchemaType, error) {
+	sd.typesLock.RLock()
+	schemaType, ok := sd.types[schemaID]
+	sd.typesLock.RUnlock()
+	if ok {
+		return schemaType, nil
+	}
+
+	theSchema, err := callRegistry(ctx, func() (*srclient.Schema, error) {
+		return sd.client.GetSchema(schemaID)
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to find schema ID %d: %w", schemaID, err)
+	}
+	// Schema Registry leaves out the type of Avro schemas
+	schemaType = srclient.Avro
+	if theSchema.SchemaType() != nil && *theSchema.SchemaType() != "" {
+		schemaType = *theSchema.SchemaType()
+	}
+
+	sd.typesLock.Lock()
+	sd.types[schemaID] = schemaType
+	sd.typesLock.Unlock()
+
+	return schemaType, nil
+}
+
+// make sure every format implements the interfaces
+var (
+	_ Serializer   = (*ProtobufSerializer)(nil)
+	_ Serializer   = (*AvroSerializer)(nil)
+	_ Serializer   = (*JSONSchemaSerializer)(nil)
+	_ Deserializer = (*ProtobufDeserializer)(nil)
+	_ Deserializer = (*AvroDeserializer)(nil)
+	_ Deserializer = (*JSONSchemaDeserializer)(nil)
+	_ Deserializer = (*SchemaTypeDeserializer)(nil)
+)
@@ -0,0 +1,387 @@
+package serdes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/finncolman/kafka-go-serdes/internal/message"
+	"github.com/riferrei/srclient"
+	"google.golang.org/protobuf/proto"
+	"reflect"
+	"testing"
+)
+
+func TestSerdes_NewSerializer(t *testing.T) {
+	md := (&message.MessageData{}).ProtoReflect().Descriptor()
+
+	cases := []struct {
+		schemaType srclient.SchemaType
+		schema     interface{}
+		want       Serializer
+	}{
+		{srclient.Protobuf, md, &ProtobufSerializer{}},
+		{srclient.Avro, testAvroSchema, &AvroSerializer{}},
+		{"", testAvroSchema, &AvroSerializer{}},
+		{srclient.Json, testJSONSchema, &JSONSchemaSerializer{}},
+	}
+	for _, c := range cases {
+		got, err := NewSerializer(c.schemaType, c.schema, &mockSchemaRegistryClient{}, nil)
+		if err != nil {
+			t.Fatalf("unexpected error on NewSerializer: %s", err.Error())
+		}
+		if reflect.TypeOf(got) != reflect.TypeOf(c.want) {
+			t.Fatalf("NewSerializer(%q) type == %T, want %T", c.schemaType, got, c.want)
+		}
+	}
+}
+
+func TestSerdes_NewSerializerErrors(t *testing.T) {
+	md := (&message.MessageData{}).ProtoReflect().Descriptor()
+
+	cases := []struct {
+		name       string
+		schemaType srclient.SchemaType
+		schema     interface{}
+		config     SerdeConfig
+		want       error
+	}{
+		{
+			"unsupported schema type",
+			"XML",
+			testAvroSchema,
+			nil,
+			errors.New("unsupported schema type XML"),
+		},
+		{
+			"string schema for Protobuf",
+			srclient.Protobuf,
+			testAvroSchema,
+			nil,
+			errors.New("schema must be a protoreflect.MessageDescriptor for PROTOBUF, got string"),
+		},
+		{
+			"descriptor schema for Avro",
+			"",
+			md,
+			nil,
+			fmt.Errorf("schema must be a string for AVRO, got %T", md),
+		},
+		{
+			"descriptor schema for JSON",
+			srclient.Json,
+			md,
+			nil,
+			fmt.Errorf("schema must be a string for JSON, got %T", md),
+		},
+		{
+			"invalid schema",
+			srclient.Avro,
+			`{"type": "nope"}`,
+			nil,
+			errors.New(`invalid Avro schema: unknown type name: "nope"`),
+		},
+		{
+			"config of the chosen format",
+			srclient.Json,
+			testJSONSchema,
+			SerdeConfig{UseLatestVersion: true},
+			fmt.Errorf("cannot enable both %s and %s", UseLatestVersion, AutoRegisterSchemas),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := NewSerializer(c.schemaType, c.schema, &mockSchemaRegistryClient{}, c.config)
+			if err == nil || err.Error() != c.want.Error() {
+				t.Fatalf("NewSerializer(%q) == %v, want %v", c.schemaType, err, c.want)
+			}
+			if got != nil {
+				t.Fatalf("NewSerializer(%q) == %#v, want nil", c.schemaType, got)
+			}
+		})
+	}
+}
+
+func TestSerdes_NewDeserializer(t *testing.T) {
+	cases := []struct {
+		schemaType srclient.SchemaType
+		want       Deserializer
+	}{
+		{srclient.Protobuf, &ProtobufDeserializer{}},
+		{srclient.Avro, &AvroDeserializer{}},
+		{"", &AvroDeserializer{}},
+		{srclient.Json, &JSONSchemaDeserializer{}},
+	}
+	for _, c := range cases {
+		got, err := NewDeserializer(c.schemaType, &mockSchemaRegistryClient{}, nil)
+		if err != nil {
+			t.Fatalf("unexpected error on NewDeserializer: %s", err.Error())
+		}
+		if reflect.TypeOf(got) != reflect.TypeOf(c.want) {
+			t.Fatalf("NewDeserializer(%q) type == %T, want %T", c.schemaType, got, c.want)
+		}
+	}
+}
+
+func TestSerdes_NewDeserializerErrors(t *testing.T) {
+	cases := []struct {
+		name       string
+		schemaType srclient.SchemaType
+		client     srclient.ISchemaRegistryClient
+		config     SerdeConfig
+		want       error
+	}{
+		{
+			"unsupported schema type",
+			"XML",
+			&mockSchemaRegistryClient{},
+			nil,
+			errors.New("unsupported schema type XML"),
+		},
+		{
+			"nil client",
+			srclient.Avro,
+			nil,
+			nil,
+			errors.New("schemaRegistryClient must not be nil"),
+		},
+		{
+			"config of another format",
+			srclient.Json,
+			&mockSchemaRegistryClient{},
+			SerdeConfig{SubjectNameStrategyImpl: TopicSubjectNameStrategy{}},
+			fmt.Errorf("unrecognized properties: %s", SubjectNameStrategyImpl),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := NewDeserializer(c.schemaType, c.client, c.config)
+			if err == nil || err.Error() != c.want.Error() {
+				t.Fatalf("NewDeserializer(%q) == %v, want %v", c.schemaType, err, c.want)
+			}
+			if got != nil {
+				t.Fatalf("NewDeserializer(%q) == %#v, want nil", c.schemaType, got)
+			}
+		})
+	}
+}
+
+func TestSerdes_RoundTrip(t *testing.T) {
+	ctx := SerializationContext{Topic: "test", Field: MessageFieldValue}
+
+	cases := []struct {
+		schemaType srclient.SchemaType
+		schema     interface{}
+		value      interface{}
+		want       interface{}
+	}{
+		{
+			srclient.Protobuf,
+			(&message.MessageData{}).ProtoReflect().Descriptor(),
+			&message.MessageData{Nest1: &message.Nested1{MessageId: 232}},
+			&message.MessageData{Nest1: &message.Nested1{MessageId: 232}},
+		},
+		{
+			srclient.Avro,
+			testAvroSchema,
+			map[string]interface{}{"name": "Ann", "age": 40},
+			map[string]interface{}{"name": "Ann", "age": int32(40)},
+		},
+		{
+			srclient.Json,
+			testJSONSchema,
+			testJSONUser{Name: "Ann", Age: 40},
+			map[string]interface{}{"name": "Ann", "age": float64(40)},
+		},
+	}
+	for _, c := range cases {
+		t.Run(string(c.schemaType), func(t *testing.T) {
+			msrc := newRegistryMockSchemaRegistryClient()
+			serializer, err := NewSerializer(c.schemaType, c.schema, msrc, nil)
+			if err != nil {
+				t.Fatalf("unexpected error on NewSerializer: %s", err.Error())
+			}
+			deserializer, err := NewDeserializer(c.schemaType, msrc, nil)
+			if err != nil {
+				t.Fatalf("unexpected error on NewDeserializer: %s", err.Error())
+			}
+			data, err := serializer.SerializeValue(context.Background(), c.value, ctx)
+			if err != nil {
+				t.Fatalf("unexpected error on SerializeValue: %s", err.Error())
+			}
+			got, err := deserializer.DeserializeValue(context.Background(), data, ctx)
+			if err != nil {
+				t.Fatalf("unexpected error on DeserializeValue: %s", err.Error())
+			}
+			if want, ok := c.want.(proto.Message); ok {
+				if !proto.Equal(got.(proto.Message), want) {
+					t.Fatalf("DeserializeValue(%v) == %v, want %v", data, got, c.want)
+				}
+			} else if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("DeserializeValue(%v) == %v, want %v", data, got, c.want)
+			}
+		})
+	}
+}
+
+func TestSerdes_SerializeValueErrors(t *testing.T) {
+	md := (&message.MessageData{}).ProtoReflect().Descriptor()
+	serializer, err := NewSerializer(srclient.Protobuf, md, &mockSchemaRegistryClient{}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewSerializer: %s", err.Error())
+	}
+
+	want := "value must be a proto.Message, got string"
+	_, err = serializer.SerializeValue(context.Background(), "not a message", SerializationContext{Topic: "test", Field: MessageFieldValue})
+	if err == nil || err.Error() != want {
+		t.Fatalf("SerializeValue() == %v, want %v", err, want)
+	}
+}
+
+func TestSerdes_SchemaTypeDeserializerMixedFormats(t *testing.T) {
+	ctx := SerializationContext{Topic: "mixed", Field: MessageFieldValue}
+	msrc := newRegistryMockSchemaRegistryClient()
+
+	cases := []struct {
+		schemaType srclient.SchemaType
+		schema     interface{}
+		value      interface{}
+		want       interface{}
+	}{
+		{
+			srclient.Avro,
+			testAvroSchema,
+			map[string]interface{}{"name": "Ann", "age": 40},
+			map[string]interface{}{"name": "Ann", "age": int32(40)},
+		},
+		{
+			srclient.Json,
+			testJSONSchema,
+			testJSONUser{Name: "Bob", Age: 41},
+			map[string]interface{}{"name": "Bob", "age": float64(41)},
+		},
+		{
+			srclient.Protobuf,
+			(&message.MessageData{}).ProtoReflect().Descriptor(),
+			&message.MessageData{Nest1: &message.Nested1{MessageId: 232}},
+			&message.MessageData{Nest1: &message.Nested1{MessageId: 232}},
+		},
+	}
+	// one stream of records interleaving every format
+	var stream [][]byte
+	for _, c := range cases {
+		serializer, err := NewSerializer(c.schemaType, c.schema, msrc, nil)
+		if err != nil {
+			t.Fatalf("unexpected error on NewSerializer: %s", err.Error())
+		}
+		data, err := serializer.SerializeValue(context.Background(), c.value, ctx)
+		if err != nil {
+			t.Fatalf("unexpected error on SerializeValue: %s", err.Error())
+		}
+		stream = append(stream, data)
+	}
+
+	sd, err := NewSchemaTypeDeserializer(msrc, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewSchemaTypeDeserializer: %s", err.Error())
+	}
+	for pass := 0; pass < 2; pass++ {
+		for i, data := range stream {
+			got, err := sd.DeserializeValue(context.Background(), data, ctx)
+			if err != nil {
+				t.Fatalf("unexpected error on DeserializeValue: %s", err.Error())
+			}
+			if want, ok := cases[i].want.(proto.Message); ok {
+				if !proto.Equal(got.(proto.Message), want) {
+					t.Fatalf("DeserializeValue(%v) == %v, want %v", data, got, want)
+				}
+			} else if !reflect.DeepEqual(got, cases[i].want) {
+				t.Fatalf("DeserializeValue(%v) == %v, want %v", data, got, cases[i].want)
+			}
+		}
+		if pass == 0 {
+			msrc.lock.Lock()
+			msrc.calls = make(map[string]int)
+			msrc.lock.Unlock()
+		}
+	}
+	// the type of each schema ID is only looked up once
+	if calls := msrc.callCount("GetSchema"); calls != 0 {
+		t.Fatalf("GetSchema called %d times on the second pass, want 0", calls)
+	}
+	for i, c := range cases {
+		schemaID, _, err := parseWireFormat(stream[i])
+		if err != nil {
+			t.Fatalf("unexpected error on parseWireFormat: %s", err.Error())
+		}
+		if got := sd.types[schemaID]; got != c.schemaType {
+			t.Fatalf("sd.types[%d] == %q, want %q", schemaID, got, c.schemaType)
+		}
+	}
+}
+
+func TestSerdes_SchemaTypeDeserializerErrors(t *testing.T) {
+	ctx := SerializationContext{Topic: "mixed", Field: MessageFieldValue}
+
+	configCases := []struct {
+		name    string
+		client  srclient.ISchemaRegistryClient
+		configs map[srclient.SchemaType]SerdeConfig
+		want    error
+	}{
+		{
+			"unsupported schema type",
+			&mockSchemaRegistryClient{},
+			map[srclient.SchemaType]SerdeConfig{"XML": nil},
+			errors.New("unsupported schema type XML"),
+		},
+		{
+			"nil client",
+			nil,
+			nil,
+			errors.New("schemaRegistryClient must not be nil"),
+		},
+		{
+			"config of another format",
+			&mockSchemaRegistryClient{},
+			map[srclient.SchemaType]SerdeConfig{"": {MigrationTargetVersion: 1}},
+			fmt.Errorf("unrecognized properties: %s", MigrationTargetVersion),
+		},
+	}
+	for _, c := range configCases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := NewSchemaTypeDeserializer(c.client, c.configs)
+			if err == nil || err.Error() != c.want.Error() {
+				t.Fatalf("NewSchemaTypeDeserializer(%v) == %v, want %v", c.configs, err, c.want)
+			}
+			if got != nil {
+				t.Fatalf("NewSchemaTypeDeserializer(%v) == %#v, want nil", c.configs, got)
+			}
+		})
+	}
+
+	msrc := newRegistryMockSchemaRegistryClient()
+	sd, err := NewSchemaTypeDeserializer(msrc, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on NewSchemaTypeDeserializer: %s", err.Error())
+	}
+	cases := []struct {
+		name  string
+		bytes []byte
+		want  error
+	}{
+		{"message too small", []byte{0, 0}, ErrMessageTooSmall},
+		{"unknown schema ID", []byte{0, 0, 0, 0, 9, 1}, errors.New("unable to find schema ID 9: schema 9 not found")},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := sd.DeserializeValue(context.Background(), c.bytes, ctx)
+			var serdeErr *SerdeError
+			if !errors.As(err, &serdeErr) || serdeErr.Topic != ctx.Topic || serdeErr.Field != ctx.Field {
+				t.Fatalf("DeserializeValue(%v) == %v, want a *SerdeError for topic %s", c.bytes, err, ctx.Topic)
+			}
+			if err.Error() != c.want.Error() {
+				t.Fatalf("DeserializeValue(%v) == %v, want %v", c.bytes, err, c.want)
+			}
+		})
+	}
+}
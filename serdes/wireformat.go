@@ -0,0 +1,57 @@
+package serdes
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// parseWireFormat splits bytes into the schema ID and the payload, for formats with nothing between them
+func parseWireFormat(bytes []byte) (int, []byte, error) {
+	const (
+		wireFormatLen = 5 // magic byte + schema ID
+		magicByte     = byte(0)
+	)
+
+	if len(bytes) < wireFormatLen {
+		return 0, nil, &kindError{kind: ErrMessageTooSmall, msg: ErrMessageTooSmall.Error(), offset: len(bytes)}
+	}
+
+	if bytes[0] != magicByte {
+		return 0, nil, &kindError{kind: ErrUnknownMagicByte, msg: ErrUnknownMagicByte.Error(), offset: 0}
+	}
+
+	schemaID := int(binary.BigEndian.Uint32(bytes[1:wireFormatLen]))
+	return schemaID, bytes[wireFormatLen:], nil
+}
+
+// schemaIdentifier identifies a writer schema by either its integer ID or its GUID
+type schemaIdentifier struct {
+	id   int
+	guid string // empty unless the payload was written with the GUID framing
+}
+
+// String for schemaIdentifier
+func (s schemaIdentifier) String() string {
+	if s.guid != "" {
+		return "schema GUID " + s.guid
+	}
+	return fmt.Sprintf("schema ID %d", s.id)
+}
+
+// guidToBytes returns the 16 bytes of a GUID in its canonical xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx form
+func guidToBytes(guid string) ([]byte, error) {
+	if len(guid) != 36 || guid[8] != '-' || guid[13] != '-' || guid[18] != '-' || guid[23] != '-' {
+		return nil, fmt.Errorf("invalid schema GUID %q", guid)
+	}
+	guidBytes, err := hex.DecodeString(guid[0:8] + guid[9:13] + guid[14:18] + guid[19:23] + guid[24:36])
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema GUID %q", guid)
+	}
+	return guidBytes, nil
+}
+
+// bytesToGUID returns the canonical form of the 16 bytes of a GUID
+func bytesToGUID(guidBytes []byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", guidBytes[0:4], guidBytes[4:6], guidBytes[6:8], guidBytes[8:10], guidBytes[10:16])
+}
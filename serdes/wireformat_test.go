@@ -0,0 +1,53 @@
+package serdes
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestWireFormat_guidToBytes(t *testing.T) {
+	cases := []struct {
+		guid string
+		want []byte
+	}{
+		{
+			"12345678-9abc-def0-1234-56789abcdef0",
+			[]byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0},
+		},
+		{
+			"12345678-9ABC-DEF0-1234-56789ABCDEF0",
+			[]byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0},
+		},
+	}
+	for _, c := range cases {
+		got, err := guidToBytes(c.guid)
+		if err != nil {
+			t.Fatalf("unexpected error on guidToBytes: %s", err.Error())
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("guidToBytes(%s) == %v, want %v", c.guid, got, c.want)
+		}
+		if back := bytesToGUID(got); back != "12345678-9abc-def0-1234-56789abcdef0" {
+			t.Fatalf("bytesToGUID(%v) == %s, want %s", got, back, "12345678-9abc-def0-1234-56789abcdef0")
+		}
+	}
+}
+
+func TestWireFormat_guidToBytesErrors(t *testing.T) {
+	cases := []struct {
+		guid string
+		want error
+	}{
+		{"", errors.New(`invalid schema GUID ""`)},
+		{"123456789abcdef0123456789abcdef0", errors.New(`invalid schema GUID "123456789abcdef0123456789abcdef0"`)},
+		{"12345678-9abc-def0-1234_56789abcdef0", errors.New(`invalid schema GUID "12345678-9abc-def0-1234_56789abcdef0"`)},
+		{"1234567g-9abc-def0-1234-56789abcdef0", errors.New(`invalid schema GUID "1234567g-9abc-def0-1234-56789abcdef0"`)},
+	}
+	for _, c := range cases {
+		_, err := guidToBytes(c.guid)
+		if err == nil || err.Error() != c.want.Error() {
+			t.Fatalf("guidToBytes(%s) == %v, want %v", c.guid, err, c.want)
+		}
+	}
+}